@@ -0,0 +1,120 @@
+# -*- coding: utf-8 -*-
+# Structured event stream for the inventory-manager executor.
+#
+# The Go executor hands ansible-playbook an extra pipe and exports its file
+# descriptor number in BOILER_EVENTS_FD. This plugin writes one JSON object per
+# playbook event to that pipe. Without the variable it stays silent, so running
+# ansible-playbook by hand is unaffected.
+
+from __future__ import (absolute_import, division, print_function)
+__metaclass__ = type
+
+DOCUMENTATION = '''
+    name: boiler_events
+    type: notification
+    short_description: JSON lines event stream for inventory-manager
+    description:
+      - Writes play, task, result and recap events as JSON lines to the file
+        descriptor named by the BOILER_EVENTS_FD environment variable.
+'''
+
+import json
+import os
+import time
+from datetime import datetime
+
+from ansible.plugins.callback import CallbackBase
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = 'notification'
+    CALLBACK_NAME = 'boiler_events'
+    CALLBACK_NEEDS_WHITELIST = False
+    CALLBACK_NEEDS_ENABLED = False
+
+    def __init__(self, display=None):
+        super(CallbackModule, self).__init__(display=display)
+        self._stream = None
+        self._play = ''
+        self._task_started = {}
+
+        fd = os.environ.get('BOILER_EVENTS_FD')
+        if fd:
+            try:
+                self._stream = os.fdopen(int(fd), 'w', 1)
+            except (OSError, ValueError):
+                self._stream = None
+
+    def _emit(self, event_type, **fields):
+        if self._stream is None:
+            return
+        fields['type'] = event_type
+        fields['time'] = datetime.utcnow().isoformat() + 'Z'
+        try:
+            self._stream.write(json.dumps(fields) + '\n')
+            self._stream.flush()
+        except (IOError, OSError, ValueError):
+            # The reader went away (process cancelled): stop emitting
+            self._stream = None
+
+    def _result(self, result, status, ignored=False):
+        task = result._task
+        started = self._task_started.get(task._uuid)
+        duration = time.time() - started if started else 0
+
+        res = result._result
+        msg = res.get('msg') or res.get('stderr') or ''
+        if not isinstance(msg, str):
+            msg = json.dumps(msg)
+
+        self._emit(
+            'task_result',
+            play=self._play,
+            task=task.get_name().strip(),
+            host=result._host.get_name(),
+            status=status,
+            changed=bool(res.get('changed', False)),
+            ignored=ignored,
+            duration=round(duration, 3),
+            msg=msg.strip(),
+        )
+
+    def v2_playbook_on_play_start(self, play):
+        self._play = play.get_name().strip()
+        self._emit('play_start', play=self._play)
+
+    def v2_playbook_on_task_start(self, task, is_conditional):
+        self._task_started[task._uuid] = time.time()
+        self._emit('task_start', play=self._play, task=task.get_name().strip())
+
+    def v2_playbook_on_handler_task_start(self, task):
+        self.v2_playbook_on_task_start(task, False)
+
+    def v2_runner_on_ok(self, result):
+        status = 'changed' if result._result.get('changed', False) else 'ok'
+        self._result(result, status)
+
+    def v2_runner_on_failed(self, result, ignore_errors=False):
+        self._result(result, 'failed', ignored=ignore_errors)
+
+    def v2_runner_on_skipped(self, result):
+        self._result(result, 'skipped')
+
+    def v2_runner_on_unreachable(self, result):
+        self._result(result, 'unreachable')
+
+    def v2_playbook_on_stats(self, stats):
+        recap = {}
+        for host in sorted(stats.processed.keys()):
+            summary = stats.summarize(host)
+            recap[host] = {
+                'ok': summary.get('ok', 0),
+                'changed': summary.get('changed', 0),
+                'unreachable': summary.get('unreachable', 0),
+                'failed': summary.get('failures', 0),
+                'skipped': summary.get('skipped', 0),
+                'rescued': summary.get('rescued', 0),
+                'ignored': summary.get('ignored', 0),
+            }
+        self._emit('recap', recap=recap)
@@ -0,0 +1,1398 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
+	"github.com/bastiblast/boiler-deploy/internal/config"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/schedule"
+	"github.com/bastiblast/boiler-deploy/internal/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+	"github.com/bastiblast/boiler-deploy/internal/storage"
+	"github.com/charmbracelet/x/term"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Exit codes used by the headless commands
+const (
+	exitOK          = 0
+	exitFailed      = 1 // at least one server ended in a failed state
+	exitUsage       = 2 // bad flags or unknown environment/server
+	exitBusy        = 3 // the environment is locked by another run
+	exitInterrupted = 130
+)
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  inventory-manager                      Launch the interactive TUI
+  inventory-manager <command> [flags]    Run headless (CI / cron)
+
+Commands:
+  provision   Provision servers with ansible
+  deploy      Deploy the application to servers
+  check       Validate configuration, SSH access and detect server state
+  rollback    Roll web servers back to the previous (or a given) release
+  status      Print the last known state of every server
+  history     List the recorded runs of an environment
+  releases    List the releases deployed on each web server
+  drift       Compare the versions running on the web servers
+  hostkey     List, accept (after a rotation) or forget server host keys
+  queue       List the queued actions, cancel, move, re-prioritize or pause one
+  daemon      Run the queues as their actions come due (scheduled, maintenance window)
+  promote     Deploy the commit verified in the previous environment of the pipeline
+  unlock      Remove the lock of an environment left by a crashed run
+
+Run 'inventory-manager <command> -h' for the flags of a command.
+`)
+}
+
+// runCLI dispatches a headless subcommand and returns the process exit code
+func runCLI(args []string) int {
+	defer printFileWarnings()
+
+	switch args[0] {
+	case "provision", "deploy", "check", "rollback":
+		return runAction(status.ActionType(args[0]), args[1:])
+	case "status":
+		return runStatus(args[1:])
+	case "history":
+		return runHistory(args[1:])
+	case "releases":
+		return runReleases(args[1:])
+	case "drift":
+		return runDrift(args[1:])
+	case "hostkey":
+		return runHostKey(args[1:])
+	case "queue":
+		return runQueue(args[1:])
+	case "daemon":
+		return runDaemon(args[1:])
+	case "promote":
+		return runPromote(args[1:])
+	case "unlock":
+		return runUnlock(args[1:])
+	case "help", "-h", "--help":
+		printUsage()
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		printUsage()
+		return exitUsage
+	}
+}
+
+// runAction queues one action per selected server, runs the orchestrator until
+// the queue drains and reports the final state of every server
+func runAction(action status.ActionType, args []string) int {
+	fs := flag.NewFlagSet(string(action), flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	serverList := fs.String("servers", "", "comma-separated server names (default: all servers)")
+	tags := fs.String("tags", "", "comma-separated ansible tags (provision/deploy only)")
+	workers := fs.Int("workers", -1, "parallel workers (default: max_parallel_workers from config.yml, 0 = sequential)")
+	noHealthCheck := fs.Bool("no-health-check", false, "skip the post-deploy health check")
+	retries := fs.Int("retries", -1, "retries of transient failures (default: max_retries from config.yml when auto_retry_enabled, 0 = no retry)")
+	resume := fs.String("resume", "", "what to do with the actions interrupted by a crash: rerun, skip or clear")
+	at := fs.String("at", "", "queue the actions to start at this time (HH:MM, \"YYYY-MM-DD HH:MM\" or RFC 3339) and exit, see the daemon command")
+	inWindow := fs.Bool("in-window", false, "queue the actions to start within the maintenance window of the environment and exit, see the daemon command")
+	overrideWindow := fs.Bool("override-window", false, "deploy to a production environment outside its maintenance window")
+	thenDeploy := false
+	deployTags := ""
+	if action == status.ActionProvision {
+		fs.BoolVar(&thenDeploy, "deploy", false, "deploy each server once its provision succeeded")
+		fs.StringVar(&deployTags, "deploy-tags", "", "comma-separated ansible tags for the deploy (with --deploy)")
+	}
+	release := ""
+	if action == status.ActionRollback {
+		fs.StringVar(&release, "release", "", "release to roll back to, a directory of app_releases_dir (default: the release deployed before the current one)")
+	}
+	strategyName := ""
+	batchSize, maxFailurePercent := -1, -1
+	autoRollback := false
+	ref := ""
+	if action == status.ActionProvision || action == status.ActionDeploy {
+		fs.StringVar(&ref, "ref", "", "branch, tag or full commit SHA to deploy (default: the git_branch of each server)")
+		fs.BoolVar(&autoRollback, "auto-rollback", false, "roll back deploys whose health check failed (default: auto_rollback_enabled from config.yml)")
+		fs.StringVar(&strategyName, "strategy", "", "deployment strategy: rolling, all_at_once or blue_green (default: deployment_strategy from config.yml)")
+		fs.IntVar(&batchSize, "batch-size", -1, "servers per rolling batch (default: rolling_batch_size from config.yml, 0 = workers)")
+		fs.IntVar(&maxFailurePercent, "max-failure-percent", -1, "stop a rolling deploy when more than this % of a batch fails (default: rolling_max_failure_percent from config.yml)")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		fs.Usage()
+		return exitUsage
+	}
+	if strategyName != "" && !ansible.IsValidStrategy(strategyName) {
+		fmt.Fprintf(os.Stderr, "Error: unknown strategy %q (rolling, all_at_once, blue_green)\n", strategyName)
+		return exitUsage
+	}
+	if err := ansible.ValidateReleaseID(release); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if ref != "" && action == status.ActionProvision && !thenDeploy {
+		fmt.Fprintln(os.Stderr, "Error: --ref is only used with --deploy")
+		return exitUsage
+	}
+	switch *resume {
+	case "", ansible.ResumeRerun, ansible.ResumeSkip, ansible.ResumeClear:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown --resume %q (rerun, skip, clear)\n", *resume)
+		return exitUsage
+	}
+
+	env, servers, err := loadServers(*envName, *serverList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	// Scheduled actions are only queued, the daemon (or any run holding the
+	// environment once they are due) runs them
+	window, err := maintenanceWindow(env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if *inWindow && window == nil {
+		fmt.Fprintf(os.Stderr, "Error: --in-window: %s has no maintenance_window in its .env-config.yml\n", *envName)
+		return exitUsage
+	}
+	now := time.Now().In(schedule.Location(env.Config.Timezone))
+	var startAt *time.Time
+	if *at != "" {
+		t, err := schedule.ParseTime(*at, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --at: %v\n", err)
+			return exitUsage
+		}
+		if t.Before(now) {
+			fmt.Fprintf(os.Stderr, "Error: --at %s is in the past\n", t.Format("2006-01-02 15:04"))
+			return exitUsage
+		}
+		startAt = &t
+	}
+	scheduled := startAt != nil || *inWindow
+
+	if !scheduled {
+		if code := unlockKeys(env, servers); code != exitOK {
+			return code
+		}
+	}
+
+	configOpts, err := config.NewManager("inventory").Load(*envName)
+	if err != nil {
+		log.Printf("[CLI] Failed to load config for %s, using defaults: %v", *envName, err)
+		configOpts = config.DefaultConfig()
+	}
+
+	if scheduled {
+		// Queued without being run: lock the environment for the time of it
+		lock, err := status.AcquireLock(*envName, status.CurrentOperator())
+		if err != nil {
+			return reportStartError(*envName, err)
+		}
+		defer lock.Release()
+	}
+
+	statusMgr, err := status.NewManager(*envName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+
+	orchestrator, err := ansible.NewEnvironmentOrchestrator(*envName, env, configOpts, statusMgr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+
+	var outMu sync.Mutex
+	orchestrator.SetProgressCallback(func(serverName, message string) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		fmt.Printf("[%s] %s\n", serverName, message)
+	})
+	if *noHealthCheck {
+		orchestrator.SetHealthCheckEnabled(false)
+	}
+	if *workers >= 0 {
+		orchestrator.SetMaxWorkers(*workers)
+	}
+	strategy := orchestrator.GetDeploymentStrategy()
+	if strategyName != "" {
+		strategy.Name = strategyName
+	}
+	if batchSize >= 0 {
+		strategy.BatchSize = batchSize
+	}
+	if maxFailurePercent >= 0 {
+		strategy.MaxFailurePercent = maxFailurePercent
+	}
+	orchestrator.SetDeploymentStrategy(strategy)
+	if *retries >= 0 {
+		orchestrator.SetAutoRetry(*retries > 0, *retries)
+	}
+	if autoRollback {
+		orchestrator.SetAutoRollback(true)
+	}
+
+	// Production deploys start within the maintenance window
+	if action == status.ActionDeploy || (action == status.ActionProvision && thenDeploy) {
+		start := now
+		if startAt != nil {
+			start = *startAt
+		}
+		if *inWindow {
+			start = window.Next(start)
+		}
+		if err := orchestrator.CheckWindow(status.ActionDeploy, start); err != nil {
+			if !*overrideWindow {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				fmt.Fprintln(os.Stderr, "Schedule it with --in-window or --at, or deploy anyway with --override-window")
+				return exitUsage
+			}
+			fmt.Printf("Warning: %v, overridden\n", err)
+		}
+	}
+
+	if scheduled {
+		orchestrator.SetSchedule(startAt, *inWindow)
+	} else {
+		// Lock the environment before touching its queue and statuses
+		if err := orchestrator.Start(servers); err != nil {
+			return reportStartError(*envName, err)
+		}
+		defer orchestrator.Stop()
+		printFileWarnings()
+	}
+
+	// Actions left running by a crashed run wait for a decision
+	if interrupted := orchestrator.InterruptedActions(); !scheduled && len(interrupted) > 0 {
+		if *resume == "" {
+			fmt.Fprintf(os.Stderr, "Error: %d action(s) were interrupted when their run stopped:\n", len(interrupted))
+			for _, a := range interrupted {
+				fmt.Fprintf(os.Stderr, "  %s on %s, started %s\n", a.Action, a.ServerName, a.StartedAt.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Fprintln(os.Stderr, "Run again with --resume rerun, skip or clear")
+			return exitUsage
+		}
+		if err := orchestrator.ResolveInterrupted(*resume); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	orchestrator.ValidateInventory(servers)
+
+	if pending := orchestrator.GetQueueSize(); pending > 0 && !scheduled {
+		fmt.Printf("Note: %d action(s) left in the queue from a previous session will run first\n", pending)
+		paused := 0
+		for _, queued := range orchestrator.GetQueuedActions() {
+			if queued.Paused {
+				paused++
+			}
+		}
+		if paused > 0 {
+			fmt.Printf("Note: %d of them are paused and stay queued (inventory-manager queue resume --env %s --id <ID>)\n", paused, *envName)
+		}
+	}
+
+	names := make([]string, 0, len(servers))
+	for _, s := range servers {
+		names = append(names, s.Name)
+	}
+
+	// Database and monitoring servers are only provisioned
+	deployable, others := inventory.SplitDeployable(servers, names)
+
+	switch action {
+	case status.ActionProvision:
+		if thenDeploy {
+			if len(deployable) > 0 {
+				pins, err := orchestrator.ResolveDeployPins(context.Background(), deployable, ref)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return exitFailed
+				}
+				orchestrator.QueueProvisionThenPinnedDeploy(deployable, 0, *tags, deployTags, pins)
+			}
+			if len(others) > 0 {
+				orchestrator.QueueProvisionWithTags(others, 0, *tags)
+			}
+		} else {
+			orchestrator.QueueProvisionWithTags(names, 0, *tags)
+		}
+	case status.ActionDeploy:
+		for _, name := range others {
+			fmt.Printf("[%s] Nothing to deploy, database and monitoring servers are only provisioned\n", name)
+		}
+		pins, err := orchestrator.ResolveDeployPins(context.Background(), deployable, ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+		orchestrator.QueuePinnedDeploy(deployable, 0, *tags, pins)
+	case status.ActionCheck:
+		orchestrator.QueueCheck(names, 0)
+	case status.ActionRollback:
+		for _, name := range others {
+			fmt.Printf("[%s] Nothing to roll back, database and monitoring servers have no releases\n", name)
+		}
+		if err := orchestrator.QueueRollback(deployable, 0, release); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	if scheduled {
+		when := ""
+		if startAt != nil {
+			when = "at " + startAt.Format("2006-01-02 15:04 MST")
+		}
+		if *inWindow {
+			next := now
+			if startAt != nil {
+				next = *startAt
+				when = "from " + startAt.Format("2006-01-02 15:04 MST") + " "
+			}
+			when += fmt.Sprintf("in the maintenance window %q, starting %s", window, window.Next(next).Format("2006-01-02 15:04 MST"))
+		}
+		fmt.Printf("Scheduled %s on %s %s: %s\n", action, *envName, when, strings.Join(names, ", "))
+		fmt.Printf("Run by 'inventory-manager daemon' (or any run of %s once due)\n", *envName)
+		return exitOK
+	}
+
+	fmt.Printf("Running %s on %s: %s\n", action, *envName, strings.Join(names, ", "))
+
+	if !waitForQueue(orchestrator) {
+		return exitInterrupted
+	}
+
+	fmt.Println()
+	return printStatuses(statusMgr, names)
+}
+
+// waitForQueue waits for the running orchestrator to be idle, then stops it.
+// It returns false when the process was interrupted first.
+func waitForQueue(orchestrator *ansible.Orchestrator) bool {
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case sig := <-interrupted:
+			fmt.Fprintf(os.Stderr, "\nReceived %v, stopping...\n", sig)
+			orchestrator.Stop()
+			return false
+		case <-ticker.C:
+			if orchestrator.IsIdle() {
+				orchestrator.Stop()
+				return true
+			}
+		}
+	}
+}
+
+// runPromote deploys the commit running on the web servers of an environment
+// to the next one of the pipeline, once it passed the gates of the promotion
+func runPromote(args []string) int {
+	fs := flag.NewFlagSet("promote", flag.ContinueOnError)
+	target := fs.String("to", "", "environment to promote to (required)")
+	from := fs.String("from", "", "environment to promote from (default: the previous stage of the pipeline)")
+	serverList := fs.String("servers", "", "comma-separated servers of the target to deploy to (default: all web servers)")
+	approve := fs.Bool("approve", false, "approve the promotion, for stages requiring approval")
+	workers := fs.Int("workers", -1, "parallel workers (default: max_parallel_workers from config.yml of the target)")
+	overrideWindow := fs.Bool("override-window", false, "promote to a production environment outside its maintenance window")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *target == "" {
+		fmt.Fprintln(os.Stderr, "Error: --to is required")
+		fs.Usage()
+		return exitUsage
+	}
+
+	configMgr := config.NewManager("inventory")
+	pipeline, err := configMgr.LoadPipeline()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	stage, source, err := pipeline.Promotion(*from, *target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	sourceEnv, sourceServers, err := loadServers(source, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	targetEnv, targetServers, err := loadServers(*target, *serverList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	names := make([]string, 0, len(targetServers))
+	for _, s := range targetServers {
+		names = append(names, s.Name)
+	}
+	deployable, _ := inventory.SplitDeployable(targetServers, names)
+	if len(deployable) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no web server to promote to in %s\n", *target)
+		return exitUsage
+	}
+
+	sourceOrchestrator, _, err := envOrchestrator(source, sourceEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	orchestrator, statusMgr, err := envOrchestrator(*target, targetEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	if *workers >= 0 {
+		orchestrator.SetMaxWorkers(*workers)
+	}
+	if err := orchestrator.CheckWindow(status.ActionDeploy, time.Now()); err != nil {
+		if !*overrideWindow {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintln(os.Stderr, "Promote within the window, or anyway with --override-window")
+			return exitUsage
+		}
+		fmt.Printf("Warning: %v, overridden\n", err)
+	}
+
+	if code := unlockKeys(sourceEnv, sourceServers); code != exitOK {
+		return code
+	}
+	if code := unlockKeys(targetEnv, targetServers); code != exitOK {
+		return code
+	}
+
+	gates := "deployed, same commit"
+	if stage.HealthGate() {
+		gates += ", health check"
+	}
+	if stage.Approval {
+		gates += ", approval"
+	}
+	fmt.Printf("Inspecting %s before promoting it to %s (gates: %s)\n", source, *target, gates)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	promoted, err := sourceOrchestrator.InspectPromotionSource(ctx, sourceServers, stage.HealthGate())
+	cancel()
+	if err != nil {
+		orchestrator.RecordPromotion(source, "", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Printf("%s runs %s on %s\n", source, promoted.Commit, strings.Join(promoted.Servers, ", "))
+
+	if stage.Approval && !*approve {
+		if !term.IsTerminal(os.Stdin.Fd()) {
+			fmt.Fprintf(os.Stderr, "Error: promotions to %s require approval, run again with --approve\n", *target)
+			return exitUsage
+		}
+		fmt.Printf("Promote %s from %s to %s (%s)? [y/N] ", promoted.ShortCommit(), source, *target, strings.Join(deployable, ", "))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			err := fmt.Errorf("%w by %s", ansible.ErrPromotionRejected, status.CurrentOperator())
+			orchestrator.RecordPromotion(source, promoted.Commit, err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+	}
+
+	// Lock the environment before touching its queue and statuses
+	if err := orchestrator.Start(targetServers); err != nil {
+		return reportStartError(*target, err)
+	}
+	defer orchestrator.Stop()
+	printFileWarnings()
+	if interrupted := orchestrator.InterruptedActions(); len(interrupted) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d action(s) of %s were interrupted when their run stopped, decide with --resume on another command first\n", len(interrupted), *target)
+		return exitUsage
+	}
+	orchestrator.ValidateInventory(targetServers)
+
+	orchestrator.QueuePinnedDeploy(deployable, 0, "", ansible.PromotedPins(deployable, promoted.Commit, source))
+	orchestrator.RecordPromotion(source, promoted.Commit, nil)
+	fmt.Printf("Promoting %s from %s to %s: %s\n", promoted.ShortCommit(), source, *target, strings.Join(deployable, ", "))
+
+	if !waitForQueue(orchestrator) {
+		return exitInterrupted
+	}
+
+	fmt.Println()
+	return printStatuses(statusMgr, deployable)
+}
+
+// envOrchestrator prepares the orchestrator of an environment, with its own
+// status manager and config.yml
+func envOrchestrator(envName string, env *inventory.Environment) (*ansible.Orchestrator, *status.Manager, error) {
+	configOpts, err := config.NewManager("inventory").Load(envName)
+	if err != nil {
+		log.Printf("[CLI] Failed to load config for %s, using defaults: %v", envName, err)
+		configOpts = config.DefaultConfig()
+	}
+	statusMgr, err := status.NewManager(envName)
+	if err != nil {
+		return nil, nil, err
+	}
+	orchestrator, err := ansible.NewEnvironmentOrchestrator(envName, env, configOpts, statusMgr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var outMu sync.Mutex
+	orchestrator.SetProgressCallback(func(serverName, message string) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		fmt.Printf("[%s] %s\n", serverName, message)
+	})
+	return orchestrator, statusMgr, nil
+}
+
+// maintenanceWindow parses the maintenance window of an environment, in its
+// time zone. It returns nil when the environment has none.
+func maintenanceWindow(env *inventory.Environment) (*schedule.Window, error) {
+	return schedule.ParseWindow(env.Config.MaintenanceWindow, schedule.Location(env.Config.Timezone))
+}
+
+// runStatus prints the stored status of every server without touching them
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (default: all environments)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	envs := []string{*envName}
+	if *envName == "" {
+		var err error
+		envs, err = storage.NewStorage(".").ListEnvironments()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+	}
+
+	code := exitOK
+	for _, env := range envs {
+		_, servers, err := loadServers(env, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+
+		statusMgr, err := status.NewManager(env)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+
+		names := make([]string, 0, len(servers))
+		for _, s := range servers {
+			names = append(names, s.Name)
+		}
+
+		fmt.Printf("Environment: %s\n", env)
+		if info, err := status.ReadLock(env); err == nil && info != nil {
+			stale := ""
+			if info.Stale() {
+				stale = " (stale)"
+			}
+			fmt.Printf("Locked by %s%s\n", info, stale)
+		}
+		if printStatuses(statusMgr, names) != exitOK {
+			code = exitFailed
+		}
+		fmt.Println()
+	}
+
+	return code
+}
+
+// runUnlock removes the lock of an environment. A lock whose holder still
+// runs is only removed with --force.
+func runUnlock(args []string) int {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	force := fs.Bool("force", false, "remove the lock even if its holder still runs")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		return exitUsage
+	}
+
+	info, err := status.ReadLock(*envName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	if info == nil {
+		fmt.Printf("Environment %s is not locked\n", *envName)
+		return exitOK
+	}
+	if !info.Stale() && !*force {
+		fmt.Fprintf(os.Stderr, "Error: environment %s is held by %s, which still runs\n", *envName, info)
+		fmt.Fprintln(os.Stderr, "Stop it first, or use --force if you are sure it is gone")
+		return exitBusy
+	}
+
+	if _, err := status.ForceUnlock(*envName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Printf("Removed the lock of %s held by %s\n", *envName, info)
+	return exitOK
+}
+
+// printFileWarnings tells about the state files recovered from their backup
+func printFileWarnings() {
+	for _, warning := range atomicfile.TakeWarnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+}
+
+// reportStartError prints why the orchestrator of an environment could not
+// start and returns the exit code
+func reportStartError(envName string, err error) int {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	var locked *status.LockedError
+	if errors.As(err, &locked) {
+		fmt.Fprintf(os.Stderr, "If that run crashed, remove its lock with: inventory-manager unlock --env %s\n", envName)
+		return exitBusy
+	}
+	return exitFailed
+}
+
+// runHistory lists the recorded runs of an environment, or prints the log of one
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	server := fs.String("server", "", "only runs on this server")
+	action := fs.String("action", "", "only runs of this action (provision, deploy, check, rollback, validate, promote)")
+	result := fs.String("result", "", "only runs with this result (success, failed, skipped, interrupted, cancelled)")
+	limit := fs.Int("limit", 20, "number of most recent runs to list (0 = all)")
+	logID := fs.String("log", "", "print the log file of the run with this ID (or ID prefix)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		return exitUsage
+	}
+	switch status.ActionType(*action) {
+	case "", status.ActionProvision, status.ActionDeploy, status.ActionCheck, status.ActionRollback, status.ActionValidate, status.ActionPromote:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown action %q\n", *action)
+		return exitUsage
+	}
+	switch *result {
+	case "", status.RunSucceeded, status.RunFailed, status.RunSkipped, status.RunInterrupted, status.RunCancelled:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown result %q (use success, failed, skipped, interrupted or cancelled)\n", *result)
+		return exitUsage
+	}
+	// Servers removed from the inventory keep their history, so only the
+	// environment is checked
+	if _, _, err := loadServers(*envName, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	history, err := status.NewHistory(*envName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+
+	if *logID != "" {
+		return printRunLog(history, *logID)
+	}
+
+	runs, err := history.List(status.HistoryFilter{
+		ServerName: *server,
+		Action:     status.ActionType(*action),
+		Status:     *result,
+		Limit:      *limit,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded")
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTARTED\tSERVER\tACTION\tRESULT\tDURATION\tRECAP\tOPERATOR")
+	for _, run := range runs {
+		id := run.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		action := string(run.Action)
+		if run.CheckMode {
+			action += " (check)"
+		}
+		if run.Tags != "" {
+			action += " [" + run.Tags + "]"
+		}
+		if run.Commit != "" {
+			action += " @" + shortOrDash(run.Commit)
+		}
+		if run.Ref != "" && run.Ref != run.Commit {
+			action += " (" + run.Ref + ")"
+		}
+		if run.Source != "" {
+			action += " (from " + run.Source + ")"
+		}
+		result := run.Status
+		if run.Attempt > 1 {
+			result += fmt.Sprintf(" (attempt %d)", run.Attempt)
+		}
+		recap := "-"
+		if run.Recap != nil {
+			recap = fmt.Sprintf("ok=%d changed=%d unreachable=%d failed=%d",
+				run.Recap.Ok, run.Recap.Changed, run.Recap.Unreachable, run.Recap.Failed)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			id, run.StartTime.Format("2006-01-02 15:04:05"), orDash(run.ServerName), action, result,
+			run.Duration().Round(time.Second), recap, run.Operator)
+	}
+	w.Flush()
+
+	return exitOK
+}
+
+// runReleases lists the releases of the web servers of an environment, the
+// current one marked with *, and flags the servers drifting from the others
+func runReleases(args []string) int {
+	fs := flag.NewFlagSet("releases", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	serverList := fs.String("servers", "", "comma-separated server names (default: all web servers)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		return exitUsage
+	}
+
+	env, servers, err := loadServers(*envName, *serverList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if code := unlockKeys(env, servers); code != exitOK {
+		return code
+	}
+
+	// Only web servers have releases
+	var web []*inventory.Server
+	for _, server := range servers {
+		if server.Deployable() {
+			web = append(web, server)
+		}
+	}
+	if len(web) == 0 {
+		fmt.Println("No web servers selected")
+		return exitOK
+	}
+
+	lister := ssh.NewReleaseLister(ssh.NewHostKeys(*envName), *env)
+	results := lister.ListAll(web, ssh.DefaultDetectWorkers)
+
+	code := exitOK
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tRELEASE\tCOMMIT\tBRANCH\tDEPLOYED\tSIZE")
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%v\n", result.Server, result.Err)
+			code = exitFailed
+			continue
+		}
+		for _, release := range result.Releases {
+			id := "  " + release.ID
+			if release.Current {
+				id = "* " + release.ID
+			}
+			branch, deployed := release.Branch, "-"
+			if branch == "" {
+				branch = "-"
+			}
+			if !release.DeployedAt.IsZero() {
+				deployed = release.DeployedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d KB\n",
+				result.Server, id, release.ShortCommit(), branch, deployed, release.SizeKB)
+		}
+	}
+	w.Flush()
+
+	var warnings []string
+	for _, result := range results {
+		if result.Drift != "" {
+			warnings = append(warnings, fmt.Sprintf("%s drifts from the environment: %s", result.Server, result.Drift))
+		}
+		if result.Err == nil && result.Current == "" {
+			warnings = append(warnings, fmt.Sprintf("%s has no current release", result.Server))
+		}
+	}
+	if len(warnings) > 0 {
+		fmt.Println()
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	}
+
+	return code
+}
+
+// runDrift compares the commit, Node.js, nginx and PM2 process count running
+// on the web servers of an environment with their configuration and with each
+// other. Exits 1 when a server drifts or cannot be inspected.
+func runDrift(args []string) int {
+	fs := flag.NewFlagSet("drift", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	serverList := fs.String("servers", "", "comma-separated server names (default: all web servers)")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		return exitUsage
+	}
+
+	env, servers, err := loadServers(*envName, *serverList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if code := unlockKeys(env, servers); code != exitOK {
+		return code
+	}
+
+	var web []*inventory.Server
+	for _, server := range servers {
+		if server.Deployable() {
+			web = append(web, server)
+		}
+	}
+	if len(web) == 0 {
+		fmt.Println("No web servers selected")
+		return exitOK
+	}
+
+	detector := ssh.NewDriftDetector(ssh.NewHostKeys(*envName), *env)
+	results := detector.DetectAll(web, ssh.DefaultDetectWorkers)
+
+	code := exitOK
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tBRANCH\tEXPECTED\tDEPLOYED\tNODE\tNGINX\tPM2\tDRIFT")
+	for _, result := range results {
+		expected := shortOrDash(result.ConfiguredCommit)
+		if result.Err != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\t%v\n", result.Server, result.Branch, expected, result.Err)
+			code = exitFailed
+			continue
+		}
+		facts := result.Deployed
+		pm2 := "-"
+		if facts.PM2Online >= 0 {
+			pm2 = fmt.Sprintf("%d online", facts.PM2Online)
+		}
+		drift := "-"
+		if result.Drifted() {
+			drift = "yes"
+			code = exitFailed
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.Server, result.Branch, expected,
+			shortOrDash(facts.Commit), orDash(facts.Node), orDash(facts.Nginx), pm2, drift)
+	}
+	w.Flush()
+
+	var notes []string
+	for _, result := range results {
+		for _, difference := range result.Differences {
+			notes = append(notes, fmt.Sprintf("Drift: %s: %s", result.Server, difference))
+		}
+		if result.ResolveErr != nil {
+			notes = append(notes, fmt.Sprintf("Warning: %s: cannot resolve %s: %v", result.Server, result.Branch, result.ResolveErr))
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Println()
+		for _, note := range notes {
+			fmt.Println(note)
+		}
+	}
+
+	return code
+}
+
+func shortOrDash(commit string) string {
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	return orDash(commit)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// printRunLog prints the ansible log of a recorded run
+func printRunLog(history *status.History, id string) int {
+	run, err := history.Get(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if run.LogFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: run %s has no log file (%s)\n", run.ID, run.Status)
+		return exitFailed
+	}
+
+	data, err := os.ReadFile(run.LogFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	os.Stdout.Write(data)
+	return exitOK
+}
+
+// runHostKey manages the known_hosts file of an environment:
+// hostkey list|accept|forget --env <env> [--servers a,b]
+func runHostKey(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Usage: inventory-manager hostkey list|accept|forget --env <env> [--servers a,b]")
+		return exitUsage
+	}
+	subcommand := args[0]
+
+	fs := flag.NewFlagSet("hostkey "+subcommand, flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	serversFlag := fs.String("servers", "", "comma-separated server names (default: all)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		return exitUsage
+	}
+
+	env, servers, err := loadServers(*envName, *serversFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	hostKeys := ssh.NewHostKeys(*envName)
+
+	switch subcommand {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVER\tADDRESS\tTYPE\tFINGERPRINT")
+		for _, server := range servers {
+			address := fmt.Sprintf("%s:%d", server.IP, server.Port)
+			keys, err := hostKeys.Known(server.IP, server.Port)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return exitFailed
+			}
+			if len(keys) == 0 {
+				fmt.Fprintf(w, "%s\t%s\t-\tnot known yet\n", server.Name, address)
+			}
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", server.Name, address, key.Type(), gossh.FingerprintSHA256(key))
+			}
+		}
+		w.Flush()
+		return exitOK
+
+	case "accept", "forget":
+		statusMgr, err := status.NewManager(*envName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+
+		code := exitOK
+		for _, server := range servers {
+			if subcommand == "forget" {
+				err = hostKeys.Forget(server.IP, server.Port)
+				if err == nil {
+					fmt.Printf("%s: host key forgotten, the next connection records the new one\n", server.Name)
+				}
+			} else {
+				var fingerprint string
+				fingerprint, err = hostKeys.Accept(server.IP, server.Port, env.JumpHostFor(*server))
+				if err == nil {
+					fmt.Printf("%s: accepted host key %s\n", server.Name, fingerprint)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", server.Name, err)
+				code = exitFailed
+				continue
+			}
+
+			// The server has to be validated again to know its real state,
+			// unless a run holds the environment and detects it itself
+			err = statusMgr.Locked(func() error {
+				if statusMgr.GetStatus(server.Name).State != status.StateHostKeyChanged {
+					return nil
+				}
+				return statusMgr.UpdateStatus(server.Name, status.StateUnknown, status.ActionCheck, "Host key updated, validate the server again")
+			})
+			var locked *status.LockedError
+			if errors.As(err, &locked) {
+				fmt.Printf("%s: state left as is, %s is held by %s\n", server.Name, *envName, locked.Info.Holder)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", server.Name, err)
+				code = exitFailed
+			}
+		}
+		return code
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown hostkey command: %s (use list, accept or forget)\n", subcommand)
+		return exitUsage
+	}
+}
+
+// cancelRequestTimeout is how long the run holding an environment is given to
+// take a cancel request
+const cancelRequestTimeout = 10 * time.Second
+
+// runQueue lists or edits the queue of an environment:
+// queue list|cancel|move|priority|pause|resume --env <env> [--id <action ID>]
+func runQueue(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Usage: inventory-manager queue list|cancel|move|priority|pause|resume --env <env> [--id <action ID>]")
+		return exitUsage
+	}
+	subcommand := args[0]
+
+	fs := flag.NewFlagSet("queue "+subcommand, flag.ContinueOnError)
+	envName := fs.String("env", "", "environment name (required)")
+	id := fs.String("id", "", "ID (or ID prefix) of the action")
+	position := fs.Int("position", 0, "new position of the action, 1 being the first (move only)")
+	priority := fs.Int("priority", 0, "new priority of the action (priority only)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if *envName == "" {
+		fmt.Fprintln(os.Stderr, "Error: --env is required")
+		return exitUsage
+	}
+	if _, _, err := loadServers(*envName, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	// Read as saved by the run holding the environment, if any
+	queue, err := ansible.NewQueue(*envName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	holder, err := status.ReadLock(*envName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	held := holder != nil && !holder.Stale()
+
+	switch subcommand {
+	case "list":
+		printQueue(queue.GetAll(), held)
+		if held {
+			fmt.Printf("\nRun by %s\n", holder)
+		}
+		return exitOK
+	case "cancel", "move", "priority", "pause", "resume":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown queue command: %s (use list, cancel, move, priority, pause or resume)\n", subcommand)
+		return exitUsage
+	}
+
+	if *id == "" {
+		fmt.Fprintln(os.Stderr, "Error: --id is required")
+		return exitUsage
+	}
+	if subcommand == "move" && *position < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --position is required, 1 being the first")
+		return exitUsage
+	}
+	action, err := queue.Find(*id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	if held {
+		// Only the run holding the environment can stop its actions
+		if subcommand != "cancel" {
+			fmt.Fprintf(os.Stderr, "Error: the queue of %s is run by %s, edit it from there\n", *envName, holder)
+			return exitBusy
+		}
+		taken, err := ansible.RequestCancel(*envName, action.ID, cancelRequestTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+		if !taken {
+			fmt.Fprintf(os.Stderr, "Error: %s did not take the cancel request within %s\n", holder, cancelRequestTimeout)
+			return exitBusy
+		}
+		fmt.Printf("Cancel of %s on %s taken by the run of %s\n", action.Action, action.ServerName, holder.Holder)
+		return exitOK
+	}
+
+	lock, err := status.AcquireLock(*envName, status.CurrentOperator())
+	if err != nil {
+		return reportStartError(*envName, err)
+	}
+	defer lock.Release()
+
+	statusMgr, err := status.NewManager(*envName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	// Loaded once the environment is locked
+	orchestrator, err := ansible.NewOrchestrator(*envName, statusMgr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	orchestrator.SetProgressCallback(func(serverName, message string) {
+		fmt.Printf("[%s] %s\n", serverName, message)
+	})
+
+	switch subcommand {
+	case "cancel":
+		_, err = orchestrator.CancelAction(action.ID)
+	case "move":
+		err = orchestrator.MoveAction(action.ID, *position-1)
+	case "priority":
+		err = orchestrator.SetActionPriority(action.ID, *priority)
+	case "pause":
+		err = orchestrator.PauseAction(action.ID)
+	case "resume":
+		err = orchestrator.ResumeAction(action.ID)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	if subcommand != "cancel" {
+		printQueue(orchestrator.GetQueuedActions(), false)
+	}
+	return exitOK
+}
+
+// printQueue writes the queued actions in the order they start in. Started
+// actions are running when the queue is held, interrupted otherwise.
+func printQueue(actions []*status.QueuedAction, held bool) {
+	if len(actions) == 0 {
+		fmt.Println("The queue is empty")
+		return
+	}
+
+	queued := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		queued[action.ID] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tID\tSERVER\tACTION\tPRIORITY\tSTATE\tQUEUED")
+	for i, action := range actions {
+		state := "queued"
+		switch {
+		case action.Interrupted || (action.StartedAt != nil && !held):
+			state = "interrupted"
+		case action.StartedAt != nil:
+			state = "running"
+		case action.Paused:
+			state = "paused"
+		case action.Scheduled && action.NotBefore != nil && action.NotBefore.After(time.Now()):
+			state = "at " + action.NotBefore.Format("2006-01-02 15:04")
+		case action.NotBefore != nil && action.NotBefore.After(time.Now()):
+			state = "retry at " + action.NotBefore.Format("15:04:05")
+		}
+		if state == "queued" {
+			for _, dep := range action.DependsOn {
+				if queued[dep] {
+					state = "waiting"
+				}
+			}
+		}
+		if action.InWindow && state == "queued" {
+			state = "in window"
+		} else if action.InWindow && action.StartedAt == nil {
+			state += ", in window"
+		}
+		name := string(action.Action)
+		if action.Commit != "" {
+			name += " @" + shortOrDash(action.Commit)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n", i+1, action.ID[:min(8, len(action.ID))], action.ServerName,
+			name, action.Priority, state, action.QueuedAt.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+// loadServers loads an environment and keeps only the requested servers.
+// An empty selection means every server of the environment.
+func loadServers(envName, selection string) (*inventory.Environment, []*inventory.Server, error) {
+	env, err := storage.NewStorage(".").LoadEnvironment(envName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("environment %s: %w", envName, err)
+	}
+
+	servers := make([]*inventory.Server, len(env.Servers))
+	for i := range env.Servers {
+		servers[i] = &env.Servers[i]
+	}
+
+	if selection == "" {
+		return env, servers, nil
+	}
+
+	byName := make(map[string]*inventory.Server, len(servers))
+	for _, s := range servers {
+		byName[s.Name] = s
+	}
+
+	var selected []*inventory.Server
+	for _, name := range strings.Split(selection, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		server, ok := byName[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("server %s not found in environment %s", name, envName)
+		}
+		selected = append(selected, server)
+	}
+
+	if len(selected) == 0 {
+		return nil, nil, fmt.Errorf("no servers selected")
+	}
+	return env, selected, nil
+}
+
+// unlockKeys asks on the terminal the passphrase of the SSH keys of servers
+// (and of their jump hosts) that are protected and not held by the agent
+func unlockKeys(env *inventory.Environment, servers []*inventory.Server) int {
+	var keyPaths []string
+	for _, server := range servers {
+		keyPaths = append(keyPaths, server.SSHKeyPath)
+		if jump := env.JumpHostFor(*server); jump != nil {
+			keyPaths = append(keyPaths, jump.SSHKeyPath)
+		}
+	}
+	locked := ssh.LockedKeys(keyPaths)
+	if len(locked) == 0 {
+		return exitOK
+	}
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprintf(os.Stderr, "Error: SSH key %s is protected by a passphrase, add it to ssh-agent (ssh-add) to run unattended\n", locked[0])
+		return exitUsage
+	}
+
+	for _, keyPath := range locked {
+		for {
+			fmt.Fprintf(os.Stderr, "Passphrase for %s: ", keyPath)
+			passphrase, err := term.ReadPassword(os.Stdin.Fd())
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return exitUsage
+			}
+			err = ssh.UnlockKey(keyPath, passphrase)
+			for i := range passphrase {
+				passphrase[i] = 0
+			}
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ssh.ErrWrongPassphrase) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return exitUsage
+			}
+			fmt.Fprintln(os.Stderr, "Wrong passphrase, try again")
+		}
+	}
+	return exitOK
+}
+
+// printStatuses writes a status table and returns exitFailed if any server failed
+func printStatuses(statusMgr *status.Manager, names []string) int {
+	code := exitOK
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tSTATE\tLAST ACTION\tUPDATED\tCOMMIT\tLAST RUN\tMESSAGE")
+	for _, name := range names {
+		st := statusMgr.GetStatus(name)
+		if st.State == status.StateFailed || st.State == status.StateHostKeyChanged {
+			code = exitFailed
+		}
+		lastRun := "-"
+		if st.LastRun != nil {
+			lastRun = st.LastRun.String()
+			if st.LastRun.NoChanges() {
+				lastRun += " no changes"
+			}
+		}
+		state := string(st.State)
+		if attempt := st.AttemptString(); attempt != "" {
+			state += " (" + attempt + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, state, st.LastAction, st.LastUpdate.Format("2006-01-02 15:04:05"), orDash(st.CommitString()), lastRun, st.ErrorMessage)
+	}
+	w.Flush()
+
+	return code
+}
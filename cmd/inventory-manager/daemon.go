@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/config"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+	"github.com/bastiblast/boiler-deploy/internal/storage"
+)
+
+// daemon runs the queues of environments as their actions come due
+type daemon struct {
+	outMu     sync.Mutex
+	mu        sync.Mutex
+	running   map[string]*ansible.Orchestrator // Environment -> orchestrator running its due actions
+	announced map[string]time.Time             // Environment -> next start last printed
+	wg        sync.WaitGroup
+}
+
+// runDaemon checks the queues of the environments every interval and runs
+// the ones holding due actions: scheduled actions whose time came, actions
+// waiting for a maintenance window now open, or actions queued to run at
+// once. An environment is only locked while its actions run, so that other
+// commands can queue (or schedule) actions in between.
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	envList := fs.String("env", "", "comma-separated environment names (default: all environments)")
+	interval := fs.Duration("interval", 30*time.Second, "how often the queues are checked")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --interval must be positive")
+		return exitUsage
+	}
+
+	var envNames []string
+	if *envList != "" {
+		envNames = strings.Split(*envList, ",")
+	} else {
+		names, err := storage.NewStorage(".").ListEnvironments()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailed
+		}
+		envNames = names
+	}
+	if len(envNames) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no environment to run")
+		return exitUsage
+	}
+
+	// Keys are unlocked once, actions run unattended afterwards
+	for i, name := range envNames {
+		envNames[i] = strings.TrimSpace(name)
+		env, servers, err := loadServers(envNames[i], "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		if _, err := maintenanceWindow(env); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", envNames[i], err)
+			return exitUsage
+		}
+		if code := unlockKeys(env, servers); code != exitOK {
+			return code
+		}
+	}
+
+	d := &daemon{
+		running:   make(map[string]*ansible.Orchestrator),
+		announced: make(map[string]time.Time),
+	}
+	d.printf("Running the queues of %s, checked every %s", strings.Join(envNames, ", "), *interval)
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range envNames {
+			d.poll(name)
+		}
+		select {
+		case sig := <-interrupted:
+			d.printf("Received %v, stopping...", sig)
+			d.stopAll()
+			return exitOK
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *daemon) printf(format string, args ...interface{}) {
+	d.outMu.Lock()
+	defer d.outMu.Unlock()
+	fmt.Printf("%s "+format+"\n", append([]interface{}{time.Now().Format("2006-01-02 15:04:05")}, args...)...)
+}
+
+// poll starts running the queue of an environment when it holds due actions
+// and nobody runs it yet
+func (d *daemon) poll(envName string) {
+	d.mu.Lock()
+	_, busy := d.running[envName]
+	d.mu.Unlock()
+	if busy {
+		return
+	}
+	if holder, _ := status.ReadLock(envName); holder != nil && !holder.Stale() {
+		return // Run by someone else, due actions included
+	}
+
+	env, servers, err := loadServers(envName, "")
+	if err != nil {
+		d.printf("[%s] %v", envName, err)
+		return
+	}
+	configOpts, err := config.NewManager("inventory").Load(envName)
+	if err != nil {
+		log.Printf("[DAEMON] Failed to load config for %s, using defaults: %v", envName, err)
+		configOpts = config.DefaultConfig()
+	}
+	statusMgr, err := status.NewManager(envName)
+	if err != nil {
+		d.printf("[%s] %v", envName, err)
+		return
+	}
+	orchestrator, err := ansible.NewEnvironmentOrchestrator(envName, env, configOpts, statusMgr)
+	if err != nil {
+		d.printf("[%s] %v", envName, err)
+		return
+	}
+
+	if orchestrator.IsIdle() {
+		if next, ok := orchestrator.NextStart(); ok && !next.Equal(d.announced[envName]) {
+			d.announced[envName] = next
+			d.printf("[%s] Next scheduled action at %s", envName, next.Format("2006-01-02 15:04 MST"))
+		}
+		return
+	}
+
+	orchestrator.SetProgressCallback(func(serverName, message string) {
+		d.printf("[%s/%s] %s", envName, serverName, message)
+	})
+	if err := orchestrator.Start(servers); err != nil {
+		var locked *status.LockedError
+		if !errors.As(err, &locked) {
+			d.printf("[%s] %v", envName, err)
+		}
+		return // Taken by another run in between
+	}
+	if interrupted := orchestrator.InterruptedActions(); len(interrupted) > 0 {
+		d.printf("[%s] %d action(s) were interrupted when their run stopped and wait for a decision (inventory-manager <command> --env %s --resume ...)",
+			envName, len(interrupted), envName)
+	}
+	d.printf("[%s] Running %d queued action(s)", envName, orchestrator.GetQueueSize())
+
+	d.mu.Lock()
+	d.running[envName] = orchestrator
+	d.mu.Unlock()
+	d.wg.Add(1)
+	go d.supervise(envName, orchestrator)
+}
+
+// supervise releases the environment once no due action is left
+func (d *daemon) supervise(envName string, orchestrator *ansible.Orchestrator) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !orchestrator.IsRunning() {
+			break // Stopped by stopAll
+		}
+		if orchestrator.IsIdle() {
+			orchestrator.Stop()
+			d.printf("[%s] Done, %d action(s) left in the queue", envName, orchestrator.GetQueueSize())
+			break
+		}
+	}
+
+	d.mu.Lock()
+	delete(d.running, envName)
+	d.mu.Unlock()
+}
+
+// stopAll stops the running queues, their running actions are cancelled
+func (d *daemon) stopAll() {
+	d.mu.Lock()
+	for _, orchestrator := range d.running {
+		orchestrator.Stop()
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
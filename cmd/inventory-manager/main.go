@@ -28,8 +28,12 @@ func resetAllEnvironments() {
 			log.Printf("Failed to create status manager for %s: %v", envName, err)
 			continue
 		}
+		// The states of an environment held by a run are live
+		if err := mgr.Locked(mgr.ResetInProgress); err != nil {
+			log.Printf("Not resetting %s: %v", envName, err)
+			continue
+		}
 		log.Printf("Reset completed for environment: %s", envName)
-		_ = mgr
 	}
 }
 
@@ -44,7 +48,6 @@ func validateAllServers() {
 		return
 	}
 	
-	detector := ssh.NewStateDetector()
 	totalChecked := 0
 	totalUpdated := 0
 	
@@ -71,32 +74,80 @@ func validateAllServers() {
 			log.Printf("[STARTUP] Failed to create status manager for %s: %v", env, err)
 			continue
 		}
+		// The states of an environment held by a run are live
+		if info, err := status.ReadLock(env); err == nil && info != nil && !info.Stale() {
+			log.Printf("[STARTUP] Skipping %s, held by %s", env, info)
+			continue
+		}
+		
+		// Jump hosts and probes are only in the environment config, not in hosts.yml
+		var jumpHost *inventory.JumpHost
+		probeEnv := inventory.Environment{Name: env}
+		if envConfig, err := stor.LoadEnvironment(env); err == nil {
+			jumpHost = envConfig.Config.JumpHost
+			probeEnv = *envConfig
+			for _, server := range servers {
+				for _, configured := range envConfig.Servers {
+					if configured.Name == server.Name {
+						server.JumpHost = configured.JumpHost
+					}
+				}
+			}
+		}
+		detector := ssh.NewStateDetector(ssh.NewHostKeys(env), jumpHost)
+		detector.SetEnvironment(probeEnv)
 		
-		// Check each server
+		// Keys are unlocked later, when an action needs them
+		var reachable []*inventory.Server
 		for _, server := range servers {
-			totalChecked++
+			keyPaths := []string{server.SSHKeyPath}
+			if jump := inventory.ResolveJumpHost(*server, jumpHost); jump != nil {
+				keyPaths = append(keyPaths, jump.SSHKeyPath)
+			}
+			if locked := ssh.LockedKeys(keyPaths); len(locked) > 0 {
+				log.Printf("[STARTUP] Skipping %s: SSH key %s needs a passphrase", server.Name, locked[0])
+				continue
+			}
 			log.Printf("[STARTUP] Checking: %s (%s:%d)", server.Name, server.IP, server.Port)
+			reachable = append(reachable, server)
+		}
+		
+		// Detect real state via SSH, several servers at once
+		results := detector.DetectAll(reachable, ssh.DefaultDetectWorkers)
+		
+		// Only recorded if no run took the environment meanwhile
+		err = statusMgr.Locked(func() error {
+			for i, server := range reachable {
+				totalChecked++
+				result := results[i]
+				for _, probe := range result.Probes {
+					if !probe.Passed {
+						log.Printf("[STARTUP] %s: probe %s failed: %s", server.Name, probe.Name, probe.Output)
+					}
+				}
 			
-			// Detect real state via SSH
-			result := detector.DetectState(*server)
-			
-			// Get current status
-			currentStatus := statusMgr.GetStatus(server.Name)
+				// Get current status
+				currentStatus := statusMgr.GetStatus(server.Name)
 			
-			// Update if state changed
-			if currentStatus.State != result.State {
-				log.Printf("[STARTUP] Updating %s: %s → %s (reason: %s)", 
-					server.Name, currentStatus.State, result.State, result.Message)
+				// Update if state changed
+				if currentStatus.State != result.State {
+					log.Printf("[STARTUP] Updating %s: %s → %s (reason: %s)", 
+						server.Name, currentStatus.State, result.State, result.Message)
 				
-				err := statusMgr.UpdateStatus(server.Name, result.State, "", result.Message)
-				if err != nil {
-					log.Printf("[STARTUP] Failed to update status for %s: %v", server.Name, err)
+					err := statusMgr.UpdateStatus(server.Name, result.State, "", result.Message)
+					if err != nil {
+						log.Printf("[STARTUP] Failed to update status for %s: %v", server.Name, err)
+					} else {
+						totalUpdated++
+					}
 				} else {
-					totalUpdated++
+					log.Printf("[STARTUP] %s state confirmed: %s", server.Name, result.State)
 				}
-			} else {
-				log.Printf("[STARTUP] %s state confirmed: %s", server.Name, result.State)
 			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("[STARTUP] Not recording the states of %s: %v", env, err)
 		}
 	}
 	
@@ -115,6 +166,19 @@ func main() {
 		log.Println("============ Application Started ============")
 	}
 
+	// Headless mode: run a subcommand instead of the TUI
+	if len(os.Args) > 1 {
+		log.Printf("[CLI] Running command: %v", os.Args[1:])
+		code := runCLI(os.Args[1:])
+		ssh.ClosePool()
+		ssh.StopSessionAgent()
+		log.Printf("============ Command Exited (code %d) ============", code)
+		if logFile != nil {
+			logFile.Close()
+		}
+		os.Exit(code)
+	}
+
 	// Reset all environments' in-progress statuses
 	resetAllEnvironments()
 
@@ -127,7 +191,10 @@ func main() {
 		tea.WithAltScreen(),
 	)
 
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	ssh.ClosePool()
+	ssh.StopSessionAgent()
+	if err != nil {
 		log.Printf("Application error: %v", err)
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,68 @@
+package ansible
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cancelRequestDir holds the cancellations asked by other processes to the
+// orchestrator holding the lock of an environment, one empty file per action ID
+func cancelRequestDir(environment string) string {
+	return filepath.Join("inventory", environment, ".queue", "cancel")
+}
+
+// RequestCancel asks the orchestrator running the queue of an environment in
+// another process to cancel the action id (see Orchestrator.CancelAction). It
+// reports whether the request was taken within timeout; a request left
+// untaken is withdrawn.
+func RequestCancel(environment, id string, timeout time.Duration) (bool, error) {
+	if id == "" || strings.Trim(id, "0123456789abcdefABCDEF-") != "" {
+		return false, fmt.Errorf("invalid action ID %q", id)
+	}
+
+	dir := cancelRequestDir(environment)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, fmt.Errorf("failed to create cancel request directory: %w", err)
+	}
+	path := filepath.Join(dir, id)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return false, fmt.Errorf("failed to request cancel: %w", err)
+	}
+	log.Printf("[CANCEL] Requested cancel of action %s in %s", id, environment)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return true, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return true, nil // Taken at the last moment
+	}
+	log.Printf("[CANCEL] Cancel of action %s in %s not taken, withdrawn", id, environment)
+	return false, nil
+}
+
+// handleCancelRequests cancels the actions other processes asked to cancel
+func (o *Orchestrator) handleCancelRequests() {
+	dir := cancelRequestDir(o.environment)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		// Taking the request is removing it, the requester waits for that
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			continue
+		}
+		if _, err := o.CancelAction(entry.Name()); err != nil {
+			log.Printf("[ORCHESTRATOR] Cannot cancel action %s as requested: %v", entry.Name(), err)
+		}
+	}
+}
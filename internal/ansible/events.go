@@ -0,0 +1,196 @@
+package ansible
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+// EventType identifies a structured playbook event
+type EventType string
+
+const (
+	EventPlayStart  EventType = "play_start"
+	EventTaskStart  EventType = "task_start"
+	EventTaskResult EventType = "task_result"
+	EventRecap      EventType = "recap"
+)
+
+// Task result statuses reported by the callback plugin
+const (
+	TaskOK          = "ok"
+	TaskChanged     = "changed"
+	TaskFailed      = "failed"
+	TaskSkipped     = "skipped"
+	TaskUnreachable = "unreachable"
+)
+
+// Event is a structured playbook event emitted by the boiler_events
+// callback plugin (see callback_plugins/boiler_events.py)
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Play     string
+	Task     string
+	Host     string
+	Status   string // task_result only: ok, changed, failed, skipped, unreachable
+	Changed  bool
+	Ignored  bool // failure ignored by ignore_errors
+	Duration time.Duration
+	Msg      string
+	Recap    map[string]status.HostStats // recap only
+}
+
+// rawEvent mirrors the JSON written by the callback plugin
+type rawEvent struct {
+	Type     EventType                   `json:"type"`
+	Time     time.Time                   `json:"time"`
+	Play     string                      `json:"play"`
+	Task     string                      `json:"task"`
+	Host     string                      `json:"host"`
+	Status   string                      `json:"status"`
+	Changed  bool                        `json:"changed"`
+	Ignored  bool                        `json:"ignored"`
+	Duration float64                     `json:"duration"` // seconds
+	Msg      string                      `json:"msg"`
+	Recap    map[string]status.HostStats `json:"recap"`
+}
+
+// ParseEvent decodes one JSON line of the event stream
+func ParseEvent(line []byte) (Event, error) {
+	var raw rawEvent
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return Event{}, fmt.Errorf("invalid event: %w", err)
+	}
+	if raw.Type == "" {
+		return Event{}, fmt.Errorf("invalid event: missing type")
+	}
+
+	return Event{
+		Type:     raw.Type,
+		Time:     raw.Time,
+		Play:     raw.Play,
+		Task:     raw.Task,
+		Host:     raw.Host,
+		Status:   raw.Status,
+		Changed:  raw.Changed,
+		Ignored:  raw.Ignored,
+		Duration: time.Duration(raw.Duration * float64(time.Second)),
+		Msg:      raw.Msg,
+		Recap:    raw.Recap,
+	}, nil
+}
+
+// ParseRecapLine parses a host line of the text PLAY RECAP, e.g.
+// "web-01 : ok=12 changed=3 unreachable=0 failed=0 skipped=2 rescued=0 ignored=0"
+func ParseRecapLine(line string) (string, status.HostStats, bool) {
+	var stats status.HostStats
+
+	parts := strings.SplitN(line, " : ", 2)
+	if len(parts) != 2 {
+		return "", stats, false
+	}
+	host := strings.TrimSpace(parts[0])
+	if host == "" || strings.ContainsAny(host, " []") {
+		return "", stats, false
+	}
+
+	counters := map[string]*int{
+		"ok":          &stats.Ok,
+		"changed":     &stats.Changed,
+		"unreachable": &stats.Unreachable,
+		"failed":      &stats.Failed,
+		"skipped":     &stats.Skipped,
+		"rescued":     &stats.Rescued,
+		"ignored":     &stats.Ignored,
+	}
+	found := 0
+	for _, field := range strings.Fields(parts[1]) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		counter, ok := counters[kv[0]]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return "", stats, false
+		}
+		*counter = n
+		found++
+	}
+	if found == 0 {
+		return "", stats, false
+	}
+	return host, stats, true
+}
+
+// IsFailure reports whether the event is a task failure that aborts the host
+func (ev Event) IsFailure() bool {
+	if ev.Type != EventTaskResult {
+		return false
+	}
+	return ev.Status == TaskUnreachable || (ev.Status == TaskFailed && !ev.Ignored)
+}
+
+// ProgressMessage renders the event as a progress line for the UI.
+// It returns an empty string for events that are not worth displaying.
+func (e *Executor) ProgressMessage(ev Event) string {
+	switch ev.Type {
+	case EventPlayStart:
+		return fmt.Sprintf("▶️  Starting: %s", ev.Play)
+
+	case EventTaskStart:
+		taskName := e.translateTaskName(ev.Task)
+		if len(taskName) > 60 {
+			taskName = taskName[:57] + "..."
+		}
+		return fmt.Sprintf("⚙️  %s", taskName)
+
+	case EventTaskResult:
+		msg := strings.Join(strings.Fields(ev.Msg), " ")
+		switch ev.Status {
+		case TaskChanged:
+			return fmt.Sprintf("  ✓ Modified on %s (%s)", ev.Host, ev.Duration.Round(100*time.Millisecond))
+		case TaskFailed:
+			if ev.Ignored {
+				return fmt.Sprintf("  ⚠️  Ignored error on %s: %s", ev.Host, msg)
+			}
+			if msg == "" {
+				return fmt.Sprintf("  ❌ Task failed on %s", ev.Host)
+			}
+			return fmt.Sprintf("  ❌ Error on %s: %s", ev.Host, msg)
+		case TaskUnreachable:
+			return fmt.Sprintf("  ⚠️  %s unreachable - check SSH connection: %s", ev.Host, msg)
+		}
+		// ok / skipped are not shown to reduce noise
+		return ""
+
+	case EventRecap:
+		hosts := make([]string, 0, len(ev.Recap))
+		for host := range ev.Recap {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		var parts []string
+		for _, host := range hosts {
+			st := ev.Recap[host]
+			parts = append(parts, fmt.Sprintf("%s ok=%d changed=%d failed=%d unreachable=%d",
+				host, st.Ok, st.Changed, st.Failed, st.Unreachable))
+		}
+		if len(parts) == 0 {
+			return "📊 Summary of execution"
+		}
+		return "📊 Summary: " + strings.Join(parts, " | ")
+	}
+
+	return ""
+}
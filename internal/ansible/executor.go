@@ -3,18 +3,25 @@ package ansible
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
+	"github.com/bastiblast/boiler-deploy/internal/health"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
 	"github.com/bastiblast/boiler-deploy/internal/logger"
 	"github.com/bastiblast/boiler-deploy/internal/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/status"
 	"github.com/rs/zerolog"
 )
 
@@ -22,6 +29,7 @@ type Executor struct {
 	environment string
 	logDir      string
 	log         zerolog.Logger
+	hostKeys    *ssh.HostKeys
 }
 
 func NewExecutor(environment string) *Executor {
@@ -32,13 +40,54 @@ func NewExecutor(environment string) *Executor {
 		environment: environment,
 		logDir:      logDir,
 		log:         logger.Get("executor"),
+		hostKeys:    ssh.NewHostKeys(environment),
 	}
 }
 
+// HostKeys returns the known host keys of the environment
+func (e *Executor) HostKeys() *ssh.HostKeys {
+	return e.hostKeys
+}
+
 type ExecutionResult struct {
-	Success      bool
-	ErrorMessage string
-	LogFile      string
+	Success      bool                        `json:"success"`
+	ErrorMessage string                      `json:"error_message,omitempty"`
+	LogFile      string                      `json:"log_file"`
+	FailedTask   string                      `json:"failed_task,omitempty"` // Name of the task that failed, when known
+	Recap        map[string]status.HostStats `json:"recap,omitempty"`       // PLAY RECAP per host, empty if the run was aborted
+	Elapsed      time.Duration               `json:"elapsed"`
+	Strategy     string                      `json:"strategy,omitempty"`
+	CheckMode    bool                        `json:"check_mode,omitempty"`
+}
+
+// Summary returns the outcome of the run for one host, as stored in its status
+func (r *ExecutionResult) Summary(action status.ActionType, host string) status.RunSummary {
+	run := status.RunSummary{
+		Action:     action,
+		Strategy:   r.Strategy,
+		CheckMode:  r.CheckMode,
+		Success:    r.Success,
+		Elapsed:    r.Elapsed,
+		FinishedAt: time.Now(),
+		LogFile:    r.LogFile,
+	}
+	if stats, ok := r.Recap[host]; ok {
+		run.Recap = &stats
+	}
+	return run
+}
+
+// writeSummaryFile stores the result next to the log file of the run
+func (r *ExecutionResult) writeSummaryFile() {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Printf("[EXECUTOR] Could not encode run summary: %v", err)
+		return
+	}
+	summaryFile := strings.TrimSuffix(r.LogFile, ".log") + ".summary.json"
+	if err := atomicfile.WriteFile(summaryFile, data, 0644); err != nil {
+		log.Printf("[EXECUTOR] Could not write run summary: %v", err)
+	}
 }
 
 func (e *Executor) RunPlaybook(playbook string, serverName string, progressChan chan<- string) (*ExecutionResult, error) {
@@ -59,6 +108,28 @@ func (e *Executor) RunPlaybookWithContext(ctx context.Context, playbook string,
 }
 
 func (e *Executor) RunPlaybookWithContextAndOptions(ctx context.Context, playbook string, serverName string, tags string, checkMode bool, progressChan chan<- string) (*ExecutionResult, error) {
+	return e.Run(ctx, playbook, serverName, PlaybookOptions{Tags: tags, CheckMode: checkMode}, progressChan)
+}
+
+// PlaybookOptions holds the optional settings of a playbook run
+type PlaybookOptions struct {
+	Tags      string
+	CheckMode bool
+	ExtraVars map[string]string // Passed as -e key=value
+	Strategy  string            // Deployment strategy, recorded in the run summary
+	// Events receives the structured events of the run (may be nil).
+	// The channel is not closed by the executor.
+	Events chan<- Event
+}
+
+// Run executes a playbook against a single server. Progress lines are derived
+// from the structured event stream of the boiler_events callback plugin when
+// it is available, and scraped from the text output otherwise.
+func (e *Executor) Run(ctx context.Context, playbook string, serverName string, opts PlaybookOptions, progressChan chan<- string) (*ExecutionResult, error) {
+	tags := opts.Tags
+	checkMode := opts.CheckMode
+	start := time.Now()
+
 	// Add timeout if none specified
 	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
 		var cancel context.CancelFunc
@@ -113,12 +184,51 @@ func (e *Executor) RunPlaybookWithContextAndOptions(ctx context.Context, playboo
 		args = append(args, "--check", "--diff")
 	}
 
-	// Use CommandContext for cancellation support
+	extraVarNames := make([]string, 0, len(opts.ExtraVars))
+	for name := range opts.ExtraVars {
+		extraVarNames = append(extraVarNames, name)
+	}
+	sort.Strings(extraVarNames)
+	for _, name := range extraVarNames {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", name, opts.ExtraVars[name]))
+	}
+
+	// Use CommandContext for cancellation support. ansible-playbook runs in its
+	// own process group so that cancelling it also kills the ssh connections
+	// it forked, and nothing else.
 	cmd := exec.CommandContext(ctx, "ansible-playbook", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
 
-	// Don't use JSON callback as it can hang - use default callback and parse text
+	// Keep the human readable callback on stdout (it goes to the log file) and
+	// receive machine-readable events on a dedicated pipe instead
 	cmd.Env = append(os.Environ(), "ANSIBLE_FORCE_COLOR=false")
 
+	// Keys unlocked in the TUI are served to ssh by the session agent
+	if socket, err := ssh.AgentSocket(); err != nil {
+		log.Printf("[EXECUTOR] Session agent unavailable, unlocked keys won't be usable by ansible: %v", err)
+	} else if socket != "" {
+		cmd.Env = append(cmd.Env, "SSH_AUTH_SOCK="+socket)
+	}
+
+	var eventsReader *os.File
+	if pluginDir, ok := callbackPluginDir(); ok {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create event pipe: %w", err)
+		}
+		defer w.Close()
+		eventsReader = r
+		// ExtraFiles[0] becomes file descriptor 3 in the child
+		cmd.ExtraFiles = []*os.File{w}
+		cmd.Env = append(cmd.Env,
+			"BOILER_EVENTS_FD=3",
+			"ANSIBLE_CALLBACK_PLUGINS="+pluginDir,
+		)
+	}
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
@@ -130,23 +240,61 @@ func (e *Executor) RunPlaybookWithContextAndOptions(ctx context.Context, playboo
 	}
 
 	if err := cmd.Start(); err != nil {
+		if eventsReader != nil {
+			eventsReader.Close()
+		}
 		return nil, fmt.Errorf("failed to start ansible: %w", err)
 	}
 
+	summary := &eventSummary{recap: make(map[string]status.HostStats)}
+
+	// Text output is only parsed for progress when no event stream is available.
+	// Warnings and errors printed by ansible itself are never events, keep those.
+	lineProgress := func(line string, progressChan chan<- string) {
+		host, stats, isRecap := ParseRecapLine(strings.TrimSpace(line))
+		if isRecap {
+			summary.recap[host] = stats
+		}
+		if progressChan == nil {
+			return
+		}
+		e.parseProgress(line, progressChan)
+		if isRecap {
+			progressChan <- e.ProgressMessage(Event{Type: EventRecap, Recap: map[string]status.HostStats{host: stats}})
+		}
+	}
+	if eventsReader != nil {
+		// Close our copy of the write end so the reader sees EOF when ansible exits
+		cmd.ExtraFiles[0].Close()
+		lineProgress = e.parseDiagnostics
+	}
+
 	// Stream output in goroutines with context awareness
-	outputDone := make(chan struct{}, 2)
+	outputDone := make(chan struct{}, 3)
+	go func() {
+		e.streamOutput(stdout, logWriter, progressChan, lineProgress)
+		outputDone <- struct{}{}
+	}()
 	go func() {
-		e.streamOutput(stdout, logWriter, progressChan)
+		e.streamOutput(stderr, logWriter, nil, nil)
 		outputDone <- struct{}{}
 	}()
+
 	go func() {
-		e.streamOutput(stderr, logWriter, nil)
+		if eventsReader != nil {
+			e.streamEvents(eventsReader, summary, opts.Events, progressChan)
+			eventsReader.Close()
+		}
 		outputDone <- struct{}{}
 	}()
 
-	// Wait for command with context cancellation monitoring
+	// Wait for command with context cancellation monitoring.
+	// Wait closes the pipes, so drain the output first or its tail is lost.
 	waitDone := make(chan error, 1)
 	go func() {
+		<-outputDone
+		<-outputDone
+		<-outputDone
 		waitDone <- cmd.Wait()
 	}()
 
@@ -155,8 +303,8 @@ func (e *Executor) RunPlaybookWithContextAndOptions(ctx context.Context, playboo
 	case <-ctx.Done():
 		// Context cancelled - kill process
 		if cmd.Process != nil {
-			log.Printf("[EXECUTOR] Context cancelled, killing ansible process")
-			cmd.Process.Kill()
+			log.Printf("[EXECUTOR] Context cancelled, killing ansible process group %d", cmd.Process.Pid)
+			killProcessGroup(cmd.Process)
 		}
 		cmdErr = ctx.Err()
 		// Wait for process to be killed
@@ -165,16 +313,24 @@ func (e *Executor) RunPlaybookWithContextAndOptions(ctx context.Context, playboo
 		// Command completed normally
 	}
 
-	// Wait for output goroutines to finish
-	<-outputDone
-	<-outputDone
 	result := &ExecutionResult{
 		Success: cmdErr == nil,
 		LogFile: logFile,
+		Recap:    summary.recap,
+		Elapsed:   time.Since(start),
+		Strategy:  opts.Strategy,
+		CheckMode: checkMode,
 	}
+	defer result.writeSummaryFile()
 
 	if cmdErr != nil {
 		result.ErrorMessage = cmdErr.Error()
+		if summary.failure != nil {
+			result.FailedTask = summary.failure.Task
+			result.ErrorMessage = summary.failureMessage()
+		} else if stats, ok := summary.recap[serverName]; ok && stats.Unreachable > 0 {
+			result.ErrorMessage = fmt.Sprintf("Host %s unreachable", serverName)
+		}
 		if progressChan != nil {
 			progressChan <- fmt.Sprintf("❌ %s failed: %v", action, cmdErr)
 		}
@@ -191,18 +347,106 @@ func (e *Executor) RunPlaybookWithContextAndOptions(ctx context.Context, playboo
 	return result, nil
 }
 
-func (e *Executor) streamOutput(reader io.Reader, writer io.Writer, progressChan chan<- string) {
+// killProcessGroup kills a process started as the leader of its own process
+// group, along with every process of the group
+func killProcessGroup(process *os.Process) error {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil {
+		return process.Kill()
+	}
+	return nil
+}
+
+// callbackPluginDir returns the absolute path of the bundled callback plugins
+func callbackPluginDir() (string, bool) {
+	dir, err := filepath.Abs("callback_plugins")
+	if err != nil {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(dir, "boiler_events.py")); err != nil {
+		return "", false
+	}
+	return dir, true
+}
+
+// eventSummary keeps what the executor needs from the event stream once the
+// playbook has finished
+type eventSummary struct {
+	failure *Event                      // first task failure that aborted the host
+	recap   map[string]status.HostStats // PLAY RECAP per host
+}
+
+func (s *eventSummary) failureMessage() string {
+	msg := strings.Join(strings.Fields(s.failure.Msg), " ")
+	if s.failure.Status == TaskUnreachable {
+		return fmt.Sprintf("Host %s unreachable: %s", s.failure.Host, msg)
+	}
+	if msg == "" {
+		return fmt.Sprintf("Task '%s' failed on %s", s.failure.Task, s.failure.Host)
+	}
+	return fmt.Sprintf("Task '%s' failed on %s: %s", s.failure.Task, s.failure.Host, msg)
+}
+
+// streamEvents decodes the JSON lines written by the callback plugin, derives
+// the progress lines from them and forwards them to the caller
+func (e *Executor) streamEvents(reader io.Reader, summary *eventSummary, events chan<- Event, progressChan chan<- string) {
+	scanner := bufio.NewScanner(reader)
+	// Task results can carry large stderr payloads
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		ev, err := ParseEvent(scanner.Bytes())
+		if err != nil {
+			log.Printf("[EXECUTOR] Skipping malformed event: %v", err)
+			continue
+		}
+
+		if ev.Type == EventRecap {
+			for host, stats := range ev.Recap {
+				summary.recap[host] = stats
+			}
+		}
+		if ev.IsFailure() && summary.failure == nil {
+			failure := ev
+			summary.failure = &failure
+		}
+
+		if progressChan != nil {
+			if msg := e.ProgressMessage(ev); msg != "" {
+				progressChan <- msg
+			}
+		}
+		if events != nil {
+			events <- ev
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("[EXECUTOR] Event stream error: %v", err)
+	}
+}
+
+func (e *Executor) streamOutput(reader io.Reader, writer io.Writer, progressChan chan<- string, parse func(string, chan<- string)) {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		fmt.Fprintln(writer, line)
 
-		if progressChan != nil {
-			e.parseProgress(line, progressChan)
+		if parse != nil {
+			parse(line, progressChan)
 		}
 	}
 }
 
+// parseDiagnostics only reports warnings and errors printed by ansible itself,
+// the task progress comes from the event stream
+func (e *Executor) parseDiagnostics(line string, progressChan chan<- string) {
+	if progressChan == nil {
+		return
+	}
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "[WARNING]") || strings.HasPrefix(trimmed, "[ERROR]") || strings.HasPrefix(trimmed, "ERROR!") {
+		e.parseProgress(trimmed, progressChan)
+	}
+}
+
 func (e *Executor) parseProgress(line string, progressChan chan<- string) {
 	line = strings.TrimSpace(line)
 	if line == "" {
@@ -367,134 +611,82 @@ func (e *Executor) DeployCheck(serverName string, tags string, progressChan chan
 	return e.RunPlaybookWithContextAndOptions(context.Background(), "deploy.yml", serverName, tags, true, progressChan)
 }
 
-func (e *Executor) HealthCheck(ip string, port int) error {
-	// For local development (127.0.0.1), use direct HTTP check
-	// For remote servers, this will still work if ports are properly forwarded
-	url := fmt.Sprintf("http://%s:%d/", ip, port)
+// HealthCheck checks the application at ip:port according to spec
+func (e *Executor) HealthCheck(ctx context.Context, ip string, port int, spec inventory.HealthCheckSpec) error {
+	checker, err := health.NewChecker(spec, nil)
+	if err != nil {
+		return err
+	}
+
+	url := checker.URL(ip, port)
 	log.Printf("[EXECUTOR] Health check starting for: %s", url)
-	
-	// Retry logic: try multiple times with increasing delays
-	maxRetries := 5
-	retryDelays := []time.Duration{2 * time.Second, 3 * time.Second, 5 * time.Second, 8 * time.Second, 10 * time.Second}
-	
-	var lastErr error
-	for i := 0; i < maxRetries; i++ {
-		if i > 0 {
-			log.Printf("[EXECUTOR] Health check retry %d/%d after %v delay", i+1, maxRetries, retryDelays[i-1])
-			time.Sleep(retryDelays[i-1])
-		}
-		
-		// Try curl first (if available)
-		if err := e.healthCheckCurl(url); err == nil {
-			log.Printf("[EXECUTOR] ✓ Health check successful via curl on attempt %d", i+1)
-			return nil
-		} else {
-			lastErr = err
-			log.Printf("[EXECUTOR] Health check curl attempt %d/%d failed: %v", i+1, maxRetries, err)
-		}
-		
-		// Fallback: Native Go HTTP client (always available, no external dependency)
-		if err := e.healthCheckNative(url); err == nil {
-			log.Printf("[EXECUTOR] ✓ Health check successful via native HTTP on attempt %d", i+1)
-			return nil
-		} else {
-			lastErr = err
-			log.Printf("[EXECUTOR] Health check native attempt %d/%d failed: %v", i+1, maxRetries, err)
-		}
-		
-		// Check if service exists at all with nc/telnet (diagnostic only)
-		if i == 0 {
-			tcpCheckCmd := exec.Command("nc", "-zv", "-w", "3", ip, fmt.Sprintf("%d", port))
-			tcpOutput, tcpErr := tcpCheckCmd.CombinedOutput()
-			if tcpErr != nil {
-				log.Printf("[EXECUTOR] Port %d appears closed or unreachable: %s", port, strings.TrimSpace(string(tcpOutput)))
-			} else {
-				log.Printf("[EXECUTOR] Port %d is open, but HTTP request failed", port)
-			}
-		}
+	attempts, err := checker.Wait(ctx, url)
+	if err != nil {
+		log.Printf("[EXECUTOR] ✗ Health check failed after %d attempts: %v", attempts, err)
+		return err
 	}
-	
-	log.Printf("[EXECUTOR] ✗ Health check failed after %d attempts", maxRetries)
-	return fmt.Errorf("health check failed after %d attempts: %w", maxRetries, lastErr)
+	log.Printf("[EXECUTOR] ✓ Health check successful on attempt %d", attempts)
+	return nil
 }
 
 // HealthCheckRemote performs health check via SSH on the remote server
-// This is needed when the app listens only on localhost inside the server
-func (e *Executor) HealthCheckRemote(sshHost string, sshPort int, sshUser string, sshKeyPath string, appPort int) error {
+// This is needed when the app listens only on localhost inside the server, or
+// when the server is only reachable through a jump host
+func (e *Executor) HealthCheckRemote(ctx context.Context, sshHost string, sshPort int, sshUser string, sshKeyPath string, jump *inventory.JumpHost, appPort int, spec inventory.HealthCheckSpec) error {
 	log.Printf("[EXECUTOR] Remote health check via SSH to %s:%d checking localhost:%d", sshHost, sshPort, appPort)
-	
-	maxRetries := 5
-	retryDelays := []time.Duration{2 * time.Second, 3 * time.Second, 5 * time.Second, 8 * time.Second, 10 * time.Second}
-	
-	for i := 0; i < maxRetries; i++ {
-		if i > 0 {
-			log.Printf("[EXECUTOR] Remote health check retry %d/%d after %v delay", i+1, maxRetries, retryDelays[i-1])
-			time.Sleep(retryDelays[i-1])
-		}
-		
-		// Try curl on remote server (check localhost from inside)
-		cmd := fmt.Sprintf("curl -sf -m 5 http://localhost:%d/ > /dev/null 2>&1 && echo 'OK' || echo 'FAIL'", appPort)
-		result := ssh.ExecuteCommand(sshHost, sshPort, sshUser, sshKeyPath, cmd)
-		
-		if result.Success && strings.TrimSpace(result.Output) == "OK" {
-			log.Printf("[EXECUTOR] ✓ Remote health check successful on attempt %d", i+1)
-			return nil
-		}
-		
-		log.Printf("[EXECUTOR] Remote health check attempt %d/%d failed: %s", i+1, maxRetries, result.Message)
+
+	client, err := ssh.Connect(sshHost, sshPort, sshUser, sshKeyPath, jump, e.hostKeys)
+	if err != nil {
+		return fmt.Errorf("remote health check: %w", err)
 	}
-	
-	log.Printf("[EXECUTOR] ✗ Remote health check failed after %d attempts", maxRetries)
-	return fmt.Errorf("remote health check failed after %d attempts", maxRetries)
-}
+	defer client.Close()
 
-// healthCheckCurl uses curl command (traditional method)
-func (e *Executor) healthCheckCurl(url string) error {
-	cmd := exec.Command("curl", "-sf", "-m", "10", "--connect-timeout", "5", url)
-	output, err := cmd.CombinedOutput()
-	
+	// Requests are tunneled through the SSH connection
+	checker, err := health.NewChecker(spec, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return client.Dial(network, addr)
+	})
 	if err != nil {
-		e.log.Debug().Str("method", "curl").Str("url", url).Err(err).Msg("Health check failed")
-		return fmt.Errorf("curl failed: %w (output: %s)", err, strings.TrimSpace(string(output)))
+		return err
 	}
-	
-	e.log.Info().Str("method", "curl").Int("bytes", len(output)).Msg("Health check successful")
+
+	url := checker.URL("localhost", appPort)
+	attempts, err := checker.Wait(ctx, url)
+	if err != nil {
+		log.Printf("[EXECUTOR] ✗ Remote health check failed after %d attempts: %v", attempts, err)
+		return fmt.Errorf("remote %w", err)
+	}
+	log.Printf("[EXECUTOR] ✓ Remote health check successful on attempt %d", attempts)
 	return nil
 }
 
-// healthCheckNative uses Go's native HTTP client (no external dependencies)
-func (e *Executor) healthCheckNative(url string) error {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives:   true,
-			MaxIdleConns:        1,
-			IdleConnTimeout:     5 * time.Second,
-			TLSHandshakeTimeout: 5 * time.Second,
-		},
-	}
-	
-	resp, err := client.Get(url)
-	if err != nil {
-		e.log.Debug().Str("method", "native_http").Str("url", url).Err(err).Msg("Health check failed")
-		return fmt.Errorf("HTTP GET failed: %w", err)
+// DatabaseHealthCheck checks through SSH that PostgreSQL answers a SELECT 1 on
+// a database server
+func (e *Executor) DatabaseHealthCheck(sshHost string, sshPort int, sshUser string, sshKeyPath string, jump *inventory.JumpHost) error {
+	log.Printf("[EXECUTOR] Database health check via SSH to %s:%d", sshHost, sshPort)
+
+	result := ssh.ExecuteCommand(sshHost, sshPort, sshUser, sshKeyPath, jump, e.hostKeys, inventory.PostgresQueryCommand)
+	output := strings.TrimSpace(result.Output)
+	if !result.Success {
+		if output != "" {
+			return fmt.Errorf("database health check: %s: %s", result.Message, output)
+		}
+		return fmt.Errorf("database health check: %s", result.Message)
 	}
-	defer resp.Body.Close()
-	
-	// Accept 2xx-4xx status codes (app is responding)
-	// 5xx means server error but app is alive
-	if resp.StatusCode >= 200 && resp.StatusCode < 500 {
-		e.log.Info().Str("method", "native_http").Int("status", resp.StatusCode).Msg("Health check successful")
-		return nil
+	if output != "1" {
+		return fmt.Errorf("database health check: SELECT 1 returned %q", output)
 	}
-	
-	e.log.Warn().Str("method", "native_http").Int("status", resp.StatusCode).Msg("Health check bad status")
-	return fmt.Errorf("bad HTTP status: %d (%s)", resp.StatusCode, resp.Status)
+
+	log.Printf("[EXECUTOR] ✓ Database health check successful on %s", sshHost)
+	return nil
 }
 
-func (e *Executor) TestSSH(ip string, port int, user string, keyPath string) ssh.TestResult {
-	log.Printf("[EXECUTOR] Testing SSH connection to %s:%d with user %s", ip, port, user)
-	result := ssh.TestConnection(ip, port, user, keyPath)
+func (e *Executor) TestSSH(ip string, port int, user string, keyPath string, jump *inventory.JumpHost) ssh.TestResult {
+	if jump != nil {
+		log.Printf("[EXECUTOR] Testing SSH connection to %s:%d with user %s via %s", ip, port, user, jump)
+	} else {
+		log.Printf("[EXECUTOR] Testing SSH connection to %s:%d with user %s", ip, port, user)
+	}
+	result := ssh.TestConnection(ip, port, user, keyPath, jump, e.hostKeys)
 	
 	if result.Success {
 		log.Printf("[EXECUTOR] SSH test successful: %s", result.Message)
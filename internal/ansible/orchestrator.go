@@ -2,14 +2,21 @@ package ansible
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bastiblast/boiler-deploy/internal/config"
+	"github.com/bastiblast/boiler-deploy/internal/gitref"
 	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/schedule"
 	"github.com/bastiblast/boiler-deploy/internal/ssh"
 	"github.com/bastiblast/boiler-deploy/internal/status"
+	"github.com/google/uuid"
 )
 
 type Orchestrator struct {
@@ -27,18 +34,44 @@ type Orchestrator struct {
 	deploySuccessCb     func(serverName, serverIP string) // Callback when deployment succeeds
 	useScript           bool // Use deploy.sh script instead of ansible directly
 	healthCheckEnabled  bool // Enable/disable health checks
-	skipHealthCheck     bool // Skip health check for current deployment
+	skipHealthCheck     bool // The deploys queued next skip their health check
 	maxWorkers          int  // Number of parallel workers (0 = sequential)
 	activeWorkers       int  // Current number of active workers
 	workersMu           sync.Mutex // Mutex for activeWorkers counter
+	strategy            DeploymentStrategy // How deploy actions are scheduled
+	autoRetry           bool // Re-enqueue actions failing for a transient reason
+	maxRetries          int  // Retries allowed per action when autoRetry is on
+	healthCheck         inventory.HealthCheckSpec // Environment health check, refined per server
+	jumpHost            *inventory.JumpHost       // Environment jump host, servers may override it
+	envConfig           inventory.Environment     // Probes and application directory of the state detection
+	autoRollback        bool // Roll back deploys whose health check failed
+	history             *status.History // Run history of the environment
+	operator            string          // Recorded as the author of the runs
+	lock                *status.EnvLock // Lock of the environment, held while running
+	actionsMu           sync.Mutex // Protects actionCancels and cancelled
+	actionCancels       map[string]context.CancelFunc // Running action ID -> cancel of its own context
+	cancelled           map[string]bool               // IDs of the running actions cancelled by CancelAction
+	window              *schedule.Window // Maintenance window of the environment, nil when none
+	production          bool             // Manual deploys are refused outside the window
+	scheduleAt          *time.Time       // Start time of the actions queued next, nil = at once
+	scheduleInWindow    bool             // The actions queued next wait for the window
 }
 
+// ErrOutsideWindow is returned by CheckWindow for a production deploy outside
+// the maintenance window
+var ErrOutsideWindow = errors.New("outside the maintenance window")
+
 func NewOrchestrator(environment string, statusMgr *status.Manager) (*Orchestrator, error) {
 	queue, err := NewQueue(environment)
 	if err != nil {
 		return nil, err
 	}
 
+	history, err := status.NewHistory(environment)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Orchestrator{
 		statusMgr:          statusMgr,
 		queue:              queue,
@@ -51,13 +84,179 @@ func NewOrchestrator(environment string, statusMgr *status.Manager) (*Orchestrat
 		skipHealthCheck:    false,
 		maxWorkers:         0,     // Sequential by default
 		activeWorkers:      0,
+		history:            history,
+		operator:           status.CurrentOperator(),
+		actionCancels:      make(map[string]context.CancelFunc),
+		cancelled:          make(map[string]bool),
 	}, nil
 }
 
+// NewEnvironmentOrchestrator prepares the orchestrator of an environment with
+// the options of its config.yml and .env-config.yml. An invalid maintenance
+// window fails rather than leaving production deploys allowed at any time.
+func NewEnvironmentOrchestrator(environment string, env *inventory.Environment, configOpts *config.ConfigOptions, statusMgr *status.Manager) (*Orchestrator, error) {
+	window, err := schedule.ParseWindow(env.Config.MaintenanceWindow, schedule.Location(env.Config.Timezone))
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := NewOrchestrator(environment, statusMgr)
+	if err != nil {
+		return nil, err
+	}
+	o.SetHealthCheckEnabled(configOpts.HealthCheckEnabled)
+	o.SetHealthCheckSpec(inventory.HealthCheckSpec{
+		Timeout: configOpts.HealthCheckTimeout,
+		Retries: configOpts.HealthCheckRetries,
+	}.Merge(env.Config.HealthCheck))
+	o.SetJumpHost(env.Config.JumpHost)
+	o.SetEnvironmentConfig(*env)
+	o.SetMaxWorkers(configOpts.MaxParallelWorkers)
+	o.SetDeploymentStrategy(DeploymentStrategy{
+		Name:              configOpts.DeploymentStrategy,
+		BatchSize:         configOpts.RollingBatchSize,
+		MaxFailurePercent: configOpts.RollingMaxFailurePercent,
+		PortOffset:        configOpts.BlueGreenPortOffset,
+	})
+	o.SetAutoRetry(configOpts.AutoRetryEnabled, configOpts.MaxRetries)
+	o.SetAutoRollback(configOpts.AutoRollbackEnabled)
+	o.SetMaintenanceWindow(window, env.IsProduction())
+	return o, nil
+}
+
 func (o *Orchestrator) SetHealthCheckEnabled(enabled bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.healthCheckEnabled = enabled
 }
 
+func (o *Orchestrator) healthCheckOn() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.healthCheckEnabled
+}
+
+// SetHealthCheckSpec sets the health check of the environment. Servers with
+// their own health_check override its fields.
+func (o *Orchestrator) SetHealthCheckSpec(spec inventory.HealthCheckSpec) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.healthCheck = spec
+}
+
+// SetJumpHost sets the jump host the servers of the environment are reached
+// through, nil to reach them directly. Servers with their own jump_host
+// override it.
+func (o *Orchestrator) SetJumpHost(jump *inventory.JumpHost) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.jumpHost = jump
+}
+
+// SetEnvironmentConfig sets the configuration of the environment, whose probes
+// the state detection of check actions runs
+func (o *Orchestrator) SetEnvironmentConfig(env inventory.Environment) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.envConfig = env
+}
+
+// SetMaintenanceWindow sets the maintenance window of the environment, nil
+// for none. With production on, CheckWindow refuses deploys outside of it.
+func (o *Orchestrator) SetMaintenanceWindow(window *schedule.Window, production bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.window = window
+	o.production = production
+}
+
+// MaintenanceWindow returns the maintenance window of the environment, or nil
+func (o *Orchestrator) MaintenanceWindow() *schedule.Window {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.window
+}
+
+// CheckWindow returns an error wrapping ErrOutsideWindow when action is a
+// deploy of a production environment starting at outside its maintenance
+// window
+func (o *Orchestrator) CheckWindow(action status.ActionType, at time.Time) error {
+	o.mu.RLock()
+	window, production := o.window, o.production
+	o.mu.RUnlock()
+	if !production || window == nil || action != status.ActionDeploy || window.Contains(at) {
+		return nil
+	}
+	return fmt.Errorf("deploy to %s at %s is %w %q, next opening %s", o.environment,
+		at.Format("2006-01-02 15:04"), ErrOutsideWindow, window, window.Next(at).Format("2006-01-02 15:04"))
+}
+
+// SetSchedule makes the actions queued from now on wait until at (nil to
+// start at once) and, with inWindow, for the maintenance window to be open
+func (o *Orchestrator) SetSchedule(at *time.Time, inWindow bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.scheduleAt = at
+	o.scheduleInWindow = inWindow
+}
+
+// enqueue queues an action with the schedule set by SetSchedule
+func (o *Orchestrator) enqueue(action *status.QueuedAction) *status.QueuedAction {
+	o.mu.RLock()
+	if o.scheduleAt != nil {
+		at := *o.scheduleAt
+		action.NotBefore = &at
+		action.Scheduled = true
+	}
+	action.InWindow = o.scheduleInWindow
+	o.mu.RUnlock()
+	return o.queue.AddAction(action)
+}
+
+// waiting reports whether a queued action waits for the time it was scheduled
+// at or for the maintenance window
+func (o *Orchestrator) waiting(action *status.QueuedAction, now time.Time) bool {
+	if action.StartedAt != nil {
+		return false
+	}
+	if action.Scheduled && action.NotBefore != nil && now.Before(*action.NotBefore) {
+		return true
+	}
+	window := o.MaintenanceWindow()
+	return action.InWindow && window != nil && !window.Contains(now)
+}
+
+// NextStart returns when the first queued action waiting for its schedule or
+// the maintenance window may start, false when none waits
+func (o *Orchestrator) NextStart() (time.Time, bool) {
+	now := time.Now()
+	window := o.MaintenanceWindow()
+	var next time.Time
+	for _, action := range o.queue.GetAll() {
+		if !o.waiting(action, now) || action.Paused || action.Interrupted {
+			continue
+		}
+		start := now
+		if action.Scheduled && action.NotBefore != nil && action.NotBefore.After(start) {
+			start = *action.NotBefore
+		}
+		if action.InWindow && window != nil {
+			start = window.Next(start)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// jumpHostFor returns the jump host to reach server through, or nil
+func (o *Orchestrator) jumpHostFor(server *inventory.Server) *inventory.JumpHost {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return inventory.ResolveJumpHost(*server, o.jumpHost)
+}
+
 func (o *Orchestrator) SetMaxWorkers(workers int) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
@@ -68,18 +267,102 @@ func (o *Orchestrator) SetMaxWorkers(workers int) {
 	log.Printf("[ORCHESTRATOR] Max workers set to %d (0=sequential, >0=parallel)", workers)
 }
 
+// SetAutoRetry enables retrying actions that failed for a transient reason
+// (unreachable host, apt lock, timeout) up to maxRetries times
+func (o *Orchestrator) SetAutoRetry(enabled bool, maxRetries int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	o.autoRetry = enabled
+	o.maxRetries = maxRetries
+	log.Printf("[ORCHESTRATOR] Auto retry set to %v (max retries: %d)", enabled, maxRetries)
+}
+
+// SetAutoRollback enables rolling a server back to its previous release when
+// the health check after its deploy fails
+func (o *Orchestrator) SetAutoRollback(enabled bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.autoRollback = enabled
+	log.Printf("[ORCHESTRATOR] Auto rollback set to %v", enabled)
+}
+
+func (o *Orchestrator) autoRollbackEnabled() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.autoRollback
+}
+
+// maxAttempts returns how many times an action may run
+func (o *Orchestrator) maxAttempts() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if !o.autoRetry {
+		return 1
+	}
+	return o.maxRetries + 1
+}
+
+// SetDeploymentStrategy sets the strategy applied to the deploys queued afterwards
+func (o *Orchestrator) SetDeploymentStrategy(strategy DeploymentStrategy) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if strategy.PortOffset <= 0 {
+		strategy.PortOffset = DefaultBlueGreenPortOffset
+	}
+	o.strategy = strategy
+	log.Printf("[ORCHESTRATOR] Deployment strategy set to %q (batch size: %d, max failure: %d%%, port offset: %d)",
+		strategy.Name, strategy.BatchSize, strategy.MaxFailurePercent, strategy.PortOffset)
+}
+
+func (o *Orchestrator) GetDeploymentStrategy() DeploymentStrategy {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.strategy
+}
+
+// SkipNextHealthCheck makes the deploys queued by the next queue call skip
+// their health check. Deploys queued later keep theirs.
 func (o *Orchestrator) SkipNextHealthCheck() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.skipHealthCheck = true
 }
 
 func (o *Orchestrator) SetProgressCallback(cb func(serverName, message string)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.progressCb = cb
 }
 
 func (o *Orchestrator) SetDeploySuccessCallback(cb func(serverName, serverIP string)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.deploySuccessCb = cb
 }
 
+// progress reports a message about a server to the progress callback, if any
+func (o *Orchestrator) progress(serverName, message string) {
+	o.mu.RLock()
+	cb := o.progressCb
+	o.mu.RUnlock()
+	if cb != nil {
+		cb(serverName, message)
+	}
+}
+
+// deploySucceeded calls the deploy success callback, if any
+func (o *Orchestrator) deploySucceeded(serverName, serverIP string) {
+	o.mu.RLock()
+	cb := o.deploySuccessCb
+	o.mu.RUnlock()
+	if cb != nil {
+		cb(serverName, serverIP)
+	}
+}
+
 func (o *Orchestrator) ValidateInventory(servers []*inventory.Server) {
 	for _, server := range servers {
 		checks := o.statusMgr.ValidateServer(server)
@@ -95,42 +378,242 @@ func (o *Orchestrator) QueueProvisionWithTags(serverNames []string, priority int
 	log.Printf("[ORCHESTRATOR] QueueProvisionWithTags called with %d servers: %v, tags: %s", len(serverNames), serverNames, tags)
 	for _, name := range serverNames {
 		log.Printf("[ORCHESTRATOR] Adding provision action for server: %s with tags: %s", name, tags)
-		item := o.queue.Add(name, status.ActionProvision, priority)
-		item.Tags = tags
+		o.enqueue(&status.QueuedAction{
+			ServerName: name,
+			Action:     status.ActionProvision,
+			Priority:   priority,
+			Tags:       tags,
+		})
 	}
 	log.Printf("[ORCHESTRATOR] Queue size after adding provisions: %d", o.GetQueueSize())
 }
 
-func (o *Orchestrator) QueueDeploy(serverNames []string, priority int) {
-	o.QueueDeployWithTags(serverNames, priority, "")
+func (o *Orchestrator) QueueDeploy(serverNames []string, priority int) error {
+	return o.QueueDeployWithTags(serverNames, priority, "")
+}
+
+// QueueDeployWithTags queues a deploy per server, each pinned to the commit
+// its branch resolves to now. Nothing is queued when a branch cannot be
+// resolved.
+func (o *Orchestrator) QueueDeployWithTags(serverNames []string, priority int, tags string) error {
+	pins, err := o.ResolveDeployPins(context.Background(), serverNames, "")
+	if err != nil {
+		return err
+	}
+	o.QueuePinnedDeploy(serverNames, priority, tags, pins)
+	return nil
 }
 
-func (o *Orchestrator) QueueDeployWithTags(serverNames []string, priority int, tags string) {
+// QueuePinnedDeploy queues a deploy per server checking out the commit of its
+// pin, see ResolveDeployPins and PromotedPins. A server without pin deploys
+// the branch the playbook resolves.
+func (o *Orchestrator) QueuePinnedDeploy(serverNames []string, priority int, tags string, pins map[string]DeployPin) {
 	log.Printf("[ORCHESTRATOR] QueueDeploy called with %d servers: %v", len(serverNames), serverNames)
-	for _, name := range serverNames {
-		log.Printf("[ORCHESTRATOR] Adding deploy action for server: %s", name)
-		item := o.queue.Add(name, status.ActionDeploy, priority)
-		item.Tags = tags
+	for _, deploy := range o.newDeployActions(serverNames, priority, tags, pins) {
+		log.Printf("[ORCHESTRATOR] Adding deploy action for server: %s (strategy: %s, batch: %d, commit: %s)", deploy.ServerName, deploy.Strategy, deploy.Batch, deploy.Commit)
+		o.enqueue(deploy)
 	}
 	log.Printf("[ORCHESTRATOR] Queue size after adding deploys: %d", o.GetQueueSize())
 }
 
+// QueueProvisionThenDeploy queues a provision and a deploy per server, the
+// deploys pinned like QueueDeployWithTags does.
+func (o *Orchestrator) QueueProvisionThenDeploy(serverNames []string, priority int, provisionTags, deployTags string) error {
+	pins, err := o.ResolveDeployPins(context.Background(), serverNames, "")
+	if err != nil {
+		return err
+	}
+	o.QueueProvisionThenPinnedDeploy(serverNames, priority, provisionTags, deployTags, pins)
+	return nil
+}
+
+// QueueProvisionThenPinnedDeploy queues a provision and a deploy per server.
+// Each deploy waits for the provision of its own server and is skipped if it
+// fails.
+func (o *Orchestrator) QueueProvisionThenPinnedDeploy(serverNames []string, priority int, provisionTags, deployTags string, pins map[string]DeployPin) {
+	log.Printf("[ORCHESTRATOR] QueueProvisionThenDeploy called with %d servers: %v", len(serverNames), serverNames)
+	for _, deploy := range o.newDeployActions(serverNames, priority, deployTags, pins) {
+		provision := o.enqueue(&status.QueuedAction{
+			ServerName: deploy.ServerName,
+			Action:     status.ActionProvision,
+			Priority:   priority,
+			Tags:       provisionTags,
+		})
+		deploy.DependsOn = []string{provision.ID}
+		o.enqueue(deploy)
+	}
+	log.Printf("[ORCHESTRATOR] Queue size after adding provision+deploy: %d", o.GetQueueSize())
+}
+
+// DeployPin is the commit the deploy of a server checks out
+type DeployPin struct {
+	Commit string
+	Ref    string // Branch, tag or SHA Commit was resolved from
+	Source string // Environment Commit is promoted from
+}
+
+// ResolveDeployPins returns the pin of each server, the commit ref (a branch,
+// tag or commit SHA, the branch of each server when empty) resolves to now. It
+// runs git ls-remote once per repository and ref so that every server of a
+// batch gets the same code, which may take a while. Servers without a git_repo
+// are left to the playbook, unless a ref was given.
+func (o *Orchestrator) ResolveDeployPins(ctx context.Context, serverNames []string, ref string) (map[string]DeployPin, error) {
+	o.mu.RLock()
+	env := o.envConfig
+	o.mu.RUnlock()
+
+	pins := make(map[string]DeployPin, len(serverNames))
+	resolved := make(map[string]string) // repo + ref -> commit
+	for _, name := range serverNames {
+		var repo, branch string
+		for _, server := range env.Servers {
+			if server.Name == name {
+				repo, branch = server.GitRepo, server.GitBranch
+			}
+		}
+		if ref != "" {
+			branch = ref
+		}
+		if repo == "" {
+			if ref != "" {
+				return nil, fmt.Errorf("cannot deploy %s to %s: no git_repo configured", ref, name)
+			}
+			log.Printf("[ORCHESTRATOR] No git_repo for %s, the playbook resolves its branch", name)
+			continue
+		}
+
+		key := repo + "\x00" + branch
+		commit, ok := resolved[key]
+		if !ok {
+			var err error
+			commit, err = gitref.Resolve(ctx, repo, branch)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve the commit to deploy to %s: %w", name, err)
+			}
+			log.Printf("[ORCHESTRATOR] %s of %s resolved to %s", orHead(branch), repo, commit)
+			resolved[key] = commit
+		}
+		pins[name] = DeployPin{Commit: commit, Ref: orHead(branch)}
+	}
+	return pins, nil
+}
+
+// PromotedPins pins every server to commit, promoted from the environment
+// source
+func PromotedPins(serverNames []string, commit, source string) map[string]DeployPin {
+	pins := make(map[string]DeployPin, len(serverNames))
+	for _, name := range serverNames {
+		pins[name] = DeployPin{Commit: commit, Source: source}
+	}
+	return pins
+}
+
+// orHead returns ref, or HEAD (the default branch) when empty
+func orHead(ref string) string {
+	if ref == "" {
+		return "HEAD"
+	}
+	return ref
+}
+
+// newDeployActions prepares one deploy action per server according to the
+// deployment strategy, checking out the commit of its pin. Rolling deploys
+// are split in batches of one rollout.
+func (o *Orchestrator) newDeployActions(serverNames []string, priority int, tags string, pins map[string]DeployPin) []*status.QueuedAction {
+	o.mu.Lock()
+	strategy := o.strategy
+	workers := o.maxWorkers
+	skipHealthCheck := o.skipHealthCheck
+	o.skipHealthCheck = false
+	o.mu.Unlock()
+
+	batchSize := strategy.BatchSize
+	if batchSize <= 0 {
+		batchSize = workers
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	rollout := ""
+	if strategy.Name == StrategyRolling {
+		rollout = uuid.New().String()
+	}
+
+	actions := make([]*status.QueuedAction, 0, len(serverNames))
+	for i, name := range serverNames {
+		pin := pins[name]
+		action := &status.QueuedAction{
+			ServerName: name,
+			Action:     status.ActionDeploy,
+			Priority:   priority,
+			Tags:       tags,
+			Strategy:   strategy.Name,
+			Commit:     pin.Commit,
+			Ref:        pin.Ref,
+			Source:     pin.Source,
+			SkipHealth: skipHealthCheck,
+		}
+		if rollout != "" {
+			action.Rollout = rollout
+			action.Batch = i / batchSize
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
+
 func (o *Orchestrator) QueueCheck(serverNames []string, priority int) {
 	log.Printf("[ORCHESTRATOR] QueueCheck called with %d servers: %v", len(serverNames), serverNames)
 	for _, name := range serverNames {
 		log.Printf("[ORCHESTRATOR] Adding check action for server: %s", name)
-		o.queue.Add(name, status.ActionCheck, priority)
+		o.enqueue(&status.QueuedAction{
+			ServerName: name,
+			Action:     status.ActionCheck,
+			Priority:   priority,
+		})
 	}
 	log.Printf("[ORCHESTRATOR] Queue size after adding checks: %d", o.GetQueueSize())
 }
 
-func (o *Orchestrator) Start(servers []*inventory.Server) {
+// QueueRollback queues a rollback per server to release, or to the release
+// deployed before the current one when release is empty
+func (o *Orchestrator) QueueRollback(serverNames []string, priority int, release string) error {
+	if err := ValidateReleaseID(release); err != nil {
+		return err
+	}
+	log.Printf("[ORCHESTRATOR] QueueRollback called with %d servers: %v, release: %q", len(serverNames), serverNames, release)
+	for _, name := range serverNames {
+		log.Printf("[ORCHESTRATOR] Adding rollback action for server: %s", name)
+		o.enqueue(&status.QueuedAction{
+			ServerName: name,
+			Action:     status.ActionRollback,
+			Priority:   priority,
+			Release:    release,
+		})
+	}
+	log.Printf("[ORCHESTRATOR] Queue size after adding rollbacks: %d", o.GetQueueSize())
+	return nil
+}
+
+// Start processes the queue until Stop. It takes the lock of the environment
+// first and fails with a *status.LockedError when another orchestrator, of
+// this process or another one, holds it.
+func (o *Orchestrator) Start(servers []*inventory.Server) error {
 	o.mu.Lock()
 	if o.running {
 		log.Println("[ORCHESTRATOR] Already running, skipping start")
 		o.mu.Unlock()
-		return
+		return nil
 	}
+
+	lock, err := status.AcquireLock(o.environment, o.operator)
+	if err != nil {
+		o.mu.Unlock()
+		log.Printf("[ORCHESTRATOR] Cannot start: %v", err)
+		return err
+	}
+	o.lock = lock
 	
 	// Reset stopChan and create new context
 	o.stopChan = make(chan struct{})
@@ -138,6 +621,14 @@ func (o *Orchestrator) Start(servers []*inventory.Server) {
 	o.running = true
 	o.mu.Unlock()
 
+	// Holding the lock, the statuses are the latest ones and the actions
+	// marked as started are known to be dead
+	if err := o.statusMgr.Load(); err != nil {
+		log.Printf("[ORCHESTRATOR] Cannot reload statuses: %v", err)
+	}
+	o.statusMgr.ResetInProgress()
+	o.markInterrupted()
+
 	log.Println("[ORCHESTRATOR] Starting processQueue goroutine with context")
 	go func() {
 		defer func() {
@@ -147,6 +638,7 @@ func (o *Orchestrator) Start(servers []*inventory.Server) {
 		}()
 		o.processQueue(servers)
 	}()
+	return nil
 }
 
 func (o *Orchestrator) Stop() {
@@ -160,6 +652,12 @@ func (o *Orchestrator) Stop() {
 		}
 		close(o.stopChan)
 		o.running = false
+		if o.lock != nil {
+			if err := o.lock.Release(); err != nil {
+				log.Printf("[ORCHESTRATOR] %v", err)
+			}
+			o.lock = nil
+		}
 	}
 }
 
@@ -183,132 +681,476 @@ func (o *Orchestrator) processQueue(servers []*inventory.Server) {
 }
 
 func (o *Orchestrator) processQueueSequential(servers []*inventory.Server) {
-	for {
-		select {
-		case <-o.stopChan:
-			log.Println("[ORCHESTRATOR] processQueueSequential received stop signal")
-			return
-		default:
-		}
-		
-		action := o.queue.Next()
-		if action == nil {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-
-		log.Printf("[ORCHESTRATOR] Processing action: %s for server %s", action.Action, action.ServerName)
-		o.executeAction(action, servers)
-		o.queue.Complete()
-		log.Printf("[ORCHESTRATOR] Completed action: %s for server %s", action.Action, action.ServerName)
-	}
+	o.dispatchReady(servers, 1)
 }
 
 func (o *Orchestrator) processQueueParallel(servers []*inventory.Server, maxWorkers int) {
-	var wg sync.WaitGroup
-	actionChan := make(chan *status.QueuedAction, maxWorkers)
+	o.dispatchReady(servers, maxWorkers)
+}
+
+// dispatchReady starts queued actions whose dependencies are satisfied, with
+// at most workers of them running at the same time
+func (o *Orchestrator) dispatchReady(servers []*inventory.Server, workers int) {
+	o.mu.RLock()
+	stopChan := o.stopChan
+	o.mu.RUnlock()
 	
-	// Start worker pool
-	for i := 0; i < maxWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			log.Printf("[ORCHESTRATOR] Worker %d started", workerID)
-			
-			for action := range actionChan {
-				o.workersMu.Lock()
-				o.activeWorkers++
-				currentActive := o.activeWorkers
-				o.workersMu.Unlock()
-				
-				log.Printf("[ORCHESTRATOR] Worker %d processing: %s for server %s (active: %d/%d)", 
-					workerID, action.Action, action.ServerName, currentActive, maxWorkers)
-				
-				o.executeAction(action, servers)
-				
-				o.workersMu.Lock()
-				o.activeWorkers--
-				o.workersMu.Unlock()
-				
-				log.Printf("[ORCHESTRATOR] Worker %d completed: %s for server %s", 
-					workerID, action.Action, action.ServerName)
-			}
-			
-			log.Printf("[ORCHESTRATOR] Worker %d stopped", workerID)
-		}(i)
-	}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
 	
-	// Feed actions to workers
 	for {
 		select {
-		case <-o.stopChan:
-			log.Println("[ORCHESTRATOR] processQueueParallel received stop signal")
-			close(actionChan)
+		case <-stopChan:
+			log.Println("[ORCHESTRATOR] dispatchReady received stop signal")
 			wg.Wait()
 			return
 		default:
 		}
 		
-		action := o.queue.Next()
+		o.handleCancelRequests()
+		
+		// Reserve a slot before picking an action so that it is only marked as
+		// started when it really starts. Rolling and all-at-once deploys do not
+		// need one, their rollout sets the concurrency.
+		hasSlot := false
+		select {
+		case slots <- struct{}{}:
+			hasSlot = true
+		default:
+		}
+		
+		now := time.Now()
+		action := o.queue.NextReadyWhere(func(a *status.QueuedAction) bool {
+			return (hasSlot || !usesWorkerSlot(a)) && !o.waiting(a, now)
+		})
 		if action == nil {
-			time.Sleep(100 * time.Millisecond)
+			if hasSlot {
+				<-slots
+			}
+			select {
+			case <-stopChan:
+			case <-time.After(100 * time.Millisecond):
+			}
 			continue
 		}
 		
-		// Remove from queue BEFORE sending to worker to prevent duplicate processing
-		o.queue.Complete()
+		holdsSlot := hasSlot && usesWorkerSlot(action)
+		if hasSlot && !holdsSlot {
+			<-slots
+		}
+		
+		o.workersMu.Lock()
+		o.activeWorkers++
+		currentActive := o.activeWorkers
+		o.workersMu.Unlock()
+		
+		log.Printf("[ORCHESTRATOR] Processing action: %s for server %s (active: %d/%d)",
+			action.Action, action.ServerName, currentActive, workers)
 		
-		log.Printf("[ORCHESTRATOR] Queueing action for workers: %s for server %s", action.Action, action.ServerName)
-		actionChan <- action
+		wg.Add(1)
+		go func(action *status.QueuedAction) {
+			defer wg.Done()
+			if holdsSlot {
+				defer func() { <-slots }()
+			}
+			
+			ctx := o.trackAction(action.ID)
+			started := time.Now()
+			o.statusMgr.SetAttempt(action.ServerName, action.Attempt+1, o.maxAttempts())
+			success := o.executeAction(ctx, action, servers)
+			cancelled := o.untrackAction(action.ID)
+			reason := fmt.Sprintf("%s on %s failed", action.Action, action.ServerName)
+			if cancelled {
+				success = false
+				reason = fmt.Sprintf("%s on %s cancelled", action.Action, action.ServerName)
+				o.statusMgr.UpdateStatus(action.ServerName, status.StateCancelled, action.Action,
+					fmt.Sprintf("%s cancelled by %s", action.Action, o.operator))
+			}
+			o.recordHistory(action, started, success)
+			if success || cancelled || !o.scheduleRetry(action) {
+				skipped := o.queue.Finish(action.ID, success)
+				o.reportSkipped(skipped, reason)
+				if action.Rollout != "" {
+					o.checkRollout(action)
+				}
+			}
+			
+			o.workersMu.Lock()
+			o.activeWorkers--
+			o.workersMu.Unlock()
+			
+			log.Printf("[ORCHESTRATOR] Completed action: %s for server %s (success: %v)",
+				action.Action, action.ServerName, success)
+		}(action)
+	}
+}
+
+// scheduleRetry puts a failed action back in the queue with a backoff when
+// auto retry is on and the failure is transient. It returns false when the
+// failure is final.
+func (o *Orchestrator) scheduleRetry(action *status.QueuedAction) bool {
+	o.mu.RLock()
+	enabled, maxRetries := o.autoRetry, o.maxRetries
+	o.mu.RUnlock()
+	if !enabled || action.Attempt >= maxRetries {
+		return false
+	}
+	// The deploy is being rolled back, running it again would undo that
+	if action.Action == status.ActionDeploy && o.rollbackQueued(action.ServerName) {
+		log.Printf("[ORCHESTRATOR] Not retrying deploy on %s: it is being rolled back", action.ServerName)
+		return false
+	}
+
+	current := o.statusMgr.GetStatus(action.ServerName)
+	class := ClassifyFailure(current.ErrorMessage)
+	if class == "" {
+		log.Printf("[ORCHESTRATOR] Not retrying %s on %s: %q is not a transient failure",
+			action.Action, action.ServerName, current.ErrorMessage)
+		return false
+	}
+
+	retry := action.Attempt + 1
+	delay := RetryDelay(retry)
+	if o.queue.Retry(action.ID, delay) == nil {
+		return false
+	}
+
+	log.Printf("[ORCHESTRATOR] Retrying %s on %s in %s (%s, retry %d/%d)",
+		action.Action, action.ServerName, delay, class, retry, maxRetries)
+	o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action,
+		fmt.Sprintf("%s (%s, retry %d/%d in %s)", current.ErrorMessage, class, retry, maxRetries, delay))
+	o.progress(action.ServerName, fmt.Sprintf("🔁 %s failed (%s), retry %d/%d in %s",
+		action.Action, class, retry, maxRetries, delay))
+	return true
+}
+
+// rollbackQueued reports whether a rollback of the server waits in the queue
+func (o *Orchestrator) rollbackQueued(serverName string) bool {
+	for _, queued := range o.queue.GetAll() {
+		if queued.ServerName == serverName && queued.Action == status.ActionRollback && queued.StartedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// recordHistory adds a finished action to the run history
+func (o *Orchestrator) recordHistory(action *status.QueuedAction, started time.Time, success bool) {
+	ended := time.Now()
+	current := o.statusMgr.GetStatus(action.ServerName)
+
+	run := status.ExecutionLog{
+		ServerName: action.ServerName,
+		Action:     action.Action,
+		Tags:       action.Tags,
+		Strategy:   action.Strategy,
+		Attempt:    action.Attempt + 1,
+		StartTime:  started,
+		EndTime:    &ended,
+		Status:     status.RunSucceeded,
+		Commit:     action.Commit,
+		Ref:        action.Ref,
+		Source:     action.Source,
+	}
+	if !success {
+		run.Status = status.RunFailed
+		run.Error = current.ErrorMessage
+		if current.State == status.StateCancelled {
+			run.Status = status.RunCancelled
+		}
+	}
+	// The playbook run of this action, if it got that far
+	if last := current.LastRun; last != nil && !last.FinishedAt.Before(started) {
+		run.Recap = last.Recap
+		run.LogFile = last.LogFile
+		run.CheckMode = last.CheckMode
+	}
+
+	o.appendHistory(run)
+}
+
+func (o *Orchestrator) appendHistory(run status.ExecutionLog) {
+	run.ID = uuid.New().String()
+	run.Operator = o.operator
+	if err := o.history.Append(run); err != nil {
+		log.Printf("[ORCHESTRATOR] Could not record %s run for %s in history: %v", run.Action, run.ServerName, err)
+	}
+}
+
+// Decisions about the actions interrupted by the death of their process
+const (
+	ResumeRerun = "rerun" // Run them again, once the state of their server is detected
+	ResumeSkip  = "skip"  // Drop them, along with the actions depending on them
+	ResumeClear = "clear" // Empty the whole queue
+)
+
+// markInterrupted records in history the actions left running by a process
+// which died. They wait in the queue for ResolveInterrupted.
+func (o *Orchestrator) markInterrupted() {
+	for _, action := range o.queue.MarkInterrupted() {
+		log.Printf("[ORCHESTRATOR] %s for server %s was interrupted", action.Action, action.ServerName)
+		o.appendHistory(status.ExecutionLog{
+			ServerName: action.ServerName,
+			Action:     action.Action,
+			Tags:       action.Tags,
+			Strategy:   action.Strategy,
+			Attempt:    action.Attempt + 1,
+			StartTime:  *action.StartedAt,
+			Status:     status.RunInterrupted,
+			Error:      "The process running it stopped",
+		})
+		o.statusMgr.UpdateStatus(action.ServerName, status.StateUnknown, action.Action,
+			fmt.Sprintf("%s interrupted, re-run or skip it", action.Action))
+	}
+}
+
+// InterruptedActions returns the actions interrupted by the death of their
+// process, which wait for ResolveInterrupted
+func (o *Orchestrator) InterruptedActions() []*status.QueuedAction {
+	return o.queue.Interrupted()
+}
+
+// ResolveInterrupted re-runs, skips or clears (see ResumeRerun) the
+// interrupted actions
+func (o *Orchestrator) ResolveInterrupted(resolution string) error {
+	switch resolution {
+	case ResumeRerun:
+		for _, action := range o.queue.ResumeInterrupted() {
+			log.Printf("[ORCHESTRATOR] Re-running interrupted %s for server %s", action.Action, action.ServerName)
+			o.progress(action.ServerName, fmt.Sprintf("🔁 Re-running interrupted %s", action.Action))
+		}
+	case ResumeSkip:
+		interrupted, skipped := o.queue.SkipInterrupted()
+		for _, action := range interrupted {
+			log.Printf("[ORCHESTRATOR] Skipping interrupted %s for server %s", action.Action, action.ServerName)
+			o.progress(action.ServerName, fmt.Sprintf("⏭️  Interrupted %s skipped", action.Action))
+		}
+		o.reportSkipped(skipped, "dependency interrupted and skipped")
+	case ResumeClear:
+		log.Printf("[ORCHESTRATOR] Clearing the queue instead of resuming %d interrupted actions", len(o.queue.Interrupted()))
+		o.queue.Clear()
+	default:
+		return fmt.Errorf("unknown resolution %q (use %s, %s or %s)", resolution, ResumeRerun, ResumeSkip, ResumeClear)
+	}
+	return nil
+}
+
+// CancelAction cancels the queued action id (or a unique prefix of it) while
+// the other actions keep running. A running action has its playbook killed and
+// its server marked cancelled; one not started yet is removed from the queue,
+// along with the actions depending on it.
+func (o *Orchestrator) CancelAction(id string) (*status.QueuedAction, error) {
+	action, err := o.queue.Find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped, err := o.queue.Remove(action.ID)
+	if errors.Is(err, ErrActionRunning) {
+		o.cancelRunning(action)
+		return action, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[ORCHESTRATOR] Removed queued %s for server %s", action.Action, action.ServerName)
+	now := time.Now()
+	o.appendHistory(status.ExecutionLog{
+		ServerName: action.ServerName,
+		Action:     action.Action,
+		Tags:       action.Tags,
+		Strategy:   action.Strategy,
+		StartTime:  now,
+		EndTime:    &now,
+		Status:     status.RunCancelled,
+		Error:      "Removed from the queue before it started",
+	})
+	o.progress(action.ServerName, fmt.Sprintf("🗑️  Queued %s removed", action.Action))
+	o.reportSkipped(skipped, fmt.Sprintf("%s on %s cancelled", action.Action, action.ServerName))
+	return action, nil
+}
+
+// cancelRunning cancels the context of a running action, which kills its
+// playbook. An action not tracked yet is cancelled as soon as it is.
+func (o *Orchestrator) cancelRunning(action *status.QueuedAction) {
+	o.actionsMu.Lock()
+	o.cancelled[action.ID] = true
+	cancel := o.actionCancels[action.ID]
+	o.actionsMu.Unlock()
+
+	log.Printf("[ORCHESTRATOR] Cancelling running %s for server %s (id: %s)", action.Action, action.ServerName, action.ID)
+	o.progress(action.ServerName, fmt.Sprintf("🛑 Cancelling %s...", action.Action))
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// trackAction returns the context an action runs with, cancelled by Stop or
+// by CancelAction for this action only
+func (o *Orchestrator) trackAction(id string) context.Context {
+	o.mu.RLock()
+	parent := o.ctx
+	o.mu.RUnlock()
+	ctx, cancel := context.WithCancel(parent)
+
+	o.actionsMu.Lock()
+	defer o.actionsMu.Unlock()
+	o.actionCancels[id] = cancel
+	if o.cancelled[id] {
+		cancel()
+	}
+	return ctx
 }
 
-func (o *Orchestrator) executeAction(action *status.QueuedAction, servers []*inventory.Server) {
+// untrackAction releases the context of a finished action and reports whether
+// it was cancelled
+func (o *Orchestrator) untrackAction(id string) bool {
+	o.actionsMu.Lock()
+	cancel := o.actionCancels[id]
+	cancelled := o.cancelled[id]
+	delete(o.actionCancels, id)
+	delete(o.cancelled, id)
+	o.actionsMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	return cancelled
+}
+
+// GetHistory returns the run history of the environment
+func (o *Orchestrator) GetHistory() *status.History {
+	return o.history
+}
+
+// checkRollout stops a rolling deploy once a finished batch failed too much
+func (o *Orchestrator) checkRollout(action *status.QueuedAction) {
+	done, total, failed := o.queue.BatchResult(action.Rollout, action.Batch)
+	if !done {
+		return
+	}
+
+	o.mu.RLock()
+	maxFailurePercent := o.strategy.MaxFailurePercent
+	o.mu.RUnlock()
+
+	log.Printf("[ORCHESTRATOR] Rollout %s batch %d done: %d/%d failed (max %d%%)",
+		action.Rollout, action.Batch, failed, total, maxFailurePercent)
+	if !batchFailed(total, failed, maxFailurePercent) {
+		return
+	}
+
+	skipped := o.queue.CancelRollout(action.Rollout)
+	if len(skipped) == 0 {
+		// Last batch, there is nothing left to stop
+		return
+	}
+
+	reason := fmt.Sprintf("rollout stopped, %d/%d servers of batch %d failed", failed, total, action.Batch+1)
+	log.Printf("[ORCHESTRATOR] %s", reason)
+	o.progress(action.ServerName, "🛑 "+reason)
+	o.reportSkipped(skipped, reason)
+}
+
+// reportSkipped tells the user about actions removed from the queue without running
+func (o *Orchestrator) reportSkipped(skipped []*status.QueuedAction, reason string) {
+	for _, action := range skipped {
+		log.Printf("[ORCHESTRATOR] Skipped %s for server %s: %s", action.Action, action.ServerName, reason)
+		now := time.Now()
+		o.appendHistory(status.ExecutionLog{
+			ServerName: action.ServerName,
+			Action:     action.Action,
+			Tags:       action.Tags,
+			Strategy:   action.Strategy,
+			StartTime:  now,
+			EndTime:    &now,
+			Status:     status.RunSkipped,
+			Error:      reason,
+		})
+		o.progress(action.ServerName, fmt.Sprintf("⏭️  %s skipped: %s", action.Action, reason))
+	}
+}
+
+// executeAction runs one queued action until ctx is cancelled and reports
+// whether it succeeded
+func (o *Orchestrator) executeAction(ctx context.Context, action *status.QueuedAction, servers []*inventory.Server) bool {
 	server := o.findServer(action.ServerName, servers)
 	if server == nil {
 		o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, "Server not found")
-		return
+		return false
 	}
 
 	progressChan := make(chan string, 100)
 	go func() {
 		for msg := range progressChan {
-			if o.progressCb != nil {
-				o.progressCb(action.ServerName, msg)
-			}
+			o.progress(action.ServerName, msg)
 		}
 	}()
 
+	// The interrupted run may have changed the server, start from its actual
+	// state. A check detects it anyway.
+	if action.Resumed && action.Action != status.ActionCheck {
+		o.statusMgr.UpdateStatus(action.ServerName, status.StateVerifying, action.Action, "Detecting server state before resuming...")
+		progressChan <- fmt.Sprintf("🔍 Detecting the state of %s before re-running the interrupted %s", action.ServerName, action.Action)
+		detected := o.detectState(server, progressChan)
+		o.statusMgr.UpdateStatus(action.ServerName, detected.State, action.Action, detected.Message)
+	}
+
 	var result *ExecutionResult
 	var err error
 
 	switch action.Action {
 	case status.ActionProvision:
 		o.statusMgr.UpdateStatus(action.ServerName, status.StateProvisioning, action.Action, "Provisioning server...")
-		log.Printf("[ORCHESTRATOR] Running provision for %s with tags: %s", action.ServerName, action.Tags)
+		// Each server type is provisioned with the roles of its own type
+		tags := ProvisionTagsFor(server.Role(), action.Tags)
+		log.Printf("[ORCHESTRATOR] Running provision for %s (%s) with tags: %s", action.ServerName, server.Role(), tags)
 		
 		if o.useScript {
 			log.Printf("[ORCHESTRATOR] Using deploy.sh for provision")
 			result, err = o.scriptExecutor.RunAction("provision", action.ServerName, progressChan)
 		} else {
-			log.Printf("[ORCHESTRATOR] Using ansible-playbook directly with context and tags: %s", action.Tags)
+			log.Printf("[ORCHESTRATOR] Using ansible-playbook directly with context and tags: %s", tags)
 			// Use context for cancellation support
-			result, err = o.executor.ProvisionWithContext(o.ctx, action.ServerName, action.Tags, progressChan)
+			result, err = o.executor.ProvisionWithContext(ctx, action.ServerName, tags, progressChan)
 		}
 		close(progressChan)
 
-		if err != nil || !result.Success {
-			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, result.ErrorMessage)
+		if ok, errMsg := o.recordRun(action, result, err); !ok {
+			o.statusMgr.UpdateStatus(action.ServerName, failureState(errMsg), action.Action, errMsg)
+		} else if !server.Deployable() && o.healthCheckOn() {
+			// Database and monitoring servers are complete once their service answers
+			o.statusMgr.UpdateStatus(action.ServerName, status.StateVerifying, action.Action, "Checking...")
+			if err := o.roleHealthCheck(ctx, server); err != nil {
+				errMsg := fmt.Sprintf("Health check failed: %v", err)
+				log.Printf("[ORCHESTRATOR] %s", errMsg)
+				o.statusMgr.UpdateStatus(action.ServerName, failureState(errMsg), action.Action, errMsg)
+			} else {
+				o.statusMgr.UpdateStatus(action.ServerName, status.StateProvisioned, action.Action, "")
+			}
 		} else {
 			o.statusMgr.UpdateStatus(action.ServerName, status.StateProvisioned, action.Action, "")
 		}
 
 	case status.ActionDeploy:
+		if !server.Deployable() {
+			log.Printf("[ORCHESTRATOR] Nothing to deploy on %s (%s server)", action.ServerName, server.Role())
+			progressChan <- fmt.Sprintf("Nothing to deploy on %s servers", server.Role())
+			close(progressChan)
+			return true
+		}
+		
 		currentStatus := o.statusMgr.GetStatus(action.ServerName)
-		if currentStatus.State != status.StateProvisioned && currentStatus.State != status.StateDeployed {
+		// A retried deploy starts from the failure of its previous attempt
+		retrying := action.Attempt > 0 && currentStatus.State == status.StateFailed && currentStatus.LastAction == status.ActionDeploy
+		// A cancelled deploy or rollback left a provisioned server behind
+		cancelled := currentStatus.State == status.StateCancelled &&
+			(currentStatus.LastAction == status.ActionDeploy || currentStatus.LastAction == status.ActionRollback)
+		if currentStatus.State != status.StateProvisioned && currentStatus.State != status.StateDeployed && currentStatus.State != status.StateRolledBack && !retrying && !cancelled {
 			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, "Server must be provisioned first")
 			close(progressChan)
-			return
+			return false
 		}
 
 		o.statusMgr.UpdateStatus(action.ServerName, status.StateDeploying, action.Action, "Deploying application...")
@@ -318,96 +1160,88 @@ func (o *Orchestrator) executeAction(action *status.QueuedAction, servers []*inv
 			log.Printf("[ORCHESTRATOR] Using deploy.sh for deploy")
 			result, err = o.scriptExecutor.RunAction("deploy", action.ServerName, progressChan)
 		} else {
-			log.Printf("[ORCHESTRATOR] Using ansible-playbook directly with context and tags: %s", action.Tags)
+			log.Printf("[ORCHESTRATOR] Using ansible-playbook directly with context and tags: %s (strategy: %s)", action.Tags, action.Strategy)
 			// Use context for cancellation support
-			result, err = o.executor.DeployWithContext(o.ctx, action.ServerName, action.Tags, progressChan)
+			playbook, opts := o.deployPlaybook(action, server)
+			result, err = o.executor.Run(ctx, playbook, action.ServerName, opts, progressChan)
 		}
 		close(progressChan)
 
-		if err != nil || !result.Success {
-			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, result.ErrorMessage)
+		if ok, errMsg := o.recordRun(action, result, err); !ok {
+			o.statusMgr.UpdateStatus(action.ServerName, failureState(errMsg), action.Action, errMsg)
 		} else {
+			// The release is in place, whatever its health check says
+			o.statusMgr.SetCommit(action.ServerName, action.Commit, action.Ref)
+
 			// Check if health check should be performed
-			performHealthCheck := o.healthCheckEnabled && !o.skipHealthCheck
-			o.skipHealthCheck = false // Reset for next deployment
+			performHealthCheck := o.healthCheckOn() && !action.SkipHealth
 			
 			if !performHealthCheck {
 				log.Printf("[ORCHESTRATOR] Health check skipped (disabled or skip requested)")
 				o.statusMgr.UpdateStatus(action.ServerName, status.StateDeployed, action.Action, "")
 				
 				// Trigger deploy success callback
-				if o.deploySuccessCb != nil {
-					o.deploySuccessCb(action.ServerName, server.IP)
-				}
+				o.deploySucceeded(action.ServerName, server.IP)
 			} else {
 				o.statusMgr.UpdateStatus(action.ServerName, status.StateVerifying, action.Action, "Checking...")
 				
-				// Determine if we need remote health check (SSH-based)
-				// Use remote check if:
-				// - Server IP is 127.0.0.1 (localhost/Docker container)
-				// - Server has SSH credentials configured
-				useRemoteCheck := server.IP == "127.0.0.1" && server.Port > 0 && server.SSHKeyPath != ""
-				
-				var healthCheckErr error
-				healthCheckPassed := false
-				
-				if useRemoteCheck {
-					// Remote health check via SSH (for Docker containers or localhost servers)
-					log.Printf("[ORCHESTRATOR] Using remote health check via SSH for %s (port %d)", action.ServerName, server.AppPort)
-					
-					if server.AppPort > 0 {
-						if err := o.executor.HealthCheckRemote(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, server.AppPort); err == nil {
-							log.Printf("[ORCHESTRATOR] Remote health check passed on port %d", server.AppPort)
-							healthCheckPassed = true
-						} else {
-							healthCheckErr = err
-							log.Printf("[ORCHESTRATOR] Remote health check failed: %v", err)
-						}
-					} else {
-						healthCheckErr = fmt.Errorf("app_port not configured for server")
-						log.Printf("[ORCHESTRATOR] Cannot perform health check: %v", healthCheckErr)
-					}
-				} else {
-					// Standard health check (direct HTTP from orchestrator to server IP)
-					log.Printf("[ORCHESTRATOR] Using direct health check for %s:%d", server.IP, server.AppPort)
-					
-					// Try health check on multiple ports: 80 (nginx), 443 (https), app port
-					ports := []int{80}
-					if server.AppPort > 0 && server.AppPort != 80 {
-						ports = append(ports, server.AppPort)
-					}
-					
-					for _, port := range ports {
-						log.Printf("[ORCHESTRATOR] Trying health check on %s:%d", server.IP, port)
-						if err := o.executor.HealthCheck(server.IP, port); err == nil {
-							log.Printf("[ORCHESTRATOR] Health check passed on port %d", port)
-							healthCheckPassed = true
-							break
-						} else {
-							healthCheckErr = err
-							log.Printf("[ORCHESTRATOR] Health check failed on port %d: %v", port, err)
-						}
-					}
-				}
-				
-				if !healthCheckPassed {
+				if healthCheckErr := o.deployHealthCheck(ctx, server); healthCheckErr != nil {
 					errMsg := fmt.Sprintf("Health check failed: %v", healthCheckErr)
 					log.Printf("[ORCHESTRATOR] %s", errMsg)
 					log.Printf("[ORCHESTRATOR] Tip: Check if application is running on server, nginx is configured, and ports are open")
-					o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, errMsg)
+					// A cancelled health check says nothing about the release
+					if o.autoRollbackEnabled() && ctx.Err() == nil {
+						errMsg += ", rolling back"
+						o.queueAutoRollback(action)
+					}
+					o.statusMgr.UpdateStatus(action.ServerName, failureState(errMsg), action.Action, errMsg)
 					
 					// Trigger callback even on health check failure (allow browser access attempt)
-					if o.deploySuccessCb != nil {
-						log.Printf("[ORCHESTRATOR] Triggering deploy success callback despite health check failure (app may still be accessible)")
-						o.deploySuccessCb(action.ServerName, server.IP)
-					}
+					log.Printf("[ORCHESTRATOR] Triggering deploy success callback despite health check failure (app may still be accessible)")
+					o.deploySucceeded(action.ServerName, server.IP)
 				} else {
 					o.statusMgr.UpdateStatus(action.ServerName, status.StateDeployed, action.Action, "")
 					
 					// Trigger deploy success callback
-					if o.deploySuccessCb != nil {
-						o.deploySuccessCb(action.ServerName, server.IP)
-					}
+					o.deploySucceeded(action.ServerName, server.IP)
+				}
+			}
+		}
+
+	case status.ActionRollback:
+		if !server.Deployable() {
+			log.Printf("[ORCHESTRATOR] Nothing to roll back on %s (%s server)", action.ServerName, server.Role())
+			progressChan <- fmt.Sprintf("Nothing to roll back on %s servers", server.Role())
+			close(progressChan)
+			return true
+		}
+		
+		target := action.Release
+		if target == "" {
+			target = "previous release"
+		}
+		o.statusMgr.UpdateStatus(action.ServerName, status.StateRollingBack, action.Action, fmt.Sprintf("Rolling back to %s...", target))
+		log.Printf("[ORCHESTRATOR] Running rollback for %s to %s", action.ServerName, target)
+		
+		// deploy.sh can only roll back a whole environment, the playbook is always run directly
+		result, err = o.executor.RollbackWithContext(ctx, action.ServerName, action.Release, progressChan)
+		close(progressChan)
+		
+		if ok, errMsg := o.recordRun(action, result, err); !ok {
+			o.statusMgr.UpdateStatus(action.ServerName, failureState(errMsg), action.Action, errMsg)
+		} else {
+			// Releases are not recorded with their full commit
+			o.statusMgr.SetCommit(action.ServerName, "", "")
+			if !o.healthCheckOn() {
+				o.statusMgr.UpdateStatus(action.ServerName, status.StateRolledBack, action.Action, fmt.Sprintf("Rolled back to %s", target))
+			} else {
+				o.statusMgr.UpdateStatus(action.ServerName, status.StateVerifying, action.Action, "Checking...")
+				if err := o.deployHealthCheck(ctx, server); err != nil {
+					errMsg := fmt.Sprintf("Health check failed after rollback: %v", err)
+					log.Printf("[ORCHESTRATOR] %s", errMsg)
+					o.statusMgr.UpdateStatus(action.ServerName, failureState(errMsg), action.Action, errMsg)
+				} else {
+					o.statusMgr.UpdateStatus(action.ServerName, status.StateRolledBack, action.Action, fmt.Sprintf("Rolled back to %s", target))
 				}
 			}
 		}
@@ -423,41 +1257,48 @@ func (o *Orchestrator) executeAction(action *status.QueuedAction, servers []*inv
 			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, 
 				"Configuration incomplete: missing required fields")
 			close(progressChan)
-			return
+			return false
 		}
 		
 		if !checks.IPValid {
 			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, 
 				"Invalid IP address format")
 			close(progressChan)
-			return
+			return false
 		}
 		
 		if !checks.PortValid {
 			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, 
 				"Invalid SSH port (must be 1-65535)")
 			close(progressChan)
-			return
+			return false
 		}
 		
 		if !checks.SSHKeyExists {
-			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, 
-				fmt.Sprintf("SSH key not found at: %s", server.SSHKeyPath))
+			errMsg := fmt.Sprintf("SSH key not found at: %s", server.SSHKeyPath)
+			if server.SSHKeyPath == "" {
+				errMsg = "No SSH key configured and no SSH agent available (SSH_AUTH_SOCK)"
+			}
+			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, errMsg)
 			close(progressChan)
-			return
+			return false
 		}
 		
 		// Step 2: Test SSH connection
 		o.statusMgr.UpdateStatus(action.ServerName, status.StateVerifying, action.Action, "Testing SSH connection...")
 		log.Printf("[ORCHESTRATOR] Testing SSH connection to %s:%d", server.IP, server.Port)
 		
-		sshTest := o.executor.TestSSH(server.IP, server.Port, "root", server.SSHKeyPath)
+		sshTest := o.executor.TestSSH(server.IP, server.Port, "root", server.SSHKeyPath, o.jumpHostFor(server))
 		if !sshTest.Success {
 			log.Printf("[ORCHESTRATOR] SSH test failed for %s: %s", action.ServerName, sshTest.Message)
-			o.statusMgr.UpdateStatus(action.ServerName, status.StateFailed, action.Action, 
+			state := status.StateFailed
+			if sshTest.HostKeyChanged {
+				state = status.StateHostKeyChanged
+			}
+			o.statusMgr.UpdateStatus(action.ServerName, state, action.Action, 
 				fmt.Sprintf("SSH connection failed: %s", sshTest.Message))
 			close(progressChan)
-			return
+			return false
 		}
 		
 		log.Printf("[ORCHESTRATOR] SSH test passed for %s", action.ServerName)
@@ -466,29 +1307,7 @@ func (o *Orchestrator) executeAction(action *status.QueuedAction, servers []*inv
 		o.statusMgr.UpdateStatus(action.ServerName, status.StateVerifying, action.Action, "Detecting server state...")
 		log.Printf("[ORCHESTRATOR] Detecting state for %s using State Detector", action.ServerName)
 		
-		detector := ssh.NewStateDetector()
-		stateResult := detector.DetectState(*server)
-		
-		log.Printf("[ORCHESTRATOR] State detected for %s: %s - %s", 
-			action.ServerName, stateResult.State, stateResult.Message)
-		
-		// Log detailed checks for debugging
-		if stateResult.ProvisioningChecks.AllProvisioned {
-			log.Printf("[ORCHESTRATOR] %s: Provisioning checks passed (Node: %v, Nginx: %v, NVM: %v, AppDir: %v)",
-				action.ServerName,
-				stateResult.ProvisioningChecks.NodeInstalled,
-				stateResult.ProvisioningChecks.NginxInstalled,
-				stateResult.ProvisioningChecks.NVMInstalled,
-				stateResult.ProvisioningChecks.AppDirExists)
-		}
-		
-		if stateResult.DeploymentChecks.AllDeployed {
-			log.Printf("[ORCHESTRATOR] %s: Deployment checks passed (PM2: %v, App: %v, Symlink: %v)",
-				action.ServerName,
-				stateResult.DeploymentChecks.PM2Running,
-				stateResult.DeploymentChecks.AppResponding,
-				stateResult.DeploymentChecks.CurrentSymlink)
-		}
+		stateResult := o.detectState(server, progressChan)
 		
 		// Update status with detected state
 		o.statusMgr.UpdateStatus(action.ServerName, stateResult.State, action.Action, stateResult.Message)
@@ -500,6 +1319,198 @@ func (o *Orchestrator) executeAction(action *status.QueuedAction, servers []*inv
 		
 		close(progressChan)
 	}
+
+	// The final state tells whether the action succeeded
+	state := o.statusMgr.GetStatus(action.ServerName).State
+	return state != status.StateFailed && state != status.StateHostKeyChanged
+}
+
+// detectState detects the actual state of a server with the probes of its
+// type, reporting every probe on progressChan
+func (o *Orchestrator) detectState(server *inventory.Server, progressChan chan<- string) ssh.StateDetectionResult {
+	o.mu.RLock()
+	detector := ssh.NewStateDetector(o.executor.HostKeys(), o.jumpHost)
+	detector.SetEnvironment(o.envConfig)
+	o.mu.RUnlock()
+	stateResult := detector.DetectState(*server)
+
+	log.Printf("[ORCHESTRATOR] State detected for %s: %s - %s",
+		server.Name, stateResult.State, stateResult.Message)
+
+	// Report every probe, with its output when it failed
+	for _, probe := range stateResult.Probes {
+		log.Printf("[ORCHESTRATOR] %s: probe %s (%s) passed=%v: %s",
+			server.Name, probe.Name, probe.Gates, probe.Passed, probe.Output)
+		if probe.Passed {
+			progressChan <- fmt.Sprintf("Probe %s ✓", probe.Name)
+		} else {
+			progressChan <- fmt.Sprintf("Probe %s ✗ %s", probe.Name, strings.ReplaceAll(probe.Output, "\n", " | "))
+		}
+	}
+	return stateResult
+}
+
+// deployHealthCheck checks the application of a web server once deployed or
+// rolled back, and returns why it does not answer
+func (o *Orchestrator) deployHealthCheck(ctx context.Context, server *inventory.Server) error {
+	// Determine if we need remote health check (SSH-based)
+	// Use remote check if:
+	// - Server IP is 127.0.0.1 (localhost/Docker container)
+	// - Server has SSH credentials configured
+	// - Or the server is only reachable through a jump host
+	jump := o.jumpHostFor(server)
+	useRemoteCheck := (server.IP == "127.0.0.1" && server.Port > 0 && (server.SSHKeyPath != "" || inventory.SSHAgentAvailable())) || jump != nil
+
+	o.mu.RLock()
+	spec := o.healthCheck.Merge(server.HealthCheck)
+	o.mu.RUnlock()
+
+	if useRemoteCheck {
+		// Remote health check via SSH (for Docker containers or localhost servers)
+		appPort := server.AppPort
+		if spec.Port > 0 {
+			appPort = spec.Port
+		}
+		log.Printf("[ORCHESTRATOR] Using remote health check via SSH for %s (port %d)", server.Name, appPort)
+
+		if appPort <= 0 {
+			err := fmt.Errorf("app_port not configured for server")
+			log.Printf("[ORCHESTRATOR] Cannot perform health check: %v", err)
+			return err
+		}
+		if err := o.executor.HealthCheckRemote(ctx, server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump, appPort, spec); err != nil {
+			log.Printf("[ORCHESTRATOR] Remote health check failed: %v", err)
+			return err
+		}
+		log.Printf("[ORCHESTRATOR] Remote health check passed on port %d", appPort)
+		return nil
+	}
+
+	// Standard health check (direct HTTP from orchestrator to server IP)
+	log.Printf("[ORCHESTRATOR] Using direct health check for %s:%d", server.IP, server.AppPort)
+
+	// Try health check on multiple ports: 80 (nginx), 443 (https), app port
+	ports := []int{80}
+	if server.AppPort > 0 && server.AppPort != 80 {
+		ports = append(ports, server.AppPort)
+	}
+	if spec.Port > 0 {
+		ports = []int{spec.Port}
+	}
+
+	var healthCheckErr error
+	for _, port := range ports {
+		log.Printf("[ORCHESTRATOR] Trying health check on %s:%d", server.IP, port)
+		if err := o.executor.HealthCheck(ctx, server.IP, port, spec); err != nil {
+			healthCheckErr = err
+			log.Printf("[ORCHESTRATOR] Health check failed on port %d: %v", port, err)
+			continue
+		}
+		log.Printf("[ORCHESTRATOR] Health check passed on port %d", port)
+		return nil
+	}
+	return healthCheckErr
+}
+
+// queueAutoRollback queues the rollback of a deploy whose health check failed,
+// ahead of the actions of the same priority
+func (o *Orchestrator) queueAutoRollback(deploy *status.QueuedAction) {
+	log.Printf("[ORCHESTRATOR] Auto rollback: queueing rollback of %s", deploy.ServerName)
+	o.queue.AddAction(&status.QueuedAction{
+		ServerName: deploy.ServerName,
+		Action:     status.ActionRollback,
+		Priority:   deploy.Priority + 1,
+	})
+	o.progress(deploy.ServerName, "↩️  Health check failed, rolling back to the previous release")
+}
+
+// roleHealthCheck checks that the service of a database or monitoring server
+// answers once it is provisioned
+func (o *Orchestrator) roleHealthCheck(ctx context.Context, server *inventory.Server) error {
+	jump := o.jumpHostFor(server)
+	switch server.Role() {
+	case inventory.ServerTypeDB:
+		return o.executor.DatabaseHealthCheck(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump)
+	case inventory.ServerTypeMonitoring:
+		// node_exporter only has to answer on the server itself
+		spec := inventory.HealthCheckSpec{Path: "/metrics", Retries: 2}
+		return o.executor.HealthCheckRemote(ctx, server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump, inventory.NodeExporterPort, spec)
+	}
+	return nil
+}
+
+// failureState returns the state of a server whose action failed with errMsg
+func failureState(errMsg string) status.ServerState {
+	if ssh.IsHostKeyMismatch(errMsg) {
+		return status.StateHostKeyChanged
+	}
+	return status.StateFailed
+}
+
+// deployPlaybook returns the playbook and options implementing the strategy of a deploy
+func (o *Orchestrator) deployPlaybook(action *status.QueuedAction, server *inventory.Server) (string, PlaybookOptions) {
+	opts := PlaybookOptions{Tags: action.Tags, Strategy: action.Strategy, ExtraVars: map[string]string{}}
+	if action.Commit != "" {
+		opts.ExtraVars["app_commit"] = action.Commit
+	}
+	if action.Strategy != StrategyBlueGreen {
+		return "deploy.yml", opts
+	}
+
+	o.mu.RLock()
+	offset := o.strategy.PortOffset
+	spec := o.healthCheck.Merge(server.HealthCheck)
+	o.mu.RUnlock()
+	if offset <= 0 {
+		offset = DefaultBlueGreenPortOffset
+	}
+	opts.ExtraVars["blue_green_port_offset"] = strconv.Itoa(offset)
+
+	// The playbook checks the candidate and the switched release itself, with
+	// the health check of the server
+	path := spec.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	opts.ExtraVars["health_check_path"] = path
+	if spec.Method != "" {
+		opts.ExtraVars["health_check_method"] = spec.Method
+	}
+	if len(spec.ExpectStatus) > 0 {
+		codes := make([]string, 0, len(spec.ExpectStatus))
+		for _, code := range spec.ExpectStatus {
+			codes = append(codes, strconv.Itoa(code))
+		}
+		opts.ExtraVars["health_check_status"] = strings.Join(codes, ",")
+	}
+	if spec.Retries > 0 {
+		opts.ExtraVars["health_check_retries"] = strconv.Itoa(spec.Retries)
+	}
+	if spec.Interval > 0 {
+		opts.ExtraVars["health_check_delay"] = strconv.Itoa(max(int(spec.Interval.Seconds()), 1))
+	}
+	return "deploy-blue-green.yml", opts
+}
+
+// recordRun stores the outcome of a playbook run in the server status and
+// returns whether it succeeded along with the error to report otherwise
+func (o *Orchestrator) recordRun(action *status.QueuedAction, result *ExecutionResult, err error) (bool, string) {
+	if result == nil {
+		// The playbook could not even be started
+		return false, fmt.Sprintf("Failed to run %s: %v", action.Action, err)
+	}
+
+	run := result.Summary(action.Action, action.ServerName)
+	run.Strategy = action.Strategy
+	if recordErr := o.statusMgr.RecordRun(action.ServerName, run); recordErr != nil {
+		log.Printf("[ORCHESTRATOR] Could not record run for %s: %v", action.ServerName, recordErr)
+	}
+	log.Printf("[ORCHESTRATOR] %s on %s finished: %s", action.Action, action.ServerName, run)
+
+	if err != nil || !result.Success {
+		return false, result.ErrorMessage
+	}
+	return true, ""
 }
 
 func (o *Orchestrator) findServer(name string, servers []*inventory.Server) *inventory.Server {
@@ -523,8 +1534,46 @@ func (o *Orchestrator) ClearQueue() {
 	o.queue.Clear()
 }
 
+// MoveAction puts a queued action at position (0 = first) of the queue, see
+// Queue.Move
+func (o *Orchestrator) MoveAction(id string, position int) error {
+	return o.queue.Move(id, position)
+}
+
+// SetActionPriority changes the priority of a queued action
+func (o *Orchestrator) SetActionPriority(id string, priority int) error {
+	return o.queue.SetPriority(id, priority)
+}
+
+// PauseAction holds a queued action, and the actions waiting for it, until
+// ResumeAction
+func (o *Orchestrator) PauseAction(id string) error {
+	return o.queue.PauseAction(id)
+}
+
+// ResumeAction lets a paused action start again
+func (o *Orchestrator) ResumeAction(id string) error {
+	return o.queue.ResumeAction(id)
+}
+
 func (o *Orchestrator) IsRunning() bool {
 	o.mu.RLock()
 	defer o.mu.RUnlock()
 	return o.running
 }
+
+// IsIdle reports whether no action is being executed and none of the queued
+// ones may start now: the queue is empty, or only holds paused, interrupted or
+// scheduled actions and the actions waiting for them
+func (o *Orchestrator) IsIdle() bool {
+	o.workersMu.Lock()
+	active := o.activeWorkers
+	o.workersMu.Unlock()
+	if active > 0 {
+		return false
+	}
+	now := time.Now()
+	return o.queue.RunnableWhere(func(a *status.QueuedAction) bool {
+		return o.waiting(a, now)
+	}) == 0
+}
@@ -0,0 +1,133 @@
+package ansible
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+// ErrPromotionGate is wrapped by the errors of the promotions whose source
+// did not pass a gate
+var ErrPromotionGate = errors.New("promotion gate not passed")
+
+// ErrPromotionRejected is recorded for the promotions an operator did not
+// approve
+var ErrPromotionRejected = errors.New("promotion not approved")
+
+// PromotionSource is the commit the web servers of an environment run, which a
+// promotion deploys to the next environment of the pipeline
+type PromotionSource struct {
+	Environment string
+	Commit      string
+	Servers     []string // Web servers running it
+}
+
+// ShortCommit returns the first 7 characters of the commit
+func (ps *PromotionSource) ShortCommit() string {
+	return ps.Commit[:min(7, len(ps.Commit))]
+}
+
+// InspectPromotionSource returns the commit the web servers among servers run.
+// It fails with ErrPromotionGate unless each of them is deployed, they all run
+// the same commit and, with healthGate, they pass their health check.
+func (o *Orchestrator) InspectPromotionSource(ctx context.Context, servers []*inventory.Server, healthGate bool) (*PromotionSource, error) {
+	names := make([]string, 0, len(servers))
+	for _, server := range servers {
+		names = append(names, server.Name)
+	}
+	webNames, _ := inventory.SplitDeployable(servers, names)
+	if len(webNames) == 0 {
+		return nil, fmt.Errorf("%w: %s has no web server", ErrPromotionGate, o.environment)
+	}
+
+	var web []*inventory.Server
+	var problems []string
+	for _, name := range webNames {
+		web = append(web, o.findServer(name, servers))
+		if st := o.statusMgr.GetStatus(name); st.State != status.StateDeployed {
+			problems = append(problems, fmt.Sprintf("%s is %s, not deployed", name, st.State))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromotionGate, strings.Join(problems, "; "))
+	}
+
+	o.mu.RLock()
+	env := o.envConfig
+	workers := max(o.maxWorkers, 1)
+	o.mu.RUnlock()
+	detector := ssh.NewDriftDetector(o.executor.HostKeys(), env)
+	runningOn := make(map[string][]string) // Commit -> servers
+	for _, result := range detector.DetectAll(web, workers) {
+		switch {
+		case result.Err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", result.Server, result.Err))
+		case result.Deployed.Commit == "":
+			problems = append(problems, fmt.Sprintf("%s runs no release", result.Server))
+		default:
+			runningOn[result.Deployed.Commit] = append(runningOn[result.Deployed.Commit], result.Server)
+		}
+	}
+	if len(runningOn) > 1 {
+		var commits []string
+		for commit, on := range runningOn {
+			commits = append(commits, fmt.Sprintf("%s on %s", commit[:min(7, len(commit))], strings.Join(on, ", ")))
+		}
+		sort.Strings(commits)
+		problems = append(problems, "the web servers run different commits: "+strings.Join(commits, ", "))
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromotionGate, strings.Join(problems, "; "))
+	}
+
+	source := &PromotionSource{Environment: o.environment, Servers: webNames}
+	for commit := range runningOn {
+		source.Commit = commit
+	}
+
+	if healthGate {
+		for _, server := range web {
+			if err := o.deployHealthCheck(ctx, server); err != nil {
+				problems = append(problems, fmt.Sprintf("health check of %s failed: %v", server.Name, err))
+			}
+		}
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrPromotionGate, strings.Join(problems, "; "))
+		}
+	}
+
+	log.Printf("[ORCHESTRATOR] %s runs %s on %v, ready to be promoted", o.environment, source.Commit, webNames)
+	return source, nil
+}
+
+// RecordPromotion records in the history of the environment the promotion of
+// commit from source: failed when a gate did not pass, cancelled when it was
+// not approved (err wrapping ErrPromotionRejected). The deploys it queued are
+// recorded on their own.
+func (o *Orchestrator) RecordPromotion(source, commit string, err error) {
+	now := time.Now()
+	run := status.ExecutionLog{
+		Action:    status.ActionPromote,
+		StartTime: now,
+		EndTime:   &now,
+		Status:    status.RunSucceeded,
+		Commit:    commit,
+		Source:    source,
+	}
+	if err != nil {
+		run.Status = status.RunFailed
+		run.Error = err.Error()
+		if errors.Is(err, ErrPromotionRejected) {
+			run.Status = status.RunCancelled
+		}
+	}
+	o.appendHistory(run)
+}
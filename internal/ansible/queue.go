@@ -2,21 +2,31 @@ package ansible
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
 	"github.com/bastiblast/boiler-deploy/internal/status"
 	"github.com/google/uuid"
 )
 
+// ErrActionRunning is returned when removing an action this process runs
+var ErrActionRunning = errors.New("action is running")
+
 type Queue struct {
 	mu          sync.RWMutex
 	actions     []*status.QueuedAction
 	current     *status.QueuedAction
+	running     map[string]bool // IDs handed out by NextReady and not finished yet
+	results     map[string]bool // Finished action ID -> success, for dependency checks
+	batches     map[string]map[int][]string // Rollout -> batch -> action IDs
 	environment string
 	queueFile   string
 	stopChan    chan struct{}
@@ -31,12 +41,16 @@ func NewQueue(environment string) (*Queue, error) {
 
 	q := &Queue{
 		actions:     make([]*status.QueuedAction, 0),
+		running:     make(map[string]bool),
+		results:     make(map[string]bool),
+		batches:     make(map[string]map[int][]string),
 		environment: environment,
 		queueFile:   filepath.Join(queueDir, "actions.json"),
 		stopChan:    make(chan struct{}),
 	}
 
 	if err := q.Load(); err != nil {
+		log.Printf("[QUEUE] Starting with an empty queue: %v", err)
 		return q, nil
 	}
 
@@ -47,7 +61,14 @@ func (q *Queue) Load() error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	data, err := os.ReadFile(q.queueFile)
+	err := atomicfile.ReadFile(q.queueFile, func(data []byte) error {
+		var actions []*status.QueuedAction
+		if err := json.Unmarshal(data, &actions); err != nil {
+			return err
+		}
+		q.actions = actions
+		return nil
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -55,8 +76,8 @@ func (q *Queue) Load() error {
 		return err
 	}
 
-	if err := json.Unmarshal(data, &q.actions); err != nil {
-		return err
+	for _, action := range q.actions {
+		q.trackBatch(action)
 	}
 
 	return nil
@@ -74,25 +95,34 @@ func (q *Queue) save() error {
 		return err
 	}
 
-	return os.WriteFile(q.queueFile, data, 0644)
+	return atomicfile.WriteFileWithBackup(q.queueFile, data, 0644)
 }
 
 func (q *Queue) Add(serverName string, action status.ActionType, priority int) *status.QueuedAction {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	id := uuid.New().String()
-	queuedAction := &status.QueuedAction{
-		ID:         id,
+	return q.AddAction(&status.QueuedAction{
 		ServerName: serverName,
 		Action:     action,
 		Priority:   priority,
-		QueuedAt:   time.Now(),
-		Tags:       "",
+	})
+}
+
+// AddAction queues a prepared action (tags, dependencies...). ID and QueuedAt
+// are filled in when empty.
+func (q *Queue) AddAction(queuedAction *status.QueuedAction) *status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if queuedAction.ID == "" {
+		queuedAction.ID = uuid.New().String()
+	}
+	if queuedAction.QueuedAt.IsZero() {
+		queuedAction.QueuedAt = time.Now()
 	}
 
-	log.Printf("[QUEUE] Adding action: %s for server %s (priority: %d, id: %s)", action, serverName, priority, id)
+	log.Printf("[QUEUE] Adding action: %s for server %s (priority: %d, id: %s, depends on: %v)",
+		queuedAction.Action, queuedAction.ServerName, queuedAction.Priority, queuedAction.ID, queuedAction.DependsOn)
 	q.actions = append(q.actions, queuedAction)
+	q.trackBatch(queuedAction)
 	q.sort()
 	q.save()
 	log.Printf("[QUEUE] Action added, queue size now: %d", len(q.actions))
@@ -100,16 +130,530 @@ func (q *Queue) Add(serverName string, action status.ActionType, priority int) *
 	return queuedAction
 }
 
+// trackBatch remembers the rollout batch of an action so that its result can
+// still be counted once it left the queue
+func (q *Queue) trackBatch(action *status.QueuedAction) {
+	if action.Rollout == "" {
+		return
+	}
+	if q.batches[action.Rollout] == nil {
+		q.batches[action.Rollout] = make(map[int][]string)
+	}
+	q.batches[action.Rollout][action.Batch] = append(q.batches[action.Rollout][action.Batch], action.ID)
+}
+
+// sort orders actions by priority, keeping insertion order for equal priorities
 func (q *Queue) sort() {
-	for i := 0; i < len(q.actions)-1; i++ {
-		for j := i + 1; j < len(q.actions); j++ {
-			if q.actions[i].Priority < q.actions[j].Priority {
-				q.actions[i], q.actions[j] = q.actions[j], q.actions[i]
+	sort.SliceStable(q.actions, func(i, j int) bool {
+		return q.actions[i].Priority > q.actions[j].Priority
+	})
+}
+
+// NextReady returns the highest priority action that is not running and whose
+// dependencies have all succeeded, and marks it as running. It returns nil when
+// nothing can be started right now.
+func (q *Queue) NextReady() *status.QueuedAction {
+	return q.NextReadyWhere(nil)
+}
+
+// NextReadyWhere is NextReady restricted to the actions accepted by filter
+// (all actions when filter is nil)
+func (q *Queue) NextReadyWhere(filter func(*status.QueuedAction) bool) *status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := make(map[string]bool, len(q.actions))
+	for _, action := range q.actions {
+		queued[action.ID] = true
+	}
+
+	now := time.Now()
+	for _, action := range q.actions {
+		if q.running[action.ID] || action.Interrupted || action.Paused || !q.dependenciesMet(action, queued) || !q.batchReady(action) {
+			continue
+		}
+		if action.NotBefore != nil && now.Before(*action.NotBefore) {
+			continue
+		}
+		if filter != nil && !filter(action) {
+			continue
+		}
+
+		action.StartedAt = &now
+		q.running[action.ID] = true
+		q.current = action
+		// Persisted as started, to be found interrupted if the process dies
+		q.save()
+		log.Printf("[QUEUE] Next ready action: %s for server %s (id: %s)", action.Action, action.ServerName, action.ID)
+		return action
+	}
+
+	return nil
+}
+
+// dependenciesMet reports whether every dependency of the action has finished
+// successfully. A dependency that is neither queued nor known (e.g. it ran in a
+// previous session) is considered satisfied.
+func (q *Queue) dependenciesMet(action *status.QueuedAction, queued map[string]bool) bool {
+	for _, dep := range action.DependsOn {
+		if queued[dep] {
+			return false
+		}
+		if success, known := q.results[dep]; known && !success {
+			return false
+		}
+	}
+	return true
+}
+
+// batchReady reports whether every earlier batch of the action's rollout is done
+func (q *Queue) batchReady(action *status.QueuedAction) bool {
+	if action.Rollout == "" {
+		return true
+	}
+	for _, other := range q.actions {
+		if other.Rollout == action.Rollout && other.Batch < action.Batch {
+			return false
+		}
+	}
+	return true
+}
+
+// BatchResult reports whether every action of a rollout batch finished, and how
+// many of them there were and failed
+func (q *Queue) BatchResult(rollout string, batch int) (done bool, total, failed int) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	ids := q.batches[rollout][batch]
+	done = true
+	for _, id := range ids {
+		success, finished := q.results[id]
+		if !finished {
+			done = false
+		} else if !success {
+			failed++
+		}
+	}
+	return done, len(ids), failed
+}
+
+// CancelRollout removes the actions of a rollout that did not start yet, along
+// with the actions depending on them, and returns them as skipped
+func (q *Queue) CancelRollout(rollout string) []*status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var skipped []*status.QueuedAction
+	for _, action := range append([]*status.QueuedAction(nil), q.actions...) {
+		if action.Rollout != rollout || q.running[action.ID] || !q.contains(action.ID) {
+			continue
+		}
+		log.Printf("[QUEUE] Cancelling %s for server %s: rollout %s stopped", action.Action, action.ServerName, rollout)
+		q.results[action.ID] = false
+		q.remove(action.ID)
+		skipped = append(skipped, action)
+		skipped = append(skipped, q.skipDependents(action.ID)...)
+	}
+
+	q.save()
+	return skipped
+}
+
+// Retry puts an action started with NextReady back in the queue, to be started
+// again after delay. Its dependents keep waiting for it.
+func (q *Queue) Retry(id string, delay time.Duration) *status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.running, id)
+	if q.current != nil && q.current.ID == id {
+		q.current = nil
+	}
+
+	for _, action := range q.actions {
+		if action.ID != id {
+			continue
+		}
+		notBefore := time.Now().Add(delay)
+		action.Attempt++
+		action.NotBefore = &notBefore
+		action.Scheduled = false // A backoff now, the retry is not left for later
+		action.StartedAt = nil
+		q.save()
+		log.Printf("[QUEUE] Action %s for server %s will be retried after %s (attempt %d)",
+			action.Action, action.ServerName, delay, action.Attempt+1)
+		return snapshot(action)
+	}
+
+	log.Printf("[QUEUE] Warning: action ID %s not found for retry", id)
+	return nil
+}
+
+// MarkInterrupted flags the actions marked as started which do not run in
+// this process: their process died while they ran. They stay in the queue,
+// without being started again, until ResumeInterrupted or SkipInterrupted.
+// It returns the actions newly flagged.
+func (q *Queue) MarkInterrupted() []*status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var marked []*status.QueuedAction
+	for _, action := range q.actions {
+		if action.StartedAt == nil || action.Interrupted || q.running[action.ID] {
+			continue
+		}
+		log.Printf("[QUEUE] %s for server %s was interrupted (started %s, id: %s)",
+			action.Action, action.ServerName, action.StartedAt.Format(time.RFC3339), action.ID)
+		action.Interrupted = true
+		marked = append(marked, snapshot(action))
+	}
+	if len(marked) > 0 {
+		q.save()
+	}
+	return marked
+}
+
+// Interrupted returns the interrupted actions waiting for a decision
+func (q *Queue) Interrupted() []*status.QueuedAction {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var interrupted []*status.QueuedAction
+	for _, action := range q.actions {
+		if action.Interrupted {
+			interrupted = append(interrupted, snapshot(action))
+		}
+	}
+	return interrupted
+}
+
+// ResumeInterrupted lets the interrupted actions run again and returns them
+func (q *Queue) ResumeInterrupted() []*status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var resumed []*status.QueuedAction
+	for _, action := range q.actions {
+		if !action.Interrupted {
+			continue
+		}
+		action.Interrupted = false
+		action.Resumed = true
+		action.StartedAt = nil
+		resumed = append(resumed, snapshot(action))
+	}
+	q.save()
+	return resumed
+}
+
+// SkipInterrupted removes the interrupted actions, and the actions depending on
+// them which are returned as skipped
+func (q *Queue) SkipInterrupted() (interrupted, skipped []*status.QueuedAction) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, action := range append([]*status.QueuedAction(nil), q.actions...) {
+		if !action.Interrupted || !q.contains(action.ID) {
+			continue
+		}
+		q.results[action.ID] = false
+		q.remove(action.ID)
+		interrupted = append(interrupted, action)
+		skipped = append(skipped, q.skipDependents(action.ID)...)
+	}
+	q.save()
+	return interrupted, skipped
+}
+
+// Finish removes an action started with NextReady. When it failed, every action
+// depending on it (directly or not) is removed as well and returned as skipped.
+func (q *Queue) Finish(id string, success bool) []*status.QueuedAction {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.running, id)
+	q.results[id] = success
+	if q.current != nil && q.current.ID == id {
+		q.current = nil
+	}
+	q.remove(id)
+
+	var skipped []*status.QueuedAction
+	if !success {
+		skipped = q.skipDependents(id)
+	}
+
+	q.save()
+	log.Printf("[QUEUE] Action %s finished (success: %v), queue size now: %d", id, success, len(q.actions))
+	return skipped
+}
+
+// skipDependents removes every action depending (directly or not) on the
+// failed action id and returns them. The caller must hold the lock.
+func (q *Queue) skipDependents(id string) []*status.QueuedAction {
+	var skipped []*status.QueuedAction
+	failed := []string{id}
+	for len(failed) > 0 {
+		dep := failed[0]
+		failed = failed[1:]
+
+		for _, action := range q.dependents(dep) {
+			log.Printf("[QUEUE] Skipping %s for server %s: dependency %s failed", action.Action, action.ServerName, dep)
+			q.results[action.ID] = false
+			q.remove(action.ID)
+			skipped = append(skipped, action)
+			failed = append(failed, action.ID)
+		}
+	}
+	return skipped
+}
+
+// dependents returns the queued actions that directly depend on id.
+// The caller must hold the lock.
+func (q *Queue) dependents(id string) []*status.QueuedAction {
+	var dependents []*status.QueuedAction
+	for _, action := range q.actions {
+		for _, dep := range action.DependsOn {
+			if dep == id {
+				dependents = append(dependents, action)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// Find returns a copy of the queued action whose ID is id, or starts with id
+// when that prefix is not ambiguous
+func (q *Queue) Find(id string) (*status.QueuedAction, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if id == "" {
+		return nil, fmt.Errorf("no action ID given")
+	}
+	var found *status.QueuedAction
+	for _, action := range q.actions {
+		if action.ID == id {
+			return snapshot(action), nil
+		}
+		if strings.HasPrefix(action.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("action ID %q is ambiguous", id)
+			}
+			found = action
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no queued action with ID %q", id)
+	}
+	return snapshot(found), nil
+}
+
+// Remove takes an action that is not running out of the queue, along with the
+// actions depending on it which are returned as skipped. It fails with
+// ErrActionRunning for an action started with NextReady and not finished.
+func (q *Queue) Remove(id string) ([]*status.QueuedAction, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.running[id] {
+		return nil, ErrActionRunning
+	}
+	if !q.contains(id) {
+		return nil, fmt.Errorf("no queued action with ID %q", id)
+	}
+
+	log.Printf("[QUEUE] Removing action %s", id)
+	q.results[id] = false
+	q.remove(id)
+	q.untrackBatch(id)
+	skipped := q.skipDependents(id)
+
+	q.save()
+	log.Printf("[QUEUE] Action removed, queue size now: %d", len(q.actions))
+	return skipped, nil
+}
+
+// Move puts an action that is not running at position (0 = first) of the
+// queue. Its priority is brought within the priorities of its new neighbours,
+// the queue staying ordered by priority.
+func (q *Queue) Move(id string, position int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	action, index := q.find(id)
+	if action == nil {
+		return fmt.Errorf("no queued action with ID %q", id)
+	}
+	if q.running[id] {
+		return ErrActionRunning
+	}
+
+	q.actions = append(q.actions[:index], q.actions[index+1:]...)
+	if position < 0 {
+		position = 0
+	}
+	if position > len(q.actions) {
+		position = len(q.actions)
+	}
+	q.actions = append(q.actions[:position], append([]*status.QueuedAction{action}, q.actions[position:]...)...)
+
+	if position > 0 && action.Priority > q.actions[position-1].Priority {
+		action.Priority = q.actions[position-1].Priority
+	}
+	if position < len(q.actions)-1 && action.Priority < q.actions[position+1].Priority {
+		action.Priority = q.actions[position+1].Priority
+	}
+
+	log.Printf("[QUEUE] Moved %s for server %s to position %d (priority: %d)", action.Action, action.ServerName, position, action.Priority)
+	return q.save()
+}
+
+// SetPriority changes the priority of an action that is not running, which
+// moves it after the actions of the same priority
+func (q *Queue) SetPriority(id string, priority int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	action, index := q.find(id)
+	if action == nil {
+		return fmt.Errorf("no queued action with ID %q", id)
+	}
+	if q.running[id] {
+		return ErrActionRunning
+	}
+
+	action.Priority = priority
+	q.actions = append(append(q.actions[:index:index], q.actions[index+1:]...), action)
+	q.sort()
+
+	log.Printf("[QUEUE] Priority of %s for server %s set to %d", action.Action, action.ServerName, priority)
+	return q.save()
+}
+
+// PauseAction holds an action that is not running in the queue until
+// ResumeAction. The actions depending on it, or on a later batch of its
+// rollout, wait with it.
+func (q *Queue) PauseAction(id string) error {
+	return q.setPaused(id, true)
+}
+
+// ResumeAction lets a paused action start again
+func (q *Queue) ResumeAction(id string) error {
+	return q.setPaused(id, false)
+}
+
+func (q *Queue) setPaused(id string, paused bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	action, _ := q.find(id)
+	if action == nil {
+		return fmt.Errorf("no queued action with ID %q", id)
+	}
+	if q.running[id] {
+		return ErrActionRunning
+	}
+
+	action.Paused = paused
+	log.Printf("[QUEUE] %s for server %s paused: %v", action.Action, action.ServerName, paused)
+	return q.save()
+}
+
+// Runnable returns how many queued actions may still start: the ones neither
+// paused nor interrupted, nor waiting for such an action
+func (q *Queue) Runnable() int {
+	return q.RunnableWhere(nil)
+}
+
+// RunnableWhere is Runnable also counting the actions held by held (e.g.
+// scheduled for later) and their dependents as blocked
+func (q *Queue) RunnableWhere(held func(*status.QueuedAction) bool) int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	blocked := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, action := range q.actions {
+			if blocked[action.ID] {
+				continue
+			}
+			if (held != nil && held(action)) || q.blockedBy(action, blocked) {
+				blocked[action.ID] = true
+				changed = true
+			}
+		}
+	}
+	return len(q.actions) - len(blocked)
+}
+
+// blockedBy reports whether an action cannot start before one of the blocked
+// actions. The caller must hold the lock.
+func (q *Queue) blockedBy(action *status.QueuedAction, blocked map[string]bool) bool {
+	if action.Paused || action.Interrupted {
+		return true
+	}
+	for _, dep := range action.DependsOn {
+		if blocked[dep] {
+			return true
+		}
+	}
+	if action.Rollout != "" {
+		for _, other := range q.actions {
+			if other.Rollout == action.Rollout && other.Batch < action.Batch && blocked[other.ID] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// find returns a queued action and its index. The caller must hold the lock.
+func (q *Queue) find(id string) (*status.QueuedAction, int) {
+	for i, action := range q.actions {
+		if action.ID == id {
+			return action, i
+		}
+	}
+	return nil, -1
+}
+
+// untrackBatch forgets a removed action in its rollout batch, which is then
+// done without it. The caller must hold the lock.
+func (q *Queue) untrackBatch(id string) {
+	for _, batches := range q.batches {
+		for batch, ids := range batches {
+			for i, batchID := range ids {
+				if batchID == id {
+					batches[batch] = append(ids[:i:i], ids[i+1:]...)
+					return
+				}
 			}
 		}
 	}
 }
 
+func (q *Queue) contains(id string) bool {
+	for _, action := range q.actions {
+		if action.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Queue) remove(id string) {
+	for i, action := range q.actions {
+		if action.ID == id {
+			q.actions = append(q.actions[:i], q.actions[i+1:]...)
+			return
+		}
+	}
+}
+
 func (q *Queue) Next() *status.QueuedAction {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -216,18 +760,40 @@ func (q *Queue) Resume() {
 func (q *Queue) GetCurrent() *status.QueuedAction {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
-	return q.current
+	if q.current == nil {
+		return nil
+	}
+	return snapshot(q.current)
 }
 
+// GetAll returns copies of the queued actions, which the queue keeps changing
 func (q *Queue) GetAll() []*status.QueuedAction {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
 	result := make([]*status.QueuedAction, len(q.actions))
-	copy(result, q.actions)
+	for i, action := range q.actions {
+		result[i] = snapshot(action)
+	}
 	return result
 }
 
+// snapshot copies an action for use outside the lock. The caller must hold
+// the lock.
+func snapshot(action *status.QueuedAction) *status.QueuedAction {
+	c := *action
+	c.DependsOn = append([]string(nil), action.DependsOn...)
+	if action.StartedAt != nil {
+		startedAt := *action.StartedAt
+		c.StartedAt = &startedAt
+	}
+	if action.NotBefore != nil {
+		notBefore := *action.NotBefore
+		c.NotBefore = &notBefore
+	}
+	return &c
+}
+
 func (q *Queue) Clear() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -0,0 +1,61 @@
+package ansible
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ssh"
+)
+
+// Failure classes worth retrying. Any other failure (a task failing on its
+// own) would fail the same way again.
+const (
+	FailureUnreachable = "unreachable"
+	FailureAptLock     = "apt lock"
+	FailureTimeout     = "timeout"
+)
+
+// Backoff between attempts: 10s, 20s, 40s... capped at 5 minutes
+const (
+	retryBaseDelay = 10 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+var failurePatterns = []struct {
+	class    string
+	patterns []string
+}{
+	{FailureAptLock, []string{"could not get lock", "dpkg/lock", "dpkg frontend lock", "failed to lock apt", "unable to lock directory /var/lib/apt"}},
+	{FailureUnreachable, []string{"unreachable", "connection refused", "no route to host", "connection reset by peer"}},
+	{FailureTimeout, []string{"timed out", "timeout", "deadline exceeded"}},
+}
+
+// ClassifyFailure returns the retryable class of a failure message, or "" when
+// retrying would not help
+func ClassifyFailure(message string) string {
+	// An unexpected host key looks unreachable but needs someone to check it
+	if ssh.IsHostKeyMismatch(message) {
+		return ""
+	}
+	message = strings.ToLower(message)
+	for _, failure := range failurePatterns {
+		for _, pattern := range failure.patterns {
+			if strings.Contains(message, pattern) {
+				return failure.class
+			}
+		}
+	}
+	return ""
+}
+
+// RetryDelay returns how long to wait before the given retry (1 = first retry)
+func RetryDelay(retry int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < retry && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
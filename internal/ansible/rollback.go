@@ -0,0 +1,33 @@
+package ansible
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// releaseIDPattern matches the release directory names of deploy-app
+// (<timestamp>_<commit>), without anything a shell or a path would interpret
+var releaseIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateReleaseID checks a release to roll back to. An empty release is
+// valid and means the one deployed before the current one.
+func ValidateReleaseID(release string) error {
+	if release == "" || releaseIDPattern.MatchString(release) {
+		return nil
+	}
+	return fmt.Errorf("invalid release %q: expected a release directory name such as 20240131T120000_abc1234", release)
+}
+
+// RollbackWithContext points the server back to release, or to the release
+// deployed before the current one when release is empty
+func (e *Executor) RollbackWithContext(ctx context.Context, serverName string, release string, progressChan chan<- string) (*ExecutionResult, error) {
+	if err := ValidateReleaseID(release); err != nil {
+		return nil, err
+	}
+	opts := PlaybookOptions{}
+	if release != "" {
+		opts.ExtraVars = map[string]string{"rollback_release": release}
+	}
+	return e.Run(ctx, "rollback.yml", serverName, opts, progressChan)
+}
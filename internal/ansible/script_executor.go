@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/status"
 )
 
 // ScriptExecutor runs deploy.sh and streams output
@@ -31,6 +33,7 @@ func NewScriptExecutor(environment string) *ScriptExecutor {
 
 // RunAction runs deploy.sh with specified action (provision, deploy, check)
 func (e *ScriptExecutor) RunAction(action string, serverName string, outputChan chan<- string) (*ExecutionResult, error) {
+	start := time.Now()
 	timestamp := time.Now().Format("20060102_150405")
 	logFile := filepath.Join(e.logDir, fmt.Sprintf("%s_%s_%s.log", serverName, action, timestamp))
 
@@ -62,9 +65,11 @@ func (e *ScriptExecutor) RunAction(action string, serverName string, outputChan
 	}
 
 	// Stream output in real-time
+	// deploy.sh runs ansible-playbook, collect the PLAY RECAP from its output
+	recap := make(map[string]status.HostStats)
 	done := make(chan bool, 2)
-	go e.streamLines(stdout, logWriter, outputChan, done)
-	go e.streamLines(stderr, logWriter, outputChan, done)
+	go e.streamLines(stdout, logWriter, outputChan, recap, done)
+	go e.streamLines(stderr, logWriter, outputChan, nil, done)
 
 	// Wait for streaming to complete
 	<-done
@@ -75,17 +80,24 @@ func (e *ScriptExecutor) RunAction(action string, serverName string, outputChan
 	result := &ExecutionResult{
 		Success: err == nil,
 		LogFile: logFile,
+		Recap:   recap,
+		Elapsed: time.Since(start),
 	}
 
 	if err != nil {
 		result.ErrorMessage = fmt.Sprintf("exit status %v", err)
+		if stats, ok := recap[serverName]; ok && stats.Unreachable > 0 {
+			result.ErrorMessage = fmt.Sprintf("Host %s unreachable", serverName)
+		}
 	}
+	result.writeSummaryFile()
 
 	return result, nil
 }
 
-// streamLines reads lines and sends them to both log file and output channel
-func (e *ScriptExecutor) streamLines(reader io.Reader, writer io.Writer, outputChan chan<- string, done chan<- bool) {
+// streamLines reads lines and sends them to both log file and output channel.
+// PLAY RECAP lines are collected into recap when it is not nil.
+func (e *ScriptExecutor) streamLines(reader io.Reader, writer io.Writer, outputChan chan<- string, recap map[string]status.HostStats, done chan<- bool) {
 	defer func() { done <- true }()
 
 	scanner := bufio.NewScanner(reader)
@@ -94,6 +106,12 @@ func (e *ScriptExecutor) streamLines(reader io.Reader, writer io.Writer, outputC
 		
 		// Write to log file
 		fmt.Fprintln(writer, line)
+
+		if recap != nil {
+			if host, stats, ok := ParseRecapLine(strings.TrimSpace(stripAnsiCodes(line))); ok {
+				recap[host] = stats
+			}
+		}
 		
 		// Send to UI if channel provided
 		if outputChan != nil {
@@ -0,0 +1,47 @@
+package ansible
+
+import "github.com/bastiblast/boiler-deploy/internal/status"
+
+// Deployment strategies, as set by deployment_strategy in config.yml
+const (
+	StrategyRolling   = "rolling"
+	StrategyAllAtOnce = "all_at_once"
+	StrategyBlueGreen = "blue_green"
+)
+
+// DefaultBlueGreenPortOffset is used when no port offset is configured
+const DefaultBlueGreenPortOffset = 1000
+
+// DeploymentStrategy describes how deploy actions are scheduled and run
+type DeploymentStrategy struct {
+	Name              string
+	BatchSize         int // rolling: servers per batch (0 = worker count)
+	MaxFailurePercent int // rolling: stop the rollout when more than this % of a batch fails
+	PortOffset        int // blue_green: the candidate release listens on app_port + offset
+}
+
+// IsValidStrategy reports whether name is a known deployment strategy
+func IsValidStrategy(name string) bool {
+	switch name {
+	case StrategyRolling, StrategyAllAtOnce, StrategyBlueGreen:
+		return true
+	}
+	return false
+}
+
+// usesWorkerSlot reports whether an action counts against the worker limit.
+// Rolling batches and all-at-once deploys set their own concurrency.
+func usesWorkerSlot(action *status.QueuedAction) bool {
+	if action.Action != status.ActionDeploy {
+		return true
+	}
+	return action.Strategy != StrategyRolling && action.Strategy != StrategyAllAtOnce
+}
+
+// batchFailed reports whether a finished batch exceeded the failure threshold
+func batchFailed(total, failed, maxFailurePercent int) bool {
+	if total == 0 || failed == 0 {
+		return false
+	}
+	return failed*100 > maxFailurePercent*total
+}
@@ -1,5 +1,11 @@
 package ansible
 
+import (
+	"strings"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
 // TagCategory représente une catégorie de tags
 type TagCategory struct {
 	Name        string
@@ -112,3 +118,68 @@ func GetAllTags(categories []TagCategory) []string {
 	}
 	return tags
 }
+
+// serverTypeTags sont les tags des rôles propres à chaque type de serveur
+var serverTypeTags = map[string][]string{
+	inventory.ServerTypeWeb:        {"nodejs", "nginx"},
+	inventory.ServerTypeDB:         {"postgresql"},
+	inventory.ServerTypeMonitoring: {"monitoring"},
+}
+
+// sharedRoleTags sont les tags des rôles appliqués à tous les serveurs par
+// provision.yml, quel que soit leur type
+var sharedRoleTags = map[string]bool{
+	"monitoring": true,
+}
+
+// ProvisionTagsFor adapte les tags de provision au type d'un serveur : les
+// tags des rôles des autres types sont retirés et, si des rôles de type ont
+// été choisis, ceux de son type sont ajoutés. Sans tags (ou avec "all"), tout
+// le playbook s'applique déjà.
+func ProvisionTagsFor(serverType, tags string) string {
+	if tags == "" {
+		return tags
+	}
+	selected := strings.Split(tags, ",")
+	for _, tag := range selected {
+		if strings.TrimSpace(tag) == "all" {
+			return tags
+		}
+	}
+
+	own := make(map[string]bool)
+	for _, tag := range serverTypeTags[serverType] {
+		own[tag] = true
+	}
+	typeRoles := make(map[string]bool)
+	for _, typeTags := range serverTypeTags {
+		for _, tag := range typeTags {
+			if !sharedRoleTags[tag] {
+				typeRoles[tag] = true
+			}
+		}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	wantsRoles := false
+	for _, tag := range selected {
+		tag = strings.TrimSpace(tag)
+		if typeRoles[tag] {
+			wantsRoles = true
+		}
+		if tag == "" || seen[tag] || (typeRoles[tag] && !own[tag]) {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if wantsRoles {
+		for _, tag := range serverTypeTags[serverType] {
+			if !seen[tag] {
+				result = append(result, tag)
+			}
+		}
+	}
+	return strings.Join(result, ",")
+}
@@ -0,0 +1,189 @@
+// Package atomicfile writes the state files of the environments so that a
+// crash never leaves them half written, and recovers them from their backup
+// when they are damaged anyway.
+package atomicfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BackupSuffix is appended to a file name for its previous version
+const BackupSuffix = ".bak"
+
+// CorruptSuffix is appended to the name of a damaged file moved aside
+const CorruptSuffix = ".corrupt"
+
+var (
+	warningsMu sync.Mutex
+	warnings   []string
+)
+
+// TakeWarnings returns the recoveries made since the last call, which the
+// user should be told about
+func TakeWarnings() []string {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	taken := warnings
+	warnings = nil
+	return taken
+}
+
+func warn(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("[ATOMICFILE] %s", message)
+	warningsMu.Lock()
+	warnings = append(warnings, message)
+	warningsMu.Unlock()
+}
+
+// WriteFile replaces path with data atomically: data is written to a
+// temporary file of the same directory, synced, then renamed over path.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return write(path, data, perm, false)
+}
+
+// WriteFileWithBackup is WriteFile keeping the replaced version of path as
+// path.bak, which ReadFile falls back to
+func WriteFileWithBackup(path string, data []byte, perm os.FileMode) error {
+	return write(path, data, perm, true)
+}
+
+func write(path string, data []byte, perm os.FileMode, backup bool) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+
+	if backup {
+		if err := backupFile(path, perm); err != nil {
+			log.Printf("[ATOMICFILE] Cannot back up %s: %v", path, err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// backupFile makes path.bak the current version of path. path itself stays
+// in place, a crash never leaves it missing.
+func backupFile(path string, perm os.FileMode) error {
+	backupPath := path + BackupSuffix
+	linkPath := backupPath + ".tmp"
+	os.Remove(linkPath)
+
+	// A hard link keeps the current content once path is replaced
+	if err := os.Link(path, linkPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			if os.IsNotExist(readErr) {
+				return nil
+			}
+			return readErr
+		}
+		return write(backupPath, data, perm, false)
+	}
+	return os.Rename(linkPath, backupPath)
+}
+
+// syncDir makes a rename in dir durable, where the system allows it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// ReadFile reads path and hands its content to decode. When path is empty or
+// decode rejects it, it is moved aside to path.corrupt and its backup is
+// decoded instead, with a warning (see TakeWarnings). The error satisfies
+// os.IsNotExist when path does not exist.
+func ReadFile(path string, decode func([]byte) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	damage := check(data, decode)
+	if damage == nil {
+		return nil
+	}
+
+	corruptPath := path + CorruptSuffix
+	if err := os.Rename(path, corruptPath); err != nil {
+		corruptPath = path
+	}
+
+	backupPath := path + BackupSuffix
+	backup, err := os.ReadFile(backupPath)
+	if err != nil {
+		warn("%s is damaged (%v) and has no backup; the damaged file is kept as %s",
+			path, damage, corruptPath)
+		return fmt.Errorf("%s is damaged: %w", path, damage)
+	}
+	if err := check(backup, decode); err != nil {
+		warn("%s and its backup are damaged (%v); the damaged file is kept as %s",
+			path, err, corruptPath)
+		return fmt.Errorf("%s is damaged: %w", path, damage)
+	}
+
+	warn("%s was damaged (%v), recovered its previous version from %s; the damaged file is kept as %s",
+		path, damage, backupPath, corruptPath)
+	// Put the recovered version back in place
+	if err := copyFile(backupPath, path); err != nil {
+		log.Printf("[ATOMICFILE] Cannot restore %s: %v", path, err)
+	}
+	return nil
+}
+
+// check returns why data is not a valid file for decode
+func check(data []byte, decode func([]byte) error) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return errors.New("empty file")
+	}
+	return decode(data)
+}
+
+func copyFile(from, to string) error {
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return WriteFile(to, data, info.Mode().Perm())
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
 	"gopkg.in/yaml.v3"
 )
 
@@ -24,18 +25,16 @@ func NewManager(inventoryPath string) *Manager {
 func (m *Manager) Load(envName string) (*ConfigOptions, error) {
 	configPath := filepath.Join(m.inventoryPath, envName, "config.yml")
 	
+	var config ConfigOptions
+	err := atomicfile.ReadFile(configPath, func(data []byte) error {
+		config = ConfigOptions{}
+		return yaml.Unmarshal(data, &config)
+	})
 	// If config doesn't exist, return defaults
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	if os.IsNotExist(err) {
 		return DefaultConfig(), nil
 	}
-	
-	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config: %w", err)
-	}
-	
-	var config ConfigOptions
-	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 	
@@ -51,7 +50,7 @@ func (m *Manager) Save(envName string, config *ConfigOptions) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 	
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := atomicfile.WriteFileWithBackup(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 	
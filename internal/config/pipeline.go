@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
+	"gopkg.in/yaml.v3"
+)
+
+// PipelineFile is the promotion pipeline of the project, in the inventory
+// directory next to the environments
+const PipelineFile = "pipeline.yml"
+
+// ErrNoPipeline is returned by LoadPipeline when the project has none
+var ErrNoPipeline = errors.New("no promotion pipeline")
+
+// Pipeline orders the environments a commit is promoted through, e.g.
+// dev → staging → prod
+type Pipeline struct {
+	Stages []Stage `yaml:"stages"`
+}
+
+// Stage is an environment of the pipeline. Its gates apply to the promotions
+// to it, from the stage before it.
+type Stage struct {
+	Environment string `yaml:"environment"`
+	HealthCheck *bool  `yaml:"health_check,omitempty"` // Gate: the web servers of the source pass their health check (default true)
+	Approval    bool   `yaml:"approval,omitempty"`     // Gate: an operator approves the promotion
+}
+
+// HealthGate reports whether the source must pass its health check
+func (s Stage) HealthGate() bool {
+	return s.HealthCheck == nil || *s.HealthCheck
+}
+
+// LoadPipeline loads the promotion pipeline of the project
+func (m *Manager) LoadPipeline() (*Pipeline, error) {
+	path := filepath.Join(m.inventoryPath, PipelineFile)
+
+	var pipeline Pipeline
+	err := atomicfile.ReadFile(path, func(data []byte) error {
+		pipeline = Pipeline{}
+		return yaml.Unmarshal(data, &pipeline)
+	})
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: create %s", ErrNoPipeline, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+	if err := pipeline.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &pipeline, nil
+}
+
+// Validate checks that the pipeline has at least two stages, each a distinct
+// environment
+func (p *Pipeline) Validate() error {
+	if len(p.Stages) < 2 {
+		return fmt.Errorf("a pipeline needs at least two stages")
+	}
+	seen := make(map[string]bool, len(p.Stages))
+	for i, stage := range p.Stages {
+		if stage.Environment == "" {
+			return fmt.Errorf("stage %d has no environment", i+1)
+		}
+		if seen[stage.Environment] {
+			return fmt.Errorf("environment %s appears twice", stage.Environment)
+		}
+		seen[stage.Environment] = true
+	}
+	return nil
+}
+
+// Promotion returns the stage promoted to when promoting to target, and the
+// environment it is promoted from: the previous stage unless from is given,
+// which must then come before target
+func (p *Pipeline) Promotion(from, target string) (Stage, string, error) {
+	for i, stage := range p.Stages {
+		if stage.Environment != target {
+			continue
+		}
+		if i == 0 {
+			return Stage{}, "", fmt.Errorf("%s is the first stage of the pipeline, nothing is promoted to it", target)
+		}
+		if from == "" {
+			return stage, p.Stages[i-1].Environment, nil
+		}
+		for _, earlier := range p.Stages[:i] {
+			if earlier.Environment == from {
+				return stage, from, nil
+			}
+		}
+		return Stage{}, "", fmt.Errorf("%s does not come before %s in the pipeline", from, target)
+	}
+	return Stage{}, "", fmt.Errorf("%s is not a stage of the pipeline", target)
+}
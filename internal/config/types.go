@@ -11,6 +11,9 @@ type ConfigOptions struct {
 	// Deployment options
 	DeploymentStrategy   string        `yaml:"deployment_strategy"`   // "rolling", "all_at_once", "blue_green"
 	DeploymentTags       []string      `yaml:"deployment_tags"`
+	RollingBatchSize     int           `yaml:"rolling_batch_size"`          // Servers per rolling batch (0 = max_parallel_workers)
+	RollingMaxFailurePercent int       `yaml:"rolling_max_failure_percent"` // Stop the rollout when more than this % of a batch fails
+	BlueGreenPortOffset  int           `yaml:"blue_green_port_offset"`      // Candidate release listens on app_port + offset
 	
 	// Parallel execution options
 	MaxParallelWorkers   int           `yaml:"max_parallel_workers"`  // Number of parallel workers (0=sequential)
@@ -27,6 +30,9 @@ type ConfigOptions struct {
 	// Retry options
 	AutoRetryEnabled     bool          `yaml:"auto_retry_enabled"`
 	MaxRetries           int           `yaml:"max_retries"`
+	
+	// Rollback options
+	AutoRollbackEnabled  bool          `yaml:"auto_rollback_enabled"` // Roll back deploys failing their health check
 }
 
 // DefaultConfig returns default configuration
@@ -36,6 +42,9 @@ func DefaultConfig() *ConfigOptions {
 		ProvisioningStrategy: "sequential",
 		DeploymentStrategy:   "rolling",
 		DeploymentTags:       []string{"all"},
+		RollingBatchSize:     1,
+		RollingMaxFailurePercent: 0, // Any failure stops the rollout
+		BlueGreenPortOffset:  1000,
 		MaxParallelWorkers:   0,  // Sequential by default (0 or 3-5 for parallel)
 		HealthCheckEnabled:   true,
 		HealthCheckTimeout:   30 * time.Second,
@@ -44,6 +53,7 @@ func DefaultConfig() *ConfigOptions {
 		LogRetention:         100,
 		AutoRetryEnabled:     false,
 		MaxRetries:           3,
+		AutoRollbackEnabled:  false,
 	}
 }
 
@@ -0,0 +1,95 @@
+// Package gitref resolves git branches and tags of a remote repository to
+// commits, the way the deploy-app role does before cloning a release.
+package gitref
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a git ls-remote run when the context has no deadline
+const DefaultTimeout = 30 * time.Second
+
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// abbrevPattern matches what may be an abbreviated SHA, which git ls-remote
+// cannot resolve
+var abbrevPattern = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+
+// IsSHA reports whether ref is a full commit SHA
+func IsSHA(ref string) bool {
+	return shaPattern.MatchString(strings.ToLower(ref))
+}
+
+// Resolve returns the commit ref points to in repo, by running git ls-remote
+// locally. ref is a branch or a tag (HEAD when empty); a full SHA is returned
+// as is. An abbreviated SHA cannot be resolved without cloning the repository,
+// it fails asking for the full one unless a branch or a tag has its name.
+func Resolve(ctx context.Context, repo, ref string) (string, error) {
+	if repo == "" {
+		return "", fmt.Errorf("no repository configured")
+	}
+	if IsSHA(ref) {
+		return strings.ToLower(ref), nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	// The peeled commit of an annotated tag is only listed when asked for
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--", repo, ref, ref+"^{}")
+	// Never wait for credentials on a terminal
+	cmd.Env = append(cmd.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git ls-remote %s %s: %s", repo, ref, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git ls-remote %s %s: %w", repo, ref, err)
+	}
+
+	commit, ok := pickRef(string(output), ref)
+	if !ok && abbrevPattern.MatchString(strings.ToLower(ref)) {
+		return "", fmt.Errorf("%s looks like an abbreviated commit, use the full 40-character SHA", ref)
+	}
+	if !ok {
+		return "", fmt.Errorf("%s not found in %s", ref, repo)
+	}
+	return commit, nil
+}
+
+// pickRef returns the commit of ref in the output of git ls-remote. A branch
+// wins over a tag of the same name, and an annotated tag is peeled to its
+// commit.
+func pickRef(output, ref string) (string, bool) {
+	refs := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		sha, name, found := strings.Cut(strings.TrimSpace(scanner.Text()), "\t")
+		if found {
+			refs[name] = sha
+		}
+	}
+
+	candidates := []string{ref, "refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref}
+	if strings.HasPrefix(ref, "refs/") {
+		candidates = []string{ref + "^{}", ref}
+	}
+	for _, name := range candidates {
+		if sha, ok := refs[name]; ok {
+			return sha, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,200 @@
+// Package health checks that a deployed application answers as expected,
+// using only Go's HTTP client (no curl/nc on either side).
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
+// Defaults for the fields left empty in a spec
+const (
+	DefaultTimeout  = 10 * time.Second
+	DefaultInterval = 5 * time.Second
+)
+
+// maxBodySize limits how much of the response is read for body/JSON matching
+const maxBodySize = 1 << 20
+
+// DialFunc opens the connections of the checker, e.g. through an SSH tunnel
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Checker runs a health check spec against an application
+type Checker struct {
+	spec      inventory.HealthCheckSpec
+	client    *http.Client
+	bodyRegex *regexp.Regexp
+}
+
+// NewChecker prepares a checker. dial may be nil to connect directly.
+func NewChecker(spec inventory.HealthCheckSpec, dial DialFunc) (*Checker, error) {
+	if spec.Path == "" {
+		spec.Path = "/"
+	}
+	if !strings.HasPrefix(spec.Path, "/") {
+		spec.Path = "/" + spec.Path
+	}
+	if spec.Method == "" {
+		spec.Method = http.MethodGet
+	}
+	if spec.Scheme == "" {
+		spec.Scheme = "http"
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = DefaultTimeout
+	}
+	if spec.Interval <= 0 {
+		spec.Interval = DefaultInterval
+	}
+
+	c := &Checker{spec: spec}
+	if spec.BodyRegex != "" {
+		re, err := regexp.Compile(spec.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_regex: %w", err)
+		}
+		c.bodyRegex = re
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: spec.Timeout,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: spec.TLSVerify != nil && !*spec.TLSVerify,
+		},
+	}
+	if dial != nil {
+		transport.DialContext = dial
+	}
+	c.client = &http.Client{
+		Timeout:   spec.Timeout,
+		Transport: transport,
+		// Redirects are reported as is so that 3xx can be expected
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return c, nil
+}
+
+// URL returns the URL checked on host:port
+func (c *Checker) URL(host string, port int) string {
+	return fmt.Sprintf("%s://%s%s", c.spec.Scheme, net.JoinHostPort(host, strconv.Itoa(port)), c.spec.Path)
+}
+
+// Check sends one request to url and verifies the response
+func (c *Checker) Check(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, c.spec.Method, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	for k, v := range c.spec.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", c.spec.Method, url, err)
+	}
+	defer resp.Body.Close()
+
+	if !c.statusAccepted(resp.StatusCode) {
+		return fmt.Errorf("unexpected HTTP status %d from %s", resp.StatusCode, url)
+	}
+
+	if c.bodyRegex == nil && c.spec.JSONField == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("cannot read response body: %w", err)
+	}
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		return fmt.Errorf("response body does not match %q", c.spec.BodyRegex)
+	}
+	if c.spec.JSONField != "" {
+		return c.checkJSON(body)
+	}
+	return nil
+}
+
+// Wait checks url until it passes or the retries are exhausted. It returns the
+// number of attempts made.
+func (c *Checker) Wait(ctx context.Context, url string) (int, error) {
+	attempts := c.spec.Retries + 1
+	var lastErr error
+	for i := 1; i <= attempts; i++ {
+		if i > 1 {
+			log.Printf("[HEALTH] Retry %d/%d for %s in %s", i, attempts, url, c.spec.Interval)
+			select {
+			case <-ctx.Done():
+				return i - 1, ctx.Err()
+			case <-time.After(c.spec.Interval):
+			}
+		}
+
+		lastErr = c.Check(ctx, url)
+		if lastErr == nil {
+			log.Printf("[HEALTH] ✓ %s healthy on attempt %d", url, i)
+			return i, nil
+		}
+		log.Printf("[HEALTH] Attempt %d/%d failed: %v", i, attempts, lastErr)
+	}
+	return attempts, fmt.Errorf("health check failed after %d attempts: %w", attempts, lastErr)
+}
+
+func (c *Checker) statusAccepted(code int) bool {
+	if len(c.spec.ExpectStatus) == 0 {
+		return code >= 200 && code < 400
+	}
+	for _, expected := range c.spec.ExpectStatus {
+		if code == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// checkJSON verifies the field at the dotted path of the spec
+func (c *Checker) checkJSON(body []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("response body is not JSON: %w", err)
+	}
+
+	for _, key := range strings.Split(c.spec.JSONField, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("JSON field %s not found", c.spec.JSONField)
+		}
+		if value, ok = object[key]; !ok {
+			return fmt.Errorf("JSON field %s not found", c.spec.JSONField)
+		}
+	}
+
+	if c.spec.JSONValue == "" {
+		return nil
+	}
+	if got := fmt.Sprint(value); got != c.spec.JSONValue {
+		return fmt.Errorf("JSON field %s is %q, expected %q", c.spec.JSONField, got, c.spec.JSONValue)
+	}
+	return nil
+}
@@ -24,8 +24,12 @@ func (g *Generator) GenerateHostsYAML(env Environment) ([]byte, error) {
 			"ansible_user":                server.SSHUser,
 			"ansible_port":                server.Port,
 			"ansible_python_interpreter":  "/usr/bin/python3",
-			"ansible_ssh_private_key_file": server.SSHKeyPath,
 			"ansible_become":              server.AnsibleBecome,
+			"ansible_ssh_common_args":     SSHCommonArgs(env.Name, server, env.JumpHostFor(server)),
+		}
+		// Without a key file, ssh uses the keys of the agent
+		if server.SSHKeyPath != "" {
+			serverConfig["ansible_ssh_private_key_file"] = server.SSHKeyPath
 		}
 		
 		if server.AppPort > 0 {
@@ -90,6 +94,49 @@ func (g *Generator) GenerateHostVarsYAML(server Server) ([]byte, error) {
 	return yaml.Marshal(hostVars)
 }
 
+// DBPasswordFile holds the password of the application database user, relative
+// to the directory of the environment. It is kept out of git, the group_vars
+// only look it up.
+const DBPasswordFile = ".secrets/db_password"
+
+// GenerateDBServersVarsYAML generates group_vars/dbservers.yml, the settings of
+// the postgresql role: one database for the application and its user, whose
+// password is read from DBPasswordFile
+func (g *Generator) GenerateDBServersVarsYAML(env Environment) ([]byte, error) {
+	dbVars := map[string]interface{}{
+		"postgresql_version":          "15",
+		"postgresql_listen_addresses": "*",
+		"postgresql_port":             5432,
+		
+		"postgresql_databases": []map[string]string{{
+			"name":       "{{ app_name }}",
+			"encoding":   "UTF-8",
+			"lc_collate": "en_US.UTF-8",
+			"lc_ctype":   "en_US.UTF-8",
+		}},
+		"postgresql_users": []map[string]string{{
+			"name":     "{{ app_name }}_user",
+			"password": "{{ lookup('file', inventory_dir + '/" + DBPasswordFile + "') }}",
+			"db":       "{{ app_name }}",
+			"priv":     "ALL",
+		}},
+		
+		// Performance tuning
+		"postgresql_shared_buffers":         "256MB",
+		"postgresql_effective_cache_size":   "1GB",
+		"postgresql_maintenance_work_mem":   "64MB",
+		"postgresql_work_mem":               "4MB",
+		"postgresql_max_connections":        100,
+		
+		// Backups
+		"postgresql_backup_enabled": true,
+		"postgresql_backup_hour":    2,
+		"postgresql_backup_minute":  0,
+	}
+	
+	return yaml.Marshal(dbVars)
+}
+
 // GenerateGroupVarsYAML generates group_vars/all.yml with common settings
 func (g *Generator) GenerateGroupVarsYAML(env Environment) ([]byte, error) {
 	// Get app name from first web server or use environment name
@@ -134,7 +181,7 @@ func (g *Generator) GenerateGroupVarsYAML(env Environment) ([]byte, error) {
 		"app_port":         appPort,
 		"app_repo":         appRepo,
 		"app_branch":       appBranch,
-		"app_dir":          fmt.Sprintf("/var/www/%s", appName),
+		"app_dir":          env.AppDir(),
 		"app_releases_dir": "{{ app_dir }}/releases",
 		"app_current_dir":  "{{ app_dir }}/current",
 		"app_shared_dir":   "{{ app_dir }}/shared",
@@ -203,6 +250,9 @@ func (g *Generator) GenerateEnvironmentSummary(env Environment) string {
 	for _, server := range env.Servers {
 		summary += fmt.Sprintf("  • %s (%s) - %s:%d\n", 
 			server.Name, server.Type, server.IP, server.AppPort)
+		if jump := env.JumpHostFor(server); jump != nil {
+			summary += fmt.Sprintf("    via %s\n", jump)
+		}
 	}
 	
 	return summary
@@ -0,0 +1,74 @@
+package inventory
+
+import "time"
+
+// HealthCheckSpec describes how to tell that a deployed application is healthy.
+// It can be set for the whole environment (config.health_check) and refined
+// per server (servers[].health_check); unset fields keep the inherited value.
+type HealthCheckSpec struct {
+	Path         string            `yaml:"path,omitempty"`          // Request path (default: /)
+	Method       string            `yaml:"method,omitempty"`        // HTTP method (default: GET)
+	Scheme       string            `yaml:"scheme,omitempty"`        // http or https (default: http)
+	Port         int               `yaml:"port,omitempty"`          // Only check this port instead of 80 then app_port
+	ExpectStatus []int             `yaml:"expect_status,omitempty"` // Accepted status codes (default: any 2xx/3xx)
+	BodyRegex    string            `yaml:"body_regex,omitempty"`    // The response body must match
+	JSONField    string            `yaml:"json_field,omitempty"`    // Dotted path of a field of the JSON body, e.g. checks.db
+	JSONValue    string            `yaml:"json_value,omitempty"`    // Expected value of JSONField (default: any value)
+	Headers      map[string]string `yaml:"headers,omitempty"`       // Extra request headers
+	TLSVerify    *bool             `yaml:"tls_verify,omitempty"`    // Verify the certificate (default: true)
+	Timeout      time.Duration     `yaml:"timeout,omitempty"`       // Per request
+	Retries      int               `yaml:"retries,omitempty"`       // Attempts after the first one
+	Interval     time.Duration     `yaml:"interval,omitempty"`      // Delay between attempts
+}
+
+// Merge returns the spec with the fields set in override replacing its own
+func (s HealthCheckSpec) Merge(override *HealthCheckSpec) HealthCheckSpec {
+	if override == nil {
+		return s
+	}
+	if override.Path != "" {
+		s.Path = override.Path
+	}
+	if override.Method != "" {
+		s.Method = override.Method
+	}
+	if override.Scheme != "" {
+		s.Scheme = override.Scheme
+	}
+	if override.Port > 0 {
+		s.Port = override.Port
+	}
+	if len(override.ExpectStatus) > 0 {
+		s.ExpectStatus = override.ExpectStatus
+	}
+	if override.BodyRegex != "" {
+		s.BodyRegex = override.BodyRegex
+	}
+	if override.JSONField != "" {
+		s.JSONField = override.JSONField
+		s.JSONValue = override.JSONValue
+	}
+	if len(override.Headers) > 0 {
+		headers := make(map[string]string, len(s.Headers)+len(override.Headers))
+		for k, v := range s.Headers {
+			headers[k] = v
+		}
+		for k, v := range override.Headers {
+			headers[k] = v
+		}
+		s.Headers = headers
+	}
+	if override.TLSVerify != nil {
+		s.TLSVerify = override.TLSVerify
+	}
+	if override.Timeout > 0 {
+		s.Timeout = override.Timeout
+	}
+	if override.Retries > 0 {
+		s.Retries = override.Retries
+	}
+	if override.Interval > 0 {
+		s.Interval = override.Interval
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package inventory
+
+import (
+	"net"
+	"strconv"
+)
+
+// JumpHost is a bastion the servers are only reachable through. It can be set
+// for the whole environment (config.jump_host) and per server
+// (servers[].jump_host); the server one replaces the environment one, and an
+// empty host connects the server directly.
+type JumpHost struct {
+	Host       string `yaml:"host"`
+	Port       int    `yaml:"port,omitempty"`         // Default: 22
+	User       string `yaml:"user,omitempty"`         // Default: the SSH user of the server
+	SSHKeyPath string `yaml:"ssh_key_path,omitempty"` // Default: the keys of the SSH agent
+}
+
+// Address returns host:port of the jump host
+func (j JumpHost) Address() string {
+	port := j.Port
+	if port <= 0 {
+		port = 22
+	}
+	return net.JoinHostPort(j.Host, strconv.Itoa(port))
+}
+
+// String returns the jump host as user@host:port
+func (j JumpHost) String() string {
+	if j.User == "" {
+		return j.Address()
+	}
+	return j.User + "@" + j.Address()
+}
+
+// ResolveJumpHost returns the jump host to reach server through, given the
+// jump host of its environment, or nil for a direct connection
+func ResolveJumpHost(server Server, environment *JumpHost) *JumpHost {
+	jump := environment
+	if server.JumpHost != nil {
+		jump = server.JumpHost
+	}
+	if jump == nil || jump.Host == "" {
+		return nil
+	}
+
+	resolved := *jump
+	if resolved.Port <= 0 {
+		resolved.Port = 22
+	}
+	if resolved.User == "" {
+		resolved.User = server.SSHUser
+	}
+	return &resolved
+}
+
+// JumpHostFor returns the jump host to reach server through, or nil
+func (e Environment) JumpHostFor(server Server) *JumpHost {
+	return ResolveJumpHost(server, e.Config.JumpHost)
+}
@@ -0,0 +1,38 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// KnownHostsFile returns the known_hosts file shared by the Go SSH client and
+// ansible for an environment
+func KnownHostsFile(environment string) string {
+	return filepath.Join("inventory", environment, ".ssh", "known_hosts")
+}
+
+// SSHCommonArgs returns the ssh options of ansible for a server. Host keys are
+// verified against the known_hosts file of the environment, unknown hosts being
+// trusted on first use like the Go SSH client does. A server with a key file
+// only offers that key, otherwise the agent keys are offered.
+//
+// A server behind a jump host is reached with ProxyJump. ProxyJump cannot give
+// the jump host its own key, so a jump host with a key file is reached with the
+// equivalent ProxyCommand instead.
+func SSHCommonArgs(environment string, server Server, jump *JumpHost) string {
+	knownHosts := fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=accept-new", KnownHostsFile(environment))
+	args := knownHosts
+	if server.SSHKeyPath != "" {
+		args += " -o IdentitiesOnly=yes"
+	}
+
+	switch {
+	case jump == nil:
+	case jump.SSHKeyPath == "":
+		args += fmt.Sprintf(" -o ProxyJump=%s", jump)
+	default:
+		args += fmt.Sprintf(` -o ProxyCommand="ssh -W %%h:%%p -p %d -i %s -o IdentitiesOnly=yes %s %s@%s"`,
+			jump.Port, jump.SSHKeyPath, knownHosts, jump.User, jump.Host)
+	}
+	return args
+}
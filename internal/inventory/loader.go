@@ -8,6 +8,13 @@ import (
 "gopkg.in/yaml.v3"
 )
 
+// groupTypes maps the inventory groups written by the generator to server types
+var groupTypes = map[string]string{
+"webservers": "web",
+"dbservers":  "db",
+"monitoring": "monitoring",
+}
+
 // LoadServersForEnv loads servers from inventory for a specific environment
 func LoadServersForEnv(environment string) ([]*Server, error) {
 inventoryPath := filepath.Join("inventory", environment, "hosts.yml")
@@ -42,11 +49,12 @@ var servers []*Server
 if all, ok := raw["all"].(map[string]interface{}); ok {
 if children, ok := all["children"].(map[string]interface{}); ok {
 // Iterate all groups (webservers, dbservers, etc.)
-for _, group := range children {
+for groupName, group := range children {
 if groupMap, ok := group.(map[string]interface{}); ok {
 if hosts, ok := groupMap["hosts"].(map[string]interface{}); ok {
 for name, hostData := range hosts {
 if server := parseHost(name, hostData); server != nil {
+server.Type = groupTypes[groupName]
 servers = append(servers, server)
 }
 }
@@ -1,5 +1,7 @@
 package inventory
 
+import "strings"
+
 // Environment represents a deployment environment
 type Environment struct {
 	Name           string   `yaml:"name"`
@@ -28,6 +30,21 @@ type Config struct {
 	AppPort       string `yaml:"app_port"`
 	DeployUser    string `yaml:"deploy_user"`
 	Timezone      string `yaml:"timezone"`
+	HealthCheck   *HealthCheckSpec `yaml:"health_check,omitempty"`
+	JumpHost      *JumpHost        `yaml:"jump_host,omitempty"` // Bastion the servers are reached through
+	Probes        map[string][]ProbeSpec `yaml:"probes,omitempty"` // State detection probes per server type
+	MaintenanceWindow string `yaml:"maintenance_window,omitempty"` // Weekly window deploys are allowed in, e.g. "Sun 01:00-04:00"
+	Production    bool   `yaml:"production,omitempty"` // Refuse manual deploys outside the maintenance window
+}
+
+// IsProduction reports whether manual deploys must wait for the maintenance
+// window: the environment is flagged production, or named so
+func (e Environment) IsProduction() bool {
+	switch strings.ToLower(e.Name) {
+	case "production", "prod", "prd":
+		return true
+	}
+	return e.Config.Production
 }
 
 // Server represents a single server
@@ -46,6 +63,8 @@ type Server struct {
 	GitRepo       string `yaml:"git_repo,omitempty"`
 	GitBranch     string `yaml:"git_branch,omitempty"`
 	NodeVersion   string `yaml:"node_version,omitempty"`
+	HealthCheck   *HealthCheckSpec `yaml:"health_check,omitempty"` // Overrides the environment health check
+	JumpHost      *JumpHost        `yaml:"jump_host,omitempty"`    // Overrides the environment jump host
 	
 	// SSH test status (not saved to YAML)
 	SSHTested     bool   `yaml:"-"`
@@ -0,0 +1,178 @@
+package inventory
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// States a probe gates
+const (
+	ProbeGatesProvisioned = "provisioned" // Every such probe must pass for the server to be provisioned
+	ProbeGatesDeployed    = "deployed"    // Every such probe must pass for the server to be deployed
+	ProbeGatesNone        = "none"        // Only reported
+)
+
+// Built-in checks a probe can run instead of a shell command
+const (
+	ProbeCheckHTTP = "http" // An HTTP request to localhost:port on the server answers 2xx/3xx
+	ProbeCheckTCP  = "tcp"  // localhost:port accepts connections on the server
+)
+
+// DefaultAppPort is the app_port of servers which do not set one, as in the
+// generated group_vars
+const DefaultAppPort = 3000
+
+// ProbeSpec is one check of the state detection. The default probes of each
+// server type can be refined per environment (config.probes.<type>): a probe
+// named like a default one replaces it, disabled removes it, and the others
+// are added.
+type ProbeSpec struct {
+	Name     string `yaml:"name"`
+	Command  string `yaml:"command,omitempty"`  // Shell command run on the server, {{ var }} are replaced
+	Check    string `yaml:"check,omitempty"`    // Built-in check instead of a command: http or tcp
+	Port     int    `yaml:"port,omitempty"`     // Port of the built-in check (default: app_port)
+	Path     string `yaml:"path,omitempty"`     // Path of the http check (default: /)
+	Expect   string `yaml:"expect,omitempty"`   // Regex the command output must match (default: exit status 0)
+	Gates    string `yaml:"gates,omitempty"`    // provisioned, deployed or none (default: provisioned)
+	Disabled bool   `yaml:"disabled,omitempty"` // Removes the default probe of the same name
+}
+
+// nvmCommand runs command with the node of the NVM installed by provisioning
+const nvmCommand = `if [ -d "/home/{{ user }}/.nvm" ]; then
+	export NVM_DIR="/home/{{ user }}/.nvm"
+elif [ -d "$HOME/.nvm" ]; then
+	export NVM_DIR="$HOME/.nvm"
+else
+	exit 1
+fi
+[ -s "$NVM_DIR/nvm.sh" ] && . "$NVM_DIR/nvm.sh" && `
+
+// NVMCommand returns a shell command running command with the node of the NVM
+// installed by provisioning, {{ user }} still to be replaced
+func NVMCommand(command string) string {
+	return nvmCommand + command
+}
+
+// PostgresQueryCommand runs SELECT 1 as the postgres user of a database server,
+// printing 1 when PostgreSQL answers
+const PostgresQueryCommand = `if [ "$(id -u)" = 0 ]; then
+	su postgres -c "psql -tAc 'SELECT 1'"
+else
+	sudo -n -u postgres psql -tAc 'SELECT 1'
+fi`
+
+// defaultProbes are the probes of each server type, aligned with the roles
+// applied by playbooks/provision.yml
+var defaultProbes = map[string][]ProbeSpec{
+	ServerTypeWeb: {
+		{Name: "nvm", Command: `test -d /home/{{ user }}/.nvm || test -d "$HOME/.nvm"`},
+		{Name: "node", Command: nvmCommand + "node --version"},
+		{Name: "nginx", Command: "command -v nginx"},
+		{Name: "app_dir", Command: "test -d {{ app_dir }}"},
+		{Name: "pm2", Command: nvmCommand + "pm2 list | grep -q online", Gates: ProbeGatesDeployed},
+		{Name: "app", Check: ProbeCheckHTTP, Gates: ProbeGatesDeployed},
+		{Name: "current", Command: "test -L {{ app_current_dir }}", Gates: ProbeGatesNone},
+	},
+	ServerTypeDB: {
+		{Name: "postgres", Command: "command -v psql && test -d /etc/postgresql"},
+		{Name: "postgres_running", Command: PostgresQueryCommand, Expect: `(?m)^1$`},
+	},
+	ServerTypeMonitoring: {
+		{Name: "node_exporter", Command: "systemctl is-active node_exporter"},
+		{Name: "node_exporter_port", Check: ProbeCheckTCP, Port: NodeExporterPort},
+		{Name: "prometheus", Command: "systemctl is-active prometheus"},
+		{Name: "prometheus_port", Check: ProbeCheckTCP, Port: 9090},
+	},
+}
+
+// DefaultProbes returns the default probes of a server type (web when empty)
+func DefaultProbes(serverType string) []ProbeSpec {
+	if serverType == "" {
+		serverType = ServerTypeWeb
+	}
+	return append([]ProbeSpec(nil), defaultProbes[serverType]...)
+}
+
+// ResolveProbes returns the probes of a server type, the default ones refined
+// by the configured ones of the environment
+func ResolveProbes(serverType string, configured map[string][]ProbeSpec) []ProbeSpec {
+	if serverType == "" {
+		serverType = ServerTypeWeb
+	}
+	probes := DefaultProbes(serverType)
+
+	for _, probe := range configured[serverType] {
+		replaced := false
+		for i := range probes {
+			if probes[i].Name == probe.Name {
+				probes[i] = probe
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			probes = append(probes, probe)
+		}
+	}
+
+	resolved := probes[:0]
+	for _, probe := range probes {
+		if probe.Disabled {
+			continue
+		}
+		if probe.Gates == "" {
+			probe.Gates = ProbeGatesProvisioned
+		}
+		resolved = append(resolved, probe)
+	}
+	return resolved
+}
+
+// ProbeVars returns the values replacing {{ var }} in the probe commands of a
+// server of env
+func ProbeVars(env Environment, server Server) map[string]string {
+	appPort := server.AppPort
+	if appPort <= 0 {
+		appPort = DefaultAppPort
+	}
+	return map[string]string{
+		"user":            server.SSHUser,
+		"deploy_user":     env.Config.DeployUser,
+		"server":          server.Name,
+		"app_name":        env.Name,
+		"app_port":        strconv.Itoa(appPort),
+		"app_dir":         env.AppDir(),
+		"app_current_dir": env.CurrentDir(),
+	}
+}
+
+var probeVarPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// ExpandProbeCommand replaces the {{ var }} of command with vars, leaving the
+// unknown ones as is
+func ExpandProbeCommand(command string, vars map[string]string) string {
+	return probeVarPattern.ReplaceAllStringFunc(command, func(match string) string {
+		if value, ok := vars[probeVarPattern.FindStringSubmatch(match)[1]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// AppDir returns the directory the application of the environment is deployed
+// to, app_dir in the generated group_vars
+func (e Environment) AppDir() string {
+	return "/var/www/" + e.Name
+}
+
+// ReleasesDir returns the directory holding one directory per deployed
+// release, app_releases_dir in the generated group_vars
+func (e Environment) ReleasesDir() string {
+	return e.AppDir() + "/releases"
+}
+
+// CurrentDir returns the symlink to the active release, app_current_dir in the
+// generated group_vars
+func (e Environment) CurrentDir() string {
+	return e.AppDir() + "/current"
+}
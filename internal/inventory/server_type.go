@@ -0,0 +1,42 @@
+package inventory
+
+// Server types, each provisioned by its own roles of playbooks/provision.yml
+const (
+	ServerTypeWeb        = "web"
+	ServerTypeDB         = "db"
+	ServerTypeMonitoring = "monitoring"
+)
+
+// NodeExporterPort is the port node_exporter listens on, set by the monitoring role
+const NodeExporterPort = 9100
+
+// Role returns the type of the server, web when it is not set
+func (s Server) Role() string {
+	if s.Type == "" {
+		return ServerTypeWeb
+	}
+	return s.Type
+}
+
+// Deployable reports whether an application is deployed to the server. Database
+// and monitoring servers are complete once provisioned.
+func (s Server) Deployable() bool {
+	return s.Role() == ServerTypeWeb
+}
+
+// SplitDeployable splits the named servers between the ones an application is
+// deployed to and the others, keeping the order of names
+func SplitDeployable(servers []*Server, names []string) (deployable, others []string) {
+	byName := make(map[string]*Server, len(servers))
+	for _, server := range servers {
+		byName[server.Name] = server
+	}
+	for _, name := range names {
+		if server, ok := byName[name]; ok && !server.Deployable() {
+			others = append(others, name)
+		} else {
+			deployable = append(deployable, name)
+		}
+	}
+	return deployable, others
+}
@@ -0,0 +1,14 @@
+package inventory
+
+import "os"
+
+// SSHAgentAvailable reports whether an SSH agent socket is set, in which case a
+// server may have no ssh_key_path
+func SSHAgentAvailable() bool {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return false
+	}
+	info, err := os.Stat(socket)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
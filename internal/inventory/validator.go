@@ -45,8 +45,16 @@ func (v *Validator) ValidateEnvironmentName(name string) error {
 	return nil
 }
 
-// ValidateSSHKeyPath checks if SSH key file exists
+// ValidateSSHKeyPath checks if SSH key file exists. An empty path is valid when
+// an SSH agent provides the key.
 func (v *Validator) ValidateSSHKeyPath(path string) error {
+	if path == "" {
+		if SSHAgentAvailable() {
+			return nil
+		}
+		return fmt.Errorf("SSH key path is empty and no SSH agent is available (SSH_AUTH_SOCK)")
+	}
+
 	// Expand home directory
 	if strings.HasPrefix(path, "~/") {
 		home, err := os.UserHomeDir()
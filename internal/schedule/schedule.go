@@ -0,0 +1,228 @@
+// Package schedule parses the maintenance windows of the environments and the
+// times actions are scheduled at.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is the maintenance window of an environment, a set of weekly time
+// ranges such as "Sun 01:00-04:00" or "Mon-Fri 22:00-02:00; Sat,Sun 00:00-24:00".
+// A range ending before it starts ends the next day.
+type Window struct {
+	spec   string
+	ranges []timeRange
+	loc    *time.Location
+}
+
+type timeRange struct {
+	days  [7]bool // Indexed by time.Weekday, the day the range starts
+	start int     // Minutes since midnight
+	end   int     // Minutes since midnight, up to 24:00
+}
+
+// ParseWindow parses a window spec, evaluated in loc (time.Local when nil).
+// An empty spec means no window: it returns nil.
+func ParseWindow(spec string, loc *time.Location) (*Window, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	w := &Window{spec: spec, loc: loc}
+	for _, part := range strings.Split(spec, ";") {
+		r, err := parseRange(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		w.ranges = append(w.ranges, r)
+	}
+	return w, nil
+}
+
+func parseRange(part string) (timeRange, error) {
+	var r timeRange
+	fields := strings.Fields(part)
+	hours := ""
+	switch len(fields) {
+	case 1:
+		hours = fields[0]
+		for i := range r.days {
+			r.days[i] = true
+		}
+	case 2:
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return r, err
+		}
+		r.days = days
+		hours = fields[1]
+	default:
+		return r, fmt.Errorf("%q is not \"[days] HH:MM-HH:MM\"", part)
+	}
+
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return r, fmt.Errorf("%q is not a HH:MM-HH:MM range", hours)
+	}
+	var err error
+	if r.start, err = parseClock(from); err != nil {
+		return r, err
+	}
+	if r.end, err = parseClock(to); err != nil {
+		return r, err
+	}
+	if r.start == r.end || r.start >= 24*60 {
+		return r, fmt.Errorf("empty range %q", hours)
+	}
+	return r, nil
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDays parses "daily", "*", "Sun", "Sat,Sun" or "Mon-Fri"
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+	if value == "*" || strings.EqualFold(value, "daily") {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		first, err := parseDay(from)
+		if err != nil {
+			return days, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseDay(to); err != nil {
+				return days, err
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseDay(value string) (time.Weekday, error) {
+	name := strings.ToLower(strings.TrimSpace(value))
+	if len(name) >= 3 {
+		if day, ok := dayNames[name[:3]]; ok {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", value)
+}
+
+// parseClock parses HH:MM, 24:00 included, into minutes since midnight
+func parseClock(value string) (int, error) {
+	hour, minute, ok := strings.Cut(strings.TrimSpace(value), ":")
+	h, errH := strconv.Atoi(hour)
+	m, errM := strconv.Atoi(minute)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (HH:MM)", value)
+	}
+	return h*60 + m, nil
+}
+
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Contains reports whether t falls within the window
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	yesterday := (t.Weekday() + 6) % 7
+	for _, r := range w.ranges {
+		if r.start < r.end {
+			if r.days[t.Weekday()] && minute >= r.start && minute < r.end {
+				return true
+			}
+			continue
+		}
+		// Ends the day after it starts
+		if (r.days[t.Weekday()] && minute >= r.start) || (r.days[yesterday] && minute < r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns t when it falls within the window, the next opening of the
+// window otherwise
+func (w *Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	local := t.In(w.loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, w.loc)
+
+	var next time.Time
+	for offset := 0; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		for _, r := range w.ranges {
+			if !r.days[day.Weekday()] {
+				continue
+			}
+			opening := day.Add(time.Duration(r.start) * time.Minute)
+			if opening.After(t) && (next.IsZero() || opening.Before(next)) {
+				next = opening
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+// Location returns the time zone named name (see time.LoadLocation), the
+// local one when name is empty or unknown
+func Location(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ParseTime parses the time an action is scheduled at, in the location of
+// now: "HH:MM" (its next occurrence), "YYYY-MM-DD HH:MM" or RFC 3339
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, now.Location()); err == nil {
+		return t, nil
+	}
+	if clock, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (HH:MM, \"YYYY-MM-DD HH:MM\" or RFC 3339)", value)
+}
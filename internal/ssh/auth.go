@@ -0,0 +1,377 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Keys unlocked with their passphrase are kept in memory for the lifetime of
+// the process only, and served to ansible by a private agent
+var (
+	sessionMu     sync.Mutex
+	sessionKeys   = agent.NewKeyring().(agent.ExtendedAgent)
+	unlockedKeys  = make(map[string]ssh.PublicKey) // Key path -> public key
+	sessionSocket string
+	sessionDir    string
+)
+
+// PassphraseRequiredError is returned when a key is protected by a passphrase
+// that was not given yet, and no agent holds the key
+type PassphraseRequiredError struct {
+	KeyPath string
+}
+
+func (e *PassphraseRequiredError) Error() string {
+	return fmt.Sprintf("SSH key %s is protected by a passphrase: unlock it or add it to ssh-agent", e.KeyPath)
+}
+
+// IsPassphraseRequired reports whether err was caused by a locked key
+func IsPassphraseRequired(err error) bool {
+	var required *PassphraseRequiredError
+	return errors.As(err, &required)
+}
+
+// keyError is returned when no usable key could be found, before connecting
+type keyError struct {
+	err error
+}
+
+func (e *keyError) Error() string { return e.err.Error() }
+func (e *keyError) Unwrap() error { return e.err }
+
+// ErrWrongPassphrase is returned by UnlockKey when the passphrase is wrong
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// UnlockKey decrypts a passphrase-protected key and keeps it for the session
+func UnlockKey(keyPath string, passphrase []byte) error {
+	data, err := readKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	raw, err := ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	if err != nil {
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return ErrWrongPassphrase
+		}
+		return fmt.Errorf("cannot parse SSH key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		return fmt.Errorf("cannot parse SSH key: %w", err)
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if err := sessionKeys.Add(agent.AddedKey{PrivateKey: raw, Comment: keyPath}); err != nil {
+		return fmt.Errorf("cannot keep SSH key: %w", err)
+	}
+	unlockedKeys[keyPath] = signer.PublicKey()
+	log.Printf("[SSH] Unlocked key %s for this session", keyPath)
+	return nil
+}
+
+// LockedKeys returns the keys among keyPaths that need a passphrase before
+// they can be used: encrypted, not unlocked yet and not held by the agent
+func LockedKeys(keyPaths []string) []string {
+	var agentKeys []*agent.Key
+	if conn, err := dialAgent(); err == nil {
+		agentKeys, _ = agent.NewClient(conn).List()
+		conn.Close()
+	}
+
+	var locked []string
+	seen := make(map[string]bool)
+	for _, keyPath := range keyPaths {
+		if keyPath == "" || seen[keyPath] {
+			continue
+		}
+		seen[keyPath] = true
+
+		data, err := readKey(keyPath)
+		if err != nil {
+			continue
+		}
+		_, err = ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if !errors.As(err, &missing) || isUnlocked(keyPath) {
+			continue
+		}
+		if missing.PublicKey != nil && agentHolds(agentKeys, missing.PublicKey) {
+			continue
+		}
+		locked = append(locked, keyPath)
+	}
+	return locked
+}
+
+// AgentAvailable reports whether an SSH agent answers on SSH_AUTH_SOCK
+func AgentAvailable() bool {
+	conn, err := dialAgent()
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// AgentSocket returns the agent socket ansible should use: a private agent
+// serving the unlocked keys (and forwarding to the user agent) when keys were
+// unlocked, "" to keep SSH_AUTH_SOCK as is otherwise
+func AgentSocket() (string, error) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	if len(unlockedKeys) == 0 {
+		return "", nil
+	}
+	if sessionSocket != "" {
+		return sessionSocket, nil
+	}
+
+	dir, err := os.MkdirTemp("", "boiler-agent-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create agent directory: %w", err)
+	}
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("cannot start session agent: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(sessionAgent{}, conn)
+			}()
+		}
+	}()
+
+	sessionSocket, sessionDir = socket, dir
+	log.Printf("[SSH] Session agent listening on %s", socket)
+	return socket, nil
+}
+
+// StopSessionAgent removes the socket of the session agent
+func StopSessionAgent() {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if sessionDir != "" {
+		os.RemoveAll(sessionDir)
+		sessionSocket, sessionDir = "", ""
+	}
+}
+
+// authMethods returns the ways to authenticate with keyPath: the agent and
+// the keys unlocked in this session first, then the key file. The returned
+// function releases the agent connection once connected.
+func authMethods(keyPath string) ([]ssh.AuthMethod, func(), error) {
+	var signers []ssh.Signer
+	release := func() {}
+
+	if conn, err := dialAgent(); err == nil {
+		release = func() { conn.Close() }
+		if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+			signers = append(signers, agentSigners...)
+		}
+	}
+	if sessionSigners, err := sessionKeys.Signers(); err == nil {
+		signers = append(signers, sessionSigners...)
+	}
+
+	var preferred ssh.PublicKey
+	if keyPath != "" {
+		data, err := readKey(keyPath)
+		if err != nil {
+			release()
+			return nil, nil, &keyError{err}
+		}
+
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case err == nil:
+			signers = append(signers, signer)
+			preferred = signer.PublicKey()
+		case errors.As(err, &missing):
+			if public := unlockedKey(keyPath); public != nil {
+				preferred = public
+			} else if missing.PublicKey != nil && hasSigner(signers, missing.PublicKey) {
+				preferred = missing.PublicKey
+			} else {
+				release()
+				return nil, nil, &PassphraseRequiredError{KeyPath: keyPath}
+			}
+		default:
+			release()
+			return nil, nil, &keyError{fmt.Errorf("cannot parse SSH key: %w (ensure it's the private key, not the .pub file)", err)}
+		}
+	}
+
+	if len(signers) == 0 {
+		release()
+		return nil, nil, &keyError{errors.New("no SSH key configured and no SSH agent available (SSH_AUTH_SOCK)")}
+	}
+
+	// The key of the server is offered first, so that an agent holding many
+	// keys does not exhaust the authentication attempts of the server
+	if preferred != nil {
+		ordered := make([]ssh.Signer, 0, len(signers))
+		for _, s := range signers {
+			if sameKey(s.PublicKey(), preferred) {
+				ordered = append(ordered, s)
+			}
+		}
+		for _, s := range signers {
+			if !sameKey(s.PublicKey(), preferred) {
+				ordered = append(ordered, s)
+			}
+		}
+		signers = ordered
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, release, nil
+}
+
+func dialAgent() (net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	return net.Dial("unix", socket)
+}
+
+// readKey reads a private key file, expanding ~
+func readKey(keyPath string) ([]byte, error) {
+	if len(keyPath) > 0 && keyPath[0] == '~' {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand home directory: %w", err)
+		}
+		keyPath = home + keyPath[1:]
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read SSH key: %w", err)
+	}
+	return data, nil
+}
+
+func isUnlocked(keyPath string) bool {
+	return unlockedKey(keyPath) != nil
+}
+
+func unlockedKey(keyPath string) ssh.PublicKey {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	return unlockedKeys[keyPath]
+}
+
+func agentHolds(keys []*agent.Key, public ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), public.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSigner(signers []ssh.Signer, public ssh.PublicKey) bool {
+	for _, s := range signers {
+		if sameKey(s.PublicKey(), public) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameKey(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// sessionAgent serves the unlocked keys and forwards everything else to the
+// agent of the user, if any
+type sessionAgent struct{}
+
+func (sessionAgent) List() ([]*agent.Key, error) {
+	keys, err := sessionKeys.List()
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := dialAgent(); err == nil {
+		defer conn.Close()
+		if upstream, err := agent.NewClient(conn).List(); err == nil {
+			keys = append(keys, upstream...)
+		}
+	}
+	return keys, nil
+}
+
+func (sessionAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if local, err := sessionKeys.List(); err == nil && agentHolds(local, key) {
+		return sessionKeys.Sign(key, data)
+	}
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, errors.New("key not found")
+	}
+	defer conn.Close()
+	return agent.NewClient(conn).Sign(key, data)
+}
+
+func (sessionAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if local, err := sessionKeys.List(); err == nil && agentHolds(local, key) {
+		return sessionKeys.SignWithFlags(key, data, flags)
+	}
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, errors.New("key not found")
+	}
+	defer conn.Close()
+	return agent.NewClient(conn).SignWithFlags(key, data, flags)
+}
+
+func (sessionAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+func (sessionAgent) Signers() ([]ssh.Signer, error) {
+	return sessionKeys.Signers()
+}
+
+func (sessionAgent) Add(key agent.AddedKey) error {
+	return sessionKeys.Add(key)
+}
+
+func (sessionAgent) Remove(key ssh.PublicKey) error {
+	return sessionKeys.Remove(key)
+}
+
+func (sessionAgent) RemoveAll() error {
+	return sessionKeys.RemoveAll()
+}
+
+func (sessionAgent) Lock(passphrase []byte) error {
+	return sessionKeys.Lock(passphrase)
+}
+
+func (sessionAgent) Unlock(passphrase []byte) error {
+	return sessionKeys.Unlock(passphrase)
+}
@@ -0,0 +1,26 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
+// Connect returns an SSH connection authenticated with the SSH agent or the key
+// at keyPath (which may be empty when an agent holds the key), verifying the
+// host key against hostKeys. The connection goes through jump unless it is nil.
+//
+// Connections are shared through a pool: Close gives the connection back, and
+// the next caller for the same server reuses it.
+func Connect(host string, port int, user string, keyPath string, jump *inventory.JumpHost, hostKeys *HostKeys) (*Conn, error) {
+	conn, err := defaultPool.Connect(host, port, user, keyPath, jump, hostKeys)
+	if err != nil {
+		var keyErr *keyError
+		if IsHostKeyChanged(err) || IsPassphraseRequired(err) || errors.As(err, &keyErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("connection failed: %w", err)
+	}
+	return conn, nil
+}
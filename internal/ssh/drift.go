@@ -0,0 +1,318 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bastiblast/boiler-deploy/internal/gitref"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
+// VersionFacts are the versions actually running on a web server
+type VersionFacts struct {
+	Release   string // Release current points to, empty when none
+	Commit    string // Commit checked out in the current release
+	Node      string // node --version of the NVM installed by provisioning
+	Nginx     string // Version of nginx -v
+	PM2Online int    // PM2 processes online, -1 when PM2 could not be queried
+}
+
+// ShortCommit returns the first 7 characters of the deployed commit
+func (f VersionFacts) ShortCommit() string {
+	return shortCommit(f.Commit)
+}
+
+// ServerDrift compares what a web server is configured to run with what it
+// actually runs, and with what the other servers of the environment run
+type ServerDrift struct {
+	Server           string
+	Repo             string // Configured repository
+	Branch           string // Configured branch
+	ConfiguredCommit string // Commit the configured branch is at, empty when it could not be resolved
+	ConfiguredNode   string // Configured Node.js version
+	ResolveErr       error  // The configured branch could not be resolved
+	Deployed         VersionFacts
+	Differences      []string // Why the server drifts, empty when it does not
+	Err              error    // The server could not be inspected
+}
+
+// Drifted reports whether the server differs from its configuration or from
+// the environment majority
+func (sd ServerDrift) Drifted() bool {
+	return len(sd.Differences) > 0
+}
+
+// driftScript prints the versions running on a web server whose current
+// symlink is %[1]s. %[2]s and %[3]s run node --version and pm2 jlist with NVM.
+const driftScript = `PATH="$PATH:/usr/sbin:/sbin"
+current=$(readlink %[1]s 2>/dev/null)
+echo "@@release ${current##*/}"
+commit=""
+[ -n "$current" ] && commit=$(git -c safe.directory='*' -C %[1]s rev-parse HEAD 2>/dev/null)
+echo "@@commit $commit"
+echo "@@node $( (%[2]s) 2>/dev/null | tail -n 1)"
+echo "@@nginx $(nginx -v 2>&1 | sed -n 's|.*nginx/\([^ ]*\).*|\1|p')"
+jlist=$( (%[3]s) 2>/dev/null)
+if [ -n "$jlist" ]; then
+	echo "@@pm2 $(printf '%%s' "$jlist" | grep -o '"status":"online"' | wc -l)"
+else
+	echo "@@pm2"
+fi`
+
+// DriftDetector gathers the versions running on the web servers of an
+// environment through SSH and compares them
+type DriftDetector struct {
+	hostKeys *HostKeys
+	jumpHost *inventory.JumpHost
+	env      inventory.Environment
+
+	mu       sync.Mutex
+	resolved map[string]*resolvedRef // By repository and branch
+}
+
+// resolvedRef is the commit a branch was resolved to, once per detection
+type resolvedRef struct {
+	once   sync.Once
+	commit string
+	err    error
+}
+
+// NewDriftDetector creates a detector for the servers of env, reached through
+// the jump host of the environment unless they have their own
+func NewDriftDetector(hostKeys *HostKeys, env inventory.Environment) *DriftDetector {
+	return &DriftDetector{
+		hostKeys: hostKeys,
+		jumpHost: env.Config.JumpHost,
+		env:      env,
+		resolved: make(map[string]*resolvedRef),
+	}
+}
+
+// Detect returns what a server is configured to run and what it runs. The
+// differences are only set by DetectAll, which sees all the servers.
+func (dd *DriftDetector) Detect(server inventory.Server) ServerDrift {
+	result := ServerDrift{
+		Server:         server.Name,
+		Repo:           firstNonEmpty(server.GitRepo, dd.env.Config.AppRepo),
+		Branch:         firstNonEmpty(server.GitBranch, dd.env.Config.AppBranch),
+		ConfiguredNode: firstNonEmpty(server.NodeVersion, dd.env.Config.NodeJSVersion),
+		Deployed:       VersionFacts{PM2Online: -1},
+	}
+	result.ConfiguredCommit, result.ResolveErr = dd.resolve(result.Repo, result.Branch)
+
+	jump := inventory.ResolveJumpHost(server, dd.jumpHost)
+	client, err := Connect(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump, dd.hostKeys)
+	if err != nil {
+		result.Err = fmt.Errorf("cannot connect via SSH: %w", err)
+		return result
+	}
+	defer client.Close()
+
+	vars := inventory.ProbeVars(dd.env, server)
+	script := fmt.Sprintf(driftScript, shellQuote(dd.env.CurrentDir()),
+		inventory.ExpandProbeCommand(inventory.NVMCommand("node --version"), vars),
+		inventory.ExpandProbeCommand(inventory.NVMCommand("pm2 jlist"), vars))
+	output, err := runScript(client.Client, script)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Deployed = ParseVersionFacts(output)
+	return result
+}
+
+// DetectAll inspects servers, at most workers at once, then compares them.
+// Results are in the order of servers.
+func (dd *DriftDetector) DetectAll(servers []*inventory.Server, workers int) []ServerDrift {
+	results := make([]ServerDrift, len(servers))
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(servers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = dd.Detect(*servers[i])
+			}
+		}()
+	}
+	for i := range servers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	CompareDrift(results)
+	return results
+}
+
+// resolve returns the commit branch is at in repo, running git ls-remote once
+// per repository and branch
+func (dd *DriftDetector) resolve(repo, branch string) (string, error) {
+	dd.mu.Lock()
+	ref, ok := dd.resolved[repo+"\x00"+branch]
+	if !ok {
+		ref = &resolvedRef{}
+		dd.resolved[repo+"\x00"+branch] = ref
+	}
+	dd.mu.Unlock()
+
+	ref.once.Do(func() {
+		ref.commit, ref.err = gitref.Resolve(context.Background(), repo, branch)
+	})
+	return ref.commit, ref.err
+}
+
+// ParseVersionFacts parses the output of the drift script
+func ParseVersionFacts(output string) VersionFacts {
+	facts := VersionFacts{PM2Online: -1}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, releaseMarker) {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimPrefix(line, releaseMarker), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "release":
+			facts.Release = value
+		case "commit":
+			facts.Commit = value
+		case "node":
+			facts.Node = value
+		case "nginx":
+			facts.Nginx = value
+		case "pm2":
+			if count, err := strconv.Atoi(value); err == nil {
+				facts.PM2Online = count
+			}
+		}
+	}
+	// Releases of deploy-app are named after their short commit
+	if facts.Commit == "" && facts.Release != "" {
+		if _, commit, found := strings.Cut(facts.Release, "_"); found {
+			facts.Commit = commit
+		}
+	}
+	return facts
+}
+
+// CompareDrift sets the Differences of the servers running another commit
+// than the configured branch, another Node.js major than configured, or
+// another commit (when the branch is unknown), Node.js, nginx or PM2 process
+// count than most servers. Without a strict majority, no server is flagged
+// for that version.
+func CompareDrift(results []ServerDrift) {
+	for i := range results {
+		results[i].Differences = nil
+	}
+
+	for i := range results {
+		result := &results[i]
+		if result.Err != nil {
+			continue
+		}
+		deployed := result.Deployed
+		if result.ConfiguredCommit != "" && deployed.Commit != "" && !sameCommit(result.ConfiguredCommit, deployed.Commit) {
+			result.Differences = append(result.Differences, fmt.Sprintf("commit %s deployed, %s is at %s",
+				deployed.ShortCommit(), firstNonEmpty(result.Branch, "HEAD"), shortCommit(result.ConfiguredCommit)))
+		}
+		if deployed.Node != "" && !nodeMatches(result.ConfiguredNode, deployed.Node) {
+			result.Differences = append(result.Differences, fmt.Sprintf("node %s, configured %s",
+				deployed.Node, result.ConfiguredNode))
+		}
+	}
+
+	flagMinority(results, "commit", func(r ServerDrift) string {
+		if r.ConfiguredCommit != "" {
+			return ""
+		}
+		return r.Deployed.ShortCommit()
+	}, func(v string) string { return v })
+	flagMinority(results, "node", func(r ServerDrift) string { return r.Deployed.Node }, func(v string) string { return v })
+	flagMinority(results, "nginx", func(r ServerDrift) string { return r.Deployed.Nginx }, func(v string) string { return v })
+	flagMinority(results, "pm2", func(r ServerDrift) string {
+		if r.Deployed.PM2Online < 0 {
+			return ""
+		}
+		return strconv.Itoa(r.Deployed.PM2Online)
+	}, func(v string) string { return v + " online" })
+}
+
+// flagMinority adds a difference to the servers whose value, as returned by
+// value, is not the one of a strict majority of the servers having one
+func flagMinority(results []ServerDrift, name string, value func(ServerDrift) string, format func(string) string) {
+	counts := make(map[string]int)
+	for _, result := range results {
+		if v := value(result); result.Err == nil && v != "" {
+			counts[v]++
+		}
+	}
+	if len(counts) < 2 {
+		return
+	}
+
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return counts[values[i]] > counts[values[j]] })
+	majority := values[0]
+	if counts[values[1]] == counts[majority] {
+		return
+	}
+
+	for i := range results {
+		v := value(results[i])
+		if results[i].Err != nil || v == "" || v == majority {
+			continue
+		}
+		results[i].Differences = append(results[i].Differences, fmt.Sprintf("%s %s, %d of %d servers run %s",
+			name, format(v), counts[majority], countServers(counts), format(majority)))
+	}
+}
+
+// nodeMatches reports whether the deployed node version (v20.11.1) is the
+// configured one (20, 20.11 or v20.11.1). Aliases such as lts/* match any.
+func nodeMatches(configured, deployed string) bool {
+	configured = strings.TrimPrefix(strings.TrimSpace(configured), "v")
+	deployed = strings.TrimPrefix(strings.TrimSpace(deployed), "v")
+	if configured == "" || configured[0] < '0' || configured[0] > '9' {
+		return true
+	}
+	return deployed == configured || strings.HasPrefix(deployed, configured+".")
+}
+
+// sameCommit reports whether two commits, full or abbreviated, are the same
+func sameCommit(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return a != "" && strings.HasPrefix(b, a)
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,300 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMu serializes the reads and writes of known_hosts files
+var knownHostsMu sync.Mutex
+
+// HostKeyChangedError is returned when a server presents another key than the
+// one recorded for it
+type HostKeyChangedError struct {
+	Host        string
+	Fingerprint string // Key presented by the server
+	Known       string // Key recorded in known_hosts
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key of %s changed (offered %s, known %s)", e.Host, e.Fingerprint, e.Known)
+}
+
+// IsHostKeyChanged reports whether err was caused by a changed host key
+func IsHostKeyChanged(err error) bool {
+	var changed *HostKeyChangedError
+	return errors.As(err, &changed)
+}
+
+// IsHostKeyMismatch reports whether an error message (e.g. from ansible) is
+// caused by a host key that does not match known_hosts
+func IsHostKeyMismatch(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "host key verification failed") ||
+		strings.Contains(message, "remote host identification has changed") ||
+		(strings.Contains(message, "host key of") && strings.Contains(message, "changed"))
+}
+
+// HostKeys verifies host keys against the known_hosts file of an environment.
+// The key of a host seen for the first time is recorded (trust on first use).
+type HostKeys struct {
+	path string
+}
+
+func NewHostKeys(environment string) *HostKeys {
+	return &HostKeys{path: inventory.KnownHostsFile(environment)}
+}
+
+// Path returns the known_hosts file
+func (h *HostKeys) Path() string {
+	return h.path
+}
+
+// Apply configures config to verify the key of host:port. The host key
+// algorithms are restricted to the types already known for the host, so that a
+// server having several keys is not mistaken for a changed one.
+func (h *HostKeys) Apply(config *ssh.ClientConfig, host string, port int) error {
+	known, err := h.Known(host, port)
+	if err != nil {
+		return err
+	}
+	config.HostKeyCallback = h.callback()
+	config.HostKeyAlgorithms = hostKeyAlgorithms(known)
+	return nil
+}
+
+// Known returns the keys recorded for host:port
+func (h *HostKeys) Known(host string, port int) ([]ssh.PublicKey, error) {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	check, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Checking a key nobody has returns the known ones in the error
+	probe, err := probeKey()
+	if err != nil {
+		return nil, err
+	}
+	err = check(address(host, port), &net.TCPAddr{IP: net.IPv4zero, Port: port}, probe)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return nil, err
+	}
+	keys := make([]ssh.PublicKey, len(keyErr.Want))
+	for i, k := range keyErr.Want {
+		keys[i] = k.Key
+	}
+	return keys, nil
+}
+
+// Accept connects to host:port, through jump unless it is nil, and records the
+// key it presents in place of the known ones. It returns the fingerprint of the
+// accepted key.
+func (h *HostKeys) Accept(host string, port int, jump *inventory.JumpHost) (string, error) {
+	var presented ssh.PublicKey
+	errCaptured := errors.New("host key captured")
+
+	config := &ssh.ClientConfig{
+		User: "hostkey",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			presented = key
+			// Stop before authenticating, only the key is wanted
+			return errCaptured
+		},
+		Timeout: 10 * time.Second,
+	}
+	client, err := dial(address(host, port), config, jump, h)
+	if client != nil {
+		client.Close()
+	}
+	if presented == nil {
+		return "", fmt.Errorf("cannot get host key of %s: %w", address(host, port), err)
+	}
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	if err := h.remove(host, port); err != nil {
+		return "", err
+	}
+	if err := h.add(address(host, port), presented); err != nil {
+		return "", err
+	}
+	fingerprint := ssh.FingerprintSHA256(presented)
+	log.Printf("[SSH] Accepted host key %s for %s", fingerprint, address(host, port))
+	return fingerprint, nil
+}
+
+// Forget removes the keys recorded for host:port
+func (h *HostKeys) Forget(host string, port int) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	return h.remove(host, port)
+}
+
+func (h *HostKeys) callback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		check, err := h.load()
+		if err != nil {
+			return err
+		}
+
+		err = check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) == 0 {
+			log.Printf("[SSH] Trusting new host key %s for %s", ssh.FingerprintSHA256(key), hostname)
+			return h.add(hostname, key)
+		}
+		return &HostKeyChangedError{
+			Host:        hostname,
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Known:       ssh.FingerprintSHA256(keyErr.Want[0].Key),
+		}
+	}
+}
+
+// load parses the known_hosts file, creating it if needed
+func (h *HostKeys) load() (ssh.HostKeyCallback, error) {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create known_hosts directory: %w", err)
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open known_hosts: %w", err)
+	}
+	f.Close()
+
+	check, err := knownhosts.New(h.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read known_hosts: %w", err)
+	}
+	return check, nil
+}
+
+func (h *HostKeys) add(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("cannot write known_hosts: %w", err)
+	}
+	return nil
+}
+
+// remove drops the lines of host:port, keeping everything else as is
+func (h *HostKeys) remove(host string, port int) error {
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot read known_hosts: %w", err)
+	}
+
+	target := knownhosts.Normalize(address(host, port))
+	var kept []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") && matchesHost(fields[0], target) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := atomicfile.WriteFile(h.path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("cannot write known_hosts: %w", err)
+	}
+	return nil
+}
+
+// matchesHost reports whether the hosts field of a known_hosts line names
+// target, in clear or hashed (|1|salt|hash) form
+func matchesHost(hosts, target string) bool {
+	if strings.HasPrefix(hosts, "|1|") {
+		parts := strings.Split(hosts, "|")
+		if len(parts) != 4 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(target))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if host == target {
+			return true
+		}
+	}
+	return false
+}
+
+// probeKey returns a key that is in no known_hosts file
+func probeKey() (ssh.PublicKey, error) {
+	private := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	return ssh.NewPublicKey(private.Public())
+}
+
+func address(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// hostKeyAlgorithms returns the algorithms able to verify the known keys, or
+// nil (the defaults) when no key is known yet
+func hostKeyAlgorithms(known []ssh.PublicKey) []string {
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, key := range known {
+		candidates := []string{key.Type()}
+		if key.Type() == ssh.KeyAlgoRSA {
+			candidates = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+		}
+		for _, algorithm := range candidates {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
@@ -0,0 +1,74 @@
+package ssh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"golang.org/x/crypto/ssh"
+)
+
+// dial opens an SSH connection to address, through jump when it is not nil.
+// The jump host key is verified against hostKeys like the server one, and the
+// connection to the jump host is closed with the returned client.
+func dial(address string, config *ssh.ClientConfig, jump *inventory.JumpHost, hostKeys *HostKeys) (*ssh.Client, error) {
+	if jump == nil {
+		return ssh.Dial("tcp", address, config)
+	}
+
+	bastion, err := dialJumpHost(jump, hostKeys, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := bastion.Dial("tcp", address)
+	if err != nil {
+		bastion.Close()
+		return nil, fmt.Errorf("jump host %s cannot reach %s: %w", jump.Address(), address, err)
+	}
+
+	// Channels have no deadline, the handshake is bounded by closing the channel
+	if config.Timeout > 0 {
+		timer := time.AfterFunc(config.Timeout, func() { conn.Close() })
+		defer timer.Stop()
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		bastion.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+
+	go func() {
+		client.Wait()
+		bastion.Close()
+	}()
+	return client, nil
+}
+
+// dialJumpHost connects to the jump host, authenticated with its own key or
+// with the SSH agent
+func dialJumpHost(jump *inventory.JumpHost, hostKeys *HostKeys, timeout time.Duration) (*ssh.Client, error) {
+	auth, release, err := authMethods(jump.SSHKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("jump host %s: %w", jump.Address(), err)
+	}
+	defer release()
+
+	config := &ssh.ClientConfig{
+		User:    jump.User,
+		Auth:    auth,
+		Timeout: timeout,
+	}
+	if err := hostKeys.Apply(config, jump.Host, jump.Port); err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", jump.Address(), config)
+	if err != nil {
+		return nil, fmt.Errorf("jump host %s: %w", jump.Address(), err)
+	}
+	return client, nil
+}
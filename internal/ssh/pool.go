@@ -0,0 +1,226 @@
+package ssh
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultIdleTimeout is how long an unused connection stays open in the pool
+const DefaultIdleTimeout = 2 * time.Minute
+
+// keepaliveTimeout bounds the check that a pooled connection is still alive
+const keepaliveTimeout = 5 * time.Second
+
+// defaultPool is shared by all the SSH callers of the process
+var defaultPool = NewPool(DefaultIdleTimeout)
+
+// ClosePool closes the connections of the shared pool
+func ClosePool() {
+	defaultPool.Close()
+}
+
+// Conn is a connection taken from a pool. Close gives it back to the pool,
+// which keeps it open for the next caller until it has been idle too long.
+type Conn struct {
+	*ssh.Client
+	release func()
+	once    sync.Once
+}
+
+// Close gives the connection back to the pool
+func (c *Conn) Close() error {
+	c.once.Do(c.release)
+	return nil
+}
+
+// Pool keeps SSH connections open between uses, one per address, user, key,
+// jump host and known_hosts file
+type Pool struct {
+	mu          sync.Mutex
+	conns       map[poolKey]*pooledClient
+	idleTimeout time.Duration
+	stop        chan struct{}
+}
+
+type poolKey struct {
+	address    string
+	user       string
+	keyPath    string
+	jump       string
+	knownHosts string
+}
+
+type pooledClient struct {
+	mu       sync.Mutex // Held while dialing, so callers share one connection
+	client   *ssh.Client
+	users    int
+	lastUsed time.Time
+}
+
+// NewPool creates a pool closing connections unused for idleTimeout
+func NewPool(idleTimeout time.Duration) *Pool {
+	return &Pool{
+		conns:       make(map[poolKey]*pooledClient),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// Connect returns a pooled connection to host:port, opening it if needed. The
+// connection is checked to still be alive before being reused.
+func (p *Pool) Connect(host string, port int, user string, keyPath string, jump *inventory.JumpHost, hostKeys *HostKeys) (*Conn, error) {
+	key := poolKey{
+		address:    fmt.Sprintf("%s:%d", host, port),
+		user:       user,
+		keyPath:    keyPath,
+		knownHosts: hostKeys.Path(),
+	}
+	if jump != nil {
+		key.jump = jump.String() + " " + jump.SSHKeyPath
+	}
+
+	p.mu.Lock()
+	entry, ok := p.conns[key]
+	if !ok {
+		entry = &pooledClient{}
+		p.conns[key] = entry
+	}
+	if p.stop == nil && p.idleTimeout > 0 {
+		p.stop = make(chan struct{})
+		go p.expireIdle(p.stop)
+	}
+	p.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.client != nil && !alive(entry.client) {
+		log.Printf("[SSH] Pooled connection to %s is dead, reconnecting", key.address)
+		entry.client.Close()
+		entry.client = nil
+	}
+	if entry.client == nil {
+		client, err := dialServer(host, port, user, keyPath, jump, hostKeys)
+		if err != nil {
+			return nil, err
+		}
+		entry.client = client
+	}
+
+	entry.users++
+	entry.lastUsed = time.Now()
+	return &Conn{
+		Client: entry.client,
+		release: func() {
+			entry.mu.Lock()
+			defer entry.mu.Unlock()
+			entry.users--
+			entry.lastUsed = time.Now()
+		},
+	}, nil
+}
+
+// Close closes all the connections of the pool, in use or not
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	for _, entry := range p.conns {
+		entry.mu.Lock()
+		if entry.client != nil {
+			entry.client.Close()
+			entry.client = nil
+		}
+		entry.mu.Unlock()
+	}
+}
+
+// Size returns the number of open connections
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	open := 0
+	for _, entry := range p.conns {
+		entry.mu.Lock()
+		if entry.client != nil {
+			open++
+		}
+		entry.mu.Unlock()
+	}
+	return open
+}
+
+// expireIdle closes the connections nobody used for idleTimeout
+func (p *Pool) expireIdle(stop chan struct{}) {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		for key, entry := range p.conns {
+			// An entry being dialed is not idle
+			if !entry.mu.TryLock() {
+				continue
+			}
+			if entry.client != nil && entry.users == 0 && time.Since(entry.lastUsed) > p.idleTimeout {
+				log.Printf("[SSH] Closing idle connection to %s", key.address)
+				entry.client.Close()
+				entry.client = nil
+			}
+			entry.mu.Unlock()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// alive reports whether the server still answers on the connection
+func alive(client *ssh.Client) bool {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err == nil
+	case <-time.After(keepaliveTimeout):
+		return false
+	}
+}
+
+// dialServer opens a new SSH connection authenticated with the SSH agent or the
+// key at keyPath, verifying the host key against hostKeys
+func dialServer(host string, port int, user string, keyPath string, jump *inventory.JumpHost, hostKeys *HostKeys) (*ssh.Client, error) {
+	auth, release, err := authMethods(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	config := &ssh.ClientConfig{
+		User:    user,
+		Auth:    auth,
+		Timeout: 10 * time.Second,
+	}
+	if err := hostKeys.Apply(config, host, port); err != nil {
+		return nil, err
+	}
+
+	return dial(fmt.Sprintf("%s:%d", host, port), config, jump, hostKeys)
+}
@@ -0,0 +1,251 @@
+package ssh
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
+// Release is one release directory of app_releases_dir on a server
+type Release struct {
+	ID         string    // Directory name, <timestamp>_<commit> for the deploys of deploy-app
+	Commit     string    // Commit checked out in the release
+	Branch     string    // Branch checked out in the release, empty when detached
+	DeployedAt time.Time // Time of the deploy, from the release name or its directory
+	SizeKB     int64     // Disk usage of the release directory
+	Current    bool      // The current symlink points to this release
+}
+
+// ShortCommit returns the first 7 characters of the commit
+func (r Release) ShortCommit() string {
+	return shortCommit(r.Commit)
+}
+
+// ServerReleases lists the releases of one server
+type ServerReleases struct {
+	Server   string
+	Releases []Release // Most recent first
+	Current  string    // ID of the release current points to, empty when none
+	Drift    string    // Why the current release differs from the other servers, empty when it does not
+	Err      error     // The releases could not be listed
+}
+
+// CurrentRelease returns the release current points to, or nil
+func (sr ServerReleases) CurrentRelease() *Release {
+	for i := range sr.Releases {
+		if sr.Releases[i].Current {
+			return &sr.Releases[i]
+		}
+	}
+	return nil
+}
+
+// releaseMarker starts the lines of the listing script the lister parses
+const releaseMarker = "@@"
+
+// releaseScript lists the releases of the releases directory %[1]s, with the
+// current symlink %[2]s. One tab separated line per release: ID, commit,
+// branch, deploy time (epoch) and size in KB.
+const releaseScript = `dir=%[1]s
+current=$(readlink %[2]s 2>/dev/null)
+echo "@@current ${current##*/}"
+if [ ! -d "$dir" ]; then
+	echo "@@missing"
+	exit 0
+fi
+for d in "$dir"/*; do
+	[ -d "$d" ] || continue
+	name=${d##*/}
+	head=$(cat "$d/.git/HEAD" 2>/dev/null)
+	branch=""
+	case "$head" in
+		"ref: refs/heads/"*) branch=${head#ref: refs/heads/} ;;
+	esac
+	commit=$(git -c safe.directory='*' -C "$d" rev-parse HEAD 2>/dev/null)
+	case "$head" in
+		ref:*) ;;
+		*) [ -n "$commit" ] || commit=$head ;;
+	esac
+	epoch=""
+	stamp=$(printf '%%s' "$name" | sed -n 's/^\([0-9]\{8\}\)T\([0-9]\{2\}\)\([0-9]\{2\}\)\([0-9]\{2\}\).*/\1 \2:\3:\4/p')
+	[ -n "$stamp" ] && epoch=$(date -d "$stamp" +%%s 2>/dev/null)
+	[ -n "$epoch" ] || epoch=$(stat -c %%Y "$d" 2>/dev/null)
+	size=$(du -sk "$d" 2>/dev/null | cut -f1)
+	printf '@@release\t%%s\t%%s\t%%s\t%%s\t%%s\n' "$name" "$commit" "$branch" "$epoch" "$size"
+done`
+
+// ReleaseLister lists the releases deployed on the web servers of an
+// environment through SSH
+type ReleaseLister struct {
+	hostKeys *HostKeys
+	jumpHost *inventory.JumpHost
+	env      inventory.Environment
+}
+
+// NewReleaseLister creates a lister for the servers of env, reached through
+// the jump host of the environment unless they have their own
+func NewReleaseLister(hostKeys *HostKeys, env inventory.Environment) *ReleaseLister {
+	return &ReleaseLister{hostKeys: hostKeys, jumpHost: env.Config.JumpHost, env: env}
+}
+
+// List returns the releases of a server
+func (rl *ReleaseLister) List(server inventory.Server) ServerReleases {
+	result := ServerReleases{Server: server.Name}
+
+	jump := inventory.ResolveJumpHost(server, rl.jumpHost)
+	client, err := Connect(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump, rl.hostKeys)
+	if err != nil {
+		result.Err = fmt.Errorf("cannot connect via SSH: %w", err)
+		return result
+	}
+	defer client.Close()
+
+	script := fmt.Sprintf(releaseScript, shellQuote(rl.env.ReleasesDir()), shellQuote(rl.env.CurrentDir()))
+	output, err := runScript(client.Client, script)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	releases, current, err := ParseReleaseListing(output)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Releases, result.Current = releases, current
+	return result
+}
+
+// ListAll lists the releases of servers, at most workers at once, then flags
+// the servers whose current release differs from the others. Results are in
+// the order of servers.
+func (rl *ReleaseLister) ListAll(servers []*inventory.Server, workers int) []ServerReleases {
+	results := make([]ServerReleases, len(servers))
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(servers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = rl.List(*servers[i])
+			}
+		}()
+	}
+	for i := range servers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	FlagReleaseDrift(results)
+	return results
+}
+
+// ParseReleaseListing parses the output of the listing script into the
+// releases, most recent first, and the ID of the current one
+func ParseReleaseListing(output string) ([]Release, string, error) {
+	var releases []Release
+	current := ""
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, releaseMarker+"current"):
+			current = strings.TrimSpace(strings.TrimPrefix(line, releaseMarker+"current"))
+		case line == releaseMarker+"missing":
+			return nil, current, fmt.Errorf("no releases directory, nothing was deployed yet")
+		case strings.HasPrefix(line, releaseMarker+"release\t"):
+			fields := strings.Split(strings.TrimPrefix(line, releaseMarker+"release\t"), "\t")
+			for len(fields) < 5 {
+				fields = append(fields, "")
+			}
+			release := Release{
+				ID:     fields[0],
+				Commit: strings.TrimSpace(fields[1]),
+				Branch: strings.TrimSpace(fields[2]),
+			}
+			if epoch, err := strconv.ParseInt(strings.TrimSpace(fields[3]), 10, 64); err == nil {
+				release.DeployedAt = time.Unix(epoch, 0)
+			}
+			release.SizeKB, _ = strconv.ParseInt(strings.TrimSpace(fields[4]), 10, 64)
+			// Releases of deploy-app are named after their short commit
+			if release.Commit == "" {
+				if _, commit, found := strings.Cut(release.ID, "_"); found {
+					release.Commit = commit
+				}
+			}
+			release.Current = release.ID == current
+			releases = append(releases, release)
+		}
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		if !releases[i].DeployedAt.Equal(releases[j].DeployedAt) {
+			return releases[i].DeployedAt.After(releases[j].DeployedAt)
+		}
+		return releases[i].ID > releases[j].ID
+	})
+	return releases, current, nil
+}
+
+// FlagReleaseDrift sets the Drift of the servers whose current commit is not
+// the one most servers run. On a tie, the most recently deployed commit wins.
+func FlagReleaseDrift(results []ServerReleases) {
+	counts := make(map[string]int)
+	latest := make(map[string]time.Time)
+	for _, result := range results {
+		if release := result.CurrentRelease(); release != nil && release.Commit != "" {
+			commit := release.ShortCommit()
+			counts[commit]++
+			if release.DeployedAt.After(latest[commit]) {
+				latest[commit] = release.DeployedAt
+			}
+		}
+	}
+	if len(counts) < 2 {
+		return
+	}
+
+	majority := ""
+	for commit, count := range counts {
+		switch {
+		case majority == "", count > counts[majority]:
+			majority = commit
+		case count == counts[majority] && (latest[commit].After(latest[majority]) ||
+			latest[commit].Equal(latest[majority]) && commit < majority):
+			majority = commit
+		}
+	}
+
+	for i := range results {
+		release := results[i].CurrentRelease()
+		if release == nil || release.Commit == "" || release.ShortCommit() == majority {
+			continue
+		}
+		results[i].Drift = fmt.Sprintf("runs %s, %d of %d servers run %s",
+			release.ShortCommit(), counts[majority], countServers(counts), majority)
+	}
+}
+
+func countServers(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+// shellQuote quotes value for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
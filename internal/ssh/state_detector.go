@@ -1,54 +1,93 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/health"
 	"github.com/bastiblast/boiler-deploy/internal/inventory"
 	"github.com/bastiblast/boiler-deploy/internal/status"
+	"golang.org/x/crypto/ssh"
 )
 
 // StateDetectionResult represents the result of server state detection
 type StateDetectionResult struct {
-	State              status.ServerState
-	Message            string
-	ProvisioningChecks ProvisioningStatus
-	DeploymentChecks   DeploymentStatus
+	State   status.ServerState
+	Message string
+	Probes  []ProbeResult // In the order of the probes of the server type
 }
 
-// ProvisioningStatus contains the result of provisioning checks
-type ProvisioningStatus struct {
-	NodeInstalled    bool
-	NginxInstalled   bool
-	NVMInstalled     bool
-	AppDirExists     bool
-	AllProvisioned   bool
+// ProbeResult is the outcome of one probe of the state detection
+type ProbeResult struct {
+	Name   string
+	Gates  string // State the probe gates, see inventory.ProbeGatesProvisioned
+	Passed bool
+	Output string // Last lines of the output, or why the probe could not run
 }
 
-// DeploymentStatus contains the result of deployment checks
-type DeploymentStatus struct {
-	PM2Running        bool
-	AppResponding     bool
-	CurrentSymlink    bool
-	AllDeployed       bool
+// ProbeSummary returns the probes as "name ✓" or "name ✗", space separated
+func (r StateDetectionResult) ProbeSummary() string {
+	parts := make([]string, 0, len(r.Probes))
+	for _, probe := range r.Probes {
+		mark := "✗"
+		if probe.Passed {
+			mark = "✓"
+		}
+		parts = append(parts, probe.Name+" "+mark)
+	}
+	return strings.Join(parts, "  ")
 }
 
+// DefaultDetectWorkers is the number of servers DetectAll is usually given to
+// detect at once
+const DefaultDetectWorkers = 10
+
+// probeTimeout bounds the run of all the probes of a server
+const probeTimeout = 30 * time.Second
+
+// probeOutputLines is how many of the last output lines a probe result keeps
+const probeOutputLines = 5
+
+// probeMarker starts the output of each probe in the output of the script
+const probeMarker = "@@probe "
+
 // StateDetector detects the actual state of a server by connecting via SSH
-type StateDetector struct{}
+type StateDetector struct {
+	hostKeys *HostKeys
+	jumpHost *inventory.JumpHost   // Jump host of the environment, servers may override it
+	env      inventory.Environment // Probes and application directory of the environment
+}
+
+// NewStateDetector creates a new StateDetector instance. jumpHost is the jump
+// host of the environment, nil when servers are reached directly.
+func NewStateDetector(hostKeys *HostKeys, jumpHost *inventory.JumpHost) *StateDetector {
+	return &StateDetector{hostKeys: hostKeys, jumpHost: jumpHost}
+}
 
-// NewStateDetector creates a new StateDetector instance
-func NewStateDetector() *StateDetector {
-	return &StateDetector{}
+// SetEnvironment sets the environment whose configured probes and application
+// directory the detection uses. Without it, the default probes are used.
+func (sd *StateDetector) SetEnvironment(env inventory.Environment) {
+	sd.env = env
 }
 
-// DetectState connects to a server and detects its current state
+// DetectState connects to a server and runs the probes of its type to detect
+// its current state
 func (sd *StateDetector) DetectState(server inventory.Server) StateDetectionResult {
 	// Try to create SSH client
 	client, err := sd.createSSHClient(server)
 	if err != nil {
+		if IsHostKeyChanged(err) {
+			return StateDetectionResult{
+				State:   status.StateHostKeyChanged,
+				Message: fmt.Sprintf("Host key changed - verify the server, then accept the new key: %v", err),
+			}
+		}
 		return StateDetectionResult{
 			State:   status.StateNotReady,
 			Message: fmt.Sprintf("Offline - Cannot connect via SSH: %v", err),
@@ -56,184 +95,224 @@ func (sd *StateDetector) DetectState(server inventory.Server) StateDetectionResu
 	}
 	defer client.Close()
 
-	// Check provisioning status
-	provStatus := sd.checkProvisioning(client, server.SSHUser)
-	
-	// Check deployment status (only if provisioned)
-	var deplStatus DeploymentStatus
-	if provStatus.AllProvisioned {
-		deplStatus = sd.checkDeployment(client, server.SSHUser, server.AppPort)
+	probes := inventory.ResolveProbes(server.Type, sd.env.Config.Probes)
+	results := sd.runProbes(client.Client, probes, inventory.ProbeVars(sd.env, server))
+
+	// Every probe gating a state must pass to reach it
+	var notProvisioned, notDeployed []string
+	hasDeployProbes := false
+	for _, result := range results {
+		if result.Gates == inventory.ProbeGatesDeployed {
+			hasDeployProbes = true
+		}
+		if result.Passed {
+			continue
+		}
+		switch result.Gates {
+		case inventory.ProbeGatesProvisioned:
+			notProvisioned = append(notProvisioned, result.Name)
+		case inventory.ProbeGatesDeployed:
+			notDeployed = append(notDeployed, result.Name)
+		}
 	}
 
 	// Determine final state
 	var finalState status.ServerState
 	var message string
 
-	if deplStatus.AllDeployed {
+	if len(notProvisioned) > 0 {
+		finalState = status.StateReady
+		message = fmt.Sprintf("Server accessible but not provisioned (failed: %s)", strings.Join(notProvisioned, ", "))
+	} else if hasDeployProbes && len(notDeployed) == 0 {
 		finalState = status.StateDeployed
 		message = "Application deployed and running"
-	} else if provStatus.AllProvisioned {
+	} else {
 		finalState = status.StateProvisioned
 		message = "Server provisioned, ready for deployment"
-	} else {
-		finalState = status.StateReady
-		message = "Server accessible but not provisioned"
 	}
 
 	return StateDetectionResult{
-		State:              finalState,
-		Message:            message,
-		ProvisioningChecks: provStatus,
-		DeploymentChecks:   deplStatus,
+		State:   finalState,
+		Message: message,
+		Probes:  results,
 	}
 }
 
-// createSSHClient creates and returns an SSH client connection
-func (sd *StateDetector) createSSHClient(server inventory.Server) (*ssh.Client, error) {
-	keyPath := server.SSHKeyPath
-
-	// Expand home directory if needed
-	if len(keyPath) > 0 && keyPath[0] == '~' {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("cannot expand home directory: %w", err)
-		}
-		keyPath = home + keyPath[1:]
+// DetectAll detects the state of servers, at most workers at once (sequentially
+// when workers <= 1). Results are in the order of servers.
+func (sd *StateDetector) DetectAll(servers []*inventory.Server, workers int) []StateDetectionResult {
+	results := make([]StateDetectionResult, len(servers))
+	if workers < 1 {
+		workers = 1
 	}
 
-	// Read private key
-	key, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read SSH key: %w", err)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(servers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = sd.DetectState(*servers[i])
+			}
+		}()
+	}
+	for i := range servers {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	// Parse private key
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		return nil, fmt.Errorf("cannot parse SSH key: %w", err)
+	return results
+}
+
+// createSSHClient returns a pooled SSH connection to the server
+func (sd *StateDetector) createSSHClient(server inventory.Server) (*Conn, error) {
+	jump := inventory.ResolveJumpHost(server, sd.jumpHost)
+	return Connect(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump, sd.hostKeys)
+}
+
+// runProbes runs probes on the server. The shell probes share a single session,
+// each in its own subshell; the built-in checks go through the connection.
+func (sd *StateDetector) runProbes(client *ssh.Client, probes []inventory.ProbeSpec, vars map[string]string) []ProbeResult {
+	results := make([]ProbeResult, len(probes))
+
+	var script strings.Builder
+	for i, probe := range probes {
+		results[i] = ProbeResult{Name: probe.Name, Gates: probe.Gates}
+		if probe.Check != "" {
+			continue
+		}
+		if strings.TrimSpace(probe.Command) == "" {
+			results[i].Output = "no command or check configured"
+			continue
+		}
+		command := inventory.ExpandProbeCommand(probe.Command, vars)
+		fmt.Fprintf(&script, "output=$( {\n%s\n} 2>&1 )\necho \"%s%d $?\"\nprintf '%%s\\n' \"$output\"\n", command, probeMarker, i)
 	}
 
-	// Configure SSH client
-	config := &ssh.ClientConfig{
-		User: server.SSHUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+	if script.Len() > 0 {
+		answered := make(map[int]bool)
+		index, code := -1, 0
+		var lines []string
+		finish := func() {
+			if index >= 0 && index < len(probes) {
+				answered[index] = true
+				results[index].Passed, results[index].Output = probeOutcome(probes[index], code, lines)
+			}
+		}
+
+		output, err := runScript(client, script.String())
+		for _, line := range strings.Split(output, "\n") {
+			if rest, ok := strings.CutPrefix(line, probeMarker); ok {
+				finish()
+				index, code, lines = -1, 0, nil
+				if i, c, found := strings.Cut(rest, " "); found {
+					index, _ = strconv.Atoi(i)
+					code, _ = strconv.Atoi(c)
+				}
+				continue
+			}
+			lines = append(lines, line)
+		}
+		finish()
+
+		for i, probe := range probes {
+			if probe.Check == "" && !answered[i] && results[i].Output == "" {
+				results[i].Output = "no answer"
+				if err != nil {
+					results[i].Output = fmt.Sprintf("no answer: %v", err)
+				}
+			}
+		}
 	}
 
-	// Connect to server
-	address := fmt.Sprintf("%s:%d", server.IP, server.Port)
-	client, err := ssh.Dial("tcp", address, config)
-	if err != nil {
-		return nil, fmt.Errorf("connection failed: %w", err)
+	for i, probe := range probes {
+		if probe.Check != "" {
+			results[i].Passed, results[i].Output = runCheck(client, probe, vars)
+		}
 	}
 
-	return client, nil
+	return results
 }
 
-// executeCheck executes a command via SSH and returns the output
-func (sd *StateDetector) executeCheck(client *ssh.Client, command string) (string, error) {
+// runScript runs script in a new session and returns its output, closing the
+// session if it takes longer than probeTimeout
+func runScript(client *ssh.Client, script string) (string, error) {
 	session, err := client.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("cannot create session: %w", err)
 	}
 	defer session.Close()
 
-	output, err := session.CombinedOutput(command)
-	if err != nil {
-		// Command might return non-zero exit code, which is fine for checks
-		return string(output), nil
-	}
+	timer := time.AfterFunc(probeTimeout, func() { session.Close() })
+	defer timer.Stop()
 
+	// The script itself always exits 0, probe failures are in its output
+	output, err := session.CombinedOutput(script)
+	if err != nil && !timer.Stop() {
+		return string(output), fmt.Errorf("timed out after %s", probeTimeout)
+	}
 	return string(output), nil
 }
 
-// checkProvisioning checks if the server has been provisioned
-func (sd *StateDetector) checkProvisioning(client *ssh.Client, user string) ProvisioningStatus {
-	var status ProvisioningStatus
-
-	// Check Node.js installed
-	// Node.js is installed via NVM, load NVM from possible locations
-	nodeCheckCmd := fmt.Sprintf(`
-		if [ -d "/home/%s/.nvm" ]; then
-			export NVM_DIR="/home/%s/.nvm"
-		elif [ -d "$HOME/.nvm" ]; then
-			export NVM_DIR="$HOME/.nvm"
-		else
-			echo 'no' && exit 0
-		fi
-		[ -s "$NVM_DIR/nvm.sh" ] && . "$NVM_DIR/nvm.sh" && command -v node >/dev/null 2>&1 && echo 'yes' || echo 'no'
-	`, user, user)
-	output, _ := sd.executeCheck(client, nodeCheckCmd)
-	status.NodeInstalled = strings.TrimSpace(output) == "yes"
-
-	// Check Nginx installed
-	output, _ = sd.executeCheck(client, "command -v nginx >/dev/null 2>&1 && echo 'yes' || echo 'no'")
-	status.NginxInstalled = strings.TrimSpace(output) == "yes"
-
-	// Check NVM installed
-	// Try multiple possible NVM locations (aligned with Ansible provisioning)
-	// Priority: /home/user/.nvm (Ansible default) -> $HOME/.nvm (fallback)
-	nvmCheckCmd := fmt.Sprintf("test -d /home/%s/.nvm || test -d $HOME/.nvm && echo 'yes' || echo 'no'", user)
-	output, _ = sd.executeCheck(client, nvmCheckCmd)
-	status.NVMInstalled = strings.TrimSpace(output) == "yes"
-
-	// Check app directory exists
-	output, _ = sd.executeCheck(client, "test -d /var/www && echo 'yes' || echo 'no'")
-	status.AppDirExists = strings.TrimSpace(output) == "yes"
-
-	// All checks must pass for provisioned state
-	status.AllProvisioned = status.NodeInstalled && 
-	                        status.NginxInstalled && 
-	                        status.NVMInstalled && 
-	                        status.AppDirExists
-
-	return status
+// probeOutcome tells whether a shell probe passed, given its exit code and
+// output, and returns the output to report
+func probeOutcome(probe inventory.ProbeSpec, code int, lines []string) (bool, string) {
+	// Drop the trailing empty lines, keep the last ones
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	output := strings.Join(lines, "\n")
+	if len(lines) > probeOutputLines {
+		lines = lines[len(lines)-probeOutputLines:]
+	}
+	reported := strings.Join(lines, "\n")
+
+	if probe.Expect == "" {
+		if code != 0 && reported == "" {
+			reported = fmt.Sprintf("exit status %d", code)
+		}
+		return code == 0, reported
+	}
+
+	expect, err := regexp.Compile(probe.Expect)
+	if err != nil {
+		return false, fmt.Sprintf("invalid expect %q: %v", probe.Expect, err)
+	}
+	return expect.MatchString(output), reported
 }
 
-// checkDeployment checks if an application has been deployed
-func (sd *StateDetector) checkDeployment(client *ssh.Client, user string, appPort int) DeploymentStatus {
-	var status DeploymentStatus
-
-	// Check PM2 running with at least one app
-	// Load NVM from possible locations (aligned with Ansible provisioning)
-	pm2Command := fmt.Sprintf(`
-		if [ -d "/home/%s/.nvm" ]; then
-			export NVM_DIR="/home/%s/.nvm"
-		elif [ -d "$HOME/.nvm" ]; then
-			export NVM_DIR="$HOME/.nvm"
-		else
-			echo 'no' && exit 0
-		fi
-		[ -s "$NVM_DIR/nvm.sh" ] && . "$NVM_DIR/nvm.sh" && pm2 list 2>/dev/null | grep -q 'online' && echo 'yes' || echo 'no'
-	`, user, user)
-	output, _ := sd.executeCheck(client, pm2Command)
-	status.PM2Running = strings.TrimSpace(output) == "yes"
-
-	// Check app responding on port
-	// Try curl first, fallback to wget, then nc (netcat)
-	checkAppCommand := fmt.Sprintf(`
-		if command -v curl >/dev/null 2>&1; then
-			curl -s -o /dev/null -w '%%{http_code}' http://localhost:%d/ --max-time 3 2>/dev/null | grep -qE '200|307' && echo 'yes' || echo 'no'
-		elif command -v wget >/dev/null 2>&1; then
-			wget -q -O /dev/null --timeout=3 http://localhost:%d/ >/dev/null 2>&1 && echo 'yes' || echo 'no'
-		elif command -v nc >/dev/null 2>&1; then
-			echo "GET / HTTP/1.0" | nc -w 3 localhost %d >/dev/null 2>&1 && echo 'yes' || echo 'no'
-		else
-			echo 'no'
-		fi
-	`, appPort, appPort, appPort)
-	output, _ = sd.executeCheck(client, checkAppCommand)
-	status.AppResponding = strings.TrimSpace(output) == "yes"
-
-	// Check current symlink exists
-	output, _ = sd.executeCheck(client, "test -L /var/www/docker/current && echo 'yes' || echo 'no'")
-	status.CurrentSymlink = strings.TrimSpace(output) == "yes"
-
-	// All critical checks must pass for deployed state
-	status.AllDeployed = status.PM2Running && status.AppResponding
-
-	return status
+// runCheck runs the built-in check of a probe on the server through client
+func runCheck(client *ssh.Client, probe inventory.ProbeSpec, vars map[string]string) (bool, string) {
+	port := probe.Port
+	if port <= 0 {
+		port, _ = strconv.Atoi(vars["app_port"])
+	}
+	address := net.JoinHostPort("localhost", strconv.Itoa(port))
+
+	switch probe.Check {
+	case inventory.ProbeCheckTCP:
+		conn, err := client.Dial("tcp", address)
+		if err != nil {
+			return false, fmt.Sprintf("%s not listening: %v", address, err)
+		}
+		conn.Close()
+		return true, fmt.Sprintf("%s listening", address)
+
+	case inventory.ProbeCheckHTTP:
+		checker, err := health.NewChecker(inventory.HealthCheckSpec{Path: probe.Path, Timeout: 5 * time.Second}, func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return client.Dial(network, addr)
+		})
+		if err != nil {
+			return false, err.Error()
+		}
+		url := checker.URL("localhost", port)
+		if err := checker.Check(context.Background(), url); err != nil {
+			return false, err.Error()
+		}
+		return true, fmt.Sprintf("%s answered", url)
+	}
+
+	return false, fmt.Sprintf("unknown check %q (http, tcp)", probe.Check)
 }
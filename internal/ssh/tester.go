@@ -1,36 +1,27 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"net"
-	"os"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
 )
 
 // TestResult represents the result of an SSH connection test
 type TestResult struct {
-	Success bool
-	Message string
-	Latency time.Duration
+	Success        bool
+	Message        string
+	Latency        time.Duration
+	HostKeyChanged bool // The server key does not match known_hosts
+	KeyLocked      bool // The key needs its passphrase
 }
 
-// TestConnection tests SSH connectivity to a server
-func TestConnection(host string, port int, user string, keyPath string) TestResult {
+// TestConnection tests SSH connectivity to a server, through jump unless it is nil
+func TestConnection(host string, port int, user string, keyPath string, jump *inventory.JumpHost, hostKeys *HostKeys) TestResult {
 	start := time.Now()
-	
-	// Expand home directory if needed
-	if len(keyPath) > 0 && keyPath[0] == '~' {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return TestResult{
-				Success: false,
-				Message: fmt.Sprintf("Cannot expand home directory: %v", err),
-			}
-		}
-		keyPath = home + keyPath[1:]
-	}
 
 	// Check for common mistake: using .pub file
 	if len(keyPath) > 4 && keyPath[len(keyPath)-4:] == ".pub" {
@@ -40,40 +31,33 @@ func TestConnection(host string, port int, user string, keyPath string) TestResu
 		}
 	}
 
-	// Read private key
-	key, err := os.ReadFile(keyPath)
+	// Reuses the pooled connection of the server, if any
+	client, err := defaultPool.Connect(host, port, user, keyPath, jump, hostKeys)
 	if err != nil {
-		return TestResult{
-			Success: false,
-			Message: fmt.Sprintf("Cannot read SSH key: %v", err),
+		var keyErr *keyError
+		if errors.As(err, &keyErr) {
+			return TestResult{
+				Success: false,
+				Message: capitalize(err.Error()),
+			}
 		}
-	}
-
-	// Parse private key
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		return TestResult{
-			Success: false,
-			Message: fmt.Sprintf("Cannot parse SSH key: %v (ensure it's the private key, not the .pub file)", err),
+		if IsPassphraseRequired(err) {
+			return TestResult{
+				Success:   false,
+				Message:   capitalize(err.Error()),
+				KeyLocked: true,
+			}
+		}
+		if IsHostKeyChanged(err) {
+			return TestResult{
+				Success:        false,
+				Message:        fmt.Sprintf("Host key changed: %v", err),
+				HostKeyChanged: true,
+			}
 		}
-	}
-
-	// Configure SSH client
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // For local use only
-		Timeout:         10 * time.Second,
-	}
-
-	// Connect to server
-	address := fmt.Sprintf("%s:%d", host, port)
-	client, err := ssh.Dial("tcp", address, config)
-	if err != nil {
 		// Check if it's a network error
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
 			return TestResult{
 				Success: false,
 				Message: "Connection timeout",
@@ -129,51 +113,10 @@ type CommandResult struct {
 	Message string
 }
 
-// ExecuteCommand executes a command on a remote server via SSH
-func ExecuteCommand(host string, port int, user string, keyPath string, command string) CommandResult {
-	// Expand home directory if needed
-	if len(keyPath) > 0 && keyPath[0] == '~' {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return CommandResult{
-				Success: false,
-				Message: fmt.Sprintf("Cannot expand home directory: %v", err),
-			}
-		}
-		keyPath = home + keyPath[1:]
-	}
-
-	// Read private key
-	key, err := os.ReadFile(keyPath)
-	if err != nil {
-		return CommandResult{
-			Success: false,
-			Message: fmt.Sprintf("Cannot read SSH key: %v", err),
-		}
-	}
-
-	// Parse private key
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
-		return CommandResult{
-			Success: false,
-			Message: fmt.Sprintf("Cannot parse SSH key: %v", err),
-		}
-	}
-
-	// Configure SSH client
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	// Connect to server
-	address := fmt.Sprintf("%s:%d", host, port)
-	client, err := ssh.Dial("tcp", address, config)
+// ExecuteCommand executes a command on a remote server via SSH, through jump
+// unless it is nil
+func ExecuteCommand(host string, port int, user string, keyPath string, jump *inventory.JumpHost, hostKeys *HostKeys, command string) CommandResult {
+	client, err := defaultPool.Connect(host, port, user, keyPath, jump, hostKeys)
 	if err != nil {
 		return CommandResult{
 			Success: false,
@@ -208,3 +151,11 @@ func ExecuteCommand(host string, port int, user string, keyPath string, command
 		Message: "Command executed successfully",
 	}
 }
+
+// capitalize makes an error message read like the other test messages
+func capitalize(message string) string {
+	if message == "" {
+		return message
+	}
+	return strings.ToUpper(message[:1]) + message[1:]
+}
@@ -0,0 +1,156 @@
+package status
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+)
+
+// History is the append-only run history of an environment, stored as one
+// JSON line per run in inventory/<env>/.history/runs.jsonl
+type History struct {
+	mu          sync.Mutex
+	environment string
+	historyFile string
+}
+
+// HistoryFilter selects runs; empty fields match everything
+type HistoryFilter struct {
+	ServerName string
+	Action     ActionType
+	Status     string
+	Limit      int // Most recent runs only (0 = all)
+}
+
+// Match reports whether a run is selected by the filter
+func (f HistoryFilter) Match(run *ExecutionLog) bool {
+	if f.ServerName != "" && run.ServerName != f.ServerName {
+		return false
+	}
+	if f.Action != "" && run.Action != f.Action {
+		return false
+	}
+	if f.Status != "" && run.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+func NewHistory(environment string) (*History, error) {
+	historyDir := filepath.Join("inventory", environment, ".history")
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	return &History{
+		environment: environment,
+		historyFile: filepath.Join(historyDir, "runs.jsonl"),
+	}, nil
+}
+
+// Append records a run
+func (h *History) Append(run ExecutionLog) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	log.Printf("[HISTORY] Recorded %s run %s for %s: %s", run.Action, run.ID, run.ServerName, run.Status)
+	return nil
+}
+
+// List returns the runs selected by filter, most recent first
+func (h *History) List(filter HistoryFilter) ([]*ExecutionLog, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.historyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []*ExecutionLog
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var run ExecutionLog
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			// A torn line (e.g. crash while writing) must not hide the others
+			log.Printf("[HISTORY] Skipping invalid history line: %v", err)
+			continue
+		}
+		if filter.Match(&run) {
+			runs = append(runs, &run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reverse to get the most recent first
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	if filter.Limit > 0 && len(runs) > filter.Limit {
+		runs = runs[:filter.Limit]
+	}
+	return runs, nil
+}
+
+// Get returns the run with the given ID (or unique ID prefix)
+func (h *History) Get(id string) (*ExecutionLog, error) {
+	runs, err := h.List(HistoryFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var found *ExecutionLog
+	for _, run := range runs {
+		if run.ID == id {
+			return run, nil
+		}
+		if len(id) >= 4 && len(run.ID) > len(id) && run.ID[:len(id)] == id {
+			if found != nil {
+				return nil, fmt.Errorf("run ID %s is ambiguous", id)
+			}
+			found = run
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("run %s not found", id)
+	}
+	return found, nil
+}
+
+// CurrentOperator returns who runs the actions: $BOILER_OPERATOR, or the
+// system user
+func CurrentOperator() string {
+	if operator := os.Getenv("BOILER_OPERATOR"); operator != "" {
+		return operator
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
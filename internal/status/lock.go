@@ -0,0 +1,271 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LockHeartbeat is how often the holder of an environment lock refreshes it
+const LockHeartbeat = 30 * time.Second
+
+// LockStaleAfter is how long a lock held from another host may go without a
+// heartbeat before it is considered abandoned
+const LockStaleAfter = 2 * time.Minute
+
+// LockInfo describes who holds the lock of an environment
+type LockInfo struct {
+	Holder    string    `json:"holder"` // Operator, see CurrentOperator
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+	Heartbeat time.Time `json:"-"` // Last refresh, the modification time of the lock file
+}
+
+func (i LockInfo) String() string {
+	return fmt.Sprintf("%s (pid %d on %s, since %s)", i.Holder, i.PID, i.Host, i.StartedAt.Format("2006-01-02 15:04:05"))
+}
+
+// Stale reports whether the holder of the lock is gone: its process is not
+// running on this host, or it stopped refreshing the lock from another one
+func (i LockInfo) Stale() bool {
+	if host, _ := os.Hostname(); i.Host == host {
+		return !processAlive(i.PID)
+	}
+	return time.Since(i.Heartbeat) > LockStaleAfter
+}
+
+// LockedError is returned when the environment is locked by someone else
+type LockedError struct {
+	Environment string
+	Info        LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("environment %s is busy, held by %s", e.Environment, e.Info)
+}
+
+// EnvLock is the advisory lock of an environment, held while an orchestrator
+// processes its queue so that two of them never rewrite its files at once. It
+// is the file inventory/<env>/.lock.
+type EnvLock struct {
+	environment string
+	path        string
+	info        LockInfo
+	stop        chan struct{}
+	once        sync.Once
+}
+
+// LockPath returns the lock file of an environment
+func LockPath(environment string) string {
+	return filepath.Join("inventory", environment, ".lock")
+}
+
+// AcquireLock takes the lock of an environment for holder. A stale lock is
+// taken over, a live one fails with a *LockedError.
+func AcquireLock(environment, holder string) (*EnvLock, error) {
+	host, _ := os.Hostname()
+	info := LockInfo{Holder: holder, PID: os.Getpid(), Host: host, StartedAt: time.Now()}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lock: %w", err)
+	}
+
+	path := LockPath(environment)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create environment directory: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := writeLockFile(path, data)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock: %w", err)
+		}
+
+		current, inspected, readErr := readLockFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+		if current == nil {
+			continue // Released meanwhile
+		}
+		if !current.Stale() || attempt > 0 {
+			return nil, &LockedError{Environment: environment, Info: *current}
+		}
+		log.Printf("[LOCK] Taking over the stale lock of %s held by %s", environment, current)
+		if err := takeOver(path, *current, inspected); err != nil {
+			return nil, err
+		}
+	}
+
+	lock := &EnvLock{environment: environment, path: path, info: info, stop: make(chan struct{})}
+	go lock.heartbeat()
+	log.Printf("[LOCK] Acquired lock of %s", environment)
+	return lock, nil
+}
+
+// takeOver removes the stale lock file inspected, unless another process
+// took the lock over meanwhile. Takeovers hold an flock of a file next to the
+// lock, which the death of a process releases: checking that the lock file is
+// still the stale one and removing it is never interleaved with another
+// takeover.
+func takeOver(path string, stale LockInfo, inspected os.FileInfo) error {
+	guard, err := os.OpenFile(path+".takeover", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to take over stale lock: %w", err)
+	}
+	defer guard.Close()
+	if err := syscall.Flock(int(guard.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to take over stale lock: %w", err)
+	}
+	defer syscall.Flock(int(guard.Fd()), syscall.LOCK_UN)
+
+	// The inode of a removed lock may be reused by the next one, the content
+	// tells them apart
+	current, stat, err := readLockFile(path)
+	if err != nil {
+		return err
+	}
+	if current == nil || !os.SameFile(stat, inspected) || !sameLock(*current, stale) {
+		return nil // Taken over meanwhile, the retry tells by whom
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale lock: %w", err)
+	}
+	return nil
+}
+
+func sameLock(a, b LockInfo) bool {
+	return a.PID == b.PID && a.Host == b.Host && a.StartedAt.Equal(b.StartedAt) && a.Heartbeat.Equal(b.Heartbeat)
+}
+
+// writeLockFile creates the lock file, failing with os.ErrExist when it exists
+func writeLockFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// Info returns who holds the lock
+func (l *EnvLock) Info() LockInfo {
+	return l.info
+}
+
+// Release gives the lock back, unless it was forcibly taken by someone else
+func (l *EnvLock) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		current, readErr := ReadLock(l.environment)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		if current == nil || !l.owns(*current) {
+			log.Printf("[LOCK] Lock of %s was taken over, not removing it", l.environment)
+			return
+		}
+		if removeErr := os.Remove(l.path); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = fmt.Errorf("failed to remove lock: %w", removeErr)
+			return
+		}
+		log.Printf("[LOCK] Released lock of %s", l.environment)
+	})
+	return err
+}
+
+// heartbeat refreshes the lock until it is released
+func (l *EnvLock) heartbeat() {
+	ticker := time.NewTicker(LockHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(l.path, now, now); err != nil {
+				log.Printf("[LOCK] Cannot refresh lock of %s: %v", l.environment, err)
+			}
+		}
+	}
+}
+
+func (l *EnvLock) owns(info LockInfo) bool {
+	return info.PID == l.info.PID && info.Host == l.info.Host && info.StartedAt.Equal(l.info.StartedAt)
+}
+
+// ReadLock returns who holds the lock of an environment, nil when it is free
+func ReadLock(environment string) (*LockInfo, error) {
+	info, _, err := readLockFile(LockPath(environment))
+	return info, err
+}
+
+// readLockFile reads a lock file, and returns the file read with it
+func readLockFile(path string) (*LockInfo, os.FileInfo, error) {
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		// Being written, or left truncated: stale once it stops changing
+		info = LockInfo{Holder: "unknown", Host: "unknown", StartedAt: stat.ModTime()}
+	}
+	info.Heartbeat = stat.ModTime()
+	return &info, stat, nil
+}
+
+// ForceUnlock removes the lock of an environment whoever holds it, and
+// returns who did, nil when it was free
+func ForceUnlock(environment string) (*LockInfo, error) {
+	info, err := ReadLock(environment)
+	if err != nil || info == nil {
+		return info, err
+	}
+	if err := os.Remove(LockPath(environment)); err != nil && !os.IsNotExist(err) {
+		return info, fmt.Errorf("failed to remove lock: %w", err)
+	}
+	log.Printf("[LOCK] Forcibly removed lock of %s held by %s", environment, info)
+	return info, nil
+}
+
+// processAlive reports whether a process of this host is running
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
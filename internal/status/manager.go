@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
 	"github.com/bastiblast/boiler-deploy/internal/inventory"
 )
 
@@ -32,17 +33,24 @@ func NewManager(environment string) (*Manager, error) {
 	}
 
 	if err := m.Load(); err != nil {
+		log.Printf("[STATUS] Starting without statuses: %v", err)
 		return m, nil
 	}
 
 	return m, nil
 }
 
+// Load reads the statuses saved by the last run. It never writes them, the
+// environment may be held by a run of another process.
 func (m *Manager) Load() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	data, err := os.ReadFile(m.statusFile)
+	var statuses map[string]*ServerStatus
+	err := atomicfile.ReadFile(m.statusFile, func(data []byte) error {
+		statuses = nil
+		return json.Unmarshal(data, &statuses)
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -50,19 +58,26 @@ func (m *Manager) Load() error {
 		return err
 	}
 
-	var statuses map[string]*ServerStatus
-	if err := json.Unmarshal(data, &statuses); err != nil {
-		return err
+	if statuses != nil {
+		m.statuses = statuses
 	}
+	return nil
+}
 
-	m.statuses = statuses
-	
-	// Reset only "in-progress" states on load (preserve stable states like Provisioned/Deployed)
+// ResetInProgress resets the states of the actions left running by a run
+// which died. Only the holder of the environment lock may call it, the states
+// of a live run are in progress too.
+func (m *Manager) ResetInProgress() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Reset only "in-progress" states (preserve stable states like Provisioned/Deployed)
 	needsSave := false
 	for _, status := range m.statuses {
 		if status.State == StateProvisioning || 
 		   status.State == StateDeploying || 
-		   status.State == StateVerifying {
+		   status.State == StateVerifying ||
+		   status.State == StateRollingBack {
 			log.Printf("[STATUS] Resetting in-progress state for %s from %v to unknown", status.Name, status.State)
 			status.State = StateUnknown
 			status.ErrorMessage = ""
@@ -70,21 +85,38 @@ func (m *Manager) Load() error {
 		} else if status.State == StateFailed {
 			// Keep failed state but log it
 			log.Printf("[STATUS] Preserving failed state for %s: %s", status.Name, status.ErrorMessage)
-		} else if status.State == StateProvisioned || status.State == StateDeployed {
+		} else if status.State == StateProvisioned || status.State == StateDeployed || status.State == StateRolledBack {
 			// Keep stable states
 			log.Printf("[STATUS] Preserving stable state for %s: %v", status.Name, status.State)
 		}
 	}
 	
-	if needsSave {
-		if err := m.save(); err != nil {
-			log.Printf("[STATUS] Error saving reset statuses: %v", err)
-		}
+	if !needsSave {
+		return nil
+	}
+	if err := m.save(); err != nil {
+		log.Printf("[STATUS] Error saving reset statuses: %v", err)
+		return err
 	}
-
 	return nil
 }
 
+// Locked runs fn holding the lock of the environment, the statuses reloaded
+// first so that fn writes over the latest ones. It fails with a *LockedError
+// without running fn when a run holds the environment.
+func (m *Manager) Locked(fn func() error) error {
+	lock, err := AcquireLock(m.environment, CurrentOperator())
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if err := m.Load(); err != nil {
+		return err
+	}
+	return fn()
+}
+
 func (m *Manager) Save() error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -96,7 +128,7 @@ func (m *Manager) save() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(m.statusFile, data, 0644)
+	return atomicfile.WriteFileWithBackup(m.statusFile, data, 0644)
 }
 
 func (m *Manager) GetStatus(serverName string) *ServerStatus {
@@ -127,6 +159,15 @@ func (m *Manager) UpdateStatus(serverName string, state ServerState, action Acti
 		LastUpdate:   time.Now(),
 		ErrorMessage: errorMsg,
 	}
+	// Keep what the state change does not describe
+	if previous, ok := m.statuses[serverName]; ok {
+		status.ReadyChecks = previous.ReadyChecks
+		status.LastRun = previous.LastRun
+		status.Attempt = previous.Attempt
+		status.MaxAttempts = previous.MaxAttempts
+		status.Commit = previous.Commit
+		status.Ref = previous.Ref
+	}
 
 	m.statuses[serverName] = status
 	err := m.save()
@@ -138,6 +179,67 @@ func (m *Manager) UpdateStatus(serverName string, state ServerState, action Acti
 	return err
 }
 
+// RecordRun stores the outcome of the last playbook run on a server
+func (m *Manager) RecordRun(serverName string, run RunSummary) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, ok := m.statuses[serverName]
+	if !ok {
+		status = &ServerStatus{
+			Name:       serverName,
+			State:      StateUnknown,
+			LastUpdate: time.Now(),
+		}
+		m.statuses[serverName] = status
+	}
+
+	log.Printf("[STATUS] Recording %s run for %s: success=%v %s", run.Action, serverName, run.Success, run)
+	status.LastRun = &run
+	return m.save()
+}
+
+// SetAttempt records which attempt of its action a server is running
+func (m *Manager) SetAttempt(serverName string, attempt, maxAttempts int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, ok := m.statuses[serverName]
+	if !ok {
+		status = &ServerStatus{
+			Name:       serverName,
+			State:      StateUnknown,
+			LastUpdate: time.Now(),
+		}
+		m.statuses[serverName] = status
+	}
+
+	status.Attempt = attempt
+	status.MaxAttempts = maxAttempts
+	return m.save()
+}
+
+// SetCommit records the commit a server runs and the ref it was resolved
+// from, both empty when unknown
+func (m *Manager) SetCommit(serverName, commit, ref string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, ok := m.statuses[serverName]
+	if !ok {
+		status = &ServerStatus{
+			Name:       serverName,
+			State:      StateUnknown,
+			LastUpdate: time.Now(),
+		}
+		m.statuses[serverName] = status
+	}
+
+	status.Commit = commit
+	status.Ref = ref
+	return m.save()
+}
+
 func (m *Manager) UpdateReadyChecks(serverName string, checks ReadyChecks) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -155,8 +257,8 @@ func (m *Manager) UpdateReadyChecks(serverName string, checks ReadyChecks) error
 	status.LastUpdate = time.Now()
 
 	// Only update state to Ready/NotReady if not already in a more advanced state
-	// Don't overwrite Provisioned or Deployed states
-	if status.State != StateProvisioned && status.State != StateDeployed {
+	// Don't overwrite Provisioned, Deployed or RolledBack states
+	if status.State != StateProvisioned && status.State != StateDeployed && status.State != StateRolledBack {
 		if checks.IsReady() {
 			if status.State == StateUnknown || status.State == StateNotReady {
 				status.State = StateReady
@@ -173,11 +275,13 @@ func (m *Manager) UpdateReadyChecks(serverName string, checks ReadyChecks) error
 func (m *Manager) ValidateServer(server *inventory.Server) ReadyChecks {
 	checks := ReadyChecks{
 		IPValid:       isValidIP(server.IP),
-		SSHKeyExists:  fileExists(server.SSHKeyPath),
+		// Without a key file, the key must come from the SSH agent
+		SSHKeyExists:  fileExists(server.SSHKeyPath) || (server.SSHKeyPath == "" && inventory.SSHAgentAvailable()),
 		PortValid:     server.Port > 0 && server.Port <= 65535,
-		AllFieldsFilled: server.Name != "" && server.IP != "" && 
-			server.SSHKeyPath != "" && server.GitRepo != "" &&
-			server.AppPort > 0 && server.NodeVersion != "",
+		// Only the servers an application is deployed to need its repository
+		AllFieldsFilled: server.Name != "" && server.IP != "" &&
+			(!server.Deployable() || server.GitRepo != "" &&
+				server.AppPort > 0 && server.NodeVersion != ""),
 	}
 	return checks
 }
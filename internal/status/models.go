@@ -1,19 +1,26 @@
 package status
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type ServerState string
 
 const (
-	StateUnknown      ServerState = "unknown"
-	StateNotReady     ServerState = "not_ready"
-	StateReady        ServerState = "ready"
-	StateProvisioning ServerState = "provisioning"
-	StateProvisioned  ServerState = "provisioned"
-	StateDeploying    ServerState = "deploying"
-	StateDeployed     ServerState = "deployed"
-	StateFailed       ServerState = "failed"
-	StateVerifying    ServerState = "verifying"
+	StateUnknown        ServerState = "unknown"
+	StateNotReady       ServerState = "not_ready"
+	StateReady          ServerState = "ready"
+	StateProvisioning   ServerState = "provisioning"
+	StateProvisioned    ServerState = "provisioned"
+	StateDeploying      ServerState = "deploying"
+	StateDeployed       ServerState = "deployed"
+	StateFailed         ServerState = "failed"
+	StateVerifying      ServerState = "verifying"
+	StateHostKeyChanged ServerState = "host_key_changed"
+	StateRollingBack    ServerState = "rolling_back"
+	StateRolledBack     ServerState = "rolled_back" // Running an earlier release after a rollback
+	StateCancelled      ServerState = "cancelled"   // Its last action was cancelled while it ran
 )
 
 type ActionType string
@@ -23,6 +30,8 @@ const (
 	ActionProvision ActionType = "provision"
 	ActionDeploy    ActionType = "deploy"
 	ActionCheck     ActionType = "check"
+	ActionRollback  ActionType = "rollback"
+	ActionPromote   ActionType = "promote" // Recorded in history only: the deploys of a promotion are deploy actions
 )
 
 type ServerStatus struct {
@@ -32,6 +41,32 @@ type ServerStatus struct {
 	LastUpdate    time.Time   `json:"last_update"`
 	ErrorMessage  string      `json:"error_message,omitempty"`
 	ReadyChecks   ReadyChecks `json:"ready_checks"`
+	LastRun       *RunSummary `json:"last_run,omitempty"`
+	Attempt       int         `json:"attempt,omitempty"`      // Attempt of the last action, starting at 1
+	MaxAttempts   int         `json:"max_attempts,omitempty"` // Attempts allowed by auto retry
+	Commit        string      `json:"commit,omitempty"`       // Commit of the last deploy, empty when unknown (rolled back)
+	Ref           string      `json:"ref,omitempty"`          // Branch or tag Commit was resolved from
+}
+
+// AttemptString returns "attempt N/M" when the last action was retried
+func (s *ServerStatus) AttemptString() string {
+	if s.Attempt <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("attempt %d/%d", s.Attempt, s.MaxAttempts)
+}
+
+// CommitString returns the short commit deployed, followed by the ref it was
+// resolved from, e.g. "abc1234 (v1.2.0)", or "" when unknown
+func (s *ServerStatus) CommitString() string {
+	if s.Commit == "" {
+		return ""
+	}
+	commit := s.Commit[:min(7, len(s.Commit))]
+	if s.Ref == "" || s.Ref == s.Commit {
+		return commit
+	}
+	return fmt.Sprintf("%s (%s)", commit, s.Ref)
 }
 
 type ReadyChecks struct {
@@ -45,6 +80,52 @@ func (r ReadyChecks) IsReady() bool {
 	return r.IPValid && r.SSHKeyExists && r.PortValid && r.AllFieldsFilled
 }
 
+// HostStats is the PLAY RECAP line of a single host
+type HostStats struct {
+	Ok          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Unreachable int `json:"unreachable"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+	Rescued     int `json:"rescued"`
+	Ignored     int `json:"ignored"`
+}
+
+// RunSummary is the outcome of the last playbook run on a server
+type RunSummary struct {
+	Action     ActionType    `json:"action"`
+	Strategy   string        `json:"strategy,omitempty"`
+	CheckMode  bool          `json:"check_mode,omitempty"`
+	Success    bool          `json:"success"`
+	Recap      *HostStats    `json:"recap,omitempty"` // nil when the run never reached the recap
+	Elapsed    time.Duration `json:"elapsed"`
+	FinishedAt time.Time     `json:"finished_at"`
+	LogFile    string        `json:"log_file,omitempty"`
+}
+
+// Unreachable reports whether the run failed because the host could not be reached
+func (r RunSummary) Unreachable() bool {
+	return r.Recap != nil && r.Recap.Unreachable > 0
+}
+
+// NoChanges reports whether a successful run left the server untouched
+func (r RunSummary) NoChanges() bool {
+	return r.Success && r.Recap != nil && r.Recap.Changed == 0
+}
+
+func (r RunSummary) String() string {
+	elapsed := r.Elapsed.Round(time.Second)
+	summary := fmt.Sprintf("no recap (%s)", elapsed)
+	if r.Recap != nil {
+		summary = fmt.Sprintf("ok=%d changed=%d failed=%d unreachable=%d (%s)",
+			r.Recap.Ok, r.Recap.Changed, r.Recap.Failed, r.Recap.Unreachable, elapsed)
+	}
+	if r.Strategy != "" {
+		summary += " " + r.Strategy
+	}
+	return summary
+}
+
 type QueuedAction struct {
 	ID          string     `json:"id"`
 	ServerName  string     `json:"server_name"`
@@ -53,13 +134,58 @@ type QueuedAction struct {
 	QueuedAt    time.Time  `json:"queued_at"`
 	StartedAt   *time.Time `json:"started_at,omitempty"`
 	Tags        string     `json:"tags,omitempty"`
+	DependsOn   []string   `json:"depends_on,omitempty"`  // IDs of actions that must succeed first
+	Strategy    string     `json:"strategy,omitempty"`    // Deployment strategy (deploy only)
+	Rollout     string     `json:"rollout,omitempty"`     // Groups the deploys of one rolling rollout
+	Batch       int        `json:"batch,omitempty"`       // Batch index within the rollout
+	Attempt     int        `json:"attempt,omitempty"`     // Failed attempts so far
+	NotBefore   *time.Time `json:"not_before,omitempty"`  // Do not start before this time: retry backoff, or schedule when Scheduled
+	Release     string     `json:"release,omitempty"`     // Release to roll back to (rollback only, empty = previous one)
+	Interrupted bool       `json:"interrupted,omitempty"` // Was running when its process died, waits for a decision
+	Resumed     bool       `json:"resumed,omitempty"`     // Re-run after an interruption, the server state is detected first
+	Paused      bool       `json:"paused,omitempty"`      // Held in the queue until resumed, its dependents wait with it
+	Scheduled   bool       `json:"scheduled,omitempty"`   // Queued for later: NotBefore is the time it was scheduled at
+	InWindow    bool       `json:"in_window,omitempty"`   // Only starts within the maintenance window of the environment
+	Commit      string     `json:"commit,omitempty"`      // Commit the deploy checks out, resolved when queued (deploy only)
+	Ref         string     `json:"ref,omitempty"`         // Branch, tag or SHA Commit was resolved from
+	Source      string     `json:"source,omitempty"`      // Environment the commit is promoted from
+	SkipHealth  bool       `json:"skip_health,omitempty"` // Deployed without its health check (deploy only)
 }
 
+// Results of a recorded run
+const (
+	RunSucceeded   = "success"
+	RunFailed      = "failed"
+	RunSkipped     = "skipped"     // Never started: a dependency failed or its rollout stopped
+	RunInterrupted = "interrupted" // Its process died while it ran
+	RunCancelled   = "cancelled"   // Cancelled by the operator, running or still queued
+)
+
+// ExecutionLog is one entry of the run history of an environment
 type ExecutionLog struct {
+	ID         string     `json:"id"`
 	ServerName string     `json:"server_name"`
 	Action     ActionType `json:"action"`
+	Tags       string     `json:"tags,omitempty"`
+	CheckMode  bool       `json:"check_mode,omitempty"`
+	Strategy   string     `json:"strategy,omitempty"`
+	Attempt    int        `json:"attempt,omitempty"`
 	StartTime  time.Time  `json:"start_time"`
 	EndTime    *time.Time `json:"end_time,omitempty"`
 	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Recap      *HostStats `json:"recap,omitempty"`
 	LogFile    string     `json:"log_file"`
+	Operator   string     `json:"operator,omitempty"`
+	Commit     string     `json:"commit,omitempty"` // Commit deployed or promoted
+	Ref        string     `json:"ref,omitempty"`    // Branch, tag or SHA the commit was resolved from
+	Source     string     `json:"source,omitempty"` // Environment the commit was promoted from
+}
+
+// Duration returns how long the run took
+func (l *ExecutionLog) Duration() time.Duration {
+	if l.EndTime == nil {
+		return 0
+	}
+	return l.EndTime.Sub(l.StartTime)
 }
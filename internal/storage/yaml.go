@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"gopkg.in/yaml.v3"
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
 	"github.com/bastiblast/boiler-deploy/internal/inventory"
 )
 
@@ -44,7 +47,7 @@ func (s *Storage) SaveEnvironment(env inventory.Environment) error {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 	
-	if err := os.WriteFile(configPath, configData, 0644); err != nil {
+	if err := atomicfile.WriteFileWithBackup(configPath, configData, 0644); err != nil {
 		return fmt.Errorf("failed to write config: %v", err)
 	}
 	
@@ -56,7 +59,9 @@ func (s *Storage) SaveEnvironment(env inventory.Environment) error {
 	}
 	
 	hostsPath := filepath.Join(envPath, "hosts.yml")
-	if err := os.WriteFile(hostsPath, hostsData, 0644); err != nil {
+	// Generated files are only written atomically, Ansible would load their
+	// backups as inventory
+	if err := atomicfile.WriteFile(hostsPath, hostsData, 0644); err != nil {
 		return fmt.Errorf("failed to write hosts.yml: %v", err)
 	}
 	
@@ -70,7 +75,7 @@ func (s *Storage) SaveEnvironment(env inventory.Environment) error {
 			
 			if hostVarsData != nil {
 				hostVarsFile := filepath.Join(hostVarsPath, fmt.Sprintf("%s.yml", server.Name))
-				if err := os.WriteFile(hostVarsFile, hostVarsData, 0644); err != nil {
+				if err := atomicfile.WriteFile(hostVarsFile, hostVarsData, 0644); err != nil {
 					return fmt.Errorf("failed to write host_vars for %s: %v", server.Name, err)
 				}
 			}
@@ -89,10 +94,87 @@ func (s *Storage) SaveEnvironment(env inventory.Environment) error {
 	}
 	
 	groupVarsFile := filepath.Join(groupVarsPath, "all.yml")
-	if err := os.WriteFile(groupVarsFile, groupVarsData, 0644); err != nil {
+	if err := atomicfile.WriteFile(groupVarsFile, groupVarsData, 0644); err != nil {
 		return fmt.Errorf("failed to write group_vars: %v", err)
 	}
 	
+	// Database settings are written once and may be edited afterwards. The
+	// password they look up is generated along with them, never over an
+	// existing one.
+	for _, server := range env.Servers {
+		if server.Type != inventory.ServerTypeDB {
+			continue
+		}
+		dbVarsFile := filepath.Join(groupVarsPath, "dbservers.yml")
+		if _, err := os.Stat(dbVarsFile); err == nil {
+			break
+		}
+		if err := writeSecretOnce(filepath.Join(envPath, inventory.DBPasswordFile)); err != nil {
+			return fmt.Errorf("failed to generate database password: %v", err)
+		}
+		dbVarsData, err := generator.GenerateDBServersVarsYAML(env)
+		if err != nil {
+			return fmt.Errorf("failed to generate dbservers group_vars: %v", err)
+		}
+		if err := atomicfile.WriteFile(dbVarsFile, dbVarsData, 0600); err != nil {
+			return fmt.Errorf("failed to write dbservers group_vars: %v", err)
+		}
+		break
+	}
+	
+	return nil
+}
+
+// randomPassword returns a random password for the generated database user
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// writeSecretOnce writes a random password to path unless it exists. Its
+// directory is ignored by git, and the password is linked into place so that
+// it is never truncated nor written over by a concurrent save.
+func writeSecretOnce(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		if err := atomicfile.WriteFile(ignore, []byte("*\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-secret-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(password); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Link(tmp.Name(), path); err != nil && !os.IsExist(err) {
+		return err
+	}
 	return nil
 }
 
@@ -100,13 +182,15 @@ func (s *Storage) SaveEnvironment(env inventory.Environment) error {
 func (s *Storage) LoadEnvironment(name string) (*inventory.Environment, error) {
 	configPath := filepath.Join(s.basePath, "inventory", name, ".env-config.yml")
 	
-	data, err := os.ReadFile(configPath)
-	if err != nil {
+	var env inventory.Environment
+	err := atomicfile.ReadFile(configPath, func(data []byte) error {
+		env = inventory.Environment{}
+		return yaml.Unmarshal(data, &env)
+	})
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("failed to read config: %v", err)
 	}
-	
-	var env inventory.Environment
-	if err := yaml.Unmarshal(data, &env); err != nil {
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
 	}
 	
@@ -33,7 +33,7 @@ func NewConfigForm(envName string, configMgr *config.Manager) (*ConfigForm, erro
 	}
 
 	// Initialize text inputs
-	inputs := make([]textinput.Model, 5)
+	inputs := make([]textinput.Model, 8)
 	
 	// Refresh interval
 	inputs[0] = textinput.New()
@@ -60,6 +60,21 @@ func NewConfigForm(envName string, configMgr *config.Manager) (*ConfigForm, erro
 	inputs[4] = textinput.New()
 	inputs[4].Placeholder = "3"
 	inputs[4].SetValue(fmt.Sprintf("%d", cfg.MaxRetries))
+	
+	// Rolling batch size
+	inputs[5] = textinput.New()
+	inputs[5].Placeholder = "1"
+	inputs[5].SetValue(fmt.Sprintf("%d", cfg.RollingBatchSize))
+	
+	// Rolling max failure percent
+	inputs[6] = textinput.New()
+	inputs[6].Placeholder = "0"
+	inputs[6].SetValue(fmt.Sprintf("%d", cfg.RollingMaxFailurePercent))
+	
+	// Blue-green port offset
+	inputs[7] = textinput.New()
+	inputs[7].Placeholder = "1000"
+	inputs[7].SetValue(fmt.Sprintf("%d", cfg.BlueGreenPortOffset))
 
 	// Initialize tag selections
 	tagSelection := make(map[string][]bool)
@@ -109,7 +124,7 @@ func (f *ConfigForm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			
 		case "tab", "down":
 			if f.currentStep == 0 {
-				f.focused = (f.focused + 1) % (len(f.inputs) + 5) // +5 for toggles
+				f.focused = (f.focused + 1) % (len(f.inputs) + 6) // +6 for toggles
 				f.updateFocus()
 			} else if f.currentStep == 1 {
 				// Cycle through provisioning tags
@@ -136,13 +151,13 @@ func (f *ConfigForm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			
 		case "shift+tab", "up":
 			if f.currentStep == 0 {
-				f.focused = (f.focused - 1 + len(f.inputs) + 5) % (len(f.inputs) + 5)
+				f.focused = (f.focused - 1 + len(f.inputs) + 6) % (len(f.inputs) + 6)
 				f.updateFocus()
 			}
 			return f, nil
 			
 		case "enter":
-			if f.currentStep == 0 && f.focused == len(f.inputs)+4 {
+			if f.currentStep == 0 && f.focused == len(f.inputs)+5 {
 				// Save button pressed
 				if err := f.saveConfig(); err != nil {
 					f.err = err
@@ -176,6 +191,9 @@ func (f *ConfigForm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else if f.currentStep == 0 && f.focused == len(f.inputs)+3 {
 				// Auto retry toggle
 				f.config.AutoRetryEnabled = !f.config.AutoRetryEnabled
+			} else if f.currentStep == 0 && f.focused == len(f.inputs)+4 {
+				// Auto rollback toggle
+				f.config.AutoRollbackEnabled = !f.config.AutoRollbackEnabled
 			} else if f.currentStep == 0 {
 				// Move to tag selection
 				f.currentStep = 1
@@ -258,6 +276,9 @@ func (f *ConfigForm) renderGeneralSettings(b *strings.Builder) {
 		"Health Check Timeout (seconds):",
 		"Health Check Retries:",
 		"Max Retries:",
+		"Rolling Batch Size (0 = max workers):",
+		"Rolling Max Failure (% of a batch):",
+		"Blue-Green Port Offset:",
 	}
 	
 	for i, label := range labels {
@@ -309,11 +330,21 @@ func (f *ConfigForm) renderGeneralSettings(b *strings.Builder) {
 	}
 	b.WriteString(fmt.Sprintf("%s %s Auto Retry Enabled\n\n", cursor, check))
 	
-	// Save button
 	cursor = " "
 	if f.focused == len(f.inputs)+4 {
 		cursor = "▶"
 	}
+	check = "☐"
+	if f.config.AutoRollbackEnabled {
+		check = "☑"
+	}
+	b.WriteString(fmt.Sprintf("%s %s Auto Rollback on Failed Health Check\n\n", cursor, check))
+	
+	// Save button
+	cursor = " "
+	if f.focused == len(f.inputs)+5 {
+		cursor = "▶"
+	}
 	b.WriteString(fmt.Sprintf("\n%s %s\n", cursor, activeStyle.Render("[Save Configuration]")))
 }
 
@@ -398,6 +429,24 @@ func (f *ConfigForm) saveConfig() error {
 	}
 	f.config.MaxRetries = maxRetries
 	
+	batchSize, err := strconv.Atoi(f.inputs[5].Value())
+	if err != nil || batchSize < 0 {
+		return fmt.Errorf("invalid rolling batch size")
+	}
+	f.config.RollingBatchSize = batchSize
+	
+	maxFailure, err := strconv.Atoi(f.inputs[6].Value())
+	if err != nil || maxFailure < 0 || maxFailure > 100 {
+		return fmt.Errorf("invalid rolling max failure (0-100)")
+	}
+	f.config.RollingMaxFailurePercent = maxFailure
+	
+	portOffset, err := strconv.Atoi(f.inputs[7].Value())
+	if err != nil || portOffset < 1 || portOffset > 65535 {
+		return fmt.Errorf("invalid blue-green port offset")
+	}
+	f.config.BlueGreenPortOffset = portOffset
+	
 	// Build selected tags
 	f.config.ProvisioningTags = []string{}
 	for i, selected := range f.tagSelection["provisioning"] {
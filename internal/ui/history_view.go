@@ -0,0 +1,185 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/status"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// historyPageSize is the number of runs shown at once
+const historyPageSize = 20
+
+var (
+	historyActions = []status.ActionType{"", status.ActionProvision, status.ActionDeploy, status.ActionCheck, status.ActionRollback, status.ActionValidate, status.ActionPromote}
+	historyResults = []string{"", status.RunSucceeded, status.RunFailed, status.RunSkipped, status.RunInterrupted, status.RunCancelled}
+)
+
+// HistoryView browses the run history of an environment
+type HistoryView struct {
+	history *status.History
+	servers []string // "" first, meaning all servers
+	filter  status.HistoryFilter
+	runs    []*status.ExecutionLog
+	cursor  int
+	err     error
+	closed  bool
+	openLog string // Log file the user asked to open
+}
+
+func NewHistoryView(history *status.History, serverNames []string) *HistoryView {
+	hv := &HistoryView{
+		history: history,
+		servers: append([]string{""}, serverNames...),
+	}
+	hv.Reload()
+	return hv
+}
+
+// Reload reads the runs matching the current filter
+func (hv *HistoryView) Reload() {
+	hv.runs, hv.err = hv.history.List(hv.filter)
+	if hv.cursor >= len(hv.runs) {
+		hv.cursor = len(hv.runs) - 1
+	}
+	if hv.cursor < 0 {
+		hv.cursor = 0
+	}
+}
+
+// IsClosed reports whether the user left the history
+func (hv *HistoryView) IsClosed() bool {
+	return hv.closed
+}
+
+// TakeLogFile returns the log file to open, if any, and forgets it
+func (hv *HistoryView) TakeLogFile() string {
+	logFile := hv.openLog
+	hv.openLog = ""
+	return logFile
+}
+
+func (hv *HistoryView) Update(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "q", "esc":
+		hv.closed = true
+	case "up", "k":
+		if hv.cursor > 0 {
+			hv.cursor--
+		}
+	case "down", "j":
+		if hv.cursor < len(hv.runs)-1 {
+			hv.cursor++
+		}
+	case "s":
+		hv.filter.ServerName = nextString(hv.servers, hv.filter.ServerName)
+		hv.Reload()
+	case "a":
+		hv.filter.Action = nextAction(historyActions, hv.filter.Action)
+		hv.Reload()
+	case "r":
+		hv.filter.Status = nextString(historyResults, hv.filter.Status)
+		hv.Reload()
+	case "c":
+		hv.filter = status.HistoryFilter{}
+		hv.Reload()
+	case "enter", "l":
+		if hv.cursor < len(hv.runs) && hv.runs[hv.cursor].LogFile != "" {
+			hv.openLog = hv.runs[hv.cursor].LogFile
+		}
+	}
+}
+
+func (hv *HistoryView) View(environment string) string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render(fmt.Sprintf("🕘 Run History - %s", environment)) + "\n\n")
+	b.WriteString(fmt.Sprintf("Server: %s | Action: %s | Result: %s\n\n",
+		orAll(hv.filter.ServerName), orAll(string(hv.filter.Action)), orAll(hv.filter.Status)))
+
+	if hv.err != nil {
+		b.WriteString(errorStyle.Render("Error loading history: "+hv.err.Error()) + "\n")
+	} else if len(hv.runs) == 0 {
+		b.WriteString(helpStyle.Render("No runs recorded") + "\n")
+	} else {
+		headerStyle := lipgloss.NewStyle().Bold(true).Foreground(primaryColor)
+		b.WriteString(headerStyle.Render(fmt.Sprintf("  %-19s %-15s %-14s %-11s %-9s %-24s %s",
+			"STARTED", "SERVER", "ACTION", "RESULT", "DURATION", "RECAP", "OPERATOR")) + "\n")
+
+		start := 0
+		if hv.cursor >= historyPageSize {
+			start = hv.cursor - historyPageSize + 1
+		}
+		end := min(start+historyPageSize, len(hv.runs))
+		for i := start; i < end; i++ {
+			b.WriteString(hv.renderRun(hv.runs[i], i == hv.cursor) + "\n")
+		}
+
+		if run := hv.runs[hv.cursor]; run.Error != "" {
+			b.WriteString("\n" + errorStyle.Render(truncate(run.Error, 120)) + "\n")
+		}
+	}
+
+	b.WriteString("\n" + helpStyle.Render("[↑↓] Navigate | [Enter] Open Log | [s] Server | [a] Action | [r] Result | [c] Clear Filters | [Esc] Back") + "\n")
+	return b.String()
+}
+
+func (hv *HistoryView) renderRun(run *status.ExecutionLog, selected bool) string {
+	action := string(run.Action)
+	if run.CheckMode {
+		action += " (check)"
+	}
+
+	var result string
+	switch run.Status {
+	case status.RunSucceeded:
+		result = successStyle.Render(fmt.Sprintf("%-11s", run.Status))
+	case status.RunFailed:
+		result = errorStyle.Render(fmt.Sprintf("%-11s", run.Status))
+	default:
+		result = helpStyle.UnsetMarginTop().Render(fmt.Sprintf("%-11s", run.Status))
+	}
+
+	recap := "-"
+	if run.Recap != nil {
+		recap = fmt.Sprintf("ok=%d changed=%d failed=%d", run.Recap.Ok, run.Recap.Changed, run.Recap.Failed)
+	} else if run.Source != "" {
+		recap = strings.TrimSpace(fmt.Sprintf("%s from %s", run.Commit[:min(7, len(run.Commit))], run.Source))
+	}
+
+	prefix := "  "
+	if selected {
+		prefix = "> "
+	}
+	return fmt.Sprintf("%s%-19s %-15s %-14s %s %-9s %-24s %s", prefix,
+		run.StartTime.Format("2006-01-02 15:04:05"), truncate(run.ServerName, 15), truncate(action, 14),
+		result, run.Duration().Round(time.Second), recap, run.Operator)
+}
+
+func nextString(values []string, current string) string {
+	for i, v := range values {
+		if v == current {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
+
+func nextAction(values []status.ActionType, current status.ActionType) status.ActionType {
+	for i, v := range values {
+		if v == current {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
+
+func orAll(value string) string {
+	if value == "" {
+		return "all"
+	}
+	return value
+}
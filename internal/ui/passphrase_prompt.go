@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bastiblast/boiler-deploy/internal/ssh"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// PassphrasePrompt asks the passphrase of each locked SSH key in turn. The
+// unlocked keys are kept in memory for the session only.
+type PassphrasePrompt struct {
+	keys      []string
+	current   int
+	input     textinput.Model
+	err       string
+	cancelled bool
+}
+
+func NewPassphrasePrompt(keys []string) *PassphrasePrompt {
+	input := textinput.New()
+	input.Placeholder = "passphrase"
+	input.EchoMode = textinput.EchoPassword
+	input.EchoCharacter = '•'
+	input.CharLimit = 256
+	input.Width = 40
+	input.Focus()
+
+	return &PassphrasePrompt{keys: keys, input: input}
+}
+
+// IsDone reports whether all the keys were unlocked
+func (pp *PassphrasePrompt) IsDone() bool {
+	return pp.current >= len(pp.keys)
+}
+
+// IsCancelled reports whether the user gave up unlocking the keys
+func (pp *PassphrasePrompt) IsCancelled() bool {
+	return pp.cancelled
+}
+
+func (pp *PassphrasePrompt) Update(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		pp.cancelled = true
+		return nil
+
+	case "enter":
+		passphrase := []byte(pp.input.Value())
+		err := ssh.UnlockKey(pp.keys[pp.current], passphrase)
+		for i := range passphrase {
+			passphrase[i] = 0
+		}
+		pp.input.Reset()
+
+		switch {
+		case errors.Is(err, ssh.ErrWrongPassphrase):
+			pp.err = "Wrong passphrase, try again"
+		case err != nil:
+			pp.err = err.Error()
+		default:
+			pp.err = ""
+			pp.current++
+		}
+		return nil
+	}
+
+	var cmd tea.Cmd
+	pp.input, cmd = pp.input.Update(msg)
+	return cmd
+}
+
+func (pp *PassphrasePrompt) View() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("🔑 SSH Key Passphrase"))
+	b.WriteString("\n\n")
+
+	if pp.IsDone() {
+		return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
+	}
+
+	if len(pp.keys) > 1 {
+		b.WriteString(fmt.Sprintf("Key %d/%d: ", pp.current+1, len(pp.keys)))
+	} else {
+		b.WriteString("Key: ")
+	}
+	b.WriteString(pp.keys[pp.current])
+	b.WriteString("\n\n")
+	b.WriteString(pp.input.View())
+	b.WriteString("\n")
+
+	if pp.err != "" {
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render(pp.err))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("The key stays unlocked until the application exits. Use ssh-add to unlock it for good."))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("[Enter] Unlock  [Esc] Cancel"))
+
+	return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
+}
@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// PromotionPrompt asks an operator to approve the promotion of the commit
+// running in the previous environment of the pipeline
+type PromotionPrompt struct {
+	source   *ansible.PromotionSource
+	target   string
+	servers  []string // Web servers of the target it is deployed to
+	approved bool
+	rejected bool
+}
+
+func NewPromotionPrompt(source *ansible.PromotionSource, target string, servers []string) *PromotionPrompt {
+	return &PromotionPrompt{source: source, target: target, servers: servers}
+}
+
+// IsApproved reports whether the operator approved the promotion
+func (pp *PromotionPrompt) IsApproved() bool {
+	return pp.approved
+}
+
+// IsRejected reports whether the operator rejected the promotion
+func (pp *PromotionPrompt) IsRejected() bool {
+	return pp.rejected
+}
+
+func (pp *PromotionPrompt) Update(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "y":
+		pp.approved = true
+	case "n", "esc", "q":
+		pp.rejected = true
+	}
+}
+
+func (pp *PromotionPrompt) View() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render(fmt.Sprintf("⇧ Promote %s → %s", pp.source.Environment, pp.target)))
+	b.WriteString("\n\n")
+	b.WriteString(fmt.Sprintf("  Commit:      %s\n", pp.source.Commit))
+	b.WriteString(fmt.Sprintf("  Verified on: %s\n", strings.Join(pp.source.Servers, ", ")))
+	b.WriteString(fmt.Sprintf("  Deploy to:   %s\n", strings.Join(pp.servers, ", ")))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("This promotion requires approval. It is recorded in the history either way."))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("[y] Approve  [n/Esc] Reject"))
+
+	return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
+}
@@ -0,0 +1,215 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// queuePaneSize is the number of queued actions shown at once
+const queuePaneSize = 10
+
+// QueuePane is the focused queue of the workflow view: it lists the queued
+// actions and reorders, re-prioritizes, pauses or removes them
+type QueuePane struct {
+	environment  string
+	orchestrator *ansible.Orchestrator
+	actions      []*status.QueuedAction
+	cursor       int
+	holder       *status.LockInfo // Another run holding the environment, the queue is read only then
+	message      string           // Outcome of the last edit
+	closed       bool
+}
+
+func NewQueuePane(environment string, orchestrator *ansible.Orchestrator) *QueuePane {
+	qp := &QueuePane{environment: environment, orchestrator: orchestrator}
+	qp.Reload()
+	return qp
+}
+
+// Reload reads the queue again, keeping the cursor on the selected action
+func (qp *QueuePane) Reload() {
+	selected := qp.selected()
+
+	qp.holder = nil
+	if info, _ := status.ReadLock(qp.environment); info != nil && !info.Stale() && !qp.orchestrator.IsRunning() {
+		qp.holder = info
+	}
+	if qp.holder != nil {
+		// The queue as saved by the run holding the environment
+		if queue, err := ansible.NewQueue(qp.environment); err == nil {
+			qp.actions = queue.GetAll()
+		}
+	} else {
+		qp.actions = qp.orchestrator.GetQueuedActions()
+	}
+
+	if selected != nil {
+		for i, action := range qp.actions {
+			if action.ID == selected.ID {
+				qp.cursor = i
+			}
+		}
+	}
+	if qp.cursor >= len(qp.actions) {
+		qp.cursor = len(qp.actions) - 1
+	}
+	if qp.cursor < 0 {
+		qp.cursor = 0
+	}
+}
+
+// IsClosed reports whether the user gave the focus back to the servers
+func (qp *QueuePane) IsClosed() bool {
+	return qp.closed
+}
+
+func (qp *QueuePane) selected() *status.QueuedAction {
+	if qp.cursor < len(qp.actions) {
+		return qp.actions[qp.cursor]
+	}
+	return nil
+}
+
+func (qp *QueuePane) Update(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "tab", "esc", "q":
+		qp.closed = true
+		return
+	case "up", "k":
+		if qp.cursor > 0 {
+			qp.cursor--
+		}
+		return
+	case "down", "j":
+		if qp.cursor < len(qp.actions)-1 {
+			qp.cursor++
+		}
+		return
+	case "r":
+		qp.message = ""
+		qp.Reload()
+		return
+	}
+
+	action := qp.selected()
+	if action == nil {
+		return
+	}
+	if qp.holder != nil {
+		qp.message = fmt.Sprintf("Read only, the queue is run by %s", qp.holder)
+		return
+	}
+
+	var err error
+	switch msg.String() {
+	case "K", "shift+up":
+		err = qp.orchestrator.MoveAction(action.ID, qp.cursor-1)
+		qp.message = fmt.Sprintf("Moved %s on %s up", action.Action, action.ServerName)
+	case "J", "shift+down":
+		err = qp.orchestrator.MoveAction(action.ID, qp.cursor+1)
+		qp.message = fmt.Sprintf("Moved %s on %s down", action.Action, action.ServerName)
+	case "+", "=":
+		err = qp.orchestrator.SetActionPriority(action.ID, action.Priority+1)
+		qp.message = fmt.Sprintf("Priority of %s on %s raised to %d", action.Action, action.ServerName, action.Priority+1)
+	case "-":
+		err = qp.orchestrator.SetActionPriority(action.ID, action.Priority-1)
+		qp.message = fmt.Sprintf("Priority of %s on %s lowered to %d", action.Action, action.ServerName, action.Priority-1)
+	case "p", " ":
+		if action.Paused {
+			err = qp.orchestrator.ResumeAction(action.ID)
+			qp.message = fmt.Sprintf("Resumed %s on %s", action.Action, action.ServerName)
+		} else {
+			err = qp.orchestrator.PauseAction(action.ID)
+			qp.message = fmt.Sprintf("Paused %s on %s", action.Action, action.ServerName)
+		}
+	case "d", "delete":
+		// A running action is cancelled, a queued one removed
+		_, err = qp.orchestrator.CancelAction(action.ID)
+		qp.message = fmt.Sprintf("Cancelled %s on %s", action.Action, action.ServerName)
+	default:
+		return
+	}
+
+	if errors.Is(err, ansible.ErrActionRunning) {
+		qp.message = fmt.Sprintf("%s on %s is running, only [d] cancels it", action.Action, action.ServerName)
+	} else if err != nil {
+		qp.message = fmt.Sprintf("✗ %v", err)
+	}
+	qp.Reload()
+}
+
+// View renders the queued actions, highest priority first
+func (qp *QueuePane) View() string {
+	var b strings.Builder
+
+	if len(qp.actions) == 0 {
+		b.WriteString(helpStyle.Render("The queue is empty") + "\n")
+	} else {
+		headerStyle := lipgloss.NewStyle().Bold(true).Foreground(primaryColor)
+		b.WriteString(headerStyle.Render(fmt.Sprintf("  %-3s %-8s %-20s %-10s %-8s %-12s %s",
+			"#", "ID", "SERVER", "ACTION", "PRIORITY", "STATE", "QUEUED")) + "\n")
+
+		start := 0
+		if qp.cursor >= queuePaneSize {
+			start = qp.cursor - queuePaneSize + 1
+		}
+		end := min(start+queuePaneSize, len(qp.actions))
+		for i := start; i < end; i++ {
+			action := qp.actions[i]
+			line := fmt.Sprintf("%-3d %-8s %-20s %-10s %-8d %-12s %s",
+				i+1, truncate(action.ID, 8), truncate(action.ServerName, 20), action.Action,
+				action.Priority, qp.state(action), action.QueuedAt.Format("15:04:05"))
+			if i == qp.cursor {
+				b.WriteString(selectedItemStyle.Render("▶ "+line) + "\n")
+			} else {
+				b.WriteString("  " + line + "\n")
+			}
+		}
+		if len(qp.actions) > end {
+			b.WriteString(helpStyle.Render(fmt.Sprintf("  ... %d more", len(qp.actions)-end)) + "\n")
+		}
+	}
+
+	if qp.message != "" {
+		b.WriteString(infoStyle.Render(qp.message) + "\n")
+	}
+	return b.String()
+}
+
+// state describes where a queued action stands
+func (qp *QueuePane) state(action *status.QueuedAction) string {
+	switch {
+	case action.Interrupted:
+		return "interrupted"
+	case action.StartedAt != nil:
+		return "running"
+	case action.Paused:
+		return "paused"
+	case action.Scheduled && action.NotBefore != nil && action.NotBefore.After(time.Now()):
+		return "at " + action.NotBefore.Format("01-02 15:04")
+	case action.NotBefore != nil && action.NotBefore.After(time.Now()):
+		return "retry " + action.NotBefore.Format("15:04")
+	case action.InWindow:
+		return "in window"
+	}
+	for _, dep := range action.DependsOn {
+		for _, other := range qp.actions {
+			if other.ID == dep {
+				return "waiting"
+			}
+		}
+	}
+	return "queued"
+}
+
+// Help lists the keys of the pane
+func (qp *QueuePane) Help() string {
+	return helpStyle.Render("[↑↓] Select | [K/J] Move Up/Down | [+/-] Priority | [p] Pause/Resume | [d] Cancel/Remove | [r] Refresh | [Tab/Esc] Servers")
+}
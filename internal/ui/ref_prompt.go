@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// RefPrompt asks which branch, tag or commit SHA the selected servers are
+// deployed from instead of their git_branch
+type RefPrompt struct {
+	servers   []string
+	input     textinput.Model
+	err       string
+	confirmed bool
+	cancelled bool
+}
+
+func NewRefPrompt(servers []string) *RefPrompt {
+	input := textinput.New()
+	input.Placeholder = "v1.2.0"
+	input.CharLimit = 128
+	input.Width = 40
+	input.Focus()
+
+	return &RefPrompt{servers: servers, input: input}
+}
+
+// IsConfirmed reports whether the deploy was confirmed with a ref
+func (rp *RefPrompt) IsConfirmed() bool {
+	return rp.confirmed
+}
+
+// IsCancelled reports whether the user gave up the deploy
+func (rp *RefPrompt) IsCancelled() bool {
+	return rp.cancelled
+}
+
+// Ref returns the branch, tag or commit SHA to deploy
+func (rp *RefPrompt) Ref() string {
+	return strings.TrimSpace(rp.input.Value())
+}
+
+func (rp *RefPrompt) Update(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		rp.cancelled = true
+		return nil
+
+	case "enter":
+		switch ref := rp.Ref(); {
+		case ref == "":
+			rp.err = "Enter a branch, tag or commit SHA"
+		case strings.ContainsAny(ref, " \t") || strings.HasPrefix(ref, "-"):
+			rp.err = "Invalid ref " + ref
+		default:
+			rp.confirmed = true
+		}
+		return nil
+	}
+
+	var cmd tea.Cmd
+	rp.input, cmd = rp.input.Update(msg)
+	return cmd
+}
+
+func (rp *RefPrompt) View() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("📌 Deploy a Ref"))
+	b.WriteString("\n\n")
+	b.WriteString("Servers: ")
+	b.WriteString(strings.Join(rp.servers, ", "))
+	b.WriteString("\n\n")
+	b.WriteString("Branch, tag or full commit SHA to deploy:\n")
+	b.WriteString(rp.input.View())
+	b.WriteString("\n")
+
+	if rp.err != "" {
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render(rp.err))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("It is resolved to a commit once, every server gets the same one"))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("[Enter] Deploy  [Esc] Cancel"))
+
+	return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
+}
@@ -0,0 +1,191 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bastiblast/boiler-deploy/internal/ssh"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// releasesLoadedMsg carries the releases listed on the servers
+type releasesLoadedMsg struct {
+	results []ssh.ServerReleases
+}
+
+// releaseRow is a line of the releases view the cursor can be on
+type releaseRow struct {
+	server  int
+	release int
+}
+
+// ReleasesView shows the releases deployed on each web server, the current one
+// highlighted and the servers drifting from the others flagged
+type ReleasesView struct {
+	results  []ssh.ServerReleases
+	rows     []releaseRow
+	cursor   int
+	loading  bool
+	closed   bool
+	reload   bool
+	rollback *releaseRow // Release the user asked to roll back to
+}
+
+func NewReleasesView() *ReleasesView {
+	return &ReleasesView{loading: true}
+}
+
+// SetResults shows the listed releases
+func (rv *ReleasesView) SetResults(results []ssh.ServerReleases) {
+	rv.results = results
+	rv.loading = false
+	rv.rows = rv.rows[:0]
+	for i, result := range results {
+		for j := range result.Releases {
+			rv.rows = append(rv.rows, releaseRow{server: i, release: j})
+		}
+	}
+	if rv.cursor >= len(rv.rows) {
+		rv.cursor = max(len(rv.rows)-1, 0)
+	}
+}
+
+// IsClosed reports whether the user left the releases view
+func (rv *ReleasesView) IsClosed() bool {
+	return rv.closed
+}
+
+// TakeReload reports whether the user asked to list the releases again
+func (rv *ReleasesView) TakeReload() bool {
+	reload := rv.reload
+	rv.reload = false
+	if reload {
+		rv.loading = true
+	}
+	return reload
+}
+
+// TakeRollback returns the server and release the user asked to roll back to,
+// if any, and forgets them
+func (rv *ReleasesView) TakeRollback() (string, string, bool) {
+	if rv.rollback == nil {
+		return "", "", false
+	}
+	row := *rv.rollback
+	rv.rollback = nil
+	result := rv.results[row.server]
+	return result.Server, result.Releases[row.release].ID, true
+}
+
+func (rv *ReleasesView) Update(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "q", "esc":
+		rv.closed = true
+	case "up", "k":
+		if rv.cursor > 0 {
+			rv.cursor--
+		}
+	case "down", "j":
+		if rv.cursor < len(rv.rows)-1 {
+			rv.cursor++
+		}
+	case "r":
+		if !rv.loading {
+			rv.reload = true
+		}
+	case "b":
+		if !rv.loading && rv.cursor < len(rv.rows) {
+			row := rv.rows[rv.cursor]
+			if !rv.results[row.server].Releases[row.release].Current {
+				rv.rollback = &row
+			}
+		}
+	}
+}
+
+func (rv *ReleasesView) View(environment string) string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render(fmt.Sprintf("📦 Releases - %s", environment)) + "\n\n")
+
+	switch {
+	case rv.loading:
+		b.WriteString(helpStyle.Render("Listing releases over SSH...") + "\n")
+	case len(rv.results) == 0:
+		b.WriteString(helpStyle.Render("No web servers in this environment") + "\n")
+	default:
+		headerStyle := lipgloss.NewStyle().Bold(true).Foreground(primaryColor)
+		warningStyle := lipgloss.NewStyle().Foreground(warningColor).Bold(true)
+		currentStyle := lipgloss.NewStyle().Foreground(successColor)
+
+		row := 0
+		for _, result := range rv.results {
+			header := result.Server
+			if result.Current != "" {
+				header += "  current: " + result.Current
+			}
+			b.WriteString(headerStyle.Render(header))
+			if result.Drift != "" {
+				b.WriteString("  " + warningStyle.Render("⚠ drift: "+result.Drift))
+			}
+			b.WriteString("\n")
+
+			if result.Err != nil {
+				b.WriteString("    " + errorStyle.Render(truncate(result.Err.Error(), 110)) + "\n\n")
+				continue
+			}
+			if len(result.Releases) == 0 {
+				b.WriteString("    " + helpStyle.UnsetMarginTop().Render("No releases") + "\n\n")
+				continue
+			}
+
+			for _, release := range result.Releases {
+				prefix := "  "
+				if row == rv.cursor {
+					prefix = "> "
+				}
+				mark := " "
+				if release.Current {
+					mark = "●"
+				}
+				deployed := "-"
+				if !release.DeployedAt.IsZero() {
+					deployed = release.DeployedAt.Format("2006-01-02 15:04")
+				}
+				branch := release.Branch
+				if branch == "" {
+					branch = "-"
+				}
+				line := fmt.Sprintf("%s%s %-32s %-8s %-14s %-16s %9s", prefix, mark,
+					truncate(release.ID, 32), release.ShortCommit(), truncate(branch, 14), deployed, formatSizeKB(release.SizeKB))
+				switch {
+				case row == rv.cursor:
+					line = selectedItemStyle.UnsetPaddingLeft().Render(line)
+				case release.Current:
+					line = currentStyle.Render(line)
+				}
+				b.WriteString("  " + line + "\n")
+				row++
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString(helpStyle.Render("● current release | [↑↓] Navigate | [b] Roll Back to Release | [r] Reload | [Esc] Back") + "\n")
+	return b.String()
+}
+
+// formatSizeKB formats a disk usage in KB for humans
+func formatSizeKB(kb int64) string {
+	switch {
+	case kb <= 0:
+		return "-"
+	case kb < 1024:
+		return fmt.Sprintf("%d KB", kb)
+	case kb < 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(kb)/1024)
+	default:
+		return fmt.Sprintf("%.1f GB", float64(kb)/(1024*1024))
+	}
+}
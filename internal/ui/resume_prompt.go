@@ -0,0 +1,68 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ResumePrompt asks what to do with the actions interrupted by the death of
+// the process running them: re-run, skip or clear them, or decide later
+type ResumePrompt struct {
+	actions    []*status.QueuedAction
+	resolution string
+	later      bool
+}
+
+func NewResumePrompt(actions []*status.QueuedAction) *ResumePrompt {
+	return &ResumePrompt{actions: actions}
+}
+
+// Resolution returns the decision of the user (see ansible.ResumeRerun), empty
+// while undecided
+func (rp *ResumePrompt) Resolution() string {
+	return rp.resolution
+}
+
+// IsPostponed reports whether the user left the actions waiting
+func (rp *ResumePrompt) IsPostponed() bool {
+	return rp.later
+}
+
+func (rp *ResumePrompt) Update(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "r":
+		rp.resolution = ansible.ResumeRerun
+	case "s":
+		rp.resolution = ansible.ResumeSkip
+	case "c":
+		rp.resolution = ansible.ResumeClear
+	case "esc", "q":
+		rp.later = true
+	}
+}
+
+func (rp *ResumePrompt) View() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("⚠️  Interrupted Actions"))
+	b.WriteString("\n\n")
+	b.WriteString("These actions were running when the previous session stopped:\n\n")
+	for _, action := range rp.actions {
+		started := "-"
+		if action.StartedAt != nil {
+			started = action.StartedAt.Format("2006-01-02 15:04:05")
+		}
+		b.WriteString(fmt.Sprintf("  %-10s %-20s started %s\n", action.Action, truncate(action.ServerName, 20), started))
+	}
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("Re-run detects the state of each server first. Skip also skips the actions waiting for them."))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("[r] Re-run  [s] Skip  [c] Clear Queue  [Esc] Decide Later ([i] in the workflow)"))
+
+	return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
+}
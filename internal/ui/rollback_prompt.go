@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// RollbackPrompt asks which release the selected servers roll back to. An
+// empty release rolls back to the one deployed before the current one.
+type RollbackPrompt struct {
+	servers   []string
+	input     textinput.Model
+	err       string
+	confirmed bool
+	cancelled bool
+}
+
+func NewRollbackPrompt(servers []string) *RollbackPrompt {
+	input := textinput.New()
+	input.Placeholder = "previous release"
+	input.CharLimit = 128
+	input.Width = 40
+	input.Focus()
+
+	return &RollbackPrompt{servers: servers, input: input}
+}
+
+// IsConfirmed reports whether the rollback was confirmed with a valid release
+func (rp *RollbackPrompt) IsConfirmed() bool {
+	return rp.confirmed
+}
+
+// IsCancelled reports whether the user gave up the rollback
+func (rp *RollbackPrompt) IsCancelled() bool {
+	return rp.cancelled
+}
+
+// Release returns the release to roll back to, empty for the previous one
+func (rp *RollbackPrompt) Release() string {
+	return strings.TrimSpace(rp.input.Value())
+}
+
+func (rp *RollbackPrompt) Update(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		rp.cancelled = true
+		return nil
+
+	case "enter":
+		if err := ansible.ValidateReleaseID(rp.Release()); err != nil {
+			rp.err = err.Error()
+			return nil
+		}
+		rp.confirmed = true
+		return nil
+	}
+
+	var cmd tea.Cmd
+	rp.input, cmd = rp.input.Update(msg)
+	return cmd
+}
+
+func (rp *RollbackPrompt) View() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("↩️  Rollback"))
+	b.WriteString("\n\n")
+	b.WriteString("Servers: ")
+	b.WriteString(strings.Join(rp.servers, ", "))
+	b.WriteString("\n\n")
+	b.WriteString("Release to roll back to (empty for the previous release):\n")
+	b.WriteString(rp.input.View())
+	b.WriteString("\n")
+
+	if rp.err != "" {
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render(rp.err))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("Releases are the directories of /var/www/<app>/releases, e.g. 20240131T120000_abc1234"))
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("[Enter] Roll back  [Esc] Cancel"))
+
+	return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
+}
@@ -58,6 +58,8 @@ func NewServerForm(env *inventory.Environment, editServer ...*inventory.Server)
 	if env.MonoSSHKey && env.MonoSSHKeyPath != "" {
 		inputs[4].Placeholder = env.MonoSSHKeyPath
 		inputs[4].SetValue(env.MonoSSHKeyPath)
+	} else if inventory.SSHAgentAvailable() {
+		inputs[4].Placeholder = "empty = use ssh-agent"
 	} else {
 		inputs[4].Placeholder = "~/.ssh/id_rsa"
 	}
@@ -296,7 +298,8 @@ func (f *ServerForm) buildServer() (*inventory.Server, error) {
 	}
 
 	sshKeyPath := f.inputs[4].Value()
-	if sshKeyPath == "" {
+	// Without a key path the SSH agent provides the key
+	if sshKeyPath == "" && !inventory.SSHAgentAvailable() {
 		sshKeyPath = "~/.ssh/id_rsa"
 	}
 	
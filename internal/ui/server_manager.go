@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -97,21 +98,12 @@ func (m ServerManager) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Update SSH status with detected state
 				stateDisplay := string(msg.detectedState.State)
 				m.environment.Servers[msg.index].SSHStatus = fmt.Sprintf("✓ %s - %s", stateDisplay, msg.detectedState.Message)
-				m.message = fmt.Sprintf("✓ SSH test passed for '%s' - State: %s", m.environment.Servers[msg.index].Name, stateDisplay)
+				m.message = fmt.Sprintf("✓ SSH test passed for '%s' - State: %s\nProbes: %s",
+					m.environment.Servers[msg.index].Name, stateDisplay, msg.detectedState.ProbeSummary())
 				m.messageType = "success"
 				
 				// Update Status Manager with detected state
-				if m.statusMgr != nil {
-					m.statusMgr.UpdateStatus(
-						m.environment.Servers[msg.index].Name,
-						msg.detectedState.State,
-						status.ActionCheck,
-						msg.detectedState.Message,
-					)
-					if err := m.statusMgr.Save(); err != nil {
-						log.Printf("Warning: Could not save status: %v", err)
-					}
-				}
+				m.recordState(m.environment.Servers[msg.index].Name, msg.detectedState.State, msg.detectedState.Message)
 			} else {
 				m.environment.Servers[msg.index].SSHStatus = "✗ " + msg.result.Message
 				m.message = fmt.Sprintf("✗ SSH test failed for '%s': %s", 
@@ -119,17 +111,11 @@ func (m ServerManager) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.messageType = "error"
 				
 				// Update Status Manager with not ready state
-				if m.statusMgr != nil {
-					m.statusMgr.UpdateStatus(
-						m.environment.Servers[msg.index].Name,
-						status.StateNotReady,
-						status.ActionCheck,
-						"SSH connection failed",
-					)
-					if err := m.statusMgr.Save(); err != nil {
-						log.Printf("Warning: Could not save status: %v", err)
-					}
+				state, reason := status.StateNotReady, "SSH connection failed"
+				if msg.result.HostKeyChanged {
+					state, reason = status.StateHostKeyChanged, msg.result.Message
 				}
+				m.recordState(m.environment.Servers[msg.index].Name, state, reason)
 			}
 		}
 		return m, nil
@@ -211,15 +197,20 @@ func (m ServerManager) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				
 				server := m.environment.Servers[m.cursor]
 				idx := m.cursor
+				hostKeys := ssh.NewHostKeys(m.environment.Name)
+				jump := m.environment.JumpHostFor(server)
+				envJump := m.environment.Config.JumpHost
+				env := *m.environment
 				
 				// Run SSH test + state detection asynchronously
 				return m, func() tea.Msg {
-					result := ssh.TestConnection(server.IP, server.Port, server.SSHUser, server.SSHKeyPath)
+					result := ssh.TestConnection(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, jump, hostKeys)
 					
 					var stateResult ssh.StateDetectionResult
 					if result.Success {
 						// If SSH works, detect the actual state
-						detector := ssh.NewStateDetector()
+						detector := ssh.NewStateDetector(hostKeys, envJump)
+						detector.SetEnvironment(env)
 						stateResult = detector.DetectState(server)
 					}
 					
@@ -241,14 +232,25 @@ func (m ServerManager) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				
 				// Capture environment servers for the closure
 				servers := m.environment.Servers
+				hostKeys := ssh.NewHostKeys(m.environment.Name)
+				env := *m.environment
 				
-				// Test all servers sequentially
+				// Test the servers concurrently, a bounded number at a time
 				return m, func() tea.Msg {
-					var results []sshTestResultMsg
+					results := make([]sshTestResultMsg, len(servers))
+					slots := make(chan struct{}, ssh.DefaultDetectWorkers)
+					var wg sync.WaitGroup
 					for i, server := range servers {
-						result := ssh.TestConnection(server.IP, server.Port, server.SSHUser, server.SSHKeyPath)
-						results = append(results, sshTestResultMsg{index: i, result: result})
+						wg.Add(1)
+						slots <- struct{}{}
+						go func(i int, server inventory.Server) {
+							defer wg.Done()
+							defer func() { <-slots }()
+							result := ssh.TestConnection(server.IP, server.Port, server.SSHUser, server.SSHKeyPath, env.JumpHostFor(server), hostKeys)
+							results[i] = sshTestResultMsg{index: i, result: result}
+						}(i, server)
 					}
+					wg.Wait()
 					return sshTestAllResultsMsg{results: results}
 				}
 			}
@@ -355,6 +357,20 @@ func (m ServerManager) View() string {
 	return lipgloss.NewStyle().Margin(1, 2).Render(b.String())
 }
 
+// recordState records the state an SSH test detected. A run holding the
+// environment detects the states itself, it is left alone.
+func (m ServerManager) recordState(serverName string, state status.ServerState, reason string) {
+	if m.statusMgr == nil {
+		return
+	}
+	err := m.statusMgr.Locked(func() error {
+		return m.statusMgr.UpdateStatus(serverName, state, status.ActionCheck, reason)
+	})
+	if err != nil {
+		log.Printf("Warning: Could not save status: %v", err)
+	}
+}
+
 // Helper function to truncate strings
 func truncate(s string, max int) string {
 	if len(s) <= max {
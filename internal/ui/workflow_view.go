@@ -1,6 +1,8 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -8,9 +10,11 @@ import (
 	"time"
 
 	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
 	"github.com/bastiblast/boiler-deploy/internal/config"
 	"github.com/bastiblast/boiler-deploy/internal/inventory"
 	"github.com/bastiblast/boiler-deploy/internal/logging"
+	"github.com/bastiblast/boiler-deploy/internal/ssh"
 	"github.com/bastiblast/boiler-deploy/internal/status"
 	"github.com/bastiblast/boiler-deploy/internal/storage"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -22,6 +26,8 @@ type WorkflowView struct {
 	mu                 sync.Mutex // Protects concurrent access to shared state
 	environment        string
 	servers            []*inventory.Server
+	jumpHost           *inventory.JumpHost // Jump host of the environment
+	env                inventory.Environment
 	statuses           map[string]*status.ServerStatus
 	selectedServers    map[string]bool
 	cursor             int
@@ -47,11 +53,49 @@ type WorkflowView struct {
 	configMgr          *config.Manager
 	configOpts         *config.ConfigOptions
 	deploySuccessChan  chan deploySuccessMsg // Channel for deploy success events
+	historyView        *HistoryView
+	showHistory        bool
+	passphrasePrompt   *PassphrasePrompt
+	pendingKey         tea.KeyMsg // Action replayed once the SSH keys are unlocked
+	rollbackPrompt     *RollbackPrompt
+	refPrompt          *RefPrompt
+	resumePrompt       *ResumePrompt
+	queuePane          *QueuePane // Focused queue, nil while the server table has the focus
+	releasesView       *ReleasesView
+	drift              map[string]ssh.ServerDrift // Last version drift check, by server
+	checkingDrift      bool
+	lockedBy           string // Holder of the environment lock when the orchestrator could not start
+	promotionPrompt    *PromotionPrompt
+	promoting          bool // The source of a promotion is being inspected
 }
 
 type tickMsg time.Time
 type statusUpdateMsg struct{}
 type validationCompleteMsg struct{}
+type driftCheckedMsg struct {
+	results []ssh.ServerDrift
+}
+type cancelRequestedMsg struct {
+	lines []string
+}
+type promotionInspectedMsg struct {
+	stage  config.Stage
+	from   string
+	source *ansible.PromotionSource
+	err    error
+}
+
+// deployResolvedMsg carries the commits a deploy was resolved to, it is
+// queued when the message arrives
+type deployResolvedMsg struct {
+	servers       []string // Web servers to deploy
+	tags          string
+	provision     bool // Each deploy waits for a provision of its server with provisionTags
+	provisionTags string
+	pins          map[string]ansible.DeployPin
+	err           error
+}
+
 type deploySuccessMsg struct {
 	serverName string
 	serverIP   string
@@ -110,6 +154,8 @@ func (wv *WorkflowView) loadEnvironment() error {
 	for i := range env.Servers {
 		wv.servers[i] = &env.Servers[i]
 	}
+	wv.jumpHost = env.Config.JumpHost
+	wv.env = *env
 
 	statusMgr, err := status.NewManager(wv.environment)
 	if err != nil {
@@ -117,22 +163,20 @@ func (wv *WorkflowView) loadEnvironment() error {
 	}
 	wv.statusMgr = statusMgr
 
-	orchestrator, err := ansible.NewOrchestrator(wv.environment, statusMgr)
+	// The constructor applies config.yml (health checks, SetMaxWorkers, strategy,
+	// retries, rollback) and the maintenance window, and fails on a window which
+	// cannot be enforced: nothing is queued then
+	orchestrator, err := ansible.NewEnvironmentOrchestrator(wv.environment, env, wv.configOpts, statusMgr)
 	if err != nil {
 		return err
 	}
 	wv.orchestrator = orchestrator
 	wv.orchestrator.SetProgressCallback(wv.onProgress)
 	wv.orchestrator.SetDeploySuccessCallback(wv.onDeploySuccess)
-	wv.orchestrator.SetHealthCheckEnabled(wv.configOpts.HealthCheckEnabled)
-	wv.orchestrator.SetMaxWorkers(wv.configOpts.MaxParallelWorkers)
 
 	wv.logReader = logging.NewReader(wv.environment)
 
 	wv.refreshStatuses()
-	
-	// Auto-validate all servers on startup
-	go wv.validateAllServers()
 
 	return nil
 }
@@ -270,7 +314,15 @@ func (wv *WorkflowView) refreshStatuses() {
 }
 
 func (wv *WorkflowView) Init() tea.Cmd {
-	wv.orchestrator.Start(wv.servers)
+	// State files damaged by a crash were recovered from their backup
+	for _, warning := range atomicfile.TakeWarnings() {
+		wv.appendLogs("⚠ " + warning)
+	}
+	// Auto-validate all servers on startup, unless a run of another process
+	// holds the environment: the statuses are its own then
+	if wv.startOrchestrator() {
+		go wv.validateAllServers()
+	}
 	return tea.Batch(
 		wv.tickCmd(),
 		wv.waitForDeploySuccess(),
@@ -344,9 +396,9 @@ func (wv *WorkflowView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				action := wv.pendingAction
 				wv.pendingAction = ""
 				// Execute action and force immediate refresh
-				wv.executeActionWithTags(action, tags)
+				queued := wv.executeActionWithTags(action, tags)
 				wv.refreshStatuses()
-				return wv, wv.tickCmd()
+				return wv, tea.Batch(wv.tickCmd(), queued)
 			} else if selector.IsCancelled() {
 				wv.showTagSelector = false
 				wv.tagSelector = nil
@@ -361,15 +413,42 @@ func (wv *WorkflowView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 		
 	case tea.KeyMsg:
+		if wv.passphrasePrompt != nil {
+			return wv.handlePassphraseKeys(msg)
+		}
+		if wv.rollbackPrompt != nil {
+			return wv.handleRollbackKeys(msg)
+		}
+		if wv.refPrompt != nil {
+			return wv.handleRefKeys(msg)
+		}
+		if wv.resumePrompt != nil {
+			return wv.handleResumeKeys(msg)
+		}
+		if wv.promotionPrompt != nil {
+			return wv.handlePromotionKeys(msg)
+		}
+		if wv.queuePane != nil {
+			return wv.handleQueueKeys(msg)
+		}
+		if wv.releasesView != nil {
+			return wv.handleReleasesKeys(msg)
+		}
 		if wv.showLogs {
 			return wv.handleLogsKeys(msg)
 		}
+		if wv.showHistory {
+			return wv.handleHistoryKeys(msg)
+		}
 		return wv.handleMainKeys(msg)
 
 	case tickMsg:
 		if wv.autoRefresh {
 			wv.refreshStatuses()
 			wv.updateLogsViewport() // Update viewport content on refresh
+			if wv.queuePane != nil {
+				wv.queuePane.Reload()
+			}
 		}
 		return wv, wv.tickCmd()
 
@@ -381,6 +460,38 @@ func (wv *WorkflowView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		wv.refreshStatuses()
 		return wv, nil
 		
+	case releasesLoadedMsg:
+		if wv.releasesView != nil {
+			wv.releasesView.SetResults(msg.results)
+		}
+		return wv, nil
+
+	case driftCheckedMsg:
+		wv.showDrift(msg.results)
+		return wv, nil
+
+	case cancelRequestedMsg:
+		wv.appendLogs(msg.lines...)
+		return wv, nil
+
+	case deployResolvedMsg:
+		wv.queueResolvedDeploy(msg)
+		return wv, nil
+
+	case promotionInspectedMsg:
+		wv.promoting = false
+		if msg.err != nil {
+			wv.appendLogs(fmt.Sprintf("✗ Promotion from %s refused: %v", msg.from, msg.err))
+			wv.orchestrator.RecordPromotion(msg.from, "", msg.err)
+			return wv, nil
+		}
+		if msg.stage.Approval {
+			wv.promotionPrompt = NewPromotionPrompt(msg.source, wv.environment, wv.promotionTargets())
+			return wv, nil
+		}
+		wv.promote(msg.source)
+		return wv, nil
+		
 	case deploySuccessMsg:
 		log.Printf("[WORKFLOW] Processing deploySuccessMsg: %s -> %s", msg.serverName, msg.serverIP)
 		
@@ -409,6 +520,24 @@ func (wv *WorkflowView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	
+	// Actions reaching the servers first ask the passphrase of locked keys
+	switch msg.String() {
+	case "v", "p", "d", "f", "g", "b", "R", "D":
+		var keyPaths []string
+		for _, server := range wv.getServersForAction() {
+			keyPaths = append(keyPaths, server.SSHKeyPath)
+			if jump := inventory.ResolveJumpHost(*server, wv.jumpHost); jump != nil {
+				keyPaths = append(keyPaths, jump.SSHKeyPath)
+			}
+		}
+		if locked := ssh.LockedKeys(keyPaths); len(locked) > 0 {
+			log.Printf("[WORKFLOW] Asking passphrase for %d locked SSH keys", len(locked))
+			wv.passphrasePrompt = NewPassphrasePrompt(locked)
+			wv.pendingKey = msg
+			return wv, nil
+		}
+	}
+	
 	switch msg.String() {
 	case "o":
 		// Open browser for selected server (if deployed)
@@ -419,7 +548,7 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			log.Printf("[WORKFLOW] 'o' key pressed for server: %s, status: %s", server.Name, st.State)
 			
 			// Only allow browser open if server is deployed
-			if st != nil && st.State == status.StateDeployed {
+			if st != nil && (st.State == status.StateDeployed || st.State == status.StateRolledBack) {
 				// Detect correct port from server configuration
 				port := wv.detectServerPort(server.IP)
 				url := fmt.Sprintf("http://%s:%d", server.IP, port)
@@ -446,7 +575,11 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 	
 	case "q", "esc":
-		// Return to workflow selector
+		// Return to workflow selector, releasing the environment lock unless
+		// actions still run in the background
+		if wv.orchestrator.IsIdle() {
+			wv.orchestrator.Stop()
+		}
 		return NewWorkflowSelector(), nil
 		
 	// Logs viewport scrolling
@@ -497,6 +630,10 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			return wv, nil
 		}
 		
+		if !wv.startOrchestrator() {
+			return wv, nil
+		}
+		
 		// Step 1: Run local validation (fields check)
 		for _, server := range selected {
 			checks := wv.statusMgr.ValidateServer(server)
@@ -504,9 +641,6 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		
 		// Step 2: Queue network validation (SSH + connectivity check)
-		if !wv.orchestrator.IsRunning() {
-			wv.orchestrator.Start(wv.servers)
-		}
 		
 		for _, name := range names {
 			wv.statusMgr.UpdateStatus(name, status.StateVerifying, status.ActionCheck, "Validating...")
@@ -546,6 +680,74 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			wv.pendingAction = "deploy"
 		}
 
+	case "f":
+		// Full run: provision then deploy each server with the configured
+		// default tags, each deploy waits for its own provision
+		names := wv.getServerNamesForAction()
+		if len(names) == 0 {
+			return wv, nil
+		}
+		provisionTags := NewTagSelectorWithDefaults("provision", wv.configOpts.ProvisioningTags).GetTagString()
+		deployTags := NewTagSelectorWithDefaults("deploy", wv.configOpts.DeploymentTags).GetTagString()
+		
+		if !wv.deployAllowed() || !wv.startOrchestrator() {
+			return wv, nil
+		}
+		// Database and monitoring servers are only provisioned
+		deployable, others := inventory.SplitDeployable(wv.servers, names)
+		if len(others) > 0 {
+			wv.orchestrator.QueueProvisionWithTags(others, 0, provisionTags)
+		}
+		
+		wv.refreshStatuses()
+		wv.updateLogsViewport()
+		if len(deployable) > 0 {
+			return wv, wv.resolveDeploy(deployResolvedMsg{
+				servers:       deployable,
+				tags:          deployTags,
+				provision:     true,
+				provisionTags: provisionTags,
+			}, "")
+		}
+
+	case "g":
+		// Ask the branch, tag or commit to deploy
+		names := wv.getServerNamesForAction()
+		if len(names) > 0 && wv.deployAllowed() {
+			wv.refPrompt = NewRefPrompt(names)
+		}
+
+	case "b":
+		// Ask the release to roll back to
+		names := wv.getServerNamesForAction()
+		if len(names) > 0 {
+			wv.rollbackPrompt = NewRollbackPrompt(names)
+		}
+
+	case "R":
+		// List the releases of every web server of the environment
+		wv.releasesView = NewReleasesView()
+		return wv, wv.loadReleases()
+
+	case "i":
+		// Decide about the actions interrupted in a previous session
+		if interrupted := wv.orchestrator.InterruptedActions(); len(interrupted) > 0 {
+			wv.resumePrompt = NewResumePrompt(interrupted)
+		}
+
+	case "P":
+		// Deploy the commit verified in the previous environment of the pipeline
+		if !wv.promoting {
+			return wv, wv.inspectPromotion()
+		}
+
+	case "D":
+		// Compare the versions running on the web servers
+		if !wv.checkingDrift {
+			wv.checkingDrift = true
+			return wv, wv.checkDrift()
+		}
+
 	case "l":
 		if wv.cursor < len(wv.servers) {
 			serverName := wv.servers[wv.cursor].Name
@@ -557,6 +759,14 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+	case "h":
+		names := make([]string, len(wv.servers))
+		for i, s := range wv.servers {
+			names[i] = s.Name
+		}
+		wv.historyView = NewHistoryView(wv.orchestrator.GetHistory(), names)
+		wv.showHistory = true
+
 	case "r":
 		wv.refreshStatuses()
 
@@ -564,10 +774,23 @@ func (wv *WorkflowView) handleMainKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if wv.orchestrator.IsRunning() {
 			wv.orchestrator.Stop()
 		} else {
-			wv.orchestrator.Start(wv.servers)
+			wv.startOrchestrator()
 		}
 
+	case "c":
+		// Cancel the running (or else next queued) action of the selected servers
+		return wv, wv.cancelSelected()
+
+	case "tab":
+		// Focus the queue to edit it
+		wv.queuePane = NewQueuePane(wv.environment, wv.orchestrator)
+
 	case "x":
+		// The queue belongs to the run holding the environment
+		if info, _ := status.ReadLock(wv.environment); info != nil && !info.Stale() && !wv.orchestrator.IsRunning() {
+			wv.appendLogs(fmt.Sprintf("✗ Queue not cleared, environment %s is busy, held by %s", wv.environment, info))
+			break
+		}
 		wv.orchestrator.ClearQueue()
 	
 	}
@@ -584,26 +807,520 @@ func (wv *WorkflowView) handleLogsKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return wv, nil
 }
 
+func (wv *WorkflowView) handlePassphraseKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	cmd := wv.passphrasePrompt.Update(msg)
+	if wv.passphrasePrompt.IsCancelled() {
+		wv.passphrasePrompt = nil
+		wv.pendingKey = tea.KeyMsg{}
+		return wv, nil
+	}
+	if wv.passphrasePrompt.IsDone() {
+		wv.passphrasePrompt = nil
+		pending := wv.pendingKey
+		wv.pendingKey = tea.KeyMsg{}
+		return wv.handleMainKeys(pending)
+	}
+	return wv, cmd
+}
+
+func (wv *WorkflowView) handleRollbackKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	cmd := wv.rollbackPrompt.Update(msg)
+	if wv.rollbackPrompt.IsCancelled() {
+		wv.rollbackPrompt = nil
+		return wv, nil
+	}
+	if wv.rollbackPrompt.IsConfirmed() {
+		release := wv.rollbackPrompt.Release()
+		wv.rollbackPrompt = nil
+		wv.rollbackSelected(release)
+		return wv, nil
+	}
+	return wv, cmd
+}
+
+func (wv *WorkflowView) handleRefKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	cmd := wv.refPrompt.Update(msg)
+	if wv.refPrompt.IsCancelled() {
+		wv.refPrompt = nil
+		return wv, nil
+	}
+	if wv.refPrompt.IsConfirmed() {
+		ref := wv.refPrompt.Ref()
+		wv.refPrompt = nil
+		// Deployed with the configured default tags, like a full run
+		tags := NewTagSelectorWithDefaults("deploy", wv.configOpts.DeploymentTags).GetTagString()
+		deployable := wv.deployableForAction()
+		if len(deployable) == 0 || !wv.deployAllowed() || !wv.startOrchestrator() {
+			return wv, nil
+		}
+		return wv, wv.resolveDeploy(deployResolvedMsg{servers: deployable, tags: tags}, ref)
+	}
+	return wv, cmd
+}
+
+func (wv *WorkflowView) handleResumeKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	wv.resumePrompt.Update(msg)
+	if wv.resumePrompt.IsPostponed() {
+		wv.resumePrompt = nil
+		wv.appendLogs("⚠ Interrupted actions wait in the queue, press 'i' to decide")
+		return wv, nil
+	}
+	if resolution := wv.resumePrompt.Resolution(); resolution != "" {
+		wv.resumePrompt = nil
+		if err := wv.orchestrator.ResolveInterrupted(resolution); err != nil {
+			log.Printf("[WORKFLOW] Cannot resolve interrupted actions: %v", err)
+		}
+		wv.refreshStatuses()
+	}
+	return wv, nil
+}
+
+func (wv *WorkflowView) handleQueueKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	wv.queuePane.Update(msg)
+	if wv.queuePane.IsClosed() {
+		wv.queuePane = nil
+	}
+	wv.refreshStatuses()
+	return wv, nil
+}
+
+func (wv *WorkflowView) handleReleasesKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	wv.releasesView.Update(msg)
+	if wv.releasesView.IsClosed() {
+		wv.releasesView = nil
+		return wv, nil
+	}
+	if server, release, ok := wv.releasesView.TakeRollback(); ok {
+		wv.releasesView = nil
+		if !wv.startOrchestrator() {
+			return wv, nil
+		}
+		if err := wv.orchestrator.QueueRollback([]string{server}, 0, release); err != nil {
+			log.Printf("[WORKFLOW] Cannot queue rollback: %v", err)
+		}
+		wv.refreshStatuses()
+		wv.updateLogsViewport()
+		return wv, nil
+	}
+	if wv.releasesView.TakeReload() {
+		return wv, wv.loadReleases()
+	}
+	return wv, nil
+}
+
+// checkDrift compares the versions running on the web servers in the
+// background
+func (wv *WorkflowView) checkDrift() tea.Cmd {
+	var servers []*inventory.Server
+	for _, server := range wv.servers {
+		if server.Deployable() {
+			servers = append(servers, server)
+		}
+	}
+	detector := ssh.NewDriftDetector(ssh.NewHostKeys(wv.environment), wv.env)
+	return func() tea.Msg {
+		return driftCheckedMsg{results: detector.DetectAll(servers, ssh.DefaultDetectWorkers)}
+	}
+}
+
+// inspectPromotion checks that the previous environment of the pipeline runs
+// one commit and passes the gates of the promotion to this environment
+func (wv *WorkflowView) inspectPromotion() tea.Cmd {
+	pipeline, err := wv.configMgr.LoadPipeline()
+	if err != nil {
+		wv.appendLogs(fmt.Sprintf("✗ Cannot promote: %v", err))
+		return nil
+	}
+	stage, from, err := pipeline.Promotion("", wv.environment)
+	if err != nil {
+		wv.appendLogs(fmt.Sprintf("✗ Cannot promote: %v", err))
+		return nil
+	}
+	if !wv.deployAllowed() {
+		return nil
+	}
+
+	wv.promoting = true
+	gates := "deployed, same commit"
+	if stage.HealthGate() {
+		gates += ", health check"
+	}
+	wv.appendLogs(fmt.Sprintf("→ Inspecting %s before promoting it to %s (%s)...", from, wv.environment, gates))
+	return func() tea.Msg {
+		msg := promotionInspectedMsg{stage: stage, from: from}
+		env, err := storage.NewStorage(".").LoadEnvironment(from)
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		configOpts, err := config.NewManager("inventory").Load(from)
+		if err != nil {
+			configOpts = config.DefaultConfig()
+		}
+		statusMgr, err := status.NewManager(from)
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+		orchestrator, err := ansible.NewEnvironmentOrchestrator(from, env, configOpts, statusMgr)
+		if err != nil {
+			msg.err = err
+			return msg
+		}
+
+		servers := make([]*inventory.Server, len(env.Servers))
+		for i := range env.Servers {
+			servers[i] = &env.Servers[i]
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		msg.source, msg.err = orchestrator.InspectPromotionSource(ctx, servers, stage.HealthGate())
+		return msg
+	}
+}
+
+// promotionTargets returns the web servers a promotion deploys to: the
+// selected ones, or all of them
+func (wv *WorkflowView) promotionTargets() []string {
+	names := wv.getSelectedServerNames()
+	if len(names) == 0 {
+		for _, server := range wv.servers {
+			names = append(names, server.Name)
+		}
+	}
+	deployable, _ := inventory.SplitDeployable(wv.servers, names)
+	return deployable
+}
+
+// promote deploys the commit of a promotion source to the web servers of
+// the environment and records the promotion
+func (wv *WorkflowView) promote(source *ansible.PromotionSource) {
+	targets := wv.promotionTargets()
+	if len(targets) == 0 {
+		wv.appendLogs("✗ Nothing to promote to, the environment has no web server")
+		return
+	}
+	if !wv.deployAllowed() || !wv.startOrchestrator() {
+		return
+	}
+
+	wv.orchestrator.QueuePinnedDeploy(targets, 0, "", ansible.PromotedPins(targets, source.Commit, source.Environment))
+	wv.orchestrator.RecordPromotion(source.Environment, source.Commit, nil)
+	wv.appendLogs(fmt.Sprintf("→ Promoting %s from %s to %s", source.ShortCommit(), source.Environment, strings.Join(targets, ", ")))
+
+	wv.refreshStatuses()
+	wv.updateLogsViewport()
+}
+
+func (wv *WorkflowView) handlePromotionKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	wv.promotionPrompt.Update(msg)
+	prompt := wv.promotionPrompt
+	switch {
+	case prompt.IsApproved():
+		wv.promotionPrompt = nil
+		wv.promote(prompt.source)
+	case prompt.IsRejected():
+		wv.promotionPrompt = nil
+		err := fmt.Errorf("%w by %s", ansible.ErrPromotionRejected, status.CurrentOperator())
+		wv.orchestrator.RecordPromotion(prompt.source.Environment, prompt.source.Commit, err)
+		wv.appendLogs(fmt.Sprintf("✗ Promotion of %s from %s rejected", prompt.source.ShortCommit(), prompt.source.Environment))
+	}
+	return wv, nil
+}
+
+// showDrift keeps the drift check results for the server table and reports
+// the drifting servers in the logs
+func (wv *WorkflowView) showDrift(results []ssh.ServerDrift) {
+	wv.checkingDrift = false
+	wv.drift = make(map[string]ssh.ServerDrift, len(results))
+
+	var lines []string
+	drifted := 0
+	for _, result := range results {
+		wv.drift[result.Server] = result
+		switch {
+		case result.Err != nil:
+			lines = append(lines, fmt.Sprintf("[%s] ✗ Drift check failed: %v", result.Server, result.Err))
+		case result.Drifted():
+			drifted++
+			for _, difference := range result.Differences {
+				lines = append(lines, fmt.Sprintf("[%s] ⚠ Drift: %s", result.Server, difference))
+			}
+		}
+		if result.ResolveErr != nil {
+			lines = append(lines, fmt.Sprintf("[%s] Cannot resolve %s: %v", result.Server, result.Branch, result.ResolveErr))
+		}
+	}
+	if drifted == 0 {
+		lines = append(lines, fmt.Sprintf("✓ No version drift across %d web servers", len(results)))
+	}
+	log.Printf("[WORKFLOW] Drift check: %d of %d web servers drift", drifted, len(results))
+
+	wv.appendLogs(lines...)
+}
+
+// cancelSelected cancels one action per selected server: the running one,
+// otherwise the next one queued. The actions of a run holding the environment
+// in another process are cancelled by request, in the background.
+func (wv *WorkflowView) cancelSelected() tea.Cmd {
+	names := wv.getServerNamesForAction()
+	holder, _ := status.ReadLock(wv.environment)
+	remote := holder != nil && !holder.Stale() && !wv.orchestrator.IsRunning()
+
+	actions := wv.orchestrator.GetQueuedActions()
+	if remote {
+		// The queue as saved by the run holding the environment
+		queue, err := ansible.NewQueue(wv.environment)
+		if err != nil {
+			wv.appendLogs(fmt.Sprintf("✗ Cannot read the queue: %v", err))
+			return nil
+		}
+		actions = queue.GetAll()
+	}
+
+	var targets []*status.QueuedAction
+	for _, name := range names {
+		var target *status.QueuedAction
+		for _, action := range actions {
+			if action.ServerName != name {
+				continue
+			}
+			if action.StartedAt != nil && !action.Interrupted {
+				target = action
+				break
+			}
+			if target == nil {
+				target = action
+			}
+		}
+		if target == nil {
+			wv.appendLogs(fmt.Sprintf("[%s] Nothing to cancel", name))
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	if !remote {
+		for _, target := range targets {
+			if _, err := wv.orchestrator.CancelAction(target.ID); err != nil {
+				wv.appendLogs(fmt.Sprintf("[%s] ✗ Cannot cancel %s: %v", target.ServerName, target.Action, err))
+			}
+		}
+		wv.refreshStatuses()
+		return nil
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+	environment := wv.environment
+	wv.appendLogs(fmt.Sprintf("Asking the run of %s to cancel %d action(s)...", holder.Holder, len(targets)))
+	return func() tea.Msg {
+		var lines []string
+		for _, target := range targets {
+			taken, err := ansible.RequestCancel(environment, target.ID, 5*time.Second)
+			switch {
+			case err != nil:
+				lines = append(lines, fmt.Sprintf("[%s] ✗ Cannot cancel %s: %v", target.ServerName, target.Action, err))
+			case !taken:
+				lines = append(lines, fmt.Sprintf("[%s] ✗ Cancel of %s not taken by the run holding the environment", target.ServerName, target.Action))
+			default:
+				lines = append(lines, fmt.Sprintf("[%s] Cancel of %s taken by the run of %s", target.ServerName, target.Action, holder.Holder))
+			}
+		}
+		return cancelRequestedMsg{lines: lines}
+	}
+}
+
+// appendLogs adds lines to the realtime logs
+func (wv *WorkflowView) appendLogs(lines ...string) {
+	wv.mu.Lock()
+	wv.realtimeLogs = append(wv.realtimeLogs, lines...)
+	if len(wv.realtimeLogs) > wv.maxRealtimeLogs {
+		wv.realtimeLogs = wv.realtimeLogs[len(wv.realtimeLogs)-wv.maxRealtimeLogs:]
+	}
+	wv.mu.Unlock()
+
+	wv.updateLogsViewport()
+}
+
+// deployAllowed tells in the logs why a deploy of a production environment
+// outside its maintenance window is refused. Such deploys are scheduled or
+// forced from the command line.
+func (wv *WorkflowView) deployAllowed() bool {
+	err := wv.orchestrator.CheckWindow(status.ActionDeploy, time.Now())
+	if err == nil {
+		return true
+	}
+	wv.appendLogs(
+		fmt.Sprintf("✗ %v", err),
+		fmt.Sprintf("  Schedule it with: inventory-manager deploy --env %s --in-window (or --override-window)", wv.environment),
+	)
+	return false
+}
+
+// startOrchestrator starts the orchestrator unless it runs. When another run
+// holds the environment, it tells who in the logs and returns false: nothing
+// may be queued then.
+func (wv *WorkflowView) startOrchestrator() bool {
+	if wv.orchestrator.IsRunning() {
+		return true
+	}
+	err := wv.orchestrator.Start(wv.servers)
+	if err == nil {
+		wv.lockedBy = ""
+		// Actions of a crashed session wait for a decision
+		if interrupted := wv.orchestrator.InterruptedActions(); len(interrupted) > 0 && wv.resumePrompt == nil {
+			wv.resumePrompt = NewResumePrompt(interrupted)
+		}
+		return true
+	}
+
+	var locked *status.LockedError
+	if errors.As(err, &locked) {
+		wv.lockedBy = locked.Info.Holder
+		wv.appendLogs(fmt.Sprintf("✗ %v", err),
+			fmt.Sprintf("  Actions are disabled until it ends. If it crashed: inventory-manager unlock --env %s", wv.environment))
+		return false
+	}
+	wv.appendLogs(fmt.Sprintf("✗ Cannot start the orchestrator: %v", err))
+	return false
+}
+
+// loadReleases lists the releases of the web servers in the background
+func (wv *WorkflowView) loadReleases() tea.Cmd {
+	var servers []*inventory.Server
+	for _, server := range wv.servers {
+		if server.Deployable() {
+			servers = append(servers, server)
+		}
+	}
+	lister := ssh.NewReleaseLister(ssh.NewHostKeys(wv.environment), wv.env)
+	return func() tea.Msg {
+		return releasesLoadedMsg{results: lister.ListAll(servers, ssh.DefaultDetectWorkers)}
+	}
+}
+
+func (wv *WorkflowView) handleHistoryKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	wv.historyView.Update(msg)
+	if wv.historyView.IsClosed() {
+		wv.showHistory = false
+		wv.historyView = nil
+		return wv, nil
+	}
+	// Opening a log keeps the history underneath, esc comes back to it
+	if logFile := wv.historyView.TakeLogFile(); logFile != "" {
+		wv.showLogs = true
+		wv.currentLogFile = logFile
+		wv.loadLogs()
+	}
+	return wv, nil
+}
+
 
 
-func (wv *WorkflowView) executeActionWithTags(action, tags string) {
+// executeActionWithTags queues the action. A deploy is queued once its
+// commits are resolved, by the command returned.
+func (wv *WorkflowView) executeActionWithTags(action, tags string) tea.Cmd {
 	// Use checked servers, or server at cursor if none checked
 	names := wv.getServerNamesForAction()
 	
 	if len(names) == 0 {
-		return
+		return nil
+	}
+	if action == "deploy" && !wv.deployAllowed() {
+		return nil
 	}
 	
 	// Ensure orchestrator is running
-	if !wv.orchestrator.IsRunning() {
-		wv.orchestrator.Start(wv.servers)
+	if !wv.startOrchestrator() {
+		return nil
 	}
 	
+	var cmd tea.Cmd
 	switch action {
 	case "provision":
 		wv.orchestrator.QueueProvisionWithTags(names, 0, tags)
 	case "deploy":
-		wv.orchestrator.QueueDeployWithTags(names, 0, tags)
+		if deployable := wv.deployableForAction(); len(deployable) > 0 {
+			cmd = wv.resolveDeploy(deployResolvedMsg{servers: deployable, tags: tags}, "")
+		}
+	}
+	
+	// Immediate refresh for instant feedback
+	wv.refreshStatuses()
+	wv.updateLogsViewport()
+	return cmd
+}
+
+// deployableForAction returns the web servers of the action, telling the
+// others there is nothing to deploy to them
+func (wv *WorkflowView) deployableForAction() []string {
+	deployable, others := inventory.SplitDeployable(wv.servers, wv.getServerNamesForAction())
+	for _, name := range others {
+		wv.onProgress(name, "Nothing to deploy, database and monitoring servers are only provisioned")
+	}
+	return deployable
+}
+
+// resolveDeploy resolves in the background the commits deploy.servers are
+// deployed from, ref or the branch of each server when empty: git ls-remote
+// is too slow for the UI loop. The deploy is queued when the
+// deployResolvedMsg arrives.
+func (wv *WorkflowView) resolveDeploy(deploy deployResolvedMsg, ref string) tea.Cmd {
+	what := "their branch"
+	if ref != "" {
+		what = ref
+	}
+	wv.appendLogs(fmt.Sprintf("→ Resolving %s to deploy to %s...", what, strings.Join(deploy.servers, ", ")))
+	orchestrator := wv.orchestrator
+	return func() tea.Msg {
+		deploy.pins, deploy.err = orchestrator.ResolveDeployPins(context.Background(), deploy.servers, ref)
+		return deploy
+	}
+}
+
+// queueResolvedDeploy queues a deploy whose commits are resolved
+func (wv *WorkflowView) queueResolvedDeploy(deploy deployResolvedMsg) {
+	if deploy.err != nil {
+		wv.appendLogs(fmt.Sprintf("✗ Cannot deploy: %v", deploy.err))
+		return
+	}
+	// The window may have closed, or the orchestrator stopped, meanwhile
+	if !wv.deployAllowed() || !wv.startOrchestrator() {
+		return
+	}
+	if deploy.provision {
+		wv.orchestrator.QueueProvisionThenPinnedDeploy(deploy.servers, 0, deploy.provisionTags, deploy.tags, deploy.pins)
+	} else {
+		wv.orchestrator.QueuePinnedDeploy(deploy.servers, 0, deploy.tags, deploy.pins)
+	}
+	wv.refreshStatuses()
+	wv.updateLogsViewport()
+}
+
+// rollbackSelected queues the rollback of the servers of the action to release,
+// the previous one when empty
+func (wv *WorkflowView) rollbackSelected(release string) {
+	names := wv.getServerNamesForAction()
+	if len(names) == 0 {
+		return
+	}
+	
+	// Ensure orchestrator is running
+	if !wv.startOrchestrator() {
+		return
+	}
+	
+	// Only web servers have releases
+	deployable, others := inventory.SplitDeployable(wv.servers, names)
+	for _, name := range others {
+		wv.onProgress(name, "Nothing to roll back, database and monitoring servers have no releases")
+	}
+	if len(deployable) > 0 {
+		if err := wv.orchestrator.QueueRollback(deployable, 0, release); err != nil {
+			log.Printf("[WORKFLOW] Cannot queue rollback: %v", err)
+		}
 	}
 	
 	// Immediate refresh for instant feedback
@@ -618,25 +1335,25 @@ func (wv *WorkflowView) provisionSelected() {
 	}
 	
 	// Ensure orchestrator is running
-	if !wv.orchestrator.IsRunning() {
-		wv.orchestrator.Start(wv.servers)
+	if !wv.startOrchestrator() {
+		return
 	}
 	
 	wv.orchestrator.QueueProvision(names, 0)
 }
 
-func (wv *WorkflowView) deploySelected() {
+func (wv *WorkflowView) deploySelected() tea.Cmd {
 	names := wv.getSelectedServerNames()
-	if len(names) == 0 {
-		return
+	if len(names) == 0 || !wv.deployAllowed() {
+		return nil
 	}
 	
 	// Ensure orchestrator is running
-	if !wv.orchestrator.IsRunning() {
-		wv.orchestrator.Start(wv.servers)
+	if !wv.startOrchestrator() {
+		return nil
 	}
 	
-	wv.orchestrator.QueueDeploy(names, 0)
+	return wv.resolveDeploy(deployResolvedMsg{servers: names}, "")
 }
 
 
@@ -708,12 +1425,33 @@ func (wv *WorkflowView) loadLogs() {
 }
 
 func (wv *WorkflowView) View() string {
+	if wv.passphrasePrompt != nil {
+		return wv.passphrasePrompt.View()
+	}
+	if wv.resumePrompt != nil {
+		return wv.resumePrompt.View()
+	}
+	if wv.promotionPrompt != nil {
+		return wv.promotionPrompt.View()
+	}
+	if wv.rollbackPrompt != nil {
+		return wv.rollbackPrompt.View()
+	}
+	if wv.refPrompt != nil {
+		return wv.refPrompt.View()
+	}
+	if wv.releasesView != nil {
+		return wv.releasesView.View(wv.environment)
+	}
 	if wv.showTagSelector && wv.tagSelector != nil {
 		return wv.tagSelector.View()
 	}
 	if wv.showLogs {
 		return wv.renderLogs()
 	}
+	if wv.showHistory && wv.historyView != nil {
+		return wv.historyView.View(wv.environment)
+	}
 	return wv.renderMain()
 }
 
@@ -726,11 +1464,18 @@ func (wv *WorkflowView) renderMain() string {
 	table := wv.renderServerTable()
 	b.WriteString(table + "\n\n")
 
-	controls := wv.renderControls()
-	b.WriteString(controls + "\n\n")
+	// The focused queue takes the place of the controls
+	if wv.queuePane != nil {
+		b.WriteString(wv.queuePane.Help() + "\n\n")
+		b.WriteString(wv.renderQueue() + "\n")
+		b.WriteString(wv.queuePane.View())
+	} else {
+		controls := wv.renderControls()
+		b.WriteString(controls + "\n\n")
 
-	queue := wv.renderQueue()
-	b.WriteString(queue + "\n")
+		queue := wv.renderQueue()
+		b.WriteString(queue + "\n")
+	}
 	
 	// Always show realtime logs section
 	b.WriteString("\n")
@@ -765,19 +1510,23 @@ func (wv *WorkflowView) renderServerTable() string {
 			st = &status.ServerStatus{State: status.StateUnknown}
 		}
 
-		statusStr, progressDetails := wv.formatStatus(st)
+		statusStr, progressDetails := wv.formatStatus(server, st)
 		progressStr := wv.progress[server.Name]
 		if progressStr == "" {
 			progressStr = progressDetails
 		} else if progressDetails != "" {
 			progressStr = progressDetails + " | " + progressStr
 		}
+		// Servers differing from their configuration or the other servers
+		if drift, ok := wv.drift[server.Name]; ok && drift.Drifted() {
+			progressStr = strings.TrimSuffix("⚠ drift: "+drift.Differences[0]+" | "+progressStr, " | ")
+		}
 		if progressStr == "" {
 			progressStr = "-"
 		}
 
 		line := fmt.Sprintf("%s %-2s %-20s %-15s %-7d %-7s %-22s %-43s",
-			cursor, sel, server.Name, server.IP, server.Port, server.Type, statusStr, progressStr)
+			cursor, sel, server.Name, server.IP, server.Port, server.Role(), statusStr, progressStr)
 
 		if i == wv.cursor {
 			line = selectedItemStyle.Render(line)
@@ -789,7 +1538,7 @@ func (wv *WorkflowView) renderServerTable() string {
 	return b.String()
 }
 
-func (wv *WorkflowView) formatStatus(st *status.ServerStatus) (string, string) {
+func (wv *WorkflowView) formatStatus(server *inventory.Server, st *status.ServerStatus) (string, string) {
 	var icon string
 	var progressDetails string
 	
@@ -813,7 +1562,7 @@ func (wv *WorkflowView) formatStatus(st *status.ServerStatus) (string, string) {
 			details = append(details, "Invalid IP")
 		}
 		if !st.ReadyChecks.SSHKeyExists {
-			details = append(details, "SSH key not found")
+			details = append(details, "SSH key not found (and no agent)")
 		}
 		if !st.ReadyChecks.PortValid {
 			details = append(details, "Invalid port")
@@ -828,28 +1577,75 @@ func (wv *WorkflowView) formatStatus(st *status.ServerStatus) (string, string) {
 		icon = yellowStyle.Render("⚡ Provisioning")
 	case status.StateProvisioned:
 		icon = blueStyle.Render("✓ Provisioned")
+		// Nothing is deployed to database and monitoring servers, provisioned
+		// and checked is their final state
+		if !server.Deployable() {
+			icon = greenStyle.Render("✓ Running")
+		}
+		progressDetails = formatLastRun(st.LastRun)
 	case status.StateDeploying:
 		icon = yellowStyle.Render("⚡ Deploying")
 	case status.StateDeployed:
 		icon = greenStyle.Render("✓ Deployed")
 		// Show browser hint for deployed servers
 		progressDetails = "Press 'o' to open in browser"
+		if run := formatLastRun(st.LastRun); run != "" {
+			progressDetails = run + " | 'o' to open"
+		}
+		if commit := st.CommitString(); commit != "" {
+			progressDetails = "@" + commit + " | " + progressDetails
+		}
+	case status.StateRollingBack:
+		icon = yellowStyle.Render("⚡ Rolling Back")
+	case status.StateRolledBack:
+		icon = greenStyle.Render("↩ Rolled Back")
+		progressDetails = st.ErrorMessage
+		if run := formatLastRun(st.LastRun); run != "" {
+			progressDetails = strings.TrimPrefix(progressDetails+" | "+run, " | ")
+		}
 	case status.StateVerifying:
 		icon = blueStyle.Render("🔍 Verifying")
 	case status.StateFailed:
 		icon = redStyle.Render("✗ Failed")
+		if st.LastRun != nil && st.LastRun.Unreachable() {
+			icon = redStyle.Render("✗ Unreachable")
+		}
 		if st.ErrorMessage != "" {
 			progressDetails = st.ErrorMessage
 		}
+	case status.StateCancelled:
+		icon = grayStyle.Render("■ Cancelled")
+		progressDetails = st.ErrorMessage
+	case status.StateHostKeyChanged:
+		icon = redStyle.Render("⚠ Host Key Changed")
+		progressDetails = fmt.Sprintf("Verify the server, then: inventory-manager hostkey accept --env %s --servers %s",
+			wv.environment, st.Name)
 	case "validating":
 		icon = yellowStyle.Render("⏳ Validating")
 	default:
 		icon = grayStyle.Render("? Unknown")
 	}
 
+	// Retried actions show which attempt they are at
+	if attempt := st.AttemptString(); attempt != "" {
+		progressDetails = strings.TrimSuffix("["+attempt+"] "+progressDetails, " ")
+	}
+
 	return icon, progressDetails
 }
 
+// formatLastRun summarizes the recap of the last playbook run for the progress column
+func formatLastRun(run *status.RunSummary) string {
+	if run == nil || run.Recap == nil {
+		return ""
+	}
+	elapsed := run.Elapsed.Round(time.Second)
+	if run.NoChanges() {
+		return fmt.Sprintf("%s: no changes (ok=%d, %s)", run.Action, run.Recap.Ok, elapsed)
+	}
+	return fmt.Sprintf("%s: ok=%d changed=%d (%s)", run.Action, run.Recap.Ok, run.Recap.Changed, elapsed)
+}
+
 func (wv *WorkflowView) renderControls() string {
 	controls := []string{
 		"[↑↓] Navigate",
@@ -858,11 +1654,21 @@ func (wv *WorkflowView) renderControls() string {
 		"[v] Validate & Check",
 		"[p] Provision",
 		"[d] Deploy",
+		"[f] Provision+Deploy",
+		"[g] Deploy Ref",
+		"[b] Rollback",
+		"[R] Releases",
+		"[D] Drift Check",
+		"[P] Promote",
 		"[PgUp/PgDn] Scroll Logs",
 		"[l] Logs",
+		"[h] History",
 		"[r] Refresh",
 		"[s] Start/Stop",
+		"[c] Cancel Action",
+		"[Tab] Edit Queue",
 		"[x] Clear Queue",
+		"[i] Interrupted Actions",
 		"[Esc] Back",
 	}
 	return helpStyle.Render(strings.Join(controls, " | "))
@@ -873,10 +1679,47 @@ func (wv *WorkflowView) renderQueue() string {
 	running := "Stopped"
 	if wv.orchestrator.IsRunning() {
 		running = "Running"
+	} else if wv.lockedBy != "" {
+		running = "Busy, locked by " + wv.lockedBy
+	}
+
+	strategy := wv.orchestrator.GetDeploymentStrategy()
+	deploy := strategy.Name
+	switch strategy.Name {
+	case ansible.StrategyRolling:
+		if strategy.BatchSize > 0 {
+			deploy = fmt.Sprintf("%s (batch %d)", deploy, strategy.BatchSize)
+		}
+	case ansible.StrategyBlueGreen:
+		deploy = fmt.Sprintf("%s (+%d)", deploy, strategy.PortOffset)
+	}
+
+	queue := fmt.Sprintf("%d actions", queueSize)
+	if interrupted := len(wv.orchestrator.InterruptedActions()); interrupted > 0 {
+		queue += fmt.Sprintf(" (%d interrupted, [i])", interrupted)
+	}
+	paused := 0
+	for _, action := range wv.orchestrator.GetQueuedActions() {
+		if action.Paused {
+			paused++
+		}
+	}
+	if paused > 0 {
+		queue += fmt.Sprintf(" (%d paused, [Tab])", paused)
+	}
+	if next, ok := wv.orchestrator.NextStart(); ok {
+		queue += fmt.Sprintf(" (next scheduled %s)", next.Format("01-02 15:04"))
+	}
+	if window := wv.orchestrator.MaintenanceWindow(); window != nil {
+		open := "closed"
+		if window.Contains(time.Now()) {
+			open = "open"
+		}
+		deploy += fmt.Sprintf(" | Window: %s (%s)", window, open)
 	}
 
-	return infoBoxStyle.Render(fmt.Sprintf("Queue: %d actions | Status: %s | Last refresh: %s",
-		queueSize, running, wv.lastRefresh.Format("15:04:05")))
+	return infoBoxStyle.Render(fmt.Sprintf("Queue: %s | Status: %s | Deploy: %s | Last refresh: %s",
+		queue, running, deploy, wv.lastRefresh.Format("15:04:05")))
 }
 
 func (wv *WorkflowView) renderRealtimeLogs() string {
@@ -0,0 +1,71 @@
+package ansible_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestBlueGreenDeployUsesHealthCheck(t *testing.T) {
+	testEnv := "test-blue-green-health"
+	defer os.RemoveAll("inventory/" + testEnv)
+	defer os.RemoveAll("logs/" + testEnv)
+
+	// An ansible-playbook recording its arguments
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsFile + "\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "ansible-playbook"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake ansible-playbook: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	orchestrator.SetHealthCheckEnabled(false)
+	orchestrator.SetDeploymentStrategy(ansible.DeploymentStrategy{Name: ansible.StrategyBlueGreen})
+	orchestrator.SetHealthCheckSpec(inventory.HealthCheckSpec{Path: "health", ExpectStatus: []int{200, 204}})
+	statusMgr.UpdateStatus("web1", status.StateProvisioned, status.ActionProvision, "")
+
+	servers := []*inventory.Server{{
+		Name: "web1", Type: "web", IP: "10.0.0.1", Port: 22,
+		HealthCheck: &inventory.HealthCheckSpec{Retries: 3, Interval: 2 * time.Second},
+	}}
+	orchestrator.QueueDeploy([]string{"web1"}, 0)
+	if err := orchestrator.Start(servers); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer orchestrator.Stop()
+	for deadline := time.Now().Add(10 * time.Second); !orchestrator.IsIdle(); {
+		if time.Now().After(deadline) {
+			t.Fatal("The deploy never finished")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("ansible-playbook was not run: %v", err)
+	}
+	args := string(data)
+	if !strings.Contains(args, "deploy-blue-green.yml") {
+		t.Fatalf("Expected the blue-green playbook, got %s", args)
+	}
+	for _, want := range []string{"health_check_path=/health", "health_check_status=200,204", "health_check_retries=3", "health_check_delay=2"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Expected %s in the arguments, got %s", want, args)
+		}
+	}
+}
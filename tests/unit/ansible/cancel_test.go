@@ -0,0 +1,178 @@
+package ansible_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestQueueFindAndRemove(t *testing.T) {
+	testEnv := "test-queue-remove"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	provision := q.AddAction(&status.QueuedAction{ID: "aaaa-1", ServerName: "server1", Action: status.ActionProvision})
+	q.AddAction(&status.QueuedAction{ID: "aaaa-2", ServerName: "server1", Action: status.ActionDeploy, DependsOn: []string{provision.ID}})
+	check := q.AddAction(&status.QueuedAction{ID: "bbbb-1", ServerName: "server2", Action: status.ActionCheck})
+
+	if found, err := q.Find("bbbb"); err != nil || found.ID != check.ID {
+		t.Errorf("Expected the prefix to find the check, got %v (%v)", found, err)
+	}
+	if _, err := q.Find("aaaa"); err == nil {
+		t.Error("Expected an ambiguous prefix to be rejected")
+	}
+	if _, err := q.Find("cccc"); err == nil {
+		t.Error("Expected an unknown ID to be rejected")
+	}
+
+	// A running action can only be cancelled, not removed
+	if next := q.NextReady(); next == nil || next.ID != provision.ID {
+		t.Fatalf("Expected provision to start, got %v", next)
+	}
+	if _, err := q.Remove(provision.ID); !errors.Is(err, ansible.ErrActionRunning) {
+		t.Errorf("Expected ErrActionRunning, got %v", err)
+	}
+
+	q2, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	skipped, err := q2.Remove(provision.ID)
+	if err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if len(skipped) != 1 || skipped[0].Action != status.ActionDeploy {
+		t.Errorf("Expected the dependent deploy to be skipped, got %v", skipped)
+	}
+	if actions := q2.GetAll(); len(actions) != 1 || actions[0].ID != check.ID {
+		t.Errorf("Expected only the check to stay queued, got %v", actions)
+	}
+}
+
+// fakeAnsible puts on PATH an ansible-playbook which runs for long on the
+// servers named slow-*, and succeeds at once on the others
+func fakeAnsible(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--limit" ]; then limit=$2; fi
+	shift
+done
+case "$limit" in
+	slow-*) sleep 60 & wait ;;
+esac
+exit 0
+`
+	if err := os.WriteFile(filepath.Join(dir, "ansible-playbook"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake ansible-playbook: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestOrchestratorCancelRunningAction(t *testing.T) {
+	testEnv := "test-cancel-running"
+	defer os.RemoveAll("inventory/" + testEnv)
+	defer os.RemoveAll("logs/" + testEnv)
+	fakeAnsible(t)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	orchestrator.SetMaxWorkers(2)
+	orchestrator.SetHealthCheckEnabled(false)
+
+	servers := []*inventory.Server{
+		{Name: "slow-1", IP: "10.0.0.1", Port: 22},
+		{Name: "fast-1", IP: "10.0.0.2", Port: 22},
+	}
+	orchestrator.QueueProvisionThenDeploy([]string{"slow-1"}, 0, "", "")
+	orchestrator.QueueProvision([]string{"fast-1"}, 0)
+	if err := orchestrator.Start(servers); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer orchestrator.Stop()
+
+	// Wait for the slow provision to run
+	var running *status.QueuedAction
+	for deadline := time.Now().Add(5 * time.Second); running == nil && time.Now().Before(deadline); {
+		for _, action := range orchestrator.GetQueuedActions() {
+			if action.ServerName == "slow-1" && action.StartedAt != nil {
+				running = action
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if running == nil {
+		t.Fatal("The slow provision never started")
+	}
+
+	if _, err := orchestrator.CancelAction(running.ID[:8]); err != nil {
+		t.Fatalf("CancelAction failed: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); !orchestrator.IsIdle(); {
+		if time.Now().After(deadline) {
+			t.Fatal("The cancelled provision is still running")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if st := statusMgr.GetStatus("slow-1"); st.State != status.StateCancelled {
+		t.Errorf("Expected slow-1 to be cancelled, got %s (%s)", st.State, st.ErrorMessage)
+	}
+	if st := statusMgr.GetStatus("fast-1"); st.State != status.StateProvisioned {
+		t.Errorf("Expected fast-1 to be provisioned anyway, got %s (%s)", st.State, st.ErrorMessage)
+	}
+
+	history := orchestrator.GetHistory()
+	if runs, _ := history.List(status.HistoryFilter{ServerName: "slow-1", Status: status.RunCancelled}); len(runs) != 1 {
+		t.Errorf("Expected the cancelled provision in history, got %d runs", len(runs))
+	}
+	if runs, _ := history.List(status.HistoryFilter{ServerName: "slow-1", Status: status.RunSkipped}); len(runs) != 1 {
+		t.Errorf("Expected the deploy waiting for it to be skipped, got %d runs", len(runs))
+	}
+}
+
+func TestOrchestratorCancelQueuedAction(t *testing.T) {
+	testEnv := "test-cancel-queued"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	orchestrator.QueueCheck([]string{"server1", "server2"}, 0)
+	actions := orchestrator.GetQueuedActions()
+
+	if _, err := orchestrator.CancelAction(actions[0].ID); err != nil {
+		t.Fatalf("CancelAction failed: %v", err)
+	}
+	if remaining := orchestrator.GetQueuedActions(); len(remaining) != 1 || remaining[0].ID != actions[1].ID {
+		t.Errorf("Expected only the other check to stay queued, got %v", remaining)
+	}
+	runs, err := orchestrator.GetHistory().List(status.HistoryFilter{Status: status.RunCancelled})
+	if err != nil {
+		t.Fatalf("Failed to list history: %v", err)
+	}
+	if len(runs) != 1 || runs[0].ServerName != "server1" {
+		t.Errorf("Expected the removed check in history, got %v", runs)
+	}
+}
@@ -0,0 +1,110 @@
+package ansible_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestParseTaskResultEvent(t *testing.T) {
+	line := `{"type": "task_result", "time": "2025-01-10T12:00:00.5Z", "play": "Deploy", "task": "Install packages", "host": "web-01", "status": "failed", "changed": false, "ignored": false, "duration": 1.5, "msg": "No package matching 'foo'"}`
+
+	ev, err := ansible.ParseEvent([]byte(line))
+	if err != nil {
+		t.Fatalf("ParseEvent failed: %v", err)
+	}
+
+	if ev.Type != ansible.EventTaskResult {
+		t.Errorf("Expected task_result, got %s", ev.Type)
+	}
+	if ev.Host != "web-01" || ev.Task != "Install packages" {
+		t.Errorf("Unexpected host/task: %s/%s", ev.Host, ev.Task)
+	}
+	if ev.Duration != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s duration, got %s", ev.Duration)
+	}
+	if !ev.IsFailure() {
+		t.Error("Expected failed task result to be a failure")
+	}
+
+	ev.Ignored = true
+	if ev.IsFailure() {
+		t.Error("Ignored failures should not abort the host")
+	}
+}
+
+func TestParseEventRejectsInvalidLines(t *testing.T) {
+	for _, line := range []string{"PLAY [all]", `{"task": "no type"}`} {
+		if _, err := ansible.ParseEvent([]byte(line)); err == nil {
+			t.Errorf("Expected error for %q", line)
+		}
+	}
+}
+
+func TestProgressMessageFromEvents(t *testing.T) {
+	executor := ansible.NewExecutor("test-events")
+
+	recap, err := ansible.ParseEvent([]byte(`{"type": "recap", "recap": {"web-02": {"ok": 3}, "web-01": {"ok": 10, "changed": 2, "failed": 1}}}`))
+	if err != nil {
+		t.Fatalf("ParseEvent failed: %v", err)
+	}
+
+	msg := executor.ProgressMessage(recap)
+	if !strings.Contains(msg, "web-01 ok=10 changed=2 failed=1") {
+		t.Errorf("Recap message missing host stats: %s", msg)
+	}
+	if strings.Index(msg, "web-01") > strings.Index(msg, "web-02") {
+		t.Errorf("Recap hosts should be sorted: %s", msg)
+	}
+
+	skipped := ansible.Event{Type: ansible.EventTaskResult, Status: ansible.TaskSkipped, Host: "web-01"}
+	if msg := executor.ProgressMessage(skipped); msg != "" {
+		t.Errorf("Skipped results should not be displayed, got %q", msg)
+	}
+}
+
+func TestParseRecapLine(t *testing.T) {
+	host, stats, ok := ansible.ParseRecapLine("web-01                     : ok=12   changed=3    unreachable=0    failed=1    skipped=2    rescued=0    ignored=1")
+	if !ok {
+		t.Fatal("Expected recap line to be parsed")
+	}
+	if host != "web-01" {
+		t.Errorf("Expected host web-01, got %s", host)
+	}
+	if stats.Ok != 12 || stats.Changed != 3 || stats.Failed != 1 || stats.Skipped != 2 || stats.Ignored != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	for _, line := range []string{
+		"PLAY RECAP *********************************************************************",
+		"TASK [Install packages : apt] ***",
+		"ok: [web-01]",
+	} {
+		if _, _, ok := ansible.ParseRecapLine(line); ok {
+			t.Errorf("Did not expect %q to be parsed as a recap line", line)
+		}
+	}
+}
+
+func TestExecutionResultSummary(t *testing.T) {
+	result := &ansible.ExecutionResult{
+		Success: true,
+		Recap:   map[string]status.HostStats{"web-01": {Ok: 8}},
+		Elapsed: 42 * time.Second,
+	}
+
+	run := result.Summary(status.ActionDeploy, "web-01")
+	if run.Recap == nil || !run.NoChanges() {
+		t.Errorf("Expected a successful run without changes, got %s", run)
+	}
+	if run.Unreachable() {
+		t.Error("Run should not be unreachable")
+	}
+
+	if missing := result.Summary(status.ActionDeploy, "web-02"); missing.Recap != nil || missing.NoChanges() {
+		t.Errorf("Host without recap should not report its stats: %s", missing)
+	}
+}
@@ -0,0 +1,53 @@
+package ansible_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestOrchestratorSkipNextHealthCheck(t *testing.T) {
+	testEnv := "test-skip-health-check"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	orchestrator.SetEnvironmentConfig(inventory.Environment{
+		Name: testEnv,
+		Servers: []inventory.Server{
+			{Name: "web1", Type: "web"},
+			{Name: "web2", Type: "web"},
+			{Name: "web3", Type: "web"},
+		},
+	})
+
+	// The skip applies to every deploy of the next queue call, and only to them
+	orchestrator.SkipNextHealthCheck()
+	if err := orchestrator.QueueDeploy([]string{"web1", "web2"}, 0); err != nil {
+		t.Fatalf("QueueDeploy failed: %v", err)
+	}
+	if err := orchestrator.QueueDeploy([]string{"web3"}, 0); err != nil {
+		t.Fatalf("QueueDeploy failed: %v", err)
+	}
+	defer orchestrator.ClearQueue()
+
+	actions := orchestrator.GetQueuedActions()
+	if len(actions) != 3 {
+		t.Fatalf("Expected 3 queued deploys, got %d", len(actions))
+	}
+	for _, action := range actions {
+		want := action.ServerName != "web3"
+		if action.SkipHealth != want {
+			t.Errorf("Expected %s SkipHealth=%v, got %v", action.ServerName, want, action.SkipHealth)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package ansible_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+// gitRepo creates a repository with a tagged commit followed by another one
+// on main, and returns its path, the tagged commit and the head of main
+func gitRepo(t *testing.T) (string, string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	git("tag", "-a", "-m", "release", "v1.0.0")
+	tagged := git("rev-parse", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	return dir, tagged, git("rev-parse", "HEAD")
+}
+
+func TestOrchestratorPinsDeploys(t *testing.T) {
+	testEnv := "test-pinned-deploys"
+	defer os.RemoveAll("inventory/" + testEnv)
+	repo, tagged, head := gitRepo(t)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	orchestrator.SetEnvironmentConfig(inventory.Environment{
+		Name: testEnv,
+		Servers: []inventory.Server{
+			{Name: "web1", Type: "web", GitRepo: repo, GitBranch: "main"},
+			{Name: "web2", Type: "web", GitRepo: repo, GitBranch: "main"},
+			{Name: "web3", Type: "web"},
+		},
+	})
+
+	// The branch is resolved once for the whole batch
+	if err := orchestrator.QueueDeploy([]string{"web1", "web2", "web3"}, 0); err != nil {
+		t.Fatalf("QueueDeploy failed: %v", err)
+	}
+	for _, action := range orchestrator.GetQueuedActions() {
+		switch action.ServerName {
+		case "web3":
+			if action.Commit != "" {
+				t.Errorf("Expected web3, without git_repo, to be left to the playbook, got %s", action.Commit)
+			}
+		default:
+			if action.Commit != head || action.Ref != "main" {
+				t.Errorf("Expected %s pinned to %s (main), got %s (%s)", action.ServerName, head, action.Commit, action.Ref)
+			}
+		}
+	}
+	orchestrator.ClearQueue()
+
+	// An explicit tag, peeled to its commit
+	pins, err := orchestrator.ResolveDeployPins(context.Background(), []string{"web1"}, "v1.0.0")
+	if err != nil {
+		t.Fatalf("ResolveDeployPins of a tag failed: %v", err)
+	}
+	orchestrator.QueuePinnedDeploy([]string{"web1"}, 0, "", pins)
+	if actions := orchestrator.GetQueuedActions(); len(actions) != 1 || actions[0].Commit != tagged || actions[0].Ref != "v1.0.0" {
+		t.Fatalf("Expected web1 pinned to %s (v1.0.0), got %v", tagged, actions)
+	}
+	orchestrator.ClearQueue()
+
+	// A server cannot be pinned to a ref missing, or without repository
+	for _, servers := range [][]string{{"web1"}, {"web1", "web3"}} {
+		ref := "v9.9.9"
+		if len(servers) > 1 {
+			ref = "v1.0.0" // web3 has no repository to resolve it in
+		}
+		if _, err := orchestrator.ResolveDeployPins(context.Background(), servers, ref); err == nil {
+			t.Errorf("Expected resolving %s for %v to fail", ref, servers)
+		}
+	}
+
+	// Nor a deploy queued when its branch cannot be resolved
+	orchestrator.SetEnvironmentConfig(inventory.Environment{
+		Name:    testEnv,
+		Servers: []inventory.Server{{Name: "web1", Type: "web", GitRepo: repo, GitBranch: "missing"}},
+	})
+	if err := orchestrator.QueueDeploy([]string{"web1"}, 0); err == nil {
+		t.Error("Expected deploying a missing branch to fail")
+	}
+	if size := orchestrator.GetQueueSize(); size != 0 {
+		t.Errorf("Expected nothing queued, got %d actions", size)
+	}
+}
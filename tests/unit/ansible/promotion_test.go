@@ -0,0 +1,97 @@
+package ansible_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestInspectPromotionSourceNotDeployed(t *testing.T) {
+	testEnv := "test-promotion-gate"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	statusMgr.UpdateStatus("web1", status.StateDeployed, status.ActionDeploy, "")
+	statusMgr.UpdateStatus("web2", status.StateFailed, status.ActionDeploy, "boom")
+
+	servers := []*inventory.Server{
+		{Name: "web1", Type: "web", IP: "192.0.2.1", Port: 22},
+		{Name: "web2", Type: "web", IP: "192.0.2.2", Port: 22},
+		{Name: "db1", Type: "db", IP: "192.0.2.3", Port: 22},
+	}
+	// Fails before connecting to any server
+	_, err = orchestrator.InspectPromotionSource(context.Background(), servers, true)
+	if !errors.Is(err, ansible.ErrPromotionGate) {
+		t.Fatalf("Expected ErrPromotionGate, got %v", err)
+	}
+
+	_, err = orchestrator.InspectPromotionSource(context.Background(), servers[2:], true)
+	if !errors.Is(err, ansible.ErrPromotionGate) {
+		t.Errorf("Expected an environment without web servers to fail the gate, got %v", err)
+	}
+}
+
+func TestOrchestratorPromotion(t *testing.T) {
+	testEnv := "test-promotion"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	targets := []string{"web1", "web2"}
+	orchestrator.QueuePinnedDeploy(targets, 0, "", ansible.PromotedPins(targets, commit, "staging"))
+	orchestrator.QueueDeploy([]string{"web3"}, 0)
+
+	for _, action := range orchestrator.GetQueuedActions() {
+		promoted := action.ServerName != "web3"
+		if promoted && (action.Commit != commit || action.Source != "staging") {
+			t.Errorf("Expected the deploy of %s to pin %s from staging, got %q from %q", action.ServerName, commit, action.Commit, action.Source)
+		}
+		if !promoted && (action.Commit != "" || action.Source != "") {
+			t.Errorf("Expected the deploy of %s to follow its branch, got %q", action.ServerName, action.Commit)
+		}
+	}
+
+	orchestrator.RecordPromotion("staging", commit, nil)
+	orchestrator.RecordPromotion("staging", "", fmt.Errorf("%w: web1 is failed", ansible.ErrPromotionGate))
+	orchestrator.RecordPromotion("staging", commit, fmt.Errorf("%w by alice", ansible.ErrPromotionRejected))
+
+	history, err := status.NewHistory(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	runs, err := history.List(status.HistoryFilter{Action: status.ActionPromote})
+	if err != nil {
+		t.Fatalf("Failed to list history: %v", err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("Expected 3 promotions recorded, got %d", len(runs))
+	}
+	// Most recent first
+	want := []string{status.RunCancelled, status.RunFailed, status.RunSucceeded}
+	for i, run := range runs {
+		if run.Status != want[i] || run.Source != "staging" {
+			t.Errorf("Expected promotion %d to be %s from staging, got %s from %s", i, want[i], run.Status, run.Source)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package ansible_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func queuedServers(q *ansible.Queue) []string {
+	var names []string
+	for _, action := range q.GetAll() {
+		names = append(names, action.ServerName)
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueueMoveAndSetPriority(t *testing.T) {
+	testEnv := "test-queue-move"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	urgent := q.Add("urgent", status.ActionCheck, 5)
+	first := q.Add("first", status.ActionCheck, 0)
+	second := q.Add("second", status.ActionCheck, 0)
+	last := q.Add("last", status.ActionCheck, 0)
+
+	if err := q.Move(last.ID, 1); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if names := queuedServers(q); !equalNames(names, []string{"urgent", "last", "first", "second"}) {
+		t.Errorf("Unexpected order after move: %v", names)
+	}
+
+	// Moved ahead of a higher priority, it takes that priority
+	if err := q.Move(second.ID, 0); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if second.Priority != urgent.Priority {
+		t.Errorf("Expected the moved action to take priority %d, got %d", urgent.Priority, second.Priority)
+	}
+
+	if err := q.SetPriority(first.ID, 10); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	want := []string{"first", "second", "urgent", "last"}
+	if names := queuedServers(q); !equalNames(names, want) {
+		t.Errorf("Unexpected order after priority change: %v", names)
+	}
+
+	// The order survives a restart
+	q2, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	if names := queuedServers(q2); !equalNames(names, want) {
+		t.Errorf("Expected order %v after reload, got %v", want, names)
+	}
+
+	// Running actions stay where they are
+	if next := q2.NextReady(); next == nil || next.ID != first.ID {
+		t.Fatalf("Expected first to start, got %v", next)
+	}
+	if err := q2.Move(first.ID, 3); !errors.Is(err, ansible.ErrActionRunning) {
+		t.Errorf("Expected ErrActionRunning, got %v", err)
+	}
+	if err := q2.SetPriority("unknown", 1); err == nil {
+		t.Error("Expected an unknown action to be rejected")
+	}
+}
+
+func TestQueuePauseAction(t *testing.T) {
+	testEnv := "test-queue-pause"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	provision := q.AddAction(&status.QueuedAction{ServerName: "server1", Action: status.ActionProvision})
+	q.AddAction(&status.QueuedAction{ServerName: "server1", Action: status.ActionDeploy, DependsOn: []string{provision.ID}})
+	check := q.Add("server2", status.ActionCheck, 0)
+
+	if err := q.PauseAction(provision.ID); err != nil {
+		t.Fatalf("PauseAction failed: %v", err)
+	}
+	if runnable := q.Runnable(); runnable != 1 {
+		t.Errorf("Expected only the check to be runnable, got %d", runnable)
+	}
+
+	// The pause survives a restart
+	q2, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	if next := q2.NextReady(); next == nil || next.ID != check.ID {
+		t.Fatalf("Expected the check to start past the paused provision, got %v", next)
+	}
+	if next := q2.NextReady(); next != nil {
+		t.Fatalf("Expected nothing else to start, got %s on %s", next.Action, next.ServerName)
+	}
+
+	if err := q2.ResumeAction(provision.ID); err != nil {
+		t.Fatalf("ResumeAction failed: %v", err)
+	}
+	if next := q2.NextReady(); next == nil || next.ID != provision.ID {
+		t.Errorf("Expected the resumed provision to start, got %v", next)
+	}
+}
+
+func TestOrchestratorIdleWithPausedActions(t *testing.T) {
+	testEnv := "test-orchestrator-paused"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	orchestrator.QueueCheck([]string{"server1"}, 0)
+	if orchestrator.IsIdle() {
+		t.Fatal("Expected a queued check to keep the orchestrator busy")
+	}
+
+	if err := orchestrator.PauseAction(orchestrator.GetQueuedActions()[0].ID); err != nil {
+		t.Fatalf("PauseAction failed: %v", err)
+	}
+	if !orchestrator.IsIdle() {
+		t.Error("Expected the orchestrator to be idle with only a paused action")
+	}
+	if size := orchestrator.GetQueueSize(); size != 1 {
+		t.Errorf("Expected the paused action to stay queued, got %d actions", size)
+	}
+}
@@ -3,6 +3,7 @@ package ansible_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/bastiblast/boiler-deploy/internal/ansible"
 	"github.com/bastiblast/boiler-deploy/internal/status"
@@ -163,4 +164,224 @@ func TestQueueGetAll(t *testing.T) {
 		t.Errorf("Actions not sorted by priority: %d < %d", 
 			actions[0].Priority, actions[1].Priority)
 	}
+
+	// Copies, read while the queue starts them
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for q.NextReady() != nil {
+		}
+	}()
+	for _, action := range q.GetAll() {
+		_ = action.StartedAt
+	}
+	<-done
+	if actions[0].StartedAt != nil {
+		t.Error("Expected the actions returned earlier to stay as they were")
+	}
+	found, err := q.Find(actions[0].ID)
+	if err != nil || found.StartedAt == nil {
+		t.Errorf("Expected Find to return the action as started, got %+v, %v", found, err)
+	}
+}
+
+func TestQueueDependencies(t *testing.T) {
+	testEnv := "test-dependencies"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	provision1 := q.Add("server1", status.ActionProvision, 0)
+	provision2 := q.Add("server2", status.ActionProvision, 0)
+	deploy1 := q.AddAction(&status.QueuedAction{
+		ServerName: "server1",
+		Action:     status.ActionDeploy,
+		DependsOn:  []string{provision1.ID},
+	})
+	q.AddAction(&status.QueuedAction{
+		ServerName: "server2",
+		Action:     status.ActionDeploy,
+		DependsOn:  []string{provision2.ID},
+	})
+
+	// Both provisions can start, the deploys must wait
+	first := q.NextReady()
+	second := q.NextReady()
+	if first == nil || second == nil || first.Action != status.ActionProvision || second.Action != status.ActionProvision {
+		t.Fatalf("Expected both provisions to be ready first, got %v and %v", first, second)
+	}
+	if next := q.NextReady(); next != nil {
+		t.Fatalf("Expected no ready action while provisions run, got %s for %s", next.Action, next.ServerName)
+	}
+
+	// server1 provision succeeds: its deploy becomes ready
+	if skipped := q.Finish(provision1.ID, true); len(skipped) != 0 {
+		t.Errorf("Expected no skipped action, got %d", len(skipped))
+	}
+	next := q.NextReady()
+	if next == nil || next.ID != deploy1.ID {
+		t.Fatalf("Expected deploy of server1 to be ready, got %v", next)
+	}
+
+	// server2 provision fails: its deploy is skipped
+	skipped := q.Finish(provision2.ID, false)
+	if len(skipped) != 1 || skipped[0].ServerName != "server2" || skipped[0].Action != status.ActionDeploy {
+		t.Fatalf("Expected deploy of server2 to be skipped, got %v", skipped)
+	}
+
+	q.Finish(deploy1.ID, true)
+	if size := q.Size(); size != 0 {
+		t.Errorf("Expected empty queue, got %d actions", size)
+	}
+}
+
+func TestQueueDependenciesPersisted(t *testing.T) {
+	testEnv := "test-dependencies-persistence"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q1, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	provision := q1.AddAction(&status.QueuedAction{ServerName: "server1", Action: status.ActionProvision, Tags: "nginx"})
+	q1.AddAction(&status.QueuedAction{ServerName: "server1", Action: status.ActionDeploy, DependsOn: []string{provision.ID}})
+
+	q2, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to load queue: %v", err)
+	}
+
+	actions := q2.GetAll()
+	if len(actions) != 2 {
+		t.Fatalf("Expected 2 actions after reload, got %d", len(actions))
+	}
+	if actions[0].Tags != "nginx" {
+		t.Errorf("Expected tags to be persisted, got %q", actions[0].Tags)
+	}
+	if len(actions[1].DependsOn) != 1 || actions[1].DependsOn[0] != provision.ID {
+		t.Errorf("Expected dependency to be persisted, got %v", actions[1].DependsOn)
+	}
+
+	// The deploy still waits for the reloaded provision
+	if next := q2.NextReady(); next == nil || next.ID != provision.ID {
+		t.Errorf("Expected provision to be ready first, got %v", next)
+	}
+	if next := q2.NextReady(); next != nil {
+		t.Errorf("Expected deploy to wait for provision, got %s", next.Action)
+	}
+}
+
+func TestQueueRolloutBatches(t *testing.T) {
+	testEnv := "test-rollout"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	var deploys []*status.QueuedAction
+	for i, name := range []string{"server1", "server2", "server3", "server4"} {
+		deploys = append(deploys, q.AddAction(&status.QueuedAction{
+			ServerName: name,
+			Action:     status.ActionDeploy,
+			Strategy:   ansible.StrategyRolling,
+			Rollout:    "rollout-1",
+			Batch:      i / 2,
+		}))
+	}
+
+	// Only the first batch can start
+	first := q.NextReady()
+	second := q.NextReady()
+	if first == nil || second == nil || first.Batch != 0 || second.Batch != 0 {
+		t.Fatalf("Expected the first batch to be ready, got %v and %v", first, second)
+	}
+	if next := q.NextReady(); next != nil {
+		t.Fatalf("Expected the second batch to wait, got %s", next.ServerName)
+	}
+
+	q.Finish(deploys[0].ID, true)
+	if done, _, _ := q.BatchResult("rollout-1", 0); done {
+		t.Error("Batch should not be done while server2 runs")
+	}
+	q.Finish(deploys[1].ID, false)
+	done, total, failed := q.BatchResult("rollout-1", 0)
+	if !done || total != 2 || failed != 1 {
+		t.Errorf("Expected batch 0 done with 1/2 failed, got done=%v %d/%d", done, failed, total)
+	}
+
+	// The rollout is stopped: the second batch is skipped
+	skipped := q.CancelRollout("rollout-1")
+	if len(skipped) != 2 {
+		t.Fatalf("Expected 2 skipped deploys, got %d", len(skipped))
+	}
+	if size := q.Size(); size != 0 {
+		t.Errorf("Expected empty queue, got %d actions", size)
+	}
+}
+
+func TestQueueNextReadyWhere(t *testing.T) {
+	testEnv := "test-next-ready-where"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	q.Add("server1", status.ActionProvision, 10)
+	deploy := q.Add("server2", status.ActionDeploy, 0)
+
+	next := q.NextReadyWhere(func(a *status.QueuedAction) bool {
+		return a.Action == status.ActionDeploy
+	})
+	if next == nil || next.ID != deploy.ID {
+		t.Fatalf("Expected the deploy to be picked, got %v", next)
+	}
+	if next := q.NextReady(); next == nil || next.Action != status.ActionProvision {
+		t.Fatalf("Expected the provision to still be ready, got %v", next)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	testEnv := "test-retry"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	provision := q.Add("server1", status.ActionProvision, 0)
+	q.AddAction(&status.QueuedAction{
+		ServerName: "server1",
+		Action:     status.ActionDeploy,
+		DependsOn:  []string{provision.ID},
+	})
+
+	if next := q.NextReady(); next == nil || next.ID != provision.ID {
+		t.Fatalf("Expected the provision to be ready, got %v", next)
+	}
+
+	retried := q.Retry(provision.ID, time.Hour)
+	if retried == nil || retried.Attempt != 1 {
+		t.Fatalf("Expected the provision to be retried as attempt 1, got %v", retried)
+	}
+	if next := q.NextReady(); next != nil {
+		t.Fatalf("Expected nothing ready during the backoff, got %s for %s", next.Action, next.ServerName)
+	}
+
+	// Once the backoff is over the provision runs again
+	q.Retry(provision.ID, 0)
+	if next := q.NextReady(); next == nil || next.ID != provision.ID || next.Attempt != 2 {
+		t.Fatalf("Expected the provision to be ready again, got %v", next)
+	}
+	if size := q.Size(); size != 2 {
+		t.Errorf("Expected the deploy to keep waiting, got %d actions", size)
+	}
 }
@@ -0,0 +1,131 @@
+package ansible_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+// startInterrupted queues a provision and a deploy depending on it, and starts
+// the provision as if the process running it then died
+func startInterrupted(t *testing.T, testEnv string) *status.QueuedAction {
+	t.Helper()
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	provision := q.AddAction(&status.QueuedAction{ServerName: "server1", Action: status.ActionProvision})
+	q.AddAction(&status.QueuedAction{ServerName: "server1", Action: status.ActionDeploy, DependsOn: []string{provision.ID}})
+	if next := q.NextReady(); next == nil || next.ID != provision.ID {
+		t.Fatalf("Expected provision to start, got %v", next)
+	}
+	return provision
+}
+
+func TestQueueResumeInterrupted(t *testing.T) {
+	testEnv := "test-resume-interrupted"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	provision := startInterrupted(t, testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	marked := q.MarkInterrupted()
+	if len(marked) != 1 || marked[0].ID != provision.ID {
+		t.Fatalf("Expected the provision to be interrupted, got %v", marked)
+	}
+	if again := q.MarkInterrupted(); len(again) != 0 {
+		t.Errorf("Expected actions to be marked once, got %d", len(again))
+	}
+	if next := q.NextReady(); next != nil {
+		t.Fatalf("Expected interrupted actions to wait for a decision, got %s", next.Action)
+	}
+
+	// The decision is still pending after a restart
+	q, err = ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	if interrupted := q.Interrupted(); len(interrupted) != 1 {
+		t.Fatalf("Expected 1 interrupted action after reload, got %d", len(interrupted))
+	}
+
+	q.ResumeInterrupted()
+	next := q.NextReady()
+	if next == nil || next.ID != provision.ID {
+		t.Fatalf("Expected the provision to run again, got %v", next)
+	}
+	if !next.Resumed {
+		t.Error("Expected the re-run to be flagged as resumed")
+	}
+}
+
+func TestQueueSkipInterrupted(t *testing.T) {
+	testEnv := "test-skip-interrupted"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	provision := startInterrupted(t, testEnv)
+
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	q.MarkInterrupted()
+
+	interrupted, skipped := q.SkipInterrupted()
+	if len(interrupted) != 1 || interrupted[0].ID != provision.ID {
+		t.Errorf("Expected the provision to be skipped, got %v", interrupted)
+	}
+	if len(skipped) != 1 || skipped[0].Action != status.ActionDeploy {
+		t.Errorf("Expected the dependent deploy to be skipped, got %v", skipped)
+	}
+	if size := q.Size(); size != 0 {
+		t.Errorf("Expected an empty queue, got %d actions", size)
+	}
+}
+
+func TestOrchestratorRecordsInterruptedRuns(t *testing.T) {
+	testEnv := "test-orchestrator-interrupted"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	startInterrupted(t, testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	if err := orchestrator.Start(nil); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer orchestrator.Stop()
+
+	if interrupted := orchestrator.InterruptedActions(); len(interrupted) != 1 {
+		t.Fatalf("Expected 1 interrupted action, got %d", len(interrupted))
+	}
+	runs, err := orchestrator.GetHistory().List(status.HistoryFilter{Status: status.RunInterrupted})
+	if err != nil {
+		t.Fatalf("Failed to list history: %v", err)
+	}
+	if len(runs) != 1 || runs[0].ServerName != "server1" {
+		t.Fatalf("Expected the interrupted run in the history, got %v", runs)
+	}
+	if st := statusMgr.GetStatus("server1"); st.State != status.StateUnknown {
+		t.Errorf("Expected the server state to be unknown, got %s", st.State)
+	}
+
+	if err := orchestrator.ResolveInterrupted(ansible.ResumeClear); err != nil {
+		t.Fatalf("ResolveInterrupted failed: %v", err)
+	}
+	if size := orchestrator.GetQueueSize(); size != 0 {
+		t.Errorf("Expected the queue to be cleared, got %d actions", size)
+	}
+}
@@ -0,0 +1,41 @@
+package ansible_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Host web-01 unreachable: Failed to connect to the host via ssh", ansible.FailureUnreachable},
+		{"Task 'Install packages' failed on web-01: Could not get lock /var/lib/dpkg/lock-frontend", ansible.FailureAptLock},
+		{"Task 'Wait for application to start' failed on web-01: Timeout when waiting for 127.0.0.1:3000", ansible.FailureTimeout},
+		{"SSH connection failed: dial tcp 10.0.0.1:22: connect: connection refused", ansible.FailureUnreachable},
+		{"Task 'Build application with npm' failed on web-01: non-zero return code", ""},
+		{"Server must be provisioned first", ""},
+		{"Host web-01 unreachable: Failed to connect to the host via ssh: Host key verification failed.", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ansible.ClassifyFailure(tt.message); got != tt.want {
+			t.Errorf("ClassifyFailure(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if d := ansible.RetryDelay(1); d != 10*time.Second {
+		t.Errorf("Expected 10s before the first retry, got %s", d)
+	}
+	if d := ansible.RetryDelay(3); d != 40*time.Second {
+		t.Errorf("Expected 40s before the third retry, got %s", d)
+	}
+	if d := ansible.RetryDelay(20); d != 5*time.Minute {
+		t.Errorf("Expected the delay to be capped at 5m, got %s", d)
+	}
+}
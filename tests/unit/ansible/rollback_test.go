@@ -0,0 +1,65 @@
+package ansible_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestValidateReleaseID(t *testing.T) {
+	valid := []string{"", "20240131T120000_abc1234", "v1.2.3", "release-2"}
+	for _, release := range valid {
+		if err := ansible.ValidateReleaseID(release); err != nil {
+			t.Errorf("ValidateReleaseID(%q) = %v, want nil", release, err)
+		}
+	}
+
+	invalid := []string{"../etc", "a b", "x;rm -rf /", "-e", ".hidden", "a/b"}
+	for _, release := range invalid {
+		if err := ansible.ValidateReleaseID(release); err == nil {
+			t.Errorf("ValidateReleaseID(%q) = nil, want an error", release)
+		}
+	}
+}
+
+func TestQueueRollback(t *testing.T) {
+	testEnv := "test-rollback"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+
+	if err := orchestrator.QueueRollback([]string{"web-1"}, 0, "../../etc"); err == nil {
+		t.Error("Expected an invalid release to be rejected")
+	}
+	if size := orchestrator.GetQueueSize(); size != 0 {
+		t.Fatalf("Expected nothing queued for an invalid release, got %d actions", size)
+	}
+
+	if err := orchestrator.QueueRollback([]string{"web-1", "web-2"}, 0, "20240131T120000_abc1234"); err != nil {
+		t.Fatalf("QueueRollback failed: %v", err)
+	}
+
+	// The release survives a restart of the queue
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	actions := q.GetAll()
+	if len(actions) != 2 {
+		t.Fatalf("Expected 2 queued rollbacks, got %d", len(actions))
+	}
+	for _, action := range actions {
+		if action.Action != status.ActionRollback || action.Release != "20240131T120000_abc1234" {
+			t.Errorf("Expected a rollback to 20240131T120000_abc1234, got %s to %q", action.Action, action.Release)
+		}
+	}
+}
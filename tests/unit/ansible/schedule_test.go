@@ -0,0 +1,148 @@
+package ansible_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+	"github.com/bastiblast/boiler-deploy/internal/config"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/schedule"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestOrchestratorScheduledActions(t *testing.T) {
+	testEnv := "test-orchestrator-scheduled"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+
+	startAt := time.Now().Add(time.Hour).Truncate(time.Minute)
+	orchestrator.SetSchedule(&startAt, false)
+	orchestrator.QueueProvisionThenDeploy([]string{"server1"}, 0, "", "")
+	orchestrator.SetSchedule(nil, false)
+
+	for _, action := range orchestrator.GetQueuedActions() {
+		if !action.Scheduled || action.NotBefore == nil || !action.NotBefore.Equal(startAt) {
+			t.Errorf("Expected %s to be scheduled at %s, got %v", action.Action, startAt, action.NotBefore)
+		}
+	}
+	if !orchestrator.IsIdle() {
+		t.Error("Expected the orchestrator to be idle until the scheduled time")
+	}
+	if next, ok := orchestrator.NextStart(); !ok || !next.Equal(startAt) {
+		t.Errorf("Expected the next start at %s, got %s (%v)", startAt, next, ok)
+	}
+
+	// Actions queued afterwards start at once
+	orchestrator.QueueCheck([]string{"server2"}, 0)
+	if orchestrator.IsIdle() {
+		t.Error("Expected the check to keep the orchestrator busy")
+	}
+
+	// The schedule survives a restart
+	q, err := ansible.NewQueue(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload queue: %v", err)
+	}
+	if next := q.NextReady(); next == nil || next.ServerName != "server2" {
+		t.Fatalf("Expected only the check to start, got %v", next)
+	}
+	if next := q.NextReady(); next != nil {
+		t.Errorf("Expected the scheduled actions to wait, got %s on %s", next.Action, next.ServerName)
+	}
+}
+
+func TestOrchestratorMaintenanceWindow(t *testing.T) {
+	testEnv := "test-orchestrator-window"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	orchestrator, err := ansible.NewOrchestrator(testEnv, statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+
+	// A one hour window starting in two hours, closed now
+	now := time.Now()
+	opening := now.Add(2 * time.Hour)
+	spec := opening.Format("15:04") + "-" + opening.Add(time.Hour).Format("15:04")
+	window, err := schedule.ParseWindow(spec, now.Location())
+	if err != nil {
+		t.Fatalf("ParseWindow failed: %v", err)
+	}
+	orchestrator.SetMaintenanceWindow(window, true)
+
+	if err := orchestrator.CheckWindow(status.ActionDeploy, now); !errors.Is(err, ansible.ErrOutsideWindow) {
+		t.Errorf("Expected a production deploy outside the window to be refused, got %v", err)
+	}
+	if err := orchestrator.CheckWindow(status.ActionDeploy, opening.Add(time.Minute)); err != nil {
+		t.Errorf("Expected a deploy within the window to be allowed, got %v", err)
+	}
+	if err := orchestrator.CheckWindow(status.ActionRollback, now); err != nil {
+		t.Errorf("Expected rollbacks to be allowed at any time, got %v", err)
+	}
+
+	orchestrator.SetSchedule(nil, true)
+	orchestrator.QueueDeploy([]string{"server1"}, 0)
+	if actions := orchestrator.GetQueuedActions(); len(actions) != 1 || !actions[0].InWindow {
+		t.Fatalf("Expected a deploy waiting for the window, got %v", actions)
+	}
+	if !orchestrator.IsIdle() {
+		t.Error("Expected the orchestrator to be idle while the window is closed")
+	}
+	if next, ok := orchestrator.NextStart(); !ok || next.Before(opening.Truncate(time.Minute)) || next.After(opening) {
+		t.Errorf("Expected the next start at the opening %s, got %s (%v)", opening, next, ok)
+	}
+
+	// Open now
+	always, err := schedule.ParseWindow("00:00-24:00", now.Location())
+	if err != nil {
+		t.Fatalf("ParseWindow failed: %v", err)
+	}
+	orchestrator.SetMaintenanceWindow(always, true)
+	if orchestrator.IsIdle() {
+		t.Error("Expected the deploy to be runnable with the window open")
+	}
+}
+
+func TestEnvironmentOrchestratorWindow(t *testing.T) {
+	testEnv := "test-orchestrator-env-window"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	statusMgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create status manager: %v", err)
+	}
+	env := &inventory.Environment{Name: testEnv}
+	env.Config.Production = true
+
+	// A window which cannot be enforced refuses the environment
+	env.Config.MaintenanceWindow = "Sun 25:00-26:00"
+	if _, err := ansible.NewEnvironmentOrchestrator(testEnv, env, config.DefaultConfig(), statusMgr); err == nil {
+		t.Fatal("Expected an invalid maintenance window to be refused")
+	}
+
+	now := time.Now()
+	opening := now.Add(2 * time.Hour)
+	env.Config.MaintenanceWindow = opening.Format("15:04") + "-" + opening.Add(time.Hour).Format("15:04")
+	orchestrator, err := ansible.NewEnvironmentOrchestrator(testEnv, env, config.DefaultConfig(), statusMgr)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	if err := orchestrator.CheckWindow(status.ActionDeploy, now); !errors.Is(err, ansible.ErrOutsideWindow) {
+		t.Errorf("Expected a production deploy outside the window to be refused, got %v", err)
+	}
+}
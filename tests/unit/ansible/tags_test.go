@@ -0,0 +1,34 @@
+package ansible_test
+
+import (
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/ansible"
+)
+
+func TestProvisionTagsFor(t *testing.T) {
+	defaults := "common,security,nodejs,nginx,postgresql"
+
+	tests := []struct {
+		serverType string
+		tags       string
+		want       string
+	}{
+		{"web", defaults, "common,security,nodejs,nginx"},
+		{"db", defaults, "common,security,postgresql"},
+		{"monitoring", defaults, "common,security,monitoring"},
+		{"db", "common,nodejs,monitoring", "common,monitoring,postgresql"},
+		// No role was chosen, none is added
+		{"web", "security,monitoring", "security,monitoring"},
+		{"db", "security", "security"},
+		// The whole playbook
+		{"db", "", ""},
+		{"monitoring", "all", "all"},
+	}
+
+	for _, tt := range tests {
+		if got := ansible.ProvisionTagsFor(tt.serverType, tt.tags); got != tt.want {
+			t.Errorf("ProvisionTagsFor(%q, %q) = %q, want %q", tt.serverType, tt.tags, got, tt.want)
+		}
+	}
+}
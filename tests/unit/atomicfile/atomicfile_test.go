@@ -0,0 +1,93 @@
+package atomicfile_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
+)
+
+func decodeJSON(target *map[string]int) func([]byte) error {
+	return func(data []byte) error {
+		*target = nil
+		return json.Unmarshal(data, target)
+	}
+}
+
+func TestWriteFileWithBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	if err := atomicfile.WriteFileWithBackup(path, []byte(`{"v": 1}`), 0644); err != nil {
+		t.Fatalf("First write failed: %v", err)
+	}
+	if _, err := os.Stat(path + atomicfile.BackupSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected no backup of a new file, got %v", err)
+	}
+
+	if err := atomicfile.WriteFileWithBackup(path, []byte(`{"v": 2}`), 0644); err != nil {
+		t.Fatalf("Second write failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"v": 2}` {
+		t.Errorf("Unexpected content: %s", data)
+	}
+	if data, _ := os.ReadFile(path + atomicfile.BackupSuffix); string(data) != `{"v": 1}` {
+		t.Errorf("Expected the backup to hold the previous version, got %s", data)
+	}
+
+	// No temporary file is left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Errorf("Expected the file and its backup only, got %d entries", len(entries))
+	}
+}
+
+func TestReadFileRecoversFromBackup(t *testing.T) {
+	atomicfile.TakeWarnings()
+	path := filepath.Join(t.TempDir(), "state.json")
+	atomicfile.WriteFileWithBackup(path, []byte(`{"v": 1}`), 0644)
+	atomicfile.WriteFileWithBackup(path, []byte(`{"v": 2}`), 0644)
+
+	// A crash in the middle of a write
+	os.WriteFile(path, []byte(`{"v": `), 0644)
+
+	var state map[string]int
+	if err := atomicfile.ReadFile(path, decodeJSON(&state)); err != nil {
+		t.Fatalf("Expected the backup to be read, got %v", err)
+	}
+	if state["v"] != 1 {
+		t.Errorf("Expected the previous version, got %v", state)
+	}
+
+	warnings := atomicfile.TakeWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "recovered") {
+		t.Errorf("Expected a recovery warning, got %v", warnings)
+	}
+	if data, _ := os.ReadFile(path + atomicfile.CorruptSuffix); string(data) != `{"v": ` {
+		t.Errorf("Expected the damaged file to be kept aside, got %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"v": 1}` {
+		t.Errorf("Expected the recovered version to be restored, got %q", data)
+	}
+}
+
+func TestReadFileWithoutBackup(t *testing.T) {
+	atomicfile.TakeWarnings()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	var state map[string]int
+	if err := atomicfile.ReadFile(path, decodeJSON(&state)); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing file to be reported as such, got %v", err)
+	}
+
+	// Truncated to nothing
+	os.WriteFile(path, nil, 0644)
+	if err := atomicfile.ReadFile(path, decodeJSON(&state)); err == nil {
+		t.Fatal("Expected an empty file without backup to fail")
+	}
+	if warnings := atomicfile.TakeWarnings(); len(warnings) != 1 {
+		t.Errorf("Expected a warning, got %v", warnings)
+	}
+}
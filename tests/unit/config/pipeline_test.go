@@ -0,0 +1,83 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/config"
+)
+
+func TestLoadPipeline(t *testing.T) {
+	dir := t.TempDir()
+	mgr := config.NewManager(dir)
+
+	if _, err := mgr.LoadPipeline(); !errors.Is(err, config.ErrNoPipeline) {
+		t.Fatalf("Expected ErrNoPipeline without pipeline.yml, got %v", err)
+	}
+
+	data := `stages:
+  - environment: dev
+  - environment: staging
+    health_check: false
+  - environment: prod
+    approval: true
+`
+	if err := os.WriteFile(filepath.Join(dir, config.PipelineFile), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write pipeline: %v", err)
+	}
+	pipeline, err := mgr.LoadPipeline()
+	if err != nil {
+		t.Fatalf("LoadPipeline failed: %v", err)
+	}
+	if len(pipeline.Stages) != 3 {
+		t.Fatalf("Expected 3 stages, got %d", len(pipeline.Stages))
+	}
+	if !pipeline.Stages[0].HealthGate() || pipeline.Stages[1].HealthGate() {
+		t.Error("Expected the health gate to default to true and be disabled for staging")
+	}
+	if !pipeline.Stages[2].Approval {
+		t.Error("Expected prod to require approval")
+	}
+}
+
+func TestPipelinePromotion(t *testing.T) {
+	pipeline := &config.Pipeline{Stages: []config.Stage{
+		{Environment: "dev"},
+		{Environment: "staging"},
+		{Environment: "prod", Approval: true},
+	}}
+
+	stage, source, err := pipeline.Promotion("", "prod")
+	if err != nil || source != "staging" || !stage.Approval {
+		t.Errorf("Expected prod promoted from staging with approval, got %s %+v (%v)", source, stage, err)
+	}
+	if _, source, err := pipeline.Promotion("dev", "prod"); err != nil || source != "dev" {
+		t.Errorf("Expected prod promoted from dev, got %s (%v)", source, err)
+	}
+
+	for _, tt := range []struct{ from, target string }{
+		{"", "dev"},         // First stage
+		{"", "qa"},          // Not a stage
+		{"prod", "staging"}, // Comes after the target
+		{"qa", "prod"},      // Source not a stage
+	} {
+		if _, _, err := pipeline.Promotion(tt.from, tt.target); err == nil {
+			t.Errorf("Expected promoting %q to %q to be refused", tt.from, tt.target)
+		}
+	}
+}
+
+func TestPipelineValidate(t *testing.T) {
+	invalid := []config.Pipeline{
+		{Stages: []config.Stage{{Environment: "dev"}}},
+		{Stages: []config.Stage{{Environment: "dev"}, {Environment: ""}}},
+		{Stages: []config.Stage{{Environment: "dev"}, {Environment: "dev"}}},
+	}
+	for _, pipeline := range invalid {
+		if err := pipeline.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", pipeline.Stages)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package gitref_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/gitref"
+)
+
+// newRepo creates a repository with a commit on main, a lightweight tag and
+// an annotated tag, and returns its path and the commit
+func newRepo(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	git("tag", "v1.0.0")
+	git("tag", "-a", "-m", "release", "v1.1.0")
+	return dir, git("rev-parse", "HEAD")
+}
+
+func TestResolve(t *testing.T) {
+	repo, commit := newRepo(t)
+
+	for _, ref := range []string{"main", "", "v1.0.0", "v1.1.0", "refs/heads/main"} {
+		got, err := gitref.Resolve(context.Background(), repo, ref)
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %v", ref, err)
+			continue
+		}
+		if got != commit {
+			t.Errorf("Resolve(%q) = %s, expected %s", ref, got, commit)
+		}
+	}
+
+	if _, err := gitref.Resolve(context.Background(), repo, "missing"); err == nil {
+		t.Error("Expected an unknown branch to fail")
+	}
+}
+
+func TestResolveSHA(t *testing.T) {
+	// A full SHA is not looked up
+	sha := "5AADCAED9C2F94D25B5936CF3E6989C92AEEEBEA"
+	got, err := gitref.Resolve(context.Background(), "/nonexistent", sha)
+	if err != nil || got != strings.ToLower(sha) {
+		t.Errorf("Resolve(sha) = %s, %v", got, err)
+	}
+	if gitref.IsSHA("abc1234") {
+		t.Error("Expected a short commit not to be a full SHA")
+	}
+}
+
+func TestResolveAbbreviatedSHA(t *testing.T) {
+	repo, commit := newRepo(t)
+
+	// Not a ref of the repository, the full SHA is asked for
+	_, err := gitref.Resolve(context.Background(), repo, commit[:7])
+	if err == nil || !strings.Contains(err.Error(), "use the full 40-character SHA") {
+		t.Errorf("Expected an abbreviated SHA to be refused, got %v", err)
+	}
+
+	// Unless a branch has that name
+	cmd := exec.Command("git", "branch", "cafe")
+	cmd.Dir = repo
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git branch failed: %v\n%s", err, output)
+	}
+	if got, err := gitref.Resolve(context.Background(), repo, "cafe"); err != nil || got != commit {
+		t.Errorf("Resolve(cafe) = %s, %v, expected %s", got, err, commit)
+	}
+}
@@ -0,0 +1,134 @@
+package health_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/health"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
+func newChecker(t *testing.T, spec inventory.HealthCheckSpec) *health.Checker {
+	t.Helper()
+	checker, err := health.NewChecker(spec, nil)
+	if err != nil {
+		t.Fatalf("NewChecker failed: %v", err)
+	}
+	return checker
+}
+
+func TestCheckStatusAndPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	checker := newChecker(t, inventory.HealthCheckSpec{Path: "api/health"})
+	if err := checker.Check(context.Background(), server.URL+"/api/health"); err != nil {
+		t.Errorf("Expected 204 to be accepted by default, got %v", err)
+	}
+
+	strict := newChecker(t, inventory.HealthCheckSpec{ExpectStatus: []int{200}})
+	err := strict.Check(context.Background(), server.URL+"/api/health")
+	if err == nil || !strings.Contains(err.Error(), "204") {
+		t.Errorf("Expected 204 to be rejected when 200 is expected, got %v", err)
+	}
+
+	if err := checker.Check(context.Background(), server.URL+"/"); err == nil {
+		t.Error("Expected 404 to fail the check")
+	}
+}
+
+func TestCheckBodyAndJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "ok", "checks": {"database": "up", "replicas": 2}}`))
+	}))
+	defer server.Close()
+
+	headers := map[string]string{"X-Token": "secret"}
+	tests := []struct {
+		name string
+		spec inventory.HealthCheckSpec
+		ok   bool
+	}{
+		{"body match", inventory.HealthCheckSpec{BodyRegex: `"status":\s*"ok"`}, true},
+		{"body mismatch", inventory.HealthCheckSpec{BodyRegex: `"status":\s*"degraded"`}, false},
+		{"json field", inventory.HealthCheckSpec{JSONField: "checks.database", JSONValue: "up"}, true},
+		{"json number", inventory.HealthCheckSpec{JSONField: "checks.replicas", JSONValue: "2"}, true},
+		{"json field present", inventory.HealthCheckSpec{JSONField: "checks.database"}, true},
+		{"json wrong value", inventory.HealthCheckSpec{JSONField: "checks.database", JSONValue: "down"}, false},
+		{"json missing field", inventory.HealthCheckSpec{JSONField: "checks.cache"}, false},
+	}
+
+	for _, tt := range tests {
+		tt.spec.Headers = headers
+		err := newChecker(t, tt.spec).Check(context.Background(), server.URL)
+		if tt.ok && err != nil {
+			t.Errorf("%s: expected success, got %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected failure", tt.name)
+		}
+	}
+
+	if err := newChecker(t, inventory.HealthCheckSpec{}).Check(context.Background(), server.URL); err == nil {
+		t.Error("Expected 401 without the header")
+	}
+}
+
+func TestCheckTLSVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if err := newChecker(t, inventory.HealthCheckSpec{}).Check(context.Background(), server.URL); err == nil {
+		t.Error("Expected self-signed certificate to be rejected by default")
+	}
+
+	verify := false
+	if err := newChecker(t, inventory.HealthCheckSpec{TLSVerify: &verify}).Check(context.Background(), server.URL); err != nil {
+		t.Errorf("Expected success with tls_verify disabled, got %v", err)
+	}
+}
+
+func TestWaitRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	spec := inventory.HealthCheckSpec{Retries: 3, Interval: 10 * time.Millisecond}
+	attempts, err := newChecker(t, spec).Wait(context.Background(), server.URL)
+	if err != nil || attempts != 3 {
+		t.Errorf("Expected success on attempt 3, got %d attempts and %v", attempts, err)
+	}
+
+	spec.Retries = 1
+	atomic.StoreInt32(&calls, 0)
+	attempts, err = newChecker(t, spec).Wait(context.Background(), server.URL)
+	if err == nil || attempts != 2 {
+		t.Errorf("Expected failure after 2 attempts, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestInvalidBodyRegex(t *testing.T) {
+	if _, err := health.NewChecker(inventory.HealthCheckSpec{BodyRegex: "("}, nil); err == nil {
+		t.Error("Expected invalid regex to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package inventory_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/bastiblast/boiler-deploy/internal/inventory"
@@ -122,6 +123,61 @@ func TestGenerateHostsYAML_MultipleServerTypes(t *testing.T) {
 	if len(dbHosts) != 1 {
 		t.Errorf("Expected 1 db server, got %d", len(dbHosts))
 	}
+
+	// Ansible verifies host keys against the environment known_hosts
+	web1 := webHosts["web1"].(map[string]interface{})
+	want := "-o UserKnownHostsFile=inventory/production/.ssh/known_hosts -o StrictHostKeyChecking=accept-new -o IdentitiesOnly=yes"
+	if args := web1["ansible_ssh_common_args"]; args != want {
+		t.Errorf("Expected ansible_ssh_common_args %q, got %v", want, args)
+	}
+}
+
+func TestGenerateHostsYAML_JumpHost(t *testing.T) {
+	gen := inventory.NewGenerator()
+
+	env := inventory.Environment{
+		Name: "production",
+		Config: inventory.Config{
+			JumpHost: &inventory.JumpHost{Host: "bastion.example.com"},
+		},
+		Servers: []inventory.Server{
+			{Name: "web1", IP: "10.0.0.10", Port: 22, SSHUser: "deploy", Type: "web"},
+			{Name: "web2", IP: "10.0.0.11", Port: 22, SSHUser: "deploy", Type: "web",
+				JumpHost: &inventory.JumpHost{Host: "10.1.0.1", Port: 2222, User: "ops", SSHKeyPath: "/keys/bastion"}},
+			{Name: "web3", IP: "203.0.113.5", Port: 22, SSHUser: "deploy", Type: "web",
+				JumpHost: &inventory.JumpHost{}}, // Reached directly
+		},
+	}
+
+	data, err := gen.GenerateHostsYAML(env)
+	if err != nil {
+		t.Fatalf("GenerateHostsYAML failed: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	hosts := result["all"].(map[string]interface{})["children"].(map[string]interface{})["webservers"].(map[string]interface{})["hosts"].(map[string]interface{})
+	args := func(name string) string {
+		return hosts[name].(map[string]interface{})["ansible_ssh_common_args"].(string)
+	}
+
+	// The environment jump host, with the user of the server and the default port
+	if !strings.Contains(args("web1"), "-o ProxyJump=deploy@bastion.example.com:22") {
+		t.Errorf("Expected web1 to use the environment jump host, got %q", args("web1"))
+	}
+
+	// A jump host with its own key needs a ProxyCommand
+	want := `-o ProxyCommand="ssh -W %h:%p -p 2222 -i /keys/bastion -o IdentitiesOnly=yes ` +
+		`-o UserKnownHostsFile=inventory/production/.ssh/known_hosts -o StrictHostKeyChecking=accept-new ops@10.1.0.1"`
+	if !strings.Contains(args("web2"), want) {
+		t.Errorf("Expected web2 ProxyCommand %q, got %q", want, args("web2"))
+	}
+
+	if strings.Contains(args("web3"), "Proxy") {
+		t.Errorf("Expected web3 to be reached directly, got %q", args("web3"))
+	}
 }
 
 func TestGenerateGroupVarsYAML(t *testing.T) {
@@ -194,6 +250,39 @@ func TestGenerateGroupVarsYAML(t *testing.T) {
 	}
 }
 
+func TestGenerateDBServersVarsYAML(t *testing.T) {
+	gen := inventory.NewGenerator()
+
+	data, err := gen.GenerateDBServersVarsYAML(inventory.Environment{Name: "staging"})
+	if err != nil {
+		t.Fatalf("Failed to generate dbservers group_vars: %v", err)
+	}
+
+	var result struct {
+		Version   string              `yaml:"postgresql_version"`
+		Databases []map[string]string `yaml:"postgresql_databases"`
+		Users     []map[string]string `yaml:"postgresql_users"`
+	}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Generated invalid YAML: %v\nYAML content:\n%s", err, string(data))
+	}
+
+	// The postgresql role installs postgresql-<version> and loops on both lists
+	if result.Version == "" {
+		t.Error("Missing postgresql_version")
+	}
+	if len(result.Databases) != 1 || result.Databases[0]["name"] == "" || result.Databases[0]["encoding"] == "" {
+		t.Errorf("Unexpected postgresql_databases: %v", result.Databases)
+	}
+	if len(result.Users) != 1 || result.Users[0]["db"] != result.Databases[0]["name"] {
+		t.Fatalf("Unexpected postgresql_users: %v", result.Users)
+	}
+	// The password stays out of the inventory tracked by git
+	if password := result.Users[0]["password"]; !strings.Contains(password, "lookup('file'") || !strings.Contains(password, inventory.DBPasswordFile) {
+		t.Errorf("Expected the password to be looked up in %s, got %q", inventory.DBPasswordFile, password)
+	}
+}
+
 func TestGenerateHostVarsYAML_WebServer(t *testing.T) {
 	gen := inventory.NewGenerator()
 
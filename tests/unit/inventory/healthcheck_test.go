@@ -0,0 +1,58 @@
+package inventory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHealthCheckSpecMerge(t *testing.T) {
+	env := inventory.HealthCheckSpec{
+		Path:    "/health",
+		Timeout: 30 * time.Second,
+		Retries: 3,
+		Headers: map[string]string{"Host": "app.example.com"},
+	}
+
+	server := env.Merge(&inventory.HealthCheckSpec{
+		Path:    "/ready",
+		Headers: map[string]string{"X-Token": "secret"},
+	})
+	if server.Path != "/ready" || server.Timeout != 30*time.Second || server.Retries != 3 {
+		t.Errorf("Unexpected merged spec: %+v", server)
+	}
+	if server.Headers["Host"] != "app.example.com" || server.Headers["X-Token"] != "secret" {
+		t.Errorf("Expected headers to be merged, got %v", server.Headers)
+	}
+	if _, ok := env.Headers["X-Token"]; ok {
+		t.Error("Merge must not modify the environment headers")
+	}
+
+	if same := env.Merge(nil); same.Path != "/health" {
+		t.Errorf("Expected nil override to keep the spec, got %+v", same)
+	}
+}
+
+func TestHealthCheckSpecYAML(t *testing.T) {
+	data := []byte(`
+path: /api/health
+expect_status: [200, 204]
+json_field: status
+json_value: ok
+tls_verify: false
+timeout: 5s
+interval: 2s
+`)
+	var spec inventory.HealthCheckSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if spec.Timeout != 5*time.Second || spec.Interval != 2*time.Second {
+		t.Errorf("Expected durations to be parsed, got %s and %s", spec.Timeout, spec.Interval)
+	}
+	if len(spec.ExpectStatus) != 2 || spec.TLSVerify == nil || *spec.TLSVerify {
+		t.Errorf("Unexpected spec: %+v", spec)
+	}
+}
@@ -0,0 +1,78 @@
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"gopkg.in/yaml.v3"
+)
+
+func probeNames(probes []inventory.ProbeSpec) []string {
+	names := make([]string, len(probes))
+	for i, probe := range probes {
+		names[i] = probe.Name
+	}
+	return names
+}
+
+func TestDefaultProbesPerServerType(t *testing.T) {
+	for serverType, probe := range map[string]string{"web": "nginx", "": "nginx", "db": "postgres", "monitoring": "node_exporter"} {
+		found := false
+		for _, name := range probeNames(inventory.ResolveProbes(serverType, nil)) {
+			found = found || name == probe
+		}
+		if !found {
+			t.Errorf("Expected %q servers to be probed for %s", serverType, probe)
+		}
+	}
+}
+
+func TestResolveProbes(t *testing.T) {
+	var config inventory.Config
+	err := yaml.Unmarshal([]byte(`
+probes:
+  db:
+    - name: postgres_running
+      disabled: true
+    - name: postgres
+      command: test -x /usr/lib/postgresql/16/bin/postgres
+    - name: replication
+      command: psql -tAc 'select pg_is_in_recovery()'
+      expect: "^f$"
+      gates: deployed
+`), &config)
+	if err != nil {
+		t.Fatalf("Failed to parse probes: %v", err)
+	}
+
+	probes := inventory.ResolveProbes("db", config.Probes)
+	names := probeNames(probes)
+	if len(names) != 2 || names[0] != "postgres" || names[1] != "replication" {
+		t.Fatalf("Expected [postgres replication], got %v", names)
+	}
+	if probes[0].Command != "test -x /usr/lib/postgresql/16/bin/postgres" || probes[0].Gates != inventory.ProbeGatesProvisioned {
+		t.Errorf("Expected the configured postgres probe gating provisioned, got %+v", probes[0])
+	}
+	if probes[1].Gates != inventory.ProbeGatesDeployed || probes[1].Expect != "^f$" {
+		t.Errorf("Unexpected replication probe: %+v", probes[1])
+	}
+
+	// Other types keep their defaults
+	if web := inventory.ResolveProbes("web", config.Probes); len(web) != len(inventory.DefaultProbes("web")) {
+		t.Errorf("Expected the default web probes, got %v", probeNames(web))
+	}
+}
+
+func TestExpandProbeCommand(t *testing.T) {
+	env := inventory.Environment{Name: "shop"}
+	vars := inventory.ProbeVars(env, inventory.Server{SSHUser: "deploy"})
+
+	got := inventory.ExpandProbeCommand("test -L {{ app_current_dir }} && ls /home/{{user}} {{ unknown }} ${HOME}", vars)
+	want := "test -L /var/www/shop/current && ls /home/deploy {{ unknown }} ${HOME}"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if vars["app_port"] != "3000" {
+		t.Errorf("Expected the default app_port, got %s", vars["app_port"])
+	}
+}
@@ -0,0 +1,25 @@
+package inventory_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+)
+
+func TestSplitDeployable(t *testing.T) {
+	servers := []*inventory.Server{
+		{Name: "web-1", Type: "web"},
+		{Name: "db-1", Type: "db"},
+		{Name: "app-1"},
+		{Name: "mon-1", Type: "monitoring"},
+	}
+
+	deployable, others := inventory.SplitDeployable(servers, []string{"mon-1", "web-1", "db-1", "app-1", "unknown"})
+	if want := []string{"web-1", "app-1", "unknown"}; !reflect.DeepEqual(deployable, want) {
+		t.Errorf("Expected deployable %v, got %v", want, deployable)
+	}
+	if want := []string{"mon-1", "db-1"}; !reflect.DeepEqual(others, want) {
+		t.Errorf("Expected others %v, got %v", want, others)
+	}
+}
@@ -1,6 +1,7 @@
 package inventory_test
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -149,6 +150,27 @@ func TestValidateSSHKeyPath_NonExistingFile(t *testing.T) {
 	}
 }
 
+func TestValidateSSHKeyPath_EmptyWithAgent(t *testing.T) {
+	validator := inventory.NewValidator()
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if err := validator.ValidateSSHKeyPath(""); err == nil {
+		t.Error("Empty key path accepted without an SSH agent")
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Cannot create unix socket: %v", err)
+	}
+	defer listener.Close()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	if err := validator.ValidateSSHKeyPath(""); err != nil {
+		t.Errorf("Empty key path rejected with an SSH agent: %v", err)
+	}
+}
+
 func TestValidateSSHKeyPath_HomeDirectory(t *testing.T) {
 	validator := inventory.NewValidator()
 
@@ -0,0 +1,105 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/schedule"
+)
+
+// at returns a time of the first week of 2023, which starts on Sunday the 1st
+func at(day, hour, minute int) time.Time {
+	return time.Date(2023, time.January, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestWindowContains(t *testing.T) {
+	window, err := schedule.ParseWindow("Sun 01:00-04:00; Mon-Fri 22:00-02:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindow failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"sunday night", at(1, 2, 30), true},
+		{"sunday opening", at(1, 1, 0), true},
+		{"sunday closing", at(1, 4, 0), false},
+		{"sunday noon", at(1, 12, 0), false},
+		{"monday evening", at(2, 23, 0), true},
+		{"tuesday after midnight", at(3, 1, 59), true},
+		{"monday after midnight", at(2, 1, 0), false}, // Sunday 22:00 is not a range
+		{"saturday after friday night", at(7, 1, 0), true},
+		{"saturday night", at(7, 23, 0), false},
+	}
+	for _, tt := range tests {
+		if got := window.Contains(tt.t); got != tt.want {
+			t.Errorf("%s: Contains(%s) = %v, want %v", tt.name, tt.t.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	window, err := schedule.ParseWindow("Sun 01:00-04:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindow failed: %v", err)
+	}
+
+	if next := window.Next(at(1, 2, 0)); !next.Equal(at(1, 2, 0)) {
+		t.Errorf("Expected a time inside the window to be kept, got %s", next)
+	}
+	if next := window.Next(at(1, 5, 0)); !next.Equal(at(8, 1, 0)) {
+		t.Errorf("Expected the next Sunday opening, got %s", next)
+	}
+	if next := window.Next(at(4, 12, 0)); !next.Equal(at(8, 1, 0)) {
+		t.Errorf("Expected Sunday 01:00, got %s", next)
+	}
+
+	daily, err := schedule.ParseWindow("daily 02:00-03:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindow failed: %v", err)
+	}
+	if next := daily.Next(at(4, 12, 0)); !next.Equal(at(5, 2, 0)) {
+		t.Errorf("Expected tomorrow 02:00, got %s", next)
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	if window, err := schedule.ParseWindow("  ", time.UTC); window != nil || err != nil {
+		t.Errorf("Expected no window for an empty spec, got %v (%v)", window, err)
+	}
+	for _, spec := range []string{"Sun", "Sun 01:00", "Funday 01:00-02:00", "Sun 25:00-26:00", "Sun 01:00-01:00", "Sun 1h-2h", "Sun Mon 01:00-02:00"} {
+		if _, err := schedule.ParseWindow(spec, time.UTC); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := at(1, 12, 0)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"14:30", at(1, 14, 30)},
+		{"02:00", at(2, 2, 0)}, // Already past today
+		{"2023-01-05 03:15", at(5, 3, 15)},
+		{"2023-01-05T03:15:00Z", at(5, 3, 15)},
+	}
+	for _, tt := range tests {
+		got, err := schedule.ParseTime(tt.value, now)
+		if err != nil {
+			t.Errorf("ParseTime(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := schedule.ParseTime("tomorrow", now); err == nil {
+		t.Error("Expected an invalid time to be rejected")
+	}
+}
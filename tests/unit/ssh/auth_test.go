@@ -0,0 +1,87 @@
+package ssh_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeEncryptedKey writes a passphrase-protected private key and returns its path
+func writeEncryptedKey(t *testing.T, passphrase string) string {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte(passphrase))
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+func TestPassphraseProtectedKey(t *testing.T) {
+	// No agent may hold the key
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-passphrase"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	hostKeys := internalssh.NewHostKeys(testEnv)
+	keyPath := writeEncryptedKey(t, "correct horse")
+	port := startServer(t, newSigner(t))
+
+	_, err := internalssh.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if !internalssh.IsPassphraseRequired(err) {
+		t.Fatalf("Expected a passphrase required error, got %v", err)
+	}
+	result := internalssh.TestConnection("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if result.Success || !result.KeyLocked {
+		t.Errorf("Expected TestConnection to report the locked key, got %+v", result)
+	}
+
+	locked := internalssh.LockedKeys([]string{keyPath, keyPath, ""})
+	if len(locked) != 1 || locked[0] != keyPath {
+		t.Fatalf("Expected %s to be locked once, got %v", keyPath, locked)
+	}
+
+	if err := internalssh.UnlockKey(keyPath, []byte("wrong")); !errors.Is(err, internalssh.ErrWrongPassphrase) {
+		t.Fatalf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := internalssh.UnlockKey(keyPath, []byte("correct horse")); err != nil {
+		t.Fatalf("UnlockKey failed: %v", err)
+	}
+	if locked := internalssh.LockedKeys([]string{keyPath}); len(locked) != 0 {
+		t.Errorf("Expected no locked key after unlock, got %v", locked)
+	}
+
+	client, err := internalssh.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("Connection after unlock failed: %v", err)
+	}
+	client.Close()
+
+	// The unlocked key is served to ansible by the session agent
+	socket, err := internalssh.AgentSocket()
+	if err != nil || socket == "" {
+		t.Fatalf("Expected a session agent socket, got %q (%v)", socket, err)
+	}
+	internalssh.StopSessionAgent()
+}
+
+func TestUnencryptedKeyIsNotLocked(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if locked := internalssh.LockedKeys([]string{writeClientKey(t)}); len(locked) != 0 {
+		t.Errorf("Expected no locked key, got %v", locked)
+	}
+}
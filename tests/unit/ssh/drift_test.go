@@ -0,0 +1,94 @@
+package ssh_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+)
+
+func TestParseVersionFacts(t *testing.T) {
+	output := strings.Join([]string{
+		"@@release 20240131T120000_abc1234",
+		"@@commit ",
+		"@@node v20.11.1",
+		"@@nginx 1.24.0",
+		"@@pm2 2",
+		"",
+	}, "\n")
+
+	facts := internalssh.ParseVersionFacts(output)
+	if facts.Release != "20240131T120000_abc1234" || facts.Node != "v20.11.1" || facts.Nginx != "1.24.0" || facts.PM2Online != 2 {
+		t.Errorf("Unexpected facts: %+v", facts)
+	}
+	// Without git, the commit comes from the release name
+	if facts.Commit != "abc1234" {
+		t.Errorf("Expected commit abc1234, got %q", facts.Commit)
+	}
+
+	// PM2 could not be queried
+	if facts := internalssh.ParseVersionFacts("@@pm2\n"); facts.PM2Online != -1 {
+		t.Errorf("Expected unknown PM2 count, got %d", facts.PM2Online)
+	}
+}
+
+func TestCompareDrift(t *testing.T) {
+	const main = "5aadcaed9c2f94d25b5936cf3e6989c92aeeebea"
+	server := func(name, commit, node, nginx string, pm2 int) internalssh.ServerDrift {
+		return internalssh.ServerDrift{
+			Server:           name,
+			Branch:           "main",
+			ConfiguredCommit: main,
+			ConfiguredNode:   "20",
+			Deployed:         internalssh.VersionFacts{Commit: commit, Node: node, Nginx: nginx, PM2Online: pm2},
+		}
+	}
+	results := []internalssh.ServerDrift{
+		server("web-1", main, "v20.11.1", "1.24.0", 1),
+		server("web-2", main, "v20.11.1", "1.24.0", 1),
+		server("web-3", "abc1234", "v18.19.0", "1.18.0", 1),
+		{Server: "web-4", Err: errors.New("unreachable")},
+	}
+
+	internalssh.CompareDrift(results)
+
+	for _, result := range results[:2] {
+		if result.Drifted() {
+			t.Errorf("Expected %s not to drift, got %v", result.Server, result.Differences)
+		}
+	}
+	differences := strings.Join(results[2].Differences, "; ")
+	for _, want := range []string{
+		"commit abc1234 deployed, main is at 5aadcae",
+		"node v18.19.0, configured 20",
+		"node v18.19.0, 2 of 3 servers run v20.11.1",
+		"nginx 1.18.0, 2 of 3 servers run 1.24.0",
+	} {
+		if !strings.Contains(differences, want) {
+			t.Errorf("Expected web-3 differences to contain %q, got %q", want, differences)
+		}
+	}
+	if results[3].Drifted() {
+		t.Errorf("Expected the unreachable server not to be compared, got %v", results[3].Differences)
+	}
+}
+
+func TestCompareDriftWithoutMajority(t *testing.T) {
+	results := []internalssh.ServerDrift{
+		{Server: "web-1", Deployed: internalssh.VersionFacts{Commit: "abc1234", Nginx: "1.24.0", PM2Online: -1}},
+		{Server: "web-2", Deployed: internalssh.VersionFacts{Commit: "def5678", Nginx: "1.18.0", PM2Online: -1}},
+		{Server: "web-3", Deployed: internalssh.VersionFacts{Commit: "def5678", Nginx: "1.22.0", PM2Online: -1}},
+	}
+
+	internalssh.CompareDrift(results)
+
+	// The branch could not be resolved, the commit is compared to the majority
+	if len(results[0].Differences) != 1 || results[0].Differences[0] != "commit abc1234, 2 of 3 servers run def5678" {
+		t.Errorf("Unexpected web-1 differences: %v", results[0].Differences)
+	}
+	// No nginx version is run by most servers
+	if results[1].Drifted() || results[2].Drifted() {
+		t.Errorf("Expected web-2 and web-3 not to drift, got %v and %v", results[1].Differences, results[2].Differences)
+	}
+}
@@ -0,0 +1,200 @@
+package ssh_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startServer runs an SSH server accepting any public key and returns its port
+func startServer(t *testing.T, hostKey ssh.Signer) int {
+	t.Helper()
+	port, _ := startCountingServer(t, hostKey)
+	return port
+}
+
+// startCountingServer is startServer also returning the number of SSH
+// connections the server accepted
+func startCountingServer(t *testing.T, hostKey ssh.Signer) (int, *int32) {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				atomic.AddInt32(&accepted, 1)
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, &accepted
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// writeClientKey writes a private key usable by Connect and returns its path
+func writeClientKey(t *testing.T) string {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+func TestHostKeysTrustOnFirstUse(t *testing.T) {
+	testEnv := "test-hostkeys"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	hostKeys := internalssh.NewHostKeys(testEnv)
+	keyPath := writeClientKey(t)
+	serverKey := newSigner(t)
+	port := startServer(t, serverKey)
+
+	client, err := internalssh.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("First connection failed: %v", err)
+	}
+	client.Close()
+
+	known, err := hostKeys.Known("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("Known failed: %v", err)
+	}
+	if len(known) != 1 || ssh.FingerprintSHA256(known[0]) != ssh.FingerprintSHA256(serverKey.PublicKey()) {
+		t.Fatalf("Expected the server key to be recorded, got %v", known)
+	}
+
+	// The same key is accepted again
+	client, err = internalssh.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("Second connection failed: %v", err)
+	}
+	client.Close()
+}
+
+func TestHostKeysChangedAndAccept(t *testing.T) {
+	testEnv := "test-hostkeys-changed"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	hostKeys := internalssh.NewHostKeys(testEnv)
+	keyPath := writeClientKey(t)
+	port := startServer(t, newSigner(t))
+
+	client, err := internalssh.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("First connection failed: %v", err)
+	}
+	client.Close()
+
+	// Another server (e.g. a reinstalled one) on the same address
+	port2 := startServer(t, newSigner(t))
+	if err := os.WriteFile(hostKeys.Path(), rewritePort(t, hostKeys, port, port2), 0600); err != nil {
+		t.Fatalf("Failed to rewrite known_hosts: %v", err)
+	}
+
+	_, err = internalssh.Connect("127.0.0.1", port2, "root", keyPath, nil, hostKeys)
+	if !internalssh.IsHostKeyChanged(err) {
+		t.Fatalf("Expected a host key changed error, got %v", err)
+	}
+	result := internalssh.TestConnection("127.0.0.1", port2, "root", keyPath, nil, hostKeys)
+	if result.Success || !result.HostKeyChanged {
+		t.Errorf("Expected TestConnection to report the changed key, got %+v", result)
+	}
+
+	if _, err := hostKeys.Accept("127.0.0.1", port2, nil); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	client, err = internalssh.Connect("127.0.0.1", port2, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("Connection after accept failed: %v", err)
+	}
+	client.Close()
+
+	if err := hostKeys.Forget("127.0.0.1", port2); err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	if known, _ := hostKeys.Known("127.0.0.1", port2); len(known) != 0 {
+		t.Errorf("Expected no known key after forget, got %d", len(known))
+	}
+}
+
+// rewritePort moves the recorded key of the first server to the port of the
+// second one, as if the same host now presented another key
+func rewritePort(t *testing.T, hostKeys *internalssh.HostKeys, from, to int) []byte {
+	t.Helper()
+	known, err := hostKeys.Known("127.0.0.1", from)
+	if err != nil || len(known) != 1 {
+		t.Fatalf("Expected one known key, got %v (%v)", known, err)
+	}
+	address := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(to)))
+	return []byte(knownhosts.Line([]string{address}, known[0]) + "\n")
+}
+
+func TestIsHostKeyMismatch(t *testing.T) {
+	messages := map[string]bool{
+		"Failed to connect to the host via ssh: Host key verification failed.": true,
+		"@@@ WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED! @@@":             true,
+		"Failed to connect to the host via ssh: Connection refused":            false,
+	}
+	for message, want := range messages {
+		if got := internalssh.IsHostKeyMismatch(message); got != want {
+			t.Errorf("IsHostKeyMismatch(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package ssh_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// startJumpServer runs an SSH server forwarding direct-tcpip channels, like a
+// bastion, and returns its port and the number of forwarded connections
+func startJumpServer(t *testing.T, hostKey ssh.Signer) (int, *int32) {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var forwarded int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					if newChannel.ChannelType() != "direct-tcpip" {
+						newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+						continue
+					}
+					var target struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}
+					if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+						newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, channelReqs, err := newChannel.Accept()
+					if err != nil {
+						upstream.Close()
+						continue
+					}
+					atomic.AddInt32(&forwarded, 1)
+					go ssh.DiscardRequests(channelReqs)
+					go func() {
+						io.Copy(channel, upstream)
+						channel.Close()
+					}()
+					go func() {
+						io.Copy(upstream, channel)
+						upstream.Close()
+					}()
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, &forwarded
+}
+
+func TestConnectThroughJumpHost(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-jumphost"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	hostKeys := internalssh.NewHostKeys(testEnv)
+	keyPath := writeClientKey(t)
+	jumpKey := newSigner(t)
+	jumpPort, forwarded := startJumpServer(t, jumpKey)
+	port := startServer(t, newSigner(t))
+
+	jump := &inventory.JumpHost{Host: "127.0.0.1", Port: jumpPort, User: "bastion", SSHKeyPath: keyPath}
+	client, err := internalssh.Connect("127.0.0.1", port, "root", keyPath, jump, hostKeys)
+	if err != nil {
+		t.Fatalf("Connection through the jump host failed: %v", err)
+	}
+	client.Close()
+
+	if atomic.LoadInt32(forwarded) != 1 {
+		t.Errorf("Expected the connection to go through the jump host, %d forwarded", atomic.LoadInt32(forwarded))
+	}
+
+	// Both host keys are verified and recorded
+	known, err := hostKeys.Known("127.0.0.1", jumpPort)
+	if err != nil || len(known) != 1 || ssh.FingerprintSHA256(known[0]) != ssh.FingerprintSHA256(jumpKey.PublicKey()) {
+		t.Errorf("Expected the jump host key to be recorded, got %v (%v)", known, err)
+	}
+	if known, _ := hostKeys.Known("127.0.0.1", port); len(known) != 1 {
+		t.Errorf("Expected the server key to be recorded, got %d", len(known))
+	}
+
+	// Accepting a key goes through the jump host as well
+	if _, err := hostKeys.Accept("127.0.0.1", port, jump); err != nil {
+		t.Fatalf("Accept through the jump host failed: %v", err)
+	}
+	if atomic.LoadInt32(forwarded) != 2 {
+		t.Errorf("Expected Accept to go through the jump host, %d forwarded", atomic.LoadInt32(forwarded))
+	}
+}
+
+func TestConnectJumpHostUnreachable(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-jumphost-down"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	keyPath := writeClientKey(t)
+	jumpPort, _ := startJumpServer(t, newSigner(t))
+
+	// Nothing listens behind the jump host
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	jump := &inventory.JumpHost{Host: "127.0.0.1", Port: jumpPort, User: "bastion", SSHKeyPath: keyPath}
+	result := internalssh.TestConnection("127.0.0.1", port, "root", keyPath, jump, internalssh.NewHostKeys(testEnv))
+	if result.Success || !strings.Contains(result.Message, "cannot reach") {
+		t.Fatalf("Expected the jump host to report the unreachable server, got %+v", result)
+	}
+}
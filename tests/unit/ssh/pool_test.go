@@ -0,0 +1,129 @@
+package ssh_test
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestPoolReusesConnections(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-pool"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	hostKeys := internalssh.NewHostKeys(testEnv)
+	keyPath := writeClientKey(t)
+	port, accepted := startCountingServer(t, newSigner(t))
+
+	pool := internalssh.NewPool(time.Minute)
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+		if err != nil {
+			t.Fatalf("Connect %d failed: %v", i, err)
+		}
+		conn.Close()
+	}
+	if got := atomic.LoadInt32(accepted); got != 1 {
+		t.Errorf("Expected 1 SSH connection for 3 uses, got %d", got)
+	}
+
+	// Another user is another connection
+	conn, err := pool.Connect("127.0.0.1", port, "deploy", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("Connect as deploy failed: %v", err)
+	}
+	conn.Close()
+	if got := atomic.LoadInt32(accepted); got != 2 {
+		t.Errorf("Expected 2 SSH connections, got %d", got)
+	}
+	if pool.Size() != 2 {
+		t.Errorf("Expected 2 pooled connections, got %d", pool.Size())
+	}
+}
+
+func TestPoolClosesIdleConnections(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-pool-idle"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	hostKeys := internalssh.NewHostKeys(testEnv)
+	keyPath := writeClientKey(t)
+	port, accepted := startCountingServer(t, newSigner(t))
+
+	pool := internalssh.NewPool(100 * time.Millisecond)
+	defer pool.Close()
+
+	conn, err := pool.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	// A connection in use is never closed
+	time.Sleep(300 * time.Millisecond)
+	if pool.Size() != 1 {
+		t.Fatalf("Expected the connection in use to stay open")
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.Size() != 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if pool.Size() != 0 {
+		t.Fatalf("Expected the idle connection to be closed")
+	}
+
+	// The next use opens a new connection
+	conn, err = pool.Connect("127.0.0.1", port, "root", keyPath, nil, hostKeys)
+	if err != nil {
+		t.Fatalf("Connect after expiry failed: %v", err)
+	}
+	conn.Close()
+	if got := atomic.LoadInt32(accepted); got != 2 {
+		t.Errorf("Expected 2 SSH connections, got %d", got)
+	}
+}
+
+func TestDetectAllKeepsServerOrder(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-detect-all"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	keyPath := writeClientKey(t)
+	var servers []*inventory.Server
+	for i := 0; i < 5; i++ {
+		servers = append(servers, &inventory.Server{
+			Name:       fmt.Sprintf("web-%d", i),
+			IP:         "127.0.0.1",
+			Port:       startServer(t, newSigner(t)),
+			SSHUser:    "root",
+			SSHKeyPath: keyPath,
+		})
+	}
+	// An unreachable server among them
+	servers[2].Port = 1
+
+	detector := internalssh.NewStateDetector(internalssh.NewHostKeys(testEnv), nil)
+	results := detector.DetectAll(servers, 3)
+
+	if len(results) != len(servers) {
+		t.Fatalf("Expected %d results, got %d", len(servers), len(results))
+	}
+	for i, result := range results {
+		want := status.StateReady // Reachable, the test server runs no command
+		if i == 2 {
+			want = status.StateNotReady
+		}
+		if result.State != want {
+			t.Errorf("%s: expected %s, got %s (%s)", servers[i].Name, want, result.State, result.Message)
+		}
+	}
+}
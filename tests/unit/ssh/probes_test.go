@@ -0,0 +1,262 @@
+package ssh_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+	"golang.org/x/crypto/ssh"
+)
+
+// startExecServer runs an SSH server executing commands with the local shell
+// and forwarding direct-tcpip channels, and returns its port
+func startExecServer(t *testing.T, hostKey ssh.Signer) int {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					switch newChannel.ChannelType() {
+					case "session":
+						go serveSession(newChannel)
+					case "direct-tcpip":
+						go forward(newChannel)
+					default:
+						newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// serveSession runs the exec request of a session with sh
+func serveSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		cmd := exec.Command("sh", "-c", payload.Command)
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		code := 0
+		if err := cmd.Run(); err != nil {
+			code = 1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.ExitCode()
+			}
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(code)}))
+		return
+	}
+}
+
+// forward connects a direct-tcpip channel to its target
+func forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(channel, upstream)
+		channel.Close()
+	}()
+	io.Copy(upstream, channel)
+	upstream.Close()
+}
+
+func probeResult(t *testing.T, result internalssh.StateDetectionResult, name string) internalssh.ProbeResult {
+	t.Helper()
+	for _, probe := range result.Probes {
+		if probe.Name == name {
+			return probe
+		}
+	}
+	t.Fatalf("No result for probe %s in %+v", name, result.Probes)
+	return internalssh.ProbeResult{}
+}
+
+func TestDetectStateRunsConfiguredProbes(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-probes"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer app.Close()
+	appPort := app.Listener.Addr().(*net.TCPAddr).Port
+
+	appDir := t.TempDir()
+	env := inventory.Environment{
+		Name: testEnv,
+		Config: inventory.Config{Probes: map[string][]inventory.ProbeSpec{
+			"web": {
+				// The tools of a provisioned server are not on the test machine
+				{Name: "nvm", Disabled: true},
+				{Name: "node", Disabled: true},
+				{Name: "nginx", Disabled: true},
+				{Name: "pm2", Disabled: true},
+				{Name: "app_dir", Command: "test -d " + appDir},
+				{Name: "version", Command: "echo building; echo v1.2.3", Expect: `(?m)^v1\.`},
+				{Name: "current", Command: "echo {{ app_current_dir }} {{ app_port }}; exit 3", Gates: inventory.ProbeGatesNone},
+			},
+		}},
+	}
+
+	server := inventory.Server{
+		Name:       "web-1",
+		IP:         "127.0.0.1",
+		Port:       startExecServer(t, newSigner(t)),
+		SSHUser:    "root",
+		SSHKeyPath: writeClientKey(t),
+		Type:       "web",
+		AppPort:    appPort,
+	}
+
+	detector := internalssh.NewStateDetector(internalssh.NewHostKeys(testEnv), nil)
+	detector.SetEnvironment(env)
+
+	result := detector.DetectState(server)
+	if result.State != status.StateDeployed {
+		t.Fatalf("Expected deployed, got %s (%s): %+v", result.State, result.Message, result.Probes)
+	}
+	if len(result.Probes) != 4 {
+		t.Errorf("Expected 4 probes, got %+v", result.Probes)
+	}
+	if probe := probeResult(t, result, "version"); !probe.Passed || !strings.Contains(probe.Output, "v1.2.3") {
+		t.Errorf("Expected the version probe to pass with its output, got %+v", probe)
+	}
+	current := probeResult(t, result, "current")
+	if current.Passed || current.Output != fmt.Sprintf("/var/www/%s/current %d", testEnv, appPort) {
+		t.Errorf("Expected the current probe to fail with the expanded command output, got %+v", current)
+	}
+
+	// The application stops answering
+	app.Close()
+	result = detector.DetectState(server)
+	if result.State != status.StateProvisioned {
+		t.Errorf("Expected provisioned without the app, got %s (%s)", result.State, result.Message)
+	}
+	if probe := probeResult(t, result, "app"); probe.Passed || probe.Output == "" {
+		t.Errorf("Expected the app probe to fail with a reason, got %+v", probe)
+	}
+
+	// A provisioning probe fails
+	os.RemoveAll(appDir)
+	result = detector.DetectState(server)
+	if result.State != status.StateReady || !strings.Contains(result.Message, "app_dir") {
+		t.Errorf("Expected ready naming app_dir, got %s (%s)", result.State, result.Message)
+	}
+}
+
+func TestDetectStateMonitoringProbes(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	testEnv := "test-probes-monitoring"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	exporter, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer exporter.Close()
+
+	env := inventory.Environment{
+		Name: testEnv,
+		Config: inventory.Config{Probes: map[string][]inventory.ProbeSpec{
+			"monitoring": {
+				{Name: "node_exporter", Command: "true"},
+				{Name: "node_exporter_port", Check: inventory.ProbeCheckTCP, Port: exporter.Addr().(*net.TCPAddr).Port},
+				{Name: "prometheus", Disabled: true},
+				{Name: "prometheus_port", Disabled: true},
+			},
+		}},
+	}
+	server := inventory.Server{
+		Name:       "mon-1",
+		IP:         "127.0.0.1",
+		Port:       startExecServer(t, newSigner(t)),
+		SSHUser:    "root",
+		SSHKeyPath: writeClientKey(t),
+		Type:       "monitoring",
+	}
+
+	detector := internalssh.NewStateDetector(internalssh.NewHostKeys(testEnv), nil)
+	detector.SetEnvironment(env)
+
+	// Monitoring servers have no deployment, provisioned is their final state
+	result := detector.DetectState(server)
+	if result.State != status.StateProvisioned {
+		t.Fatalf("Expected provisioned, got %s (%s): %+v", result.State, result.Message, result.Probes)
+	}
+
+	exporter.Close()
+	result = detector.DetectState(server)
+	if result.State != status.StateReady || !strings.Contains(result.Message, "node_exporter_port") {
+		t.Errorf("Expected ready naming node_exporter_port, got %s (%s)", result.State, result.Message)
+	}
+}
@@ -0,0 +1,98 @@
+package ssh_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	internalssh "github.com/bastiblast/boiler-deploy/internal/ssh"
+)
+
+func TestParseReleaseListing(t *testing.T) {
+	output := strings.Join([]string{
+		"@@current 20240131T120000_abc1234",
+		"@@release\t20240131T120000_abc1234\t\t\t1706702400\t2048",
+		"@@release\t20240201T080000_def5678\t5aadcaed9c2f94d25b5936cf3e6989c92aeeebea\tmain\t1706774400\t4096",
+		"motd noise",
+		"",
+	}, "\n")
+
+	releases, current, err := internalssh.ParseReleaseListing(output)
+	if err != nil {
+		t.Fatalf("ParseReleaseListing failed: %v", err)
+	}
+	if current != "20240131T120000_abc1234" {
+		t.Errorf("Expected current 20240131T120000_abc1234, got %q", current)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("Expected 2 releases, got %d", len(releases))
+	}
+
+	// Most recent first
+	latest := releases[0]
+	if latest.ID != "20240201T080000_def5678" || latest.Branch != "main" || latest.ShortCommit() != "5aadcae" || latest.SizeKB != 4096 {
+		t.Errorf("Unexpected latest release: %+v", latest)
+	}
+	if latest.Current {
+		t.Error("Expected the latest release not to be current")
+	}
+
+	// Without git, the commit comes from the release name
+	previous := releases[1]
+	if !previous.Current || previous.Commit != "abc1234" || !previous.DeployedAt.Equal(time.Unix(1706702400, 0)) {
+		t.Errorf("Unexpected current release: %+v", previous)
+	}
+
+	if _, _, err := internalssh.ParseReleaseListing("@@current \n@@missing\n"); err == nil {
+		t.Error("Expected an error when the releases directory is missing")
+	}
+}
+
+func serverRunning(name, commit string, deployedAt int64) internalssh.ServerReleases {
+	return internalssh.ServerReleases{
+		Server:   name,
+		Current:  "r_" + commit,
+		Releases: []internalssh.Release{{ID: "r_" + commit, Commit: commit, DeployedAt: time.Unix(deployedAt, 0), Current: true}},
+	}
+}
+
+func TestFlagReleaseDrift(t *testing.T) {
+	results := []internalssh.ServerReleases{
+		serverRunning("web-1", "abc1234", 100),
+		serverRunning("web-2", "abc1234", 100),
+		serverRunning("web-3", "def5678", 200),
+		{Server: "web-4"}, // Nothing deployed
+	}
+	internalssh.FlagReleaseDrift(results)
+
+	for _, result := range results {
+		drifted := result.Drift != ""
+		if drifted != (result.Server == "web-3") {
+			t.Errorf("%s: unexpected drift %q", result.Server, result.Drift)
+		}
+	}
+	if !strings.Contains(results[2].Drift, "2 of 3 servers run abc1234") {
+		t.Errorf("Unexpected drift message: %q", results[2].Drift)
+	}
+
+	// On a tie, the most recent deploy is the reference
+	tie := []internalssh.ServerReleases{
+		serverRunning("web-1", "abc1234", 100),
+		serverRunning("web-2", "def5678", 200),
+	}
+	internalssh.FlagReleaseDrift(tie)
+	if tie[0].Drift == "" || tie[1].Drift != "" {
+		t.Errorf("Expected only web-1 to drift, got %q and %q", tie[0].Drift, tie[1].Drift)
+	}
+
+	aligned := []internalssh.ServerReleases{
+		serverRunning("web-1", "abc1234", 100),
+		serverRunning("web-2", "abc1234", 300),
+	}
+	internalssh.FlagReleaseDrift(aligned)
+	for _, result := range aligned {
+		if result.Drift != "" {
+			t.Errorf("%s: unexpected drift %q", result.Server, result.Drift)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package status_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestHistoryAppendAndList(t *testing.T) {
+	testEnv := "test-history"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	h, err := status.NewHistory(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create history: %v", err)
+	}
+
+	start := time.Now()
+	runs := []status.ExecutionLog{
+		{ID: "aaaa-1", ServerName: "web1", Action: status.ActionProvision, Status: status.RunSucceeded},
+		{ID: "bbbb-1", ServerName: "web1", Action: status.ActionDeploy, Status: status.RunFailed, Error: "boom"},
+		{ID: "bbbb-2", ServerName: "web2", Action: status.ActionDeploy, Status: status.RunSkipped},
+	}
+	for i := range runs {
+		runs[i].StartTime = start.Add(time.Duration(i) * time.Minute)
+		if err := h.Append(runs[i]); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	all, err := h.List(status.HistoryFilter{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 runs, got %d", len(all))
+	}
+	if all[0].ID != "bbbb-2" {
+		t.Errorf("Expected most recent run first, got %s", all[0].ID)
+	}
+
+	deploys, _ := h.List(status.HistoryFilter{ServerName: "web1", Action: status.ActionDeploy})
+	if len(deploys) != 1 || deploys[0].Error != "boom" {
+		t.Errorf("Expected the failed deploy of web1, got %+v", deploys)
+	}
+
+	failed, _ := h.List(status.HistoryFilter{Status: status.RunFailed})
+	if len(failed) != 1 || failed[0].ID != "bbbb-1" {
+		t.Errorf("Expected one failed run, got %+v", failed)
+	}
+
+	limited, _ := h.List(status.HistoryFilter{Limit: 2})
+	if len(limited) != 2 || limited[1].ID != "bbbb-1" {
+		t.Errorf("Expected the 2 most recent runs, got %+v", limited)
+	}
+}
+
+func TestHistorySkipsInvalidLines(t *testing.T) {
+	testEnv := "test-history-invalid"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	h, err := status.NewHistory(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create history: %v", err)
+	}
+	h.Append(status.ExecutionLog{ID: "run-1", ServerName: "web1", Status: status.RunSucceeded})
+
+	// Simulate a line torn by a crash
+	f, err := os.OpenFile(filepath.Join("inventory", testEnv, ".history", "runs.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open history file: %v", err)
+	}
+	f.WriteString(`{"id":"run-2","serv` + "\n")
+	f.Close()
+
+	h.Append(status.ExecutionLog{ID: "run-3", ServerName: "web1", Status: status.RunFailed})
+
+	runs, err := h.List(status.HistoryFilter{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Errorf("Expected 2 valid runs, got %d", len(runs))
+	}
+}
+
+func TestHistoryGet(t *testing.T) {
+	testEnv := "test-history-get"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	h, err := status.NewHistory(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create history: %v", err)
+	}
+	h.Append(status.ExecutionLog{ID: "3f2a9c1e-0001", ServerName: "web1"})
+	h.Append(status.ExecutionLog{ID: "3f2a9c1e-0002", ServerName: "web2"})
+	h.Append(status.ExecutionLog{ID: "7b00d4aa-0001", ServerName: "web3"})
+
+	run, err := h.Get("7b00")
+	if err != nil || run.ServerName != "web3" {
+		t.Errorf("Expected web3 run by prefix, got %+v (%v)", run, err)
+	}
+
+	run, err = h.Get("3f2a9c1e-0002")
+	if err != nil || run.ServerName != "web2" {
+		t.Errorf("Expected web2 run by full ID, got %+v (%v)", run, err)
+	}
+
+	if _, err := h.Get("3f2a"); err == nil {
+		t.Error("Expected an error for an ambiguous prefix")
+	}
+	if _, err := h.Get("ffff"); err == nil {
+		t.Error("Expected an error for an unknown run")
+	}
+}
@@ -0,0 +1,137 @@
+package status_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestEnvLock(t *testing.T) {
+	testEnv := "test-lock"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	lock, err := status.AcquireLock(testEnv, "alice")
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+
+	// A second run of the environment is refused, and told who holds it
+	_, err = status.AcquireLock(testEnv, "bob")
+	var locked *status.LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected a LockedError, got %v", err)
+	}
+	if locked.Info.Holder != "alice" || locked.Info.PID != os.Getpid() {
+		t.Errorf("Unexpected holder: %+v", locked.Info)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if info, err := status.ReadLock(testEnv); err != nil || info != nil {
+		t.Fatalf("Expected the lock to be free, got %+v, %v", info, err)
+	}
+
+	lock, err = status.AcquireLock(testEnv, "bob")
+	if err != nil {
+		t.Fatalf("Expected the released lock to be acquired again: %v", err)
+	}
+	defer lock.Release()
+}
+
+func TestEnvLockStale(t *testing.T) {
+	testEnv := "test-lock-stale"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	// Held by a process of this host which is gone
+	host, _ := os.Hostname()
+	writeLock(t, testEnv, status.LockInfo{Holder: "crashed", PID: 999999999, Host: host, StartedAt: time.Now()})
+
+	info, err := status.ReadLock(testEnv)
+	if err != nil || info == nil || !info.Stale() {
+		t.Fatalf("Expected a stale lock, got %+v, %v", info, err)
+	}
+
+	lock, err := status.AcquireLock(testEnv, "alice")
+	if err != nil {
+		t.Fatalf("Expected the stale lock to be taken over: %v", err)
+	}
+	lock.Release()
+
+	// Held from another host which still refreshes it
+	writeLock(t, testEnv, status.LockInfo{Holder: "bob", PID: 1, Host: "elsewhere", StartedAt: time.Now()})
+	if _, err := status.AcquireLock(testEnv, "alice"); err == nil {
+		t.Fatal("Expected a live lock of another host to be kept")
+	}
+
+	// Which a forced unlock removes
+	removed, err := status.ForceUnlock(testEnv)
+	if err != nil || removed == nil || removed.Holder != "bob" {
+		t.Fatalf("Unexpected ForceUnlock result: %+v, %v", removed, err)
+	}
+	if info, _ := status.ReadLock(testEnv); info != nil {
+		t.Errorf("Expected no lock after ForceUnlock, got %+v", info)
+	}
+}
+
+func TestEnvLockStaleTakeoverRace(t *testing.T) {
+	testEnv := "test-lock-race"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	host, _ := os.Hostname()
+	for round := 0; round < 20; round++ {
+		writeLock(t, testEnv, status.LockInfo{Holder: "crashed", PID: 999999999, Host: host, StartedAt: time.Now()})
+
+		// Everyone finds the lock stale, only one takes it over
+		const acquirers = 8
+		locks := make(chan *status.EnvLock, acquirers)
+		var wg sync.WaitGroup
+		for i := 0; i < acquirers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lock, err := status.AcquireLock(testEnv, "racer")
+				var locked *status.LockedError
+				if err == nil {
+					locks <- lock
+				} else if !errors.As(err, &locked) {
+					t.Errorf("Expected a LockedError, got %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		close(locks)
+
+		var held []*status.EnvLock
+		for lock := range locks {
+			held = append(held, lock)
+		}
+		if len(held) != 1 {
+			t.Fatalf("Round %d: expected exactly one acquirer to take the stale lock over, got %d", round, len(held))
+		}
+		info, err := status.ReadLock(testEnv)
+		if err != nil || info == nil || !info.StartedAt.Equal(held[0].Info().StartedAt) {
+			t.Fatalf("Round %d: expected the lock file to be the winner's, got %+v, %v", round, info, err)
+		}
+		held[0].Release()
+	}
+}
+
+func writeLock(t *testing.T, environment string, info status.LockInfo) {
+	t.Helper()
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("inventory/"+environment, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(status.LockPath(environment), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
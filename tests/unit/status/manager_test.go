@@ -0,0 +1,185 @@
+package status_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/atomicfile"
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/status"
+)
+
+func TestValidateServerPerType(t *testing.T) {
+	testEnv := "test-ready-checks"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	mgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	// Only web servers need the application fields
+	db := &inventory.Server{Name: "db-1", IP: "10.0.0.2", Port: 22, Type: "db"}
+	if checks := mgr.ValidateServer(db); !checks.AllFieldsFilled {
+		t.Errorf("Expected a db server without application fields to be complete, got %+v", checks)
+	}
+	monitoring := &inventory.Server{Name: "mon-1", IP: "10.0.0.3", Port: 22, Type: "monitoring"}
+	if checks := mgr.ValidateServer(monitoring); !checks.AllFieldsFilled {
+		t.Errorf("Expected a monitoring server without application fields to be complete, got %+v", checks)
+	}
+
+	web := &inventory.Server{Name: "web-1", IP: "10.0.0.1", Port: 22, Type: "web"}
+	if checks := mgr.ValidateServer(web); checks.AllFieldsFilled {
+		t.Error("Expected a web server without repository to be incomplete")
+	}
+	web.GitRepo, web.AppPort, web.NodeVersion = "https://github.com/user/app.git", 3000, "20"
+	if checks := mgr.ValidateServer(web); !checks.AllFieldsFilled {
+		t.Errorf("Expected a complete web server, got %+v", checks)
+	}
+
+	// A server without type is a web server
+	untyped := &inventory.Server{Name: "app-1", IP: "10.0.0.4", Port: 22}
+	if checks := mgr.ValidateServer(untyped); checks.AllFieldsFilled {
+		t.Error("Expected a server without type to need the application fields")
+	}
+}
+
+func TestRolledBackStateIsKept(t *testing.T) {
+	testEnv := "test-rolled-back"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	mgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	mgr.UpdateStatus("web-1", status.StateRolledBack, status.ActionRollback, "Rolled back to previous release")
+	mgr.UpdateStatus("web-2", status.StateRollingBack, status.ActionRollback, "Rolling back...")
+	mgr.UpdateReadyChecks("web-1", status.ReadyChecks{IPValid: true, SSHKeyExists: true, PortValid: true, AllFieldsFilled: true})
+	if state := mgr.GetStatus("web-1").State; state != status.StateRolledBack {
+		t.Errorf("Expected validation to keep the rolled back state, got %s", state)
+	}
+
+	// An interrupted rollback is not trusted after a restart
+	reloaded, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload manager: %v", err)
+	}
+	if state := reloaded.GetStatus("web-1").State; state != status.StateRolledBack {
+		t.Errorf("Expected web-1 to stay rolled back, got %s", state)
+	}
+	if err := reloaded.ResetInProgress(); err != nil {
+		t.Fatalf("ResetInProgress failed: %v", err)
+	}
+	if state := reloaded.GetStatus("web-2").State; state != status.StateUnknown {
+		t.Errorf("Expected the interrupted rollback of web-2 to be reset, got %s", state)
+	}
+}
+
+func TestLoadKeepsStatusesOfLockedEnvironment(t *testing.T) {
+	testEnv := "test-status-locked"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	mgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	lock, err := status.AcquireLock(testEnv, "alice")
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+	defer lock.Release()
+	mgr.UpdateStatus("web-1", status.StateDeploying, status.ActionDeploy, "Deploying...")
+
+	// Another process reading the statuses of the live run rewrites nothing
+	reader, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if state := reader.GetStatus("web-1").State; state != status.StateDeploying {
+		t.Errorf("Expected web-1 to be deploying, got %s", state)
+	}
+	statusFile := "inventory/" + testEnv + "/.status/servers.json"
+	before, err := os.ReadFile(statusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor records a state while the environment is held
+	err = reader.Locked(func() error {
+		return reader.UpdateStatus("web-1", status.StateUnknown, status.ActionCheck, "")
+	})
+	var locked *status.LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected a LockedError, got %v", err)
+	}
+	if after, _ := os.ReadFile(statusFile); string(after) != string(before) {
+		t.Errorf("Expected servers.json to be left alone, got %s", after)
+	}
+
+	// Once released, it records over the latest statuses
+	lock.Release()
+	mgr.UpdateStatus("web-2", status.StateDeployed, status.ActionDeploy, "")
+	err = reader.Locked(func() error {
+		return reader.UpdateStatus("web-1", status.StateUnknown, status.ActionCheck, "")
+	})
+	if err != nil {
+		t.Fatalf("Locked failed: %v", err)
+	}
+	if state := reader.GetStatus("web-2").State; state != status.StateDeployed {
+		t.Errorf("Expected web-2 to be reloaded as deployed, got %s", state)
+	}
+}
+
+func TestStatusesRecoveredFromBackup(t *testing.T) {
+	testEnv := "test-status-recovery"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	mgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	mgr.UpdateStatus("web-1", status.StateDeployed, status.ActionDeploy, "")
+	mgr.UpdateStatus("web-2", status.StateDeployed, status.ActionDeploy, "")
+
+	// servers.json truncated by a crash, its backup holds web-1
+	statusFile := "inventory/" + testEnv + "/.status/servers.json"
+	if err := os.WriteFile(statusFile, []byte(`{"web-1": {"na`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err = status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload manager: %v", err)
+	}
+	if st := mgr.GetStatus("web-1"); st == nil || st.State != status.StateDeployed {
+		t.Errorf("Expected web-1 to be recovered as deployed, got %+v", st)
+	}
+	if warnings := atomicfile.TakeWarnings(); len(warnings) == 0 {
+		t.Error("Expected a warning about the recovery")
+	}
+}
+
+func TestStatusCommit(t *testing.T) {
+	testEnv := "test-status-commit"
+	defer os.RemoveAll("inventory/" + testEnv)
+
+	mgr, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	mgr.SetCommit("web1", commit, "v1.0.0")
+	// Kept across state changes
+	mgr.UpdateStatus("web1", status.StateDeployed, status.ActionDeploy, "")
+
+	reloaded, err := status.NewManager(testEnv)
+	if err != nil {
+		t.Fatalf("Failed to reload statuses: %v", err)
+	}
+	st := reloaded.GetStatus("web1")
+	if st.Commit != commit || st.CommitString() != "0123456 (v1.0.0)" {
+		t.Errorf("Expected web1 at 0123456 (v1.0.0), got %q", st.CommitString())
+	}
+}
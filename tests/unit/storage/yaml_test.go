@@ -0,0 +1,58 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bastiblast/boiler-deploy/internal/inventory"
+	"github.com/bastiblast/boiler-deploy/internal/storage"
+)
+
+func TestSaveEnvironmentDatabasePassword(t *testing.T) {
+	base := t.TempDir()
+	store := storage.NewStorage(base)
+	env := inventory.Environment{
+		Name: "staging",
+		Servers: []inventory.Server{
+			{Name: "db-1", IP: "10.0.0.2", Port: 22, SSHUser: "root", Type: "db"},
+		},
+	}
+
+	if err := store.SaveEnvironment(env); err != nil {
+		t.Fatalf("SaveEnvironment failed: %v", err)
+	}
+	envPath := filepath.Join(base, "inventory", "staging")
+	passwordFile := filepath.Join(envPath, inventory.DBPasswordFile)
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		t.Fatalf("Expected the password in %s: %v", inventory.DBPasswordFile, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the password file to be 0600, got %v", info.Mode().Perm())
+	}
+	password, _ := os.ReadFile(passwordFile)
+	if len(password) == 0 {
+		t.Fatal("Expected a generated password")
+	}
+	if ignore, err := os.ReadFile(filepath.Join(filepath.Dir(passwordFile), ".gitignore")); err != nil || strings.TrimSpace(string(ignore)) != "*" {
+		t.Errorf("Expected the secrets directory to be ignored by git, got %q (%v)", ignore, err)
+	}
+	dbVars, err := os.ReadFile(filepath.Join(envPath, "group_vars", "dbservers.yml"))
+	if err != nil {
+		t.Fatalf("Expected dbservers.yml: %v", err)
+	}
+	if strings.Contains(string(dbVars), string(password)) {
+		t.Error("Expected dbservers.yml not to hold the password")
+	}
+
+	// Saved again, even without dbservers.yml, the password is kept
+	os.Remove(filepath.Join(envPath, "group_vars", "dbservers.yml"))
+	if err := store.SaveEnvironment(env); err != nil {
+		t.Fatalf("SaveEnvironment failed: %v", err)
+	}
+	if again, _ := os.ReadFile(passwordFile); string(again) != string(password) {
+		t.Error("Expected the existing password not to be regenerated")
+	}
+}